@@ -1,7 +1,68 @@
 package main
 
+import (
+	"context"
+	"strings"
+)
+
+// Account is someone who has logged in to builder with a source host like
+// Github, GitLab or Gitea. Login, Name, Email and AvatarUrl are their profile
+// on that host from the last time they logged in.
 type Account struct {
 	Id           int
 	AccessToken  string
+	Login        string
+	Name         string
+	Email        string
+	AvatarUrl    string
 	Repositories []*Repository
+
+	// Host is the name of the source host the account logged in with, and
+	// HostUserId the id of the user on that host. Together they identify
+	// the account's user, because each host numbers its users from one.
+	Host       string
+	HostUserId int
+}
+
+// DisplayName is the name of the account, or the login when there's no
+// name on their profile on the account's host.
+func (account *Account) DisplayName() string {
+	if account.Name != "" {
+		return account.Name
+	}
+	return account.Login
+}
+
+// findBuildAuthors fills in the profile of the authors of builds who have
+// logged in to builder. Authors are looked up on the host of each build, as
+// logins are only unique on one host.
+func findBuildAuthors(ctx context.Context, builds []*Build) error {
+	logins := map[string][]string{}
+	seen := map[string]bool{}
+	for _, build := range builds {
+		host := hostName(build.Host)
+		login := strings.ToLower(build.Author)
+		if login != "" && !seen[host+"/"+login] {
+			seen[host+"/"+login] = true
+			logins[host] = append(logins[host], build.Author)
+		}
+	}
+
+	byLogin := map[string]*Account{}
+	for host, hostLogins := range logins {
+		accounts, err := database.FindAccountsByLogin(ctx, host, hostLogins)
+		if err != nil {
+			return err
+		}
+		for _, account := range accounts {
+			byLogin[host+"/"+strings.ToLower(account.Login)] = account
+		}
+	}
+	for _, build := range builds {
+		if account, ok := byLogin[hostName(build.Host)+"/"+strings.ToLower(build.Author)]; ok {
+			build.AuthorName = account.DisplayName()
+			build.AuthorAvatarUrl = account.AvatarUrl
+		}
+	}
+	return nil
 }
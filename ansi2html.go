@@ -1,43 +1,555 @@
 package main
 
 import (
-	"regexp"
+	"fmt"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
-var ansiColours = map[int]string{
-	30: "black",
-	31: "red",
-	32: "green",
-	33: "yellow",
-	34: "blue",
-	35: "magenta",
-	36: "cyan",
-	37: "white",
-	90: "grey",
+var ansiColours = []string{
+	"black",
+	"red",
+	"green",
+	"yellow",
+	"blue",
+	"magenta",
+	"cyan",
+	"white",
 }
 
-func AnsiToHtml(ansi string) string {
-	ansi = strings.Replace(ansi, `&`, `&amp;`, -1)
-	ansi = strings.Replace(ansi, `>`, `&gt;`, -1)
-	ansi = strings.Replace(ansi, `<`, `&lt;`, -1)
-	re := regexp.MustCompile("\x1b\\[\\d+m")
-	ansi = re.ReplaceAllStringFunc(ansi, func(match string) string {
-		if match == "\x1b[1m" {
-			return `<span style="font-weight: bold;">`
-		}
-		for code, name := range ansiColours {
-			if strings.Contains(match, strconv.Itoa(code)) {
-				return `<span class="` + name + `">`
+const (
+	colourDefault = iota
+	colourIndexed
+	colourRGB
+)
+
+type colour struct {
+	kind    int
+	index   int
+	r, g, b int
+}
+
+// class returns the css class for the 16 standard colours. Other colours
+// have no class and are written as an inline style instead.
+func (c colour) class() string {
+	if c.kind != colourIndexed || c.index > 15 {
+		return ""
+	}
+	if c.index < 8 {
+		return ansiColours[c.index]
+	}
+	if c.index == 8 {
+		return "grey"
+	}
+	return "bright-" + ansiColours[c.index-8]
+}
+
+func (c colour) css() string {
+	r, g, b := c.r, c.g, c.b
+	if c.kind == colourIndexed {
+		r, g, b = xtermColour(c.index)
+	}
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
+
+// xtermColour returns the rgb value of one of the 240 extended colours of
+// the xterm 256 colour palette.
+func xtermColour(index int) (int, int, int) {
+	if index >= 232 {
+		grey := 8 + (index-232)*10
+		return grey, grey, grey
+	}
+	index -= 16
+	level := func(n int) int {
+		if n == 0 {
+			return 0
+		}
+		return 55 + n*40
+	}
+	return level(index / 36), level((index / 6) % 6), level(index % 6)
+}
+
+type textStyle struct {
+	bold       bool
+	faint      bool
+	italic     bool
+	underline  bool
+	inverse    bool
+	hidden     bool
+	strike     bool
+	foreground colour
+	background colour
+}
+
+// apply updates the style with the parameters of an SGR escape sequence.
+func (s *textStyle) apply(params []int) {
+	if len(params) == 0 {
+		params = []int{0}
+	}
+
+	for i := 0; i < len(params); i++ {
+		code := params[i]
+		switch {
+		case code == 0:
+			*s = textStyle{}
+		case code == 1:
+			s.bold = true
+		case code == 2:
+			s.faint = true
+		case code == 3:
+			s.italic = true
+		case code == 4 || code == 21:
+			s.underline = true
+		case code == 7:
+			s.inverse = true
+		case code == 8:
+			s.hidden = true
+		case code == 9:
+			s.strike = true
+		case code == 22:
+			s.bold = false
+			s.faint = false
+		case code == 23:
+			s.italic = false
+		case code == 24:
+			s.underline = false
+		case code == 27:
+			s.inverse = false
+		case code == 28:
+			s.hidden = false
+		case code == 29:
+			s.strike = false
+		case code >= 30 && code <= 37:
+			s.foreground = colour{kind: colourIndexed, index: code - 30}
+		case code == 38:
+			s.foreground, i = extendedColour(params, i)
+		case code == 39:
+			s.foreground = colour{}
+		case code >= 40 && code <= 47:
+			s.background = colour{kind: colourIndexed, index: code - 40}
+		case code == 48:
+			s.background, i = extendedColour(params, i)
+		case code == 49:
+			s.background = colour{}
+		case code >= 90 && code <= 97:
+			s.foreground = colour{kind: colourIndexed, index: code - 90 + 8}
+		case code >= 100 && code <= 107:
+			s.background = colour{kind: colourIndexed, index: code - 100 + 8}
+		}
+	}
+}
+
+// extendedColour parses "5;n" and "2;r;g;b" after a 38 or 48 code, and
+// returns the index of the last parameter it used.
+func extendedColour(params []int, i int) (colour, int) {
+	if i+2 < len(params) && params[i+1] == 5 {
+		index := params[i+2]
+		if index < 0 || index > 255 {
+			return colour{}, i + 2
+		}
+		return colour{kind: colourIndexed, index: index}, i + 2
+	}
+	if i+4 < len(params) && params[i+1] == 2 {
+		clamp := func(n int) int {
+			if n > 255 {
+				return 255
+			}
+			return n
+		}
+		return colour{
+			kind: colourRGB,
+			r:    clamp(params[i+2]),
+			g:    clamp(params[i+3]),
+			b:    clamp(params[i+4]),
+		}, i + 4
+	}
+	return colour{}, len(params)
+}
+
+// parameters returns the SGR parameters that recreate this colour, where
+// base is 30 for a foreground colour and 40 for a background colour.
+func (c colour) parameters(base int) []int {
+	switch {
+	case c.kind == colourIndexed && c.index < 8:
+		return []int{base + c.index}
+	case c.kind == colourIndexed && c.index < 16:
+		return []int{base + 60 + c.index - 8}
+	case c.kind == colourIndexed:
+		return []int{base + 8, 5, c.index}
+	case c.kind == colourRGB:
+		return []int{base + 8, 2, c.r, c.g, c.b}
+	}
+	return nil
+}
+
+// parameters returns the SGR parameters that recreate this style.
+func (s textStyle) parameters() []int {
+	var params []int
+	flags := []struct {
+		set  bool
+		code int
+	}{
+		{s.bold, 1},
+		{s.faint, 2},
+		{s.italic, 3},
+		{s.underline, 4},
+		{s.inverse, 7},
+		{s.hidden, 8},
+		{s.strike, 9},
+	}
+	for _, flag := range flags {
+		if flag.set {
+			params = append(params, flag.code)
+		}
+	}
+	params = append(params, s.foreground.parameters(30)...)
+	return append(params, s.background.parameters(40)...)
+}
+
+// span returns the opening tag for text in this style, or an empty string
+// for unstyled text.
+func (s textStyle) span() string {
+	if s == (textStyle{}) {
+		return ""
+	}
+
+	foreground, background := s.foreground, s.background
+	var classes []string
+	var styles []string
+	if s.inverse {
+		foreground, background = background, foreground
+		classes = append(classes, "inverse")
+	}
+
+	if foreground.kind != colourDefault {
+		if class := foreground.class(); class != "" {
+			classes = append(classes, class)
+		} else {
+			styles = append(styles, "color: "+foreground.css()+";")
+		}
+	}
+	if background.kind != colourDefault {
+		if class := background.class(); class != "" {
+			classes = append(classes, "bg-"+class)
+		} else {
+			styles = append(styles, "background-color: "+background.css()+";")
+		}
+	}
+
+	if s.bold {
+		styles = append(styles, "font-weight: bold;")
+	}
+	if s.faint {
+		styles = append(styles, "opacity: 0.5;")
+	}
+	if s.italic {
+		styles = append(styles, "font-style: italic;")
+	}
+	if s.underline && s.strike {
+		styles = append(styles, "text-decoration: underline line-through;")
+	} else if s.underline {
+		styles = append(styles, "text-decoration: underline;")
+	} else if s.strike {
+		styles = append(styles, "text-decoration: line-through;")
+	}
+	if s.hidden {
+		styles = append(styles, "visibility: hidden;")
+	}
+
+	span := "<span"
+	if len(classes) > 0 {
+		span += ` class="` + strings.Join(classes, " ") + `"`
+	}
+	if len(styles) > 0 {
+		span += ` style="` + strings.Join(styles, " ") + `"`
+	}
+	return span + ">"
+}
+
+type cell struct {
+	style textStyle
+	c     rune
+}
+
+// maxLineWidth is the furthest the cursor can be moved past the end of a
+// line. Moving it further would pad the line with blanks without bound.
+const maxLineWidth = 1024
+
+// ansiRenderer turns terminal output into html, one div per line. It keeps
+// the current line in a buffer the way a terminal would, so carriage
+// returns, cursor movement and erases change what has already been written
+// to the line instead of showing up in the output. Styles carry over from
+// one line to the next, but every line closes its own spans so the markup
+// is always balanced.
+//
+// Fold markers are turned into the start and end of collapsible sections.
+// Lines are never nested inside each other, instead every line lists the
+// folds it is in with a data-fold attribute.
+type ansiRenderer struct {
+	style    textStyle
+	line     []cell
+	column   int
+	folds    []openFold
+	lastFold int
+	output   strings.Builder
+}
+
+type openFold struct {
+	Id      int    `json:"id"`
+	Name    string `json:"name"`
+	Started int64  `json:"started,omitempty"`
+}
+
+func (r *ansiRenderer) write(ansi string) {
+	for i := 0; i < len(ansi); {
+		switch ansi[i] {
+		case '\x1b':
+			i += r.escape(ansi[i:])
+		case '\n':
+			r.endLine()
+			i++
+		case '\r':
+			r.column = 0
+			i++
+		case '\b':
+			r.moveTo(r.column - 1)
+			i++
+		default:
+			c, size := utf8.DecodeRuneInString(ansi[i:])
+			r.text(c)
+			i += size
+		}
+	}
+}
+
+func (r *ansiRenderer) text(c rune) {
+	for len(r.line) < r.column {
+		r.line = append(r.line, cell{c: ' '})
+	}
+	if r.column < len(r.line) {
+		r.line[r.column] = cell{style: r.style, c: c}
+	} else {
+		r.line = append(r.line, cell{style: r.style, c: c})
+	}
+	r.column++
+}
+
+func (r *ansiRenderer) moveTo(column int) {
+	if column < 0 {
+		column = 0
+	}
+	if column > maxLineWidth && column > len(r.line) {
+		column = maxLineWidth
+		if len(r.line) > column {
+			column = len(r.line)
+		}
+	}
+	r.column = column
+}
+
+// eraseInLine handles "\x1b[K". 0 erases to the end of the line, 1 erases
+// to the start of the line and 2 erases the whole line. The cursor doesn't
+// move.
+func (r *ansiRenderer) eraseInLine(mode int) {
+	switch mode {
+	case 0:
+		if r.column < len(r.line) {
+			r.line = r.line[:r.column]
+		}
+	case 1:
+		for i := 0; i <= r.column && i < len(r.line); i++ {
+			r.line[i] = cell{c: ' '}
+		}
+	case 2:
+		r.line = nil
+	}
+}
+
+// escape handles the escape sequence at the start of ansi, and returns its
+// length. Sequences the renderer doesn't understand are dropped.
+func (r *ansiRenderer) escape(ansi string) int {
+	if len(ansi) < 2 {
+		return len(ansi)
+	}
+
+	switch ansi[1] {
+	case '[':
+		end := 2
+		for end < len(ansi) && (ansi[end] < 0x40 || ansi[end] > 0x7e) {
+			end++
+		}
+		if end == len(ansi) {
+			return len(ansi)
+		}
+		parameters := ansi[2:end]
+		if !strings.ContainsAny(parameters, "<=>?") {
+			r.control(ansi[end], parseParameters(parameters))
+		}
+		return end + 1
+	case ']':
+		for end := 2; end < len(ansi); end++ {
+			if ansi[end] == '\a' {
+				return end + 1
+			}
+			if ansi[end] == '\x1b' && end+1 < len(ansi) && ansi[end+1] == '\\' {
+				return end + 2
 			}
 		}
-		return match
+		return len(ansi)
+	}
+	return 2
+}
+
+// control handles a CSI sequence. Moving the cursor up or down can't be
+// shown in a log that only grows, so those sequences are ignored.
+func (r *ansiRenderer) control(command byte, params []int) {
+	n := 1
+	if len(params) > 0 && params[0] > 0 {
+		n = params[0]
+	}
+	if n > maxLineWidth {
+		n = maxLineWidth
+	}
+
+	switch command {
+	case 'm':
+		r.style.apply(params)
+	case 'K':
+		mode := 0
+		if len(params) > 0 {
+			mode = params[0]
+		}
+		r.eraseInLine(mode)
+	case 'C':
+		r.moveTo(r.column + n)
+	case 'D':
+		r.moveTo(r.column - n)
+	case 'G':
+		r.moveTo(n - 1)
+	}
+}
+
+// parseParameters splits the parameters of a CSI sequence. Missing
+// parameters, as in "\x1b[;1m", are zero.
+func parseParameters(parameters string) []int {
+	if parameters == "" {
+		return nil
+	}
+	var params []int
+	for _, p := range strings.Split(strings.Replace(parameters, ":", ";", -1), ";") {
+		n, _ := strconv.Atoi(p)
+		params = append(params, n)
+	}
+	return params
+}
+
+func (r *ansiRenderer) endLine() {
+	text := make([]rune, len(r.line))
+	for i, cell := range r.line {
+		text[i] = cell.c
+	}
+
+	if marker, ok := parseFoldMarker(string(text)); ok {
+		if marker.start {
+			r.startFold(marker)
+		} else if !r.endFold(marker) {
+			r.renderLine(`<div class="line"` + r.foldAttribute() + `>`)
+		}
+	} else {
+		r.renderLine(`<div class="line"` + r.foldAttribute() + `>`)
+	}
+
+	r.line = nil
+	r.column = 0
+}
+
+func (r *ansiRenderer) foldAttribute() string {
+	if len(r.folds) == 0 {
+		return ""
+	}
+	ids := make([]string, len(r.folds))
+	for i, fold := range r.folds {
+		ids[i] = strconv.Itoa(fold.Id)
+	}
+	return ` data-fold="` + strings.Join(ids, " ") + `"`
+}
+
+func (r *ansiRenderer) startFold(marker foldMarker) {
+	r.lastFold++
+	r.output.WriteString(fmt.Sprintf(
+		`<div class="line fold-start"%v data-fold-start="%d">%v</div>`,
+		r.foldAttribute(), r.lastFold, escapeHtml(marker.name),
+	))
+	r.folds = append(r.folds, openFold{
+		Id:      r.lastFold,
+		Name:    marker.name,
+		Started: marker.time,
 	})
-	ansi = strings.Replace(ansi, "\x1b[0m", "</span>", -1)
+}
+
+// endFold closes the innermost open fold with the same name as the marker,
+// along with any folds inside it that were never closed. It returns false
+// if there is no fold to close.
+func (r *ansiRenderer) endFold(marker foldMarker) bool {
+	for i := len(r.folds) - 1; i >= 0; i-- {
+		fold := r.folds[i]
+		if fold.Name != marker.name {
+			continue
+		}
+
+		duration := ""
+		if fold.Started != 0 && marker.time >= fold.Started {
+			duration = fmt.Sprintf(` data-duration="%v"`, formatFoldDuration(marker.time-fold.Started))
+		}
+		r.output.WriteString(fmt.Sprintf(
+			`<div class="line fold-end"%v data-fold-end="%d"%v></div>`,
+			r.foldAttribute(), fold.Id, duration,
+		))
+		r.folds = r.folds[:i]
+		return true
+	}
+	return false
+}
+
+func formatFoldDuration(milliseconds int64) string {
+	duration := time.Duration(milliseconds) * time.Millisecond
+	if duration < time.Second {
+		return duration.String()
+	}
+	return duration.Round(100 * time.Millisecond).String()
+}
 
-	ansi = strings.Replace(ansi, "\n", `</div><div class="line">`, -1)
-	ansi = `<div class="line">` + ansi + "</div>"
+func (r *ansiRenderer) renderLine(div string) {
+	r.output.WriteString(div)
+	for start := 0; start < len(r.line); {
+		style := r.line[start].style
+		end := start
+		var text strings.Builder
+		for ; end < len(r.line) && r.line[end].style == style; end++ {
+			text.WriteString(escapeHtml(string(r.line[end].c)))
+		}
 
-	return ansi
+		if span := style.span(); span != "" {
+			r.output.WriteString(span + text.String() + "</span>")
+		} else {
+			r.output.WriteString(text.String())
+		}
+		start = end
+	}
+	r.output.WriteString("</div>")
+}
+
+func escapeHtml(text string) string {
+	text = strings.Replace(text, `&`, `&amp;`, -1)
+	text = strings.Replace(text, `>`, `&gt;`, -1)
+	return strings.Replace(text, `<`, `&lt;`, -1)
+}
+
+func AnsiToHtml(ansi string) string {
+	r := &ansiRenderer{}
+	r.write(ansi)
+	r.endLine()
+	return r.output.String()
 }
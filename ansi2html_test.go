@@ -49,3 +49,176 @@ func TestAddsClickableLines(t *testing.T) {
 		t.Errorf("\nExpected:\n%v\nGot:\n%v\n", expected, AnsiToHtml(ansi))
 	}
 }
+
+func TestAnsiToHtml(t *testing.T) {
+	tests := []struct {
+		name     string
+		ansi     string
+		expected string
+	}{
+		{
+			name:     "combined codes",
+			ansi:     "\x1b[1;31mFAIL\x1b[0m",
+			expected: `<div class="line"><span class="red" style="font-weight: bold;">FAIL</span></div>`,
+		},
+		{
+			name:     "codes that used to match the wrong colour",
+			ansi:     "\x1b[3mitalic\x1b[0m \x1b[4munderlined\x1b[0m",
+			expected: `<div class="line"><span style="font-style: italic;">italic</span> <span style="text-decoration: underline;">underlined</span></div>`,
+		},
+		{
+			name:     "256 colours",
+			ansi:     "\x1b[38;5;208morange\x1b[38;5;9m bright red\x1b[0m",
+			expected: `<div class="line"><span style="color: #ff8700;">orange</span><span class="bright-red"> bright red</span></div>`,
+		},
+		{
+			name:     "truecolour",
+			ansi:     "\x1b[38;2;255;100;0mtruecolour\x1b[0m",
+			expected: `<div class="line"><span style="color: #ff6400;">truecolour</span></div>`,
+		},
+		{
+			name:     "backgrounds",
+			ansi:     "\x1b[41m red \x1b[48;5;236m dark \x1b[101m bright \x1b[49mnone",
+			expected: `<div class="line"><span class="bg-red"> red </span><span style="background-color: #303030;"> dark </span><span class="bg-bright-red"> bright </span>none</div>`,
+		},
+		{
+			name:     "partial resets",
+			ansi:     "\x1b[1;4;32mA\x1b[22mB\x1b[24;39mC",
+			expected: `<div class="line"><span class="green" style="font-weight: bold; text-decoration: underline;">A</span><span class="green" style="text-decoration: underline;">B</span>C</div>`,
+		},
+		{
+			name:     "inverse",
+			ansi:     "\x1b[7;34mselected\x1b[27m",
+			expected: `<div class="line"><span class="inverse bg-blue">selected</span></div>`,
+		},
+		{
+			name:     "unbalanced resets",
+			ansi:     "\x1b[0mplain\x1b[0m\x1b[0m",
+			expected: `<div class="line">plain</div>`,
+		},
+		{
+			name:     "styles carry over lines",
+			ansi:     "\x1b[33mone\ntwo\x1b[0m\nthree",
+			expected: `<div class="line"><span class="yellow">one</span></div><div class="line"><span class="yellow">two</span></div><div class="line">three</div>`,
+		},
+		{
+			name:     "other escape sequences are dropped",
+			ansi:     "\x1b]0;title\x07\x1b[?25lworking\x1b[?25h",
+			expected: `<div class="line">working</div>`,
+		},
+		{
+			name: "go test",
+			ansi: "=== RUN   TestBuild\n" +
+				"\x1b[31m--- FAIL: TestBuild (0.01s)\x1b[0m\n" +
+				"\x1b[32mok  \x1b[0m\tgithub.com/AndrewVos/builder\t0.204s",
+			expected: `<div class="line">=== RUN   TestBuild</div>` +
+				`<div class="line"><span class="red">--- FAIL: TestBuild (0.01s)</span></div>` +
+				`<div class="line"><span class="green">ok  </span>	github.com/AndrewVos/builder	0.204s</div>`,
+		},
+		{
+			name: "rspec",
+			ansi: "\x1b[32m.\x1b[0m\x1b[31mF\x1b[0m\n" +
+				"\x1b[31mFailure/Error: \x1b[0m\x1b[31mexpect(build.result).to eq(\"pass\")\x1b[0m\n" +
+				"\x1b[31mrspec ./spec/build_spec.rb:12\x1b[0m \x1b[36m# Build passes\x1b[0m",
+			expected: `<div class="line"><span class="green">.</span><span class="red">F</span></div>` +
+				`<div class="line"><span class="red">Failure/Error: expect(build.result).to eq("pass")</span></div>` +
+				`<div class="line"><span class="red">rspec ./spec/build_spec.rb:12</span> <span class="cyan"># Build passes</span></div>`,
+		},
+		{
+			name: "npm",
+			ansi: "\x1b[37;40mnpm\x1b[0m \x1b[0m\x1b[30;43mWARN\x1b[0m \x1b[0m\x1b[35mdeprecated\x1b[0m request@2.88.2: request has been deprecated\n" +
+				"\x1b[37;40mnpm\x1b[0m \x1b[0m\x1b[31;40mERR!\x1b[0m \x1b[0m\x1b[35mcode\x1b[0m ELIFECYCLE",
+			expected: `<div class="line"><span class="white bg-black">npm</span> <span class="black bg-yellow">WARN</span> <span class="magenta">deprecated</span> request@2.88.2: request has been deprecated</div>` +
+				`<div class="line"><span class="white bg-black">npm</span> <span class="red bg-black">ERR!</span> <span class="magenta">code</span> ELIFECYCLE</div>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := AnsiToHtml(test.ansi)
+			if actual != test.expected {
+				t.Errorf("\nExpected:\n%v\nGot:\n%v\n", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestAnsiToHtmlEmulatesTerminalLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		ansi     string
+		expected string
+	}{
+		{
+			name:     "carriage return line endings",
+			ansi:     "one\r\ntwo\r\n",
+			expected: `<div class="line">one</div><div class="line">two</div><div class="line"></div>`,
+		},
+		{
+			name: "curl progress",
+			ansi: "  0  1024    0     0    0     0 --:--:--\r" +
+				" 50  1024  512   512    0     0  0:00:01\r" +
+				"100  1024 1024  1024    0     0  0:00:02\n",
+			expected: `<div class="line">100  1024 1024  1024    0     0  0:00:02</div><div class="line"></div>`,
+		},
+		{
+			name:     "shorter overwrites leave the rest of the line",
+			ansi:     "Downloading 100%\rDone",
+			expected: `<div class="line">Doneloading 100%</div>`,
+		},
+		{
+			name:     "npm spinner",
+			ansi:     "⠙ fetchMetadata: sill resolveWithNewModule\x1b[K\r⠹ extract: sill extract\x1b[K\r\x1b[Kadded 1 package",
+			expected: `<div class="line">added 1 package</div>`,
+		},
+		{
+			name:     "erase to the start of the line",
+			ansi:     "abcdef\x1b[3D\x1b[1Kx",
+			expected: `<div class="line">   xef</div>`,
+		},
+		{
+			name:     "erase the whole line",
+			ansi:     "abc\x1b[2K\rdef",
+			expected: `<div class="line">def</div>`,
+		},
+		{
+			name:     "cursor forward",
+			ansi:     "a\x1b[3Cb",
+			expected: `<div class="line">a   b</div>`,
+		},
+		{
+			name:     "cursor to column",
+			ansi:     "abcdef\x1b[2GX",
+			expected: `<div class="line">aXcdef</div>`,
+		},
+		{
+			name:     "cursor movement stops at the maximum line width",
+			ansi:     "a\x1b[99999999999GX\x1b[99999999999CY",
+			expected: `<div class="line">a` + strings.Repeat(" ", maxLineWidth-2) + `XY</div>`,
+		},
+		{
+			name:     "backspace",
+			ansi:     "ab\bc",
+			expected: `<div class="line">ac</div>`,
+		},
+		{
+			name:     "overwrites keep the style of each character",
+			ansi:     "\x1b[31mred text\x1b[0m\rplain",
+			expected: `<div class="line">plain<span class="red">ext</span></div>`,
+		},
+		{
+			name:     "moving up and down is ignored",
+			ansi:     "layer 1: Downloading\nlayer 2: Downloading\n\x1b[2A\x1b[2K\rlayer 1: Pull complete\x1b[2B",
+			expected: `<div class="line">layer 1: Downloading</div><div class="line">layer 2: Downloading</div><div class="line">layer 1: Pull complete</div>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := AnsiToHtml(test.ansi)
+			if actual != test.expected {
+				t.Errorf("\nExpected:\n%q\nGot:\n%q\n", test.expected, actual)
+			}
+		})
+	}
+}
@@ -1,5 +1,5 @@
 window.autoScroll = true;
-window.downloadedOutputBytes = 0;
+window.outputCursor = "";
 window.scrolledToHash = false;
 
 $(document).ready(function() {
@@ -7,7 +7,11 @@ $(document).ready(function() {
 });
 
 $(document).on("click", ".line", function() {
-  selectLine($(this));
+  if ($(this).hasClass("fold-start")) {
+    toggleFold($(this));
+  } else {
+    selectLine($(this));
+  }
 });
 
 $(window).scroll(function() {
@@ -19,6 +23,7 @@ $(window).scroll(function() {
 });
 
 function selectLine(element) {
+  expandFoldsAround(element);
   hash = "#line" + element.index();
   location.hash = hash;
   $(".line.focused").removeClass("focused");
@@ -27,10 +32,12 @@ function selectLine(element) {
 }
 
 function update() {
-  $.getJSON("/build/" + $("#build_id").val() + "/output/raw?start=" + window.downloadedOutputBytes, function(data) {
+  $.getJSON("/build/" + $("#build_id").val() + "/output/raw?cursor=" + encodeURIComponent(window.outputCursor), function(data) {
+    window.outputCursor = data.cursor;
     if (data.output != "") {
-      window.downloadedOutputBytes += data.length;
-      $("#output").append($(data.output));
+      var lines = $(data.output);
+      $("#output").append(lines);
+      endFolds(lines.filter(".fold-end"));
       if (window.scrolledToHash == false && location.hash != "") {
         window.scrolledToHash = true;
         index = location.hash.replace("#line", "");
@@ -43,7 +50,15 @@ function update() {
       }
       updateScroller();
     }
-    setTimeout(update, 1000);
+    if (data.complete) {
+      if (!data.success) {
+        showFailedFolds();
+      }
+      loadTests();
+      loadCoverage();
+    } else {
+      setTimeout(update, 1000);
+    }
   });
 }
 
@@ -65,3 +80,156 @@ function updateScroller() {
     }
   });
 }
+
+function foldStart(id) {
+  return $(".fold-start[data-fold-start='" + id + "']");
+}
+
+function foldIds(line) {
+  var folds = line.attr("data-fold");
+  return folds ? folds.split(" ") : [];
+}
+
+// Folds that end have passed, so they're collapsed.
+function endFolds(ends) {
+  ends.each(function() {
+    var end = $(this);
+    var start = foldStart(end.attr("data-fold-end"));
+    start.addClass("fold-ended");
+    if (end.attr("data-duration")) {
+      start.append($("<span class='duration'></span>").text(end.attr("data-duration")));
+    }
+    start.addClass("collapsed");
+    refreshFold(end.attr("data-fold-end"));
+  });
+}
+
+// When a build fails, the folds that never ended are where it failed.
+function showFailedFolds() {
+  $(".fold-start").not(".fold-ended").each(function() {
+    $(this).addClass("failed").removeClass("collapsed");
+    refreshFold($(this).attr("data-fold-start"));
+  });
+}
+
+function toggleFold(start) {
+  start.toggleClass("collapsed");
+  refreshFold(start.attr("data-fold-start"));
+}
+
+function expandFoldsAround(line) {
+  $.each(foldIds(line), function(i, id) {
+    foldStart(id).removeClass("collapsed");
+    refreshFold(id);
+  });
+}
+
+// A line is shown when none of the folds it is in are collapsed.
+function refreshFold(id) {
+  $("#output .line[data-fold~='" + id + "']").each(function() {
+    var line = $(this);
+    var hidden = false;
+    $.each(foldIds(line), function(i, fold) {
+      hidden = hidden || foldStart(fold).hasClass("collapsed");
+    });
+    line.toggleClass("folded", hidden);
+  });
+}
+
+function loadTests() {
+  var buildId = $("#build_id").val();
+  $.getJSON("/build/" + buildId + "/tests", function(data) {
+    var summary = data.summary;
+    if (summary == null) {
+      return;
+    }
+
+    var panel = $("<div class='panel'><div class='panel-heading'></div></div>");
+    panel.addClass(summary.Failed > 0 ? "panel-danger" : "panel-success");
+    panel.find(".panel-heading")
+      .text(summary.Total + " tests, " + summary.Failed + " failed, " + summary.Skipped +
+        " skipped in " + (summary.Duration / 1e9).toFixed(1) + "s ")
+      .append($("<a>history</a>").attr("href", "/build/" + buildId + "/tests/history"));
+
+    var packages = $("<ul class='list-group packages'></ul>");
+    var failures = $("<ul class='list-group'></ul>");
+    $.each(data.results, function(i, result) {
+      if (result.Name == "") {
+        packages.append(packageResult(result));
+      } else if (result.Status == "failed") {
+        failures.append(testFailure(result));
+      }
+    });
+    panel.append(packages);
+    panel.append(failures);
+
+    $("#tests").empty().append(panel);
+  });
+}
+
+var testStatusLabels = {
+  passed: "<span class='label label-success'>ok</span>",
+  failed: "<span class='label label-danger'>FAIL</span>",
+  skipped: "<span class='label label-default'>skip</span>"
+};
+
+function packageResult(result) {
+  var item = $("<li class='list-group-item'></li>");
+  item.append($(testStatusLabels[result.Status]));
+  item.append($("<span></span>").text(" " + result.ClassName + " " + (result.Duration / 1e9).toFixed(2) + "s"));
+  if (result.Status == "failed") {
+    item.append($("<pre></pre>").text(result.Message));
+  }
+  return item;
+}
+
+// Failing tests link to the line of the output they start on.
+function testFailure(result) {
+  var item = $("<li class='list-group-item'></li>");
+  var name = $("<strong></strong>").text(result.ClassName + " " + result.Name);
+  if (result.Line > 0) {
+    var link = $("<a></a>").attr("href", "#line" + (result.Line - 1)).append(name);
+    link.click(function(event) {
+      event.preventDefault();
+      selectLine($($("#output .line").get(result.Line - 1)));
+    });
+    item.append(link);
+  } else {
+    item.append(name);
+  }
+  item.append($("<pre></pre>").text(result.Message));
+  return item;
+}
+
+function loadCoverage() {
+  var buildId = $("#build_id").val();
+  $.getJSON("/build/" + buildId + "/coverage", function(data) {
+    var summary = data.summary;
+    if (summary == null) {
+      return;
+    }
+
+    var panel = $("<div class='panel panel-default'><div class='panel-heading'></div></div>");
+    var heading = panel.find(".panel-heading");
+    heading.text(summary.percent.toFixed(1) + "% coverage ");
+    if (data.delta != null) {
+      var delta = $("<span class='coverage-delta'></span>")
+        .text((data.delta >= 0 ? "+" : "") + data.delta.toFixed(1) + "% compared to " + data.base.ref + " ")
+        .addClass(data.delta < 0 ? "text-danger" : "text-success");
+      heading.append(delta);
+    }
+    heading.append($("<a>trend</a>").attr("href", "/build/" + buildId + "/coverage/trend"));
+
+    var files = $("<table class='table table-condensed coverage-files'></table>");
+    $.each(data.files, function(i, file) {
+      var percent = file.Total == 0 ? 0 : file.Covered * 100 / file.Total;
+      var row = $("<tr></tr>");
+      row.append($("<td></td>").text(file.Path));
+      row.append($("<td class='percent'></td>").text(percent.toFixed(1) + "%"));
+      files.append(row);
+    });
+    panel.append(files);
+
+    $("#coverage").empty().append(panel);
+  });
+}
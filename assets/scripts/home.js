@@ -1,50 +1,109 @@
 $(document).ready(function() {
-  update()
+  update();
   setInterval(update, 1000);
+  $("#more_builds").click(loadMore);
 });
 
+// The filters in the url of the page, which every request for builds keeps.
+function filters() {
+  return $("#filters").val();
+}
+
+function buildsUrl(before) {
+  var params = filters();
+  if (before) {
+    params += (params == "" ? "" : "&") + "before=" + before;
+  }
+  return "/builds" + (params == "" ? "" : "?" + params);
+}
+
+// filterUrl narrows the current search down by another filter.
+function filterUrl(name, value) {
+  var params = filters();
+  params += (params == "" ? "" : "&") + name + "=" + encodeURIComponent(value);
+  return "/?" + params;
+}
+
+var nextBuilds = null;
+
+// update refreshes the newest page of builds, adding any new ones to the top.
 function update() {
-  $.getJSON("/builds", function(data) {
-    $.each(data, function(i, build) {
-      var container = $("#builds");
-
-      if ($("#"+build.Id).length == 0) {
-        var commits = "";
-
-        if (build.Commits != null && build.Commits.length > 0) {
-          for (i = 0; i < build.Commits.length; i++) {
-            var commit = build.Commits[i];
-            commits += "<div>";
-            commits += '<span class="label label-info">' + commit.Sha.slice(0,7) + '</span>';
-            commits += "<span> " + commit.Message + "</span>";
-            commits += "</div>";
-          }
-        }
-
-        var html = "<div id='"+build.Id+"' class='build'>" +
-          "<h2>" +
-            "<div class='ball-container'><div class='ball'></div></div>" +
-            "<a href='" + build.Url + "'>" +
-              build.Repository + "/" + build.Ref +
-            "</a>" +
-          "</h2>" +
-            commits +
-            "<div><a href='" + build.GithubUrl + "'>View on Github</a></div>" +
-        "</div>";
-
-        container.prepend($(html));
-      }
-      var buildLine = $("#" + build.Id);
-      if (build.Complete == true) {
-        buildLine.removeClass("blue");
-        if (build.Success == true) {
-          buildLine.addClass("green");
-        } else {
-          buildLine.addClass("red")
-        }
-      } else {
-        buildLine.addClass("blue")
-      }
+  $.getJSON(buildsUrl(), function(data) {
+    if (nextBuilds == null) {
+      setNext(data.next);
+    }
+    $.each(data.builds.slice().reverse(), function(i, build) {
+      showBuild(build, function(html) {
+        $("#builds").prepend(html);
+      });
     });
   });
 }
+
+function loadMore() {
+  $.getJSON(buildsUrl(nextBuilds), function(data) {
+    setNext(data.next);
+    $.each(data.builds, function(i, build) {
+      showBuild(build, function(html) {
+        $("#builds").append(html);
+      });
+    });
+  });
+}
+
+function setNext(next) {
+  nextBuilds = next;
+  $("#more_builds").toggle(next != 0);
+}
+
+function showBuild(build, insert) {
+  if ($("#"+build.Id).length == 0) {
+    var commits = "";
+
+    if (build.Commits != null && build.Commits.length > 0) {
+      for (i = 0; i < build.Commits.length; i++) {
+        var commit = build.Commits[i];
+        commits += "<div>";
+        commits += '<span class="label label-info">' + $("<span>").text(commit.Sha.slice(0,7)).html() + '</span>';
+        commits += "<span> " + $("<span>").text(commit.Message).html() + "</span>";
+        commits += "</div>";
+      }
+    }
+
+    var html = $("<div class='build'>" +
+      "<h2>" +
+        "<div class='ball-container'><div class='ball'></div></div>" +
+      "</h2>" +
+      "<div class='build-filters'></div>" +
+        commits +
+        "<div class='build-source'></div>" +
+    "</div>").attr("id", build.Id);
+    html.find("h2").append($("<a></a>").attr("href", build.Url).text(build.Repository + "/" + build.Ref));
+    html.find(".build-source").append($("<a></a>").attr("href", build.GithubUrl).text("View on Github"));
+
+    var links = html.find(".build-filters");
+    links.append($("<a></a>").attr("href", "/" + build.Owner + "/" + build.Repository).text(build.Owner + "/" + build.Repository));
+    links.append($("<a></a>").attr("href", filterUrl("branch", build.Ref)).text(build.Ref));
+    if (build.Author != "") {
+      var author = $("<a></a>").attr("href", filterUrl("author", build.Author)).attr("title", build.Author);
+      if (build.AuthorAvatarUrl != "") {
+        author.append($("<img class='avatar' alt=''>").attr("src", build.AuthorAvatarUrl)).append(" ");
+      }
+      author.append($("<span></span>").text(build.AuthorName != "" ? build.AuthorName : build.Author));
+      links.append(author);
+    }
+
+    insert(html);
+  }
+  var buildLine = $("#" + build.Id);
+  if (build.Complete == true) {
+    buildLine.removeClass("blue");
+    if (build.Success == true) {
+      buildLine.addClass("green");
+    } else {
+      buildLine.addClass("red")
+    }
+  } else {
+    buildLine.addClass("blue")
+  }
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/kr/pty"
 	"io"
 	"io/ioutil"
+	"log"
 	"os"
 	"os/exec"
 	"strconv"
+	"sync"
+	"syscall"
+	"time"
 )
 
 type Build struct {
@@ -18,12 +23,38 @@ type Build struct {
 	Owner        string
 	Repository   string
 	Ref          string
+	BaseRef      string
+	Author       string
+	Trigger      string
 	Sha          string
 	Complete     bool
 	Success      bool
 	Result       string
 	GithubUrl    string
+	CreatedAt    time.Time
+	FinishedAt   time.Time
 	Commits      []Commit
+	Tests        *TestSummary
+	Coverage     *CoverageSummary
+
+	// Host is the name of the source host of the build's repository. It's
+	// set when a build is launched and loaded from the repository after
+	// that, rather than stored with the build.
+	Host string
+
+	// PullRequestClosed is set on the builds of a pull request once it has
+	// been closed.
+	PullRequestClosed bool
+
+	// Pruned is set once the retention policy has deleted the workspace
+	// and log of the build.
+	Pruned bool
+
+	// AuthorName and AuthorAvatarUrl are from the profile of the author's
+	// account, when they have logged in to builder. They're filled in by
+	// findBuildAuthors rather than stored with the build.
+	AuthorName      string
+	AuthorAvatarUrl string
 }
 
 type Commit struct {
@@ -34,27 +65,66 @@ type Commit struct {
 	Url     string
 }
 
+// runningBuilds are the builds this process is running, so that they can
+// be cancelled.
+var runningBuilds = struct {
+	sync.Mutex
+	cancels map[int]context.CancelFunc
+}{cancels: map[int]context.CancelFunc{}}
+
+// cancelBuild stops a build that this process is running. It returns false
+// when the build isn't running here.
+func cancelBuild(id int) bool {
+	runningBuilds.Lock()
+	defer runningBuilds.Unlock()
+
+	cancel, ok := runningBuilds.cancels[id]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
 func (build *Build) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	runningBuilds.Lock()
+	runningBuilds.cancels[build.Id] = cancel
+	runningBuilds.Unlock()
+	defer func() {
+		runningBuilds.Lock()
+		delete(runningBuilds.cancels, build.Id)
+		runningBuilds.Unlock()
+		cancel()
+	}()
+	build.reportStatus(statePending)
+
 	err := os.MkdirAll(build.Path(), 0700)
 	if err != nil {
 		build.fail()
 		return
 	}
 
-	output, err := os.Create(build.LogPath())
+	logWriter, err := logStore.Create(build)
 	if err != nil {
 		build.fail()
 		return
 	}
-	defer output.Close()
+	output := newFoldStamper(logWriter)
 
 	err = build.checkout(output)
-	if err != nil {
-		build.fail()
-		return
+	if err == nil {
+		err = build.execute(ctx, output)
+		build.collectReports(output)
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintln(output, "Build cancelled")
+	}
+
+	output.Close()
+	if err := logStore.Complete(build); err != nil {
+		log.Println("Error completing log:", err)
 	}
 
-	err = build.execute(output)
 	if err != nil {
 		build.fail()
 		return
@@ -62,14 +132,24 @@ func (build *Build) start() {
 	build.pass()
 }
 
-func (build *Build) checkout(output *os.File) error {
-	repository := database.FindRepository(build.Owner, build.Repository)
+func (build *Build) checkout(output io.Writer) error {
+	repository, err := database.FindRepository(context.Background(), build.Host, build.Owner, build.Repository)
+	if err != nil {
+		fmt.Fprintln(output, "Error finding repository")
+		return err
+	}
 	if repository == nil {
 		return errors.New("Don't have access to build this project")
 	}
-	url := "https://" + repository.Account.AccessToken + "@github.com/" + build.Owner + "/" + build.Repository
+	token, err := repository.Account.HostToken()
+	if err != nil {
+		fmt.Fprintln(output, "Error decrypting access token")
+		return err
+	}
+	host := sourceHost(repository.Host)
+	url := host.CloneUrl(build.Owner, build.Repository)
 
-	err := git.Retrieve(output, url, build.SourcePath(), build.Ref, build.Sha)
+	err = git.Retrieve(output, url, host.CloneCredentials(token), build.SourcePath(), build.Ref, build.Sha)
 	if err != nil {
 		fmt.Fprintln(output, err)
 		return err
@@ -90,18 +170,48 @@ func (build *Build) environs() []string {
 	}
 }
 
-func (build *Build) execute(output *os.File) error {
-	cmd := exec.Command("bash", "./Builderfile")
+// inheritedEnvironment are the variables builds inherit from builder's own
+// environment. Nothing else is passed on, so secrets like TOKEN_KEY and the
+// database url can't be read by a Builderfile.
+var inheritedEnvironment = []string{
+	"PATH",
+	"HOME",
+	"USER",
+	"LOGNAME",
+	"SHELL",
+	"TERM",
+	"TMPDIR",
+	"TZ",
+	"LANG",
+	"LANGUAGE",
+	"LC_ALL",
+	"LC_CTYPE",
+}
+
+// environment is the whole environment of the Builderfile and hooks of
+// a build.
+func (build *Build) environment() []string {
+	env := build.environs()
+	for _, name := range inheritedEnvironment {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+func (build *Build) execute(ctx context.Context, output io.Writer) error {
+	cmd := exec.CommandContext(ctx, "bash", "./Builderfile")
+	// pty.Start runs the Builderfile in a session of its own, so cancelling
+	// kills everything it started rather than just bash.
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
 	cmd.Dir = build.SourcePath()
 	cmd.Stdout = output
 	cmd.Stderr = output
 
-	customEnv := build.environs()
-
-	for _, c := range os.Environ() {
-		customEnv = append(customEnv, c)
-	}
-	cmd.Env = customEnv
+	cmd.Env = build.environment()
 
 	f, err := pty.Start(cmd)
 	if err != nil {
@@ -121,7 +231,11 @@ func (build *Build) pass() {
 	build.Complete = true
 	build.Success = true
 	build.Result = "pass"
-	database.SaveBuild(build)
+	build.FinishedAt = time.Now().UTC().Truncate(time.Second)
+	if err := database.SaveBuild(context.Background(), build); err != nil {
+		log.Println("Error saving build:", err)
+	}
+	build.reportStatus(stateSuccess)
 	build.executeHooks()
 }
 
@@ -129,22 +243,55 @@ func (build *Build) fail() {
 	build.Complete = true
 	build.Success = false
 	build.Result = "fail"
-	database.SaveBuild(build)
+	build.FinishedAt = time.Now().UTC().Truncate(time.Second)
+	if err := database.SaveBuild(context.Background(), build); err != nil {
+		log.Println("Error saving build:", err)
+	}
+	build.reportStatus(stateFailure)
 	build.executeHooks()
 }
 
+// reportStatus shows the state of the build next to its commit on the
+// repository's source host. Builds are run whether or not the host hears
+// about them.
+func (build *Build) reportStatus(state CommitState) {
+	if build.Sha == "" {
+		return
+	}
+	repository, err := database.FindRepository(context.Background(), build.Host, build.Owner, build.Repository)
+	if err != nil {
+		log.Println("Error finding repository:", err)
+		return
+	}
+	if repository == nil || repository.Account == nil {
+		return
+	}
+	token, err := repository.Account.HostToken()
+	if err != nil {
+		log.Println("Error decrypting access token:", err)
+		return
+	}
+	err = sourceHost(repository.Host).SetStatus(token, build.Owner, build.Repository, build.Sha, state, build.Url)
+	if err != nil {
+		log.Println("Error reporting build status:", err)
+	}
+}
+
+// Duration is how long a finished build took.
+func (build *Build) Duration() time.Duration {
+	if build.FinishedAt.IsZero() {
+		return 0
+	}
+	return build.FinishedAt.Sub(build.CreatedAt)
+}
+
 func (build *Build) executeHooks() {
 	hooks, _ := ioutil.ReadDir("data/hooks")
 	for _, file := range hooks {
 		cmd := exec.Command("bash", "../../../data/hooks/"+file.Name())
 		cmd.Dir = build.Path()
 
-		customEnv := build.environs()
-
-		for _, c := range os.Environ() {
-			customEnv = append(customEnv, c)
-		}
-		cmd.Env = customEnv
+		cmd.Env = build.environment()
 		output, err := cmd.CombinedOutput()
 		if err != nil {
 			fmt.Println(err)
@@ -153,6 +300,14 @@ func (build *Build) executeHooks() {
 	}
 }
 
+func buildUrl(build *Build) string {
+	url := configuration.Host
+	if configuration.Port != "80" {
+		url += ":" + configuration.Port
+	}
+	return url + "/build/" + strconv.Itoa(build.Id) + "/output"
+}
+
 func (b *Build) Path() string {
 	return "data/builds/" + strconv.Itoa(b.Id)
 }
@@ -166,7 +321,7 @@ func (b *Build) SourcePath() string {
 }
 
 func (build *Build) ReadOutput() string {
-	b, err := ioutil.ReadFile(build.LogPath())
+	b, err := logStore.ReadFrom(build, 0)
 	if err != nil {
 		fmt.Println(err)
 	}
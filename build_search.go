@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	triggerPush        = "push"
+	triggerPullRequest = "pull_request"
+)
+
+// The home page shows this many builds at a time.
+const buildsPerPage = 25
+
+// BuildFilter narrows down the builds SearchBuilds returns. Fields that
+// are empty match every build.
+type BuildFilter struct {
+	// Repository is either a repository name or owner/name.
+	Repository string
+	Branch     string
+	// Result is one of pass, fail or incomplete.
+	Result  string
+	Author  string
+	Trigger string
+	// Since and Until are the range of days builds were created in. Until
+	// includes the whole of its day.
+	Since time.Time
+	Until time.Time
+	// Query finds builds with the text in their repository, branch, author
+	// or commit messages.
+	Query string
+	// Before continues a search from the Next of the previous page.
+	Before int
+	Limit  int
+}
+
+// BuildPage is a page of builds, newest first. Next is where the following
+// page starts, or zero on the last page.
+type BuildPage struct {
+	Builds []*Build
+	Next   int
+}
+
+// buildFilterParams are the url params a BuildFilter is read from, in the
+// order filter chips are shown.
+var buildFilterParams = []string{"repository", "branch", "result", "author", "trigger", "since", "until", "q"}
+
+const buildFilterDate = "2006-01-02"
+
+func parseBuildFilter(values url.Values) (BuildFilter, error) {
+	filter := BuildFilter{
+		Repository: strings.TrimSpace(values.Get("repository")),
+		Branch:     strings.TrimSpace(values.Get("branch")),
+		Result:     values.Get("result"),
+		Author:     strings.TrimSpace(values.Get("author")),
+		Trigger:    values.Get("trigger"),
+		Query:      strings.TrimSpace(values.Get("q")),
+		Limit:      buildsPerPage,
+	}
+
+	switch filter.Result {
+	case "", "pass", "fail", "incomplete":
+	default:
+		return BuildFilter{}, fmt.Errorf("Unknown result %q", filter.Result)
+	}
+	switch filter.Trigger {
+	case "", triggerPush, triggerPullRequest:
+	default:
+		return BuildFilter{}, fmt.Errorf("Unknown trigger %q", filter.Trigger)
+	}
+
+	var err error
+	if since := values.Get("since"); since != "" {
+		if filter.Since, err = time.Parse(buildFilterDate, since); err != nil {
+			return BuildFilter{}, fmt.Errorf("Invalid since date %q", since)
+		}
+	}
+	if until := values.Get("until"); until != "" {
+		if filter.Until, err = time.Parse(buildFilterDate, until); err != nil {
+			return BuildFilter{}, fmt.Errorf("Invalid until date %q", until)
+		}
+	}
+	if before := values.Get("before"); before != "" {
+		if filter.Before, err = strconv.Atoi(before); err != nil || filter.Before <= 0 {
+			return BuildFilter{}, fmt.Errorf("Invalid cursor %q", before)
+		}
+	}
+	return filter, nil
+}
+
+// values are the url params that give the same search, without the cursor.
+func (filter BuildFilter) values() url.Values {
+	values := url.Values{}
+	set := func(name string, value string) {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	set("repository", filter.Repository)
+	set("branch", filter.Branch)
+	set("result", filter.Result)
+	set("author", filter.Author)
+	set("trigger", filter.Trigger)
+	if !filter.Since.IsZero() {
+		set("since", filter.Since.Format(buildFilterDate))
+	}
+	if !filter.Until.IsZero() {
+		set("until", filter.Until.Format(buildFilterDate))
+	}
+	set("q", filter.Query)
+	return values
+}
+
+// createdBefore is the end of the date range, the start of the day after
+// Until.
+func (filter BuildFilter) createdBefore() time.Time {
+	return filter.Until.AddDate(0, 0, 1)
+}
+
+// matches is the filter for builds that are already in memory. messages are
+// the messages of the build's commits.
+func (filter BuildFilter) matches(build Build, messages []string) bool {
+	if filter.Before != 0 && build.Id >= filter.Before {
+		return false
+	}
+	if filter.Repository != "" &&
+		!strings.EqualFold(filter.Repository, build.Repository) &&
+		!strings.EqualFold(filter.Repository, build.Owner+"/"+build.Repository) {
+		return false
+	}
+	if filter.Branch != "" && filter.Branch != build.Ref {
+		return false
+	}
+	if filter.Result != "" && filter.Result != build.Result {
+		return false
+	}
+	if filter.Author != "" && !strings.EqualFold(filter.Author, build.Author) {
+		return false
+	}
+	if filter.Trigger != "" && filter.Trigger != build.Trigger {
+		return false
+	}
+	if !filter.Since.IsZero() && build.CreatedAt.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && !build.CreatedAt.Before(filter.createdBefore()) {
+		return false
+	}
+	if filter.Query != "" {
+		query := strings.ToLower(filter.Query)
+		found := false
+		for _, text := range append([]string{build.Repository, build.Ref, build.Author}, messages...) {
+			found = found || strings.Contains(strings.ToLower(text), query)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// page cuts builds, newest first, down to the filter's limit.
+func (filter BuildFilter) page(builds []*Build) *BuildPage {
+	page := &BuildPage{Builds: builds}
+	if filter.Limit > 0 && len(builds) > filter.Limit {
+		page.Builds = builds[:filter.Limit]
+		page.Next = page.Builds[filter.Limit-1].Id
+	}
+	return page
+}
+
+type buildFilterChip struct {
+	Name  string
+	Value string
+	// RemoveUrl is the same search without this filter.
+	RemoveUrl string
+}
+
+// chips are the filters that are set, each with a link to remove it.
+func (filter BuildFilter) chips() []buildFilterChip {
+	values := filter.values()
+	var chips []buildFilterChip
+	for _, name := range buildFilterParams {
+		value := values.Get(name)
+		if value == "" {
+			continue
+		}
+		without := filter.values()
+		without.Del(name)
+		removeUrl := "/"
+		if len(without) > 0 {
+			removeUrl += "?" + without.Encode()
+		}
+		if name == "q" {
+			name = "search"
+		}
+		chips = append(chips, buildFilterChip{Name: name, Value: value, RemoveUrl: removeUrl})
+	}
+	return chips
+}
+
+// quickFilters are links that narrow the search down by result or trigger.
+func (filter BuildFilter) quickFilters() []map[string]string {
+	var links []map[string]string
+	add := func(label string, name string, value string) {
+		values := filter.values()
+		if values.Get(name) != "" {
+			return
+		}
+		values.Set(name, value)
+		links = append(links, map[string]string{"label": label, "url": "/?" + values.Encode()})
+	}
+	add("passed", "result", "pass")
+	add("failed", "result", "fail")
+	add("running", "result", "incomplete")
+	add("pushes", "trigger", triggerPush)
+	add("pull requests", "trigger", triggerPullRequest)
+	return links
+}
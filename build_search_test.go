@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseBuildFilter(t *testing.T) {
+	values, _ := url.ParseQuery("repository=AndrewVos/builder&branch=master&result=fail&author=AndrewVos&trigger=pull_request&since=2026-10-01&until=2026-10-19&q=flaky&before=40")
+	filter, err := parseBuildFilter(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := BuildFilter{
+		Repository: "AndrewVos/builder",
+		Branch:     "master",
+		Result:     "fail",
+		Author:     "AndrewVos",
+		Trigger:    triggerPullRequest,
+		Since:      time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+		Until:      time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+		Query:      "flaky",
+		Before:     40,
+		Limit:      buildsPerPage,
+	}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Expected filter:\n%+v\nGot:\n%+v", expected, filter)
+	}
+
+	values.Del("before")
+	if filter.values().Encode() != values.Encode() {
+		t.Errorf("Expected the filter to keep its url params, got %v", filter.values().Encode())
+	}
+}
+
+func TestParseBuildFilterRejectsInvalidParams(t *testing.T) {
+	for _, query := range []string{"result=maybe", "trigger=cron", "since=yesterday", "until=2026-13-01", "before=-1"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := parseBuildFilter(values); err == nil {
+			t.Errorf("Expected an error parsing %q", query)
+		}
+	}
+}
+
+func TestBuildFilterMatchesTheWholeOfTheLastDay(t *testing.T) {
+	filter := BuildFilter{
+		Since: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+		Until: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+	}
+	for created, matches := range map[time.Time]bool{
+		time.Date(2026, 9, 30, 23, 59, 59, 0, time.UTC):  false,
+		time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC):     true,
+		time.Date(2026, 10, 19, 23, 59, 59, 0, time.UTC): true,
+		time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC):    false,
+	} {
+		if filter.matches(Build{CreatedAt: created}, nil) != matches {
+			t.Errorf("Expected a build created at %v to match: %v", created, matches)
+		}
+	}
+}
+
+func TestBuildFilterChips(t *testing.T) {
+	filter := BuildFilter{Branch: "master", Query: "fix"}
+
+	expected := []buildFilterChip{
+		{Name: "branch", Value: "master", RemoveUrl: "/?q=fix"},
+		{Name: "search", Value: "fix", RemoveUrl: "/?branch=master"},
+	}
+	if chips := filter.chips(); !reflect.DeepEqual(chips, expected) {
+		t.Errorf("Expected chips:\n%+v\nGot:\n%+v", expected, chips)
+	}
+
+	filter = BuildFilter{Result: "pass"}
+	if chips := filter.chips(); len(chips) != 1 || chips[0].RemoveUrl != "/" {
+		t.Errorf("Expected removing the last filter to go home, got %+v", chips)
+	}
+}
+
+func TestBuildFilterQuickFiltersKeepTheSearch(t *testing.T) {
+	filter := BuildFilter{Branch: "master", Result: "fail"}
+
+	var urls []string
+	for _, link := range filter.quickFilters() {
+		urls = append(urls, link["url"])
+	}
+	expected := []string{"/?branch=master&result=fail&trigger=push", "/?branch=master&result=fail&trigger=pull_request"}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Expected quick filters %v, got %v", expected, urls)
+	}
+}
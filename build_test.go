@@ -1,11 +1,13 @@
 package main
 
 import (
-	"io/ioutil"
+	"context"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func cleanDataDirectory() {
@@ -13,16 +15,17 @@ func cleanDataDirectory() {
 }
 
 func TestBuildUrl(t *testing.T) {
-	database := &PostgresDatabase{}
+	resetMemoryDatabase()
+	ctx := context.Background()
 	account := &Account{}
-	database.CreateAccount(account)
+	database.CreateAccount(ctx, account)
 	repository := &Repository{Owner: "bla", Repository: "repooo"}
-	database.AddRepositoryToAccount(account, repository)
+	database.AddRepositoryToAccount(ctx, account, repository)
 	build := &Build{
 		Owner:      "bla",
 		Repository: "repooo",
 	}
-	database.CreateBuild(repository, build)
+	database.CreateBuild(ctx, repository, build)
 	expected := "http://localhost:1212/build/" + strconv.Itoa(build.Id) + "/output"
 	if build.Url != expected {
 		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, build.Url)
@@ -30,21 +33,22 @@ func TestBuildUrl(t *testing.T) {
 }
 
 func TestBuildUrlPort80(t *testing.T) {
-	database := &PostgresDatabase{}
+	resetMemoryDatabase()
+	ctx := context.Background()
 
 	oldPort := configuration.Port
 	configuration.Port = "80"
 	defer func() { configuration.Port = oldPort }()
 
 	account := &Account{}
-	database.CreateAccount(account)
+	database.CreateAccount(ctx, account)
 	repository := &Repository{Owner: "bla", Repository: "repooo"}
-	database.AddRepositoryToAccount(account, repository)
+	database.AddRepositoryToAccount(ctx, account, repository)
 	build := &Build{
 		Owner:      "bla",
 		Repository: "repooo",
 	}
-	database.CreateBuild(repository, build)
+	database.CreateBuild(ctx, repository, build)
 	expected := "http://localhost/build/" + strconv.Itoa(build.Id) + "/output"
 	if build.Url != expected {
 		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, build.Url)
@@ -55,10 +59,8 @@ func TestFailingBuild(t *testing.T) {
 	defer cleanDataDirectory()
 
 	fakeGit.FakeRepo = "red"
-	account := &Account{AccessToken: "sdsd"}
-	fakeDatabase.FindAccountByIdToReturn = account
-	repository := &Repository{Account: account, Owner: "some-owner", Repository: "some-repo"}
-	fakeDatabase.SavedRepository = repository
+	resetMemoryDatabase()
+	createAccountWithRepository(&Account{AccessToken: "sdsd"}, "some-owner", "some-repo")
 
 	build := &Build{Owner: "some-owner", Repository: "some-repo"}
 
@@ -74,9 +76,9 @@ func TestFailingBuild(t *testing.T) {
 		t.Error("Build should have failed")
 	}
 
-	buildOutput, _ := ioutil.ReadFile(build.LogPath())
-	if expected := "FAILING BUILD"; strings.Contains(string(buildOutput), expected) == false {
-		t.Errorf("Expected log to contain %q. Got:\n%v", expected, string(buildOutput))
+	buildOutput := build.ReadOutput()
+	if expected := "FAILING BUILD"; strings.Contains(buildOutput, expected) == false {
+		t.Errorf("Expected log to contain %q. Got:\n%v", expected, buildOutput)
 	}
 }
 
@@ -84,10 +86,8 @@ func TestPassingBuild(t *testing.T) {
 	defer cleanDataDirectory()
 
 	fakeGit.FakeRepo = "green"
-	account := &Account{AccessToken: "sdsd"}
-	fakeDatabase.FindAccountByIdToReturn = account
-	repository := &Repository{Account: account, Owner: "some-owner", Repository: "some-repo"}
-	fakeDatabase.SavedRepository = repository
+	resetMemoryDatabase()
+	createAccountWithRepository(&Account{AccessToken: "sdsd"}, "some-owner", "some-repo")
 	build := &Build{Owner: "some-owner", Repository: "some-repo"}
 
 	build.start()
@@ -102,9 +102,87 @@ func TestPassingBuild(t *testing.T) {
 		t.Error("Build should have passed")
 	}
 
-	buildOutput, _ := ioutil.ReadFile(build.LogPath())
-	if expected := "SUCCESSFUL BUILD"; strings.Contains(string(buildOutput), expected) == false {
-		t.Errorf("Expected log to contain %q. Got:\n%v", expected, string(buildOutput))
+	buildOutput := build.ReadOutput()
+	if expected := "SUCCESSFUL BUILD"; strings.Contains(buildOutput, expected) == false {
+		t.Errorf("Expected log to contain %q. Got:\n%v", expected, buildOutput)
+	}
+}
+
+func TestBuildChecksOutFromTheConfiguredGitHost(t *testing.T) {
+	defer cleanDataDirectory()
+	resetFakeGit()
+	fakeGit.FakeRepo = "green"
+	resetMemoryDatabase()
+	configuration.GithubGitUrl = "https://github.example.com"
+	defer func() { configuration.GithubGitUrl = "https://github.com" }()
+
+	createAccountWithRepository(&Account{AccessToken: "token"}, "some-owner", "some-repo")
+	build := &Build{Owner: "some-owner", Repository: "some-repo"}
+	build.start()
+
+	if url := fakeGit.retrieveParameters["url"]; url != "https://github.example.com/some-owner/some-repo" {
+		t.Errorf("Expected to clone from Github Enterprise, got %q", url)
+	}
+}
+
+func TestBuildChecksOutWithoutTheTokenInTheUrl(t *testing.T) {
+	defer cleanDataDirectory()
+	resetFakeGit()
+	fakeGit.FakeRepo = "green"
+	resetMemoryDatabase()
+
+	withTokenKeys(testTokenKey, nil, func() {
+		encrypted, _ := encryptToken("secret-token")
+		createAccountWithRepository(&Account{AccessToken: encrypted}, "some-owner", "some-repo")
+		build := &Build{Owner: "some-owner", Repository: "some-repo"}
+
+		build.start()
+	})
+
+	if url := fakeGit.retrieveParameters["url"]; url != "https://github.com/some-owner/some-repo" {
+		t.Errorf("Expected the url to not have credentials, got %q", url)
+	}
+	if token := fakeGit.retrieveParameters["password"]; token != "secret-token" {
+		t.Errorf("Expected the decrypted token to be used, got %q", token)
+	}
+	if username := fakeGit.retrieveParameters["username"]; username != "x-access-token" {
+		t.Errorf("Expected Github's username for tokens, got %q", username)
+	}
+}
+
+func TestBuildChecksOutFromTheRepositorysHost(t *testing.T) {
+	defer cleanDataDirectory()
+	resetFakeGit()
+	fakeGit.FakeRepo = "green"
+	resetMemoryDatabase()
+
+	createAccountWithRepository(&Account{Id: -1, HostUserId: -1, AccessToken: "token", Host: "gitlab"}, "group/subgroup", "project")
+	build := &Build{Host: "gitlab", Owner: "group/subgroup", Repository: "project"}
+	build.start()
+
+	expected := map[string]string{
+		"url":      configuration.GitlabUrl + "/group/subgroup/project.git",
+		"username": "oauth2",
+		"password": "token",
+	}
+	if !reflect.DeepEqual(fakeGit.retrieveParameters, expected) {
+		t.Errorf("Expected to clone from GitLab, got %v", fakeGit.retrieveParameters)
+	}
+}
+
+func TestBuildReportsItsStatus(t *testing.T) {
+	defer cleanDataDirectory()
+	resetFakeGit()
+	fakeGit.FakeRepo = "green"
+	resetMemoryDatabase()
+
+	createAccountWithRepository(&Account{AccessToken: "token"}, "some-owner", "some-repo")
+	build := &Build{Owner: "some-owner", Repository: "some-repo", Sha: "abc123"}
+	build.start()
+
+	expected := []CommitState{statePending, stateSuccess}
+	if statuses := fakeGit.reportedStatuses(); !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("Expected %v, got %v", expected, statuses)
 	}
 }
 
@@ -112,10 +190,8 @@ func TestOutputEnvirons(t *testing.T) {
 	defer cleanDataDirectory()
 
 	fakeGit.FakeRepo = "environs"
-	account := &Account{AccessToken: "sdsd"}
-	fakeDatabase.FindAccountByIdToReturn = account
-	repository := &Repository{Account: account, Owner: "some-owner", Repository: "some-repo"}
-	fakeDatabase.SavedRepository = repository
+	resetMemoryDatabase()
+	createAccountWithRepository(&Account{AccessToken: "sdsd"}, "some-owner", "some-repo")
 	build := &Build{
 		Url:        " sdsdfsd",
 		Id:         23,
@@ -143,3 +219,66 @@ func TestOutputEnvirons(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildsDontInheritBuildersSecrets(t *testing.T) {
+	defer cleanDataDirectory()
+	t.Setenv("TOKEN_KEY", "a-secret-key")
+
+	fakeGit.FakeRepo = "environs"
+	resetMemoryDatabase()
+	repository := createAccountWithRepository(&Account{AccessToken: "sdsd"}, "some-owner", "some-repo")
+	build := &Build{Owner: "some-owner", Repository: "some-repo"}
+	database.CreateBuild(context.Background(), repository, build)
+
+	build.start()
+
+	actual := build.ReadOutput()
+	if strings.Contains(actual, "a-secret-key") || !strings.Contains(actual, "TOKEN_KEY=unset") {
+		t.Errorf("Expected TOKEN_KEY not to be visible to the build, got:\n%v", actual)
+	}
+	if !build.Success {
+		t.Errorf("Expected the build to pass, got:\n%v", actual)
+	}
+}
+
+func TestCancelBuild(t *testing.T) {
+	defer cleanDataDirectory()
+
+	fakeGit.FakeRepo = "slow"
+	resetMemoryDatabase()
+	repository := createAccountWithRepository(&Account{AccessToken: "sdsd"}, "some-owner", "some-repo")
+	build := &Build{Owner: "some-owner", Repository: "some-repo"}
+	database.CreateBuild(context.Background(), repository, build)
+
+	if cancelBuild(build.Id) {
+		t.Fatal("Expected a build that isn't running to not be cancelled")
+	}
+
+	done := make(chan bool)
+	go func() {
+		build.start()
+		close(done)
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for !strings.Contains(build.ReadOutput(), "STARTED") {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the build to start, got:\n%v", build.ReadOutput())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !cancelBuild(build.Id) {
+		t.Fatal("Expected the running build to be cancelled")
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the build to stop when it was cancelled")
+	}
+	if !build.Complete || build.Success {
+		t.Errorf("Expected a cancelled build to fail, got %+v", build)
+	}
+	if output := build.ReadOutput(); !strings.Contains(output, "Build cancelled") {
+		t.Errorf("Expected the output to say the build was cancelled, got:\n%v", output)
+	}
+}
@@ -1,21 +1,103 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"log"
 	"net/http"
-	"strconv"
+	"os"
 	"strings"
+	"time"
 )
 
-var githubDomain string = "https://api.github.com"
 var git GitTool
 
 func main() {
+	flag.BoolVar(&configuration.Development, "dev", false, "use an in-memory database and a local login instead of postgres and Github")
+	flag.Parse()
+
+	if configuration.Development {
+		database = NewMemoryDatabase()
+	} else {
+		db, err := openDatabase(configuration)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+		database = db
+
+		if flag.Arg(0) == "migrate" {
+			err := runMigrateCommand(context.Background(), os.Stdout, db, flag.Arg(1))
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		if configuration.TokenKey == "" {
+			log.Fatal("TOKEN_KEY has to be set to encrypt access tokens, or builder has to be run with --dev")
+		}
+		if flag.Arg(0) == "reencrypt-tokens" {
+			err := runReencryptTokensCommand(context.Background(), os.Stdout, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		err = migrateOnStartup(context.Background(), db)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	store, err := openLogStore(configuration)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logStore = store
+
+	if configuration.SessionSecret == "" {
+		log.Println("SESSION_SECRET isn't set, so everyone will be logged out when builder restarts")
+		configuration.SessionSecret, err = randomToken()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if _, err := tokenKeys(); err != nil {
+		log.Fatal(err)
+	}
+	if configuration.GitlabClientID != "" && configuration.GitlabWebhookSecret == "" {
+		log.Println("GITLAB_WEBHOOK_SECRET isn't set, so webhooks from GitLab are rejected")
+	}
+	if configuration.GiteaClientID != "" && configuration.GiteaWebhookSecret == "" {
+		log.Println("GITEA_WEBHOOK_SECRET isn't set, so webhooks from Gitea are rejected")
+	}
+
 	deleteIncompleteBuilds()
+	go pruneBuilds(configuration.Retention)
+	go pruneSessions()
+	go syncCollaboratorsEvery(configuration.CollaboratorSync)
 	serve()
 }
 
+func pruneBuilds(policy RetentionPolicy) {
+	for {
+		err := policy.Prune(context.Background(), database, logStore)
+		if err != nil {
+			log.Println("Error pruning old builds:", err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
 func deleteIncompleteBuilds() {
-	for _, build := range database.IncompleteBuilds() {
+	builds, err := database.IncompleteBuilds(context.Background())
+	if err != nil {
+		log.Println("Error finding incomplete builds:", err)
+		return
+	}
+	for _, build := range builds {
 		if build.Complete == false {
 			build.fail()
 		}
@@ -39,19 +121,3 @@ func serveFile(filename string) {
 		http.ServeFile(w, r, filename)
 	})
 }
-
-func currentAccount(r *http.Request) *Account {
-	account_id, _ := r.Cookie("account_id")
-	token, _ := r.Cookie("token")
-
-	if account_id == nil || token == nil {
-		return nil
-	}
-
-	id, _ := strconv.Atoi(account_id.Value)
-	if database.LoginExists(id, token.Value) {
-		account := database.FindAccountById(id)
-		return account
-	}
-	return nil
-}
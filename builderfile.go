@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Builderfiles configure builder with comments, one directive per line:
+//
+//	# builder: junit reports/*.xml
+const builderfileDirectivePrefix = "# builder:"
+
+// readBuilderfileDirectives returns the arguments of every directive in
+// the Builderfile, keyed by directive name.
+func readBuilderfileDirectives(sourcePath string) (map[string][]string, error) {
+	file, err := os.Open(filepath.Join(sourcePath, "Builderfile"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	directives := map[string][]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, builderfileDirectivePrefix) {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, builderfileDirectivePrefix))
+		if len(fields) == 0 {
+			continue
+		}
+		directives[fields[0]] = append(directives[fields[0]], strings.Join(fields[1:], " "))
+	}
+	return directives, scanner.Err()
+}
+
+// globSourcePath returns the paths relative to sourcePath of the files that
+// match pattern, leaving out anything outside of sourcePath.
+func globSourcePath(sourcePath string, pattern string) ([]string, error) {
+	root, err := filepath.Abs(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(filepath.Join(root, pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, match := range matches {
+		if strings.HasPrefix(match, root+string(filepath.Separator)) {
+			paths = append(paths, strings.TrimPrefix(match, root+string(filepath.Separator)))
+		}
+	}
+	return paths, nil
+}
+
+// collectReports saves the test results and coverage from the reports the
+// Builderfile points at.
+func (build *Build) collectReports(output io.Writer) {
+	directives, err := readBuilderfileDirectives(build.SourcePath())
+	if err != nil {
+		return
+	}
+	build.collectTestResults(output, directives)
+	build.collectCoverage(output, directives)
+}
+
+// readReports calls read with every report that matches the globs of a
+// directive. Reports that can't be read are mentioned in the build output.
+func (build *Build) readReports(output io.Writer, directives map[string][]string, directive string, read func(report io.Reader) error) {
+	for _, pattern := range directives[directive] {
+		paths, err := globSourcePath(build.SourcePath(), pattern)
+		if err != nil {
+			fmt.Fprintf(output, "Error finding %v reports %q: %v\n", directive, pattern, err)
+			continue
+		}
+		for _, path := range paths {
+			file, err := os.Open(filepath.Join(build.SourcePath(), path))
+			if err == nil {
+				err = read(file)
+				file.Close()
+			}
+			if err != nil {
+				fmt.Fprintf(output, "Error reading %v report %v: %v\n", directive, path, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadBuilderfileDirectives(t *testing.T) {
+	directory, _ := ioutil.TempDir("", "builderfile")
+	defer os.RemoveAll(directory)
+
+	ioutil.WriteFile(filepath.Join(directory, "Builderfile"), []byte(
+		"#!/bin/bash\n"+
+			"# builder: junit reports/*.xml\n"+
+			"  # builder: junit  spec/reports/*.xml \n"+
+			"# builder:\n"+
+			"# a comment about the builder\n"+
+			"make test\n",
+	), 0700)
+
+	directives, err := readBuilderfileDirectives(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"junit": []string{"reports/*.xml", "spec/reports/*.xml"},
+	}
+	if !reflect.DeepEqual(directives, expected) {
+		t.Errorf("Expected directives %v, got %v", expected, directives)
+	}
+}
+
+func TestGlobSourcePathStaysInTheSourcePath(t *testing.T) {
+	directory, _ := ioutil.TempDir("", "builderfile")
+	defer os.RemoveAll(directory)
+
+	source := filepath.Join(directory, "source")
+	os.MkdirAll(filepath.Join(source, "reports"), 0700)
+	ioutil.WriteFile(filepath.Join(source, "reports", "junit.xml"), nil, 0600)
+	ioutil.WriteFile(filepath.Join(directory, "secret.xml"), nil, 0600)
+
+	paths, err := globSourcePath(source, "reports/*.xml")
+	if err != nil || !reflect.DeepEqual(paths, []string{filepath.Join("reports", "junit.xml")}) {
+		t.Errorf("Expected to find reports/junit.xml, got %v, %v", paths, err)
+	}
+
+	paths, err = globSourcePath(source, "../*.xml")
+	if err != nil || len(paths) != 0 {
+		t.Errorf("Expected files outside of the source path to be left out, got %v, %v", paths, err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// syncCollaborators replaces the collaborations on a repository with its
+// collaborators on its source host, using the access token of owner.
+// Collaborations are left alone when the host can't be asked, so nobody
+// loses access because of an outage.
+func syncCollaborators(ctx context.Context, owner *Account, repository *Repository) error {
+	token, err := owner.HostToken()
+	if err != nil {
+		return err
+	}
+	collaborators, err := sourceHost(repository.Host).RepositoryCollaborators(token, repository.Owner, repository.Repository)
+	if err != nil {
+		return err
+	}
+
+	roles := map[int]Role{}
+	for _, collaborator := range collaborators {
+		roles[collaborator.Id] = collaborator.Role()
+	}
+	return database.ReplaceCollaborations(ctx, repository.Id, roles)
+}
+
+// syncAllCollaborators syncs the collaborators of every repository with the
+// account that added it. A repository that fails doesn't stop the others.
+func syncAllCollaborators(ctx context.Context) error {
+	repositories, err := database.AllRepositories(ctx)
+	if err != nil {
+		return err
+	}
+	for _, repository := range repositories {
+		owner, err := database.FindAccountById(ctx, repository.AccountId)
+		if err != nil {
+			return err
+		}
+		if owner == nil {
+			continue
+		}
+		err = syncCollaborators(ctx, owner, repository)
+		if err != nil {
+			log.Printf("Error syncing collaborators on %v/%v: %v\n", repository.Owner, repository.Repository, err)
+		}
+	}
+	return nil
+}
+
+func syncCollaboratorsEvery(interval time.Duration) {
+	for {
+		err := syncAllCollaborators(context.Background())
+		if err != nil {
+			log.Println("Error syncing collaborators:", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// syncCollaboratorsHandler lets admins of a repository sync its collaborators
+// without waiting for the next periodic sync.
+func syncCollaboratorsHandler(w http.ResponseWriter, r *http.Request) {
+	repository := findRepositoryWithRole(w, r, roleAdmin)
+	if repository == nil {
+		return
+	}
+
+	owner := findRepositoryOwner(w, r, repository)
+	if owner == nil {
+		return
+	}
+	err := syncCollaborators(r.Context(), owner, repository)
+	if err != nil {
+		fmt.Println("Error syncing collaborators:", err)
+		http.Error(w, "Couldn't get the collaborators from "+sourceHost(repository.Host).Name(), 502)
+		return
+	}
+	http.Redirect(w, r, repository.Url(), 302)
+}
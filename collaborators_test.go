@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// fakeGithubCollaborators serves the collaborators of every repository as
+// pages of a single collaborator, like Github does when there are many.
+func fakeGithubCollaborators(collaborators []string) *httptest.Server {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := 0
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		if page < len(collaborators)-1 {
+			w.Header().Set("Link", fmt.Sprintf(`<%v%v?page=%v>; rel="next"`, ts.URL, r.URL.Path, page+1))
+		}
+		w.Write([]byte("[" + collaborators[page] + "]"))
+	}))
+	return ts
+}
+
+// assertRoles checks the roles of the Github users with the ids in expected,
+// logging them in first when they don't have accounts yet.
+func assertRoles(t *testing.T, repository *Repository, expected map[int]Role) {
+	ctx := context.Background()
+	for userId, role := range expected {
+		if account, _ := database.FindAccountById(ctx, userId); account == nil {
+			database.CreateAccount(ctx, &Account{Id: userId, HostUserId: userId})
+		}
+		actual, _ := database.RepositoryRole(ctx, userId, repository.Id)
+		if actual != role {
+			t.Errorf("Expected user %d to have role %q, got %q", userId, role, actual)
+		}
+	}
+}
+
+func TestSyncCollaboratorsReplacesCollaborationsWithEveryPageFromGithub(t *testing.T) {
+	resetMemoryDatabase()
+	owner := &Account{Id: 1, HostUserId: 1, AccessToken: "TOKEN"}
+	repository := createAccountWithRepository(owner, "owner", "repo")
+	database.SaveCollaboration(context.Background(), 2, repository.Id, roleWrite)
+
+	ts := fakeGithubCollaborators([]string{
+		`{ "id": 3, "login": "writer", "permissions": { "push": true, "pull": true } }`,
+		`{ "id": 4, "login": "reader", "permissions": { "pull": true } }`,
+	})
+	defer ts.Close()
+	sourceHosts["github"] = Github{}
+	defer resetFakeGit()
+
+	withFakedGithubApiDomain(ts.URL, func() {
+		if err := syncCollaborators(context.Background(), owner, repository); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	assertRoles(t, repository, map[int]Role{1: roleAdmin, 2: roleNone, 3: roleWrite, 4: roleRead})
+}
+
+func TestSyncCollaboratorsKeepsCollaborationsWhenGithubFails(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	owner := &Account{Id: 1, HostUserId: 1, AccessToken: "TOKEN"}
+	repository := createAccountWithRepository(owner, "owner", "repo")
+	database.SaveCollaboration(context.Background(), 2, repository.Id, roleWrite)
+	fakeGit.CollaboratorsError = errors.New("Bad credentials")
+
+	if err := syncCollaborators(context.Background(), owner, repository); err == nil {
+		t.Errorf("Expected the Github error to be returned")
+	}
+	assertRoles(t, repository, map[int]Role{2: roleWrite})
+}
+
+func TestSyncAllCollaboratorsUsesTheTokenOfTheAccountThatAddedEachRepository(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	first := createAccountWithRepository(&Account{Id: 1, HostUserId: 1, AccessToken: "TOKEN"}, "owner", "first")
+	second := createAccountWithRepository(&Account{Id: 2, HostUserId: 2, AccessToken: "TOKEN"}, "owner", "second")
+	fakeGit.CollaboratorsToReturn = []Collaborator{{Id: 5, Login: "collaborator"}}
+
+	if err := syncAllCollaborators(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertRoles(t, first, map[int]Role{5: roleRead})
+	assertRoles(t, second, map[int]Role{5: roleRead})
+}
+
+func TestSyncCollaboratorsHandlerNeedsAdminAccess(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	_, members := createOrganizationBuild()
+	fakeGit.CollaboratorsToReturn = []Collaborator{{Id: 10, Login: "newcomer"}}
+	query := "?" + url.Values{":owner": {"acme"}, ":repo": {"widgets"}}.Encode()
+	repository, _ := database.FindRepository(context.Background(), "github", "acme", "widgets")
+
+	for _, role := range []Role{roleRead, roleWrite} {
+		w := httptest.NewRecorder()
+		syncCollaboratorsHandler(w, postForm(loginRequest("POST", "/acme/widgets/collaborators/sync"+query, members[role]), url.Values{}))
+		if w.Code != 403 {
+			t.Errorf("Expected %v to get 403, got %v", role, w.Code)
+		}
+	}
+	assertRoles(t, repository, map[int]Role{10: roleNone})
+
+	w := httptest.NewRecorder()
+	syncCollaboratorsHandler(w, postForm(loginRequest("POST", "/acme/widgets/collaborators/sync"+query, members[roleAdmin]), url.Values{}))
+	if w.Code != 302 {
+		t.Errorf("Expected the admin to be redirected, got %v", w.Code)
+	}
+	assertRoles(t, repository, map[int]Role{10: roleRead})
+}
+
+func TestSyncCollaboratorsHandlerShowsGithubErrors(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	_, members := createOrganizationBuild()
+	fakeGit.CollaboratorsError = errors.New("Bad credentials")
+	query := "?" + url.Values{":owner": {"acme"}, ":repo": {"widgets"}}.Encode()
+
+	w := httptest.NewRecorder()
+	syncCollaboratorsHandler(w, postForm(loginRequest("POST", "/acme/widgets/collaborators/sync"+query, members[roleAdmin]), url.Values{}))
+	if w.Code != 502 {
+		t.Errorf("Expected a bad gateway, got %v", w.Code)
+	}
+}
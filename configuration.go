@@ -2,13 +2,48 @@ package main
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Configuration struct {
-	GithubClientID     string
-	GithubClientSecret string
-	Host               string
-	Port               string
+	GithubClientID         string
+	GithubClientSecret     string
+	GithubApiUrl           string
+	GithubWebUrl           string
+	GithubGitUrl           string
+	GitlabClientID         string
+	GitlabClientSecret     string
+	GitlabUrl              string
+	GitlabWebhookSecret    string
+	GiteaClientID          string
+	GiteaClientSecret      string
+	GiteaUrl               string
+	GiteaWebhookSecret     string
+	Host                   string
+	Port                   string
+	DatabaseDriver         string
+	DatabaseURL            string
+	DatabaseMaxConnections int
+	DatabaseTimeout        time.Duration
+	Development            bool
+	LogStore               string
+	S3Endpoint             string
+	S3Bucket               string
+	S3Region               string
+	S3AccessKeyId          string
+	S3SecretAccessKey      string
+	S3Prefix               string
+	Retention              RetentionPolicy
+	SessionSecret          string
+	SessionLifetime        time.Duration
+	CollaboratorSync       time.Duration
+
+	// TokenKey encrypts the access tokens of accounts. OldTokenKeys
+	// can still decrypt tokens after the key has been rotated.
+	TokenKey     string
+	OldTokenKeys []string
 }
 
 func (c Configuration) PostgresPassword() string {
@@ -22,16 +57,97 @@ var configuration Configuration
 
 func init() {
 	configuration = Configuration{
-		GithubClientID:     os.Getenv("GITHUB_CLIENT_ID"),
-		GithubClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
-		Host:               os.Getenv("HOST"),
-		Port:               os.Getenv("PORT"),
+		GithubClientID:      os.Getenv("GITHUB_CLIENT_ID"),
+		GithubClientSecret:  os.Getenv("GITHUB_CLIENT_SECRET"),
+		GithubApiUrl:        strings.TrimRight(os.Getenv("GITHUB_API_URL"), "/"),
+		GithubWebUrl:        strings.TrimRight(os.Getenv("GITHUB_WEB_URL"), "/"),
+		GithubGitUrl:        strings.TrimRight(os.Getenv("GITHUB_GIT_URL"), "/"),
+		GitlabClientID:      os.Getenv("GITLAB_CLIENT_ID"),
+		GitlabClientSecret:  os.Getenv("GITLAB_CLIENT_SECRET"),
+		GitlabUrl:           strings.TrimRight(os.Getenv("GITLAB_URL"), "/"),
+		GitlabWebhookSecret: os.Getenv("GITLAB_WEBHOOK_SECRET"),
+		GiteaClientID:       os.Getenv("GITEA_CLIENT_ID"),
+		GiteaClientSecret:   os.Getenv("GITEA_CLIENT_SECRET"),
+		GiteaUrl:            strings.TrimRight(os.Getenv("GITEA_URL"), "/"),
+		GiteaWebhookSecret:  os.Getenv("GITEA_WEBHOOK_SECRET"),
+		Host:                os.Getenv("HOST"),
+		Port:                os.Getenv("PORT"),
+		DatabaseDriver:      os.Getenv("DATABASE_DRIVER"),
+		DatabaseURL:         os.Getenv("DATABASE_URL"),
+		LogStore:            os.Getenv("LOG_STORE"),
+		S3Endpoint:          os.Getenv("S3_ENDPOINT"),
+		S3Bucket:            os.Getenv("S3_BUCKET"),
+		S3Region:            os.Getenv("S3_REGION"),
+		S3AccessKeyId:       os.Getenv("S3_ACCESS_KEY_ID"),
+		S3SecretAccessKey:   os.Getenv("S3_SECRET_ACCESS_KEY"),
+		S3Prefix:            os.Getenv("S3_PREFIX"),
+		SessionSecret:       os.Getenv("SESSION_SECRET"),
+		TokenKey:            os.Getenv("TOKEN_KEY"),
 	}
 
+	if configuration.GithubApiUrl == "" {
+		configuration.GithubApiUrl = "https://api.github.com"
+	}
+	if configuration.GithubWebUrl == "" {
+		configuration.GithubWebUrl = "https://github.com"
+	}
+	if configuration.GithubGitUrl == "" {
+		configuration.GithubGitUrl = configuration.GithubWebUrl
+	}
+	if configuration.GitlabUrl == "" {
+		configuration.GitlabUrl = "https://gitlab.com"
+	}
 	if configuration.Host == "" {
 		configuration.Host = "http://localhost"
 	}
 	if configuration.Port == "" {
 		configuration.Port = "1212"
 	}
+	if configuration.DatabaseDriver == "" {
+		configuration.DatabaseDriver = "postgres"
+	}
+	if configuration.DatabaseURL == "" {
+		if configuration.DatabaseDriver == "sqlite" {
+			configuration.DatabaseURL = "data/builder.db"
+		} else {
+			configuration.DatabaseURL = "host=/var/run/postgresql dbname=builder sslmode=disable"
+		}
+	}
+
+	configuration.DatabaseMaxConnections, _ = strconv.Atoi(os.Getenv("DATABASE_MAX_CONNECTIONS"))
+	if configuration.DatabaseMaxConnections <= 0 {
+		configuration.DatabaseMaxConnections = 10
+	}
+
+	configuration.DatabaseTimeout, _ = time.ParseDuration(os.Getenv("DATABASE_TIMEOUT"))
+	if configuration.DatabaseTimeout <= 0 {
+		configuration.DatabaseTimeout = 5 * time.Second
+	}
+
+	if configuration.LogStore == "" {
+		configuration.LogStore = "file"
+	}
+	if configuration.S3Region == "" {
+		configuration.S3Region = "us-east-1"
+	}
+
+	days, _ := strconv.Atoi(os.Getenv("BUILD_RETENTION_DAYS"))
+	configuration.Retention.MaxAge = time.Duration(days) * 24 * time.Hour
+	configuration.Retention.MaxBuilds, _ = strconv.Atoi(os.Getenv("BUILD_RETENTION_COUNT"))
+	sessionDays, _ := strconv.Atoi(os.Getenv("SESSION_LIFETIME_DAYS"))
+	if sessionDays <= 0 {
+		sessionDays = 30
+	}
+	configuration.SessionLifetime = time.Duration(sessionDays) * 24 * time.Hour
+
+	configuration.CollaboratorSync, _ = time.ParseDuration(os.Getenv("COLLABORATOR_SYNC_INTERVAL"))
+	if configuration.CollaboratorSync <= 0 {
+		configuration.CollaboratorSync = time.Hour
+	}
+
+	for _, key := range strings.Split(os.Getenv("OLD_TOKEN_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			configuration.OldTokenKeys = append(configuration.OldTokenKeys, key)
+		}
+	}
 }
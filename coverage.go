@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FileCoverage is how much of one file the tests of a build covered.
+// Go coverprofiles count statements, other reports count lines.
+type FileCoverage struct {
+	Id      int
+	BuildId int
+	Path    string
+	Covered int
+	Total   int
+}
+
+type CoverageSummary struct {
+	Covered int
+	Total   int
+}
+
+func (summary *CoverageSummary) Percent() float64 {
+	if summary.Total == 0 {
+		return 0
+	}
+	return float64(summary.Covered) * 100 / float64(summary.Total)
+}
+
+func summariseCoverage(files []FileCoverage) *CoverageSummary {
+	if len(files) == 0 {
+		return nil
+	}
+	summary := &CoverageSummary{}
+	for _, file := range files {
+		summary.Covered += file.Covered
+		summary.Total += file.Total
+	}
+	return summary
+}
+
+// baseCoverageBuild returns the most recent build with coverage of the
+// branch a pull request build is merging into, from before the pull request
+// was built. builds are the builds of the repository, oldest first.
+func baseCoverageBuild(build *Build, builds []*Build) *Build {
+	if build.BaseRef == "" {
+		return nil
+	}
+	var base *Build
+	for _, b := range builds {
+		if b.Ref == build.BaseRef && b.Id < build.Id && b.Coverage != nil {
+			base = b
+		}
+	}
+	return base
+}
+
+// The coverage trend chart is drawn in a box this size.
+const (
+	coverageChartWidth  = 600
+	coverageChartHeight = 200
+)
+
+type coverageChartPoint struct {
+	Build   *Build
+	Percent float64
+	X       float64
+	Y       float64
+}
+
+// coverageChart places builds on the coverage trend chart, oldest on the
+// left, with 0% at the bottom and 100% at the top. Builds without coverage
+// are left off.
+func coverageChart(builds []*Build) []coverageChartPoint {
+	var points []coverageChartPoint
+	for _, build := range builds {
+		if build.Coverage != nil {
+			points = append(points, coverageChartPoint{Build: build, Percent: build.Coverage.Percent()})
+		}
+	}
+	for i := range points {
+		if len(points) > 1 {
+			points[i].X = float64(i) * coverageChartWidth / float64(len(points)-1)
+		}
+		points[i].Y = coverageChartHeight - points[i].Percent*coverageChartHeight/100
+	}
+	return points
+}
+
+// coverageReports are the Builderfile directives that point at coverage
+// reports, along with the parser for each kind of report.
+var coverageReports = []struct {
+	directive string
+	parse     func(report io.Reader) ([]FileCoverage, error)
+}{
+	{"coverprofile", parseGoCoverprofile},
+	{"cobertura", parseCobertura},
+	{"lcov", parseLCOV},
+}
+
+// collectCoverage saves the coverage from the reports the Builderfile
+// points at. When more than one report covers a file, the file keeps the
+// best coverage any of them had.
+func (build *Build) collectCoverage(output io.Writer, directives map[string][]string) {
+	files := map[string]FileCoverage{}
+	for _, report := range coverageReports {
+		build.readReports(output, directives, report.directive, func(r io.Reader) error {
+			reportFiles, err := report.parse(r)
+			if err != nil {
+				return err
+			}
+			for _, file := range reportFiles {
+				existing, ok := files[file.Path]
+				if !ok || file.Covered*existing.Total > existing.Covered*file.Total {
+					files[file.Path] = file
+				}
+			}
+			return nil
+		})
+	}
+
+	if len(files) == 0 {
+		return
+	}
+	var coverage []FileCoverage
+	for _, file := range files {
+		coverage = append(coverage, file)
+	}
+	sortCoverage(coverage)
+
+	err := database.SaveCoverage(context.Background(), build, coverage)
+	if err != nil {
+		log.Println("Error saving coverage:", err)
+	}
+}
+
+func sortCoverage(files []FileCoverage) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+}
+
+// parseGoCoverprofile reads the output of "go test -coverprofile". Blocks
+// that are listed more than once, as happens with -coverpkg, are covered if
+// any of them ran.
+func parseGoCoverprofile(report io.Reader) ([]FileCoverage, error) {
+	type block struct {
+		path       string
+		statements int
+		covered    bool
+	}
+	blocks := map[string]*block{}
+
+	scanner := bufio.NewScanner(report)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "mode:") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		colon := strings.LastIndex(fields[0], ":")
+		if len(fields) != 3 || colon == -1 {
+			return nil, fmt.Errorf("line %d isn't a coverprofile block: %q", line, text)
+		}
+		statements, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d has an invalid statement count: %q", line, text)
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d has an invalid count: %q", line, text)
+		}
+
+		b, ok := blocks[fields[0]]
+		if !ok {
+			b = &block{path: fields[0][:colon], statements: statements}
+			blocks[fields[0]] = b
+		}
+		b.covered = b.covered || count > 0
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	byPath := map[string]*FileCoverage{}
+	for _, b := range blocks {
+		file, ok := byPath[b.path]
+		if !ok {
+			file = &FileCoverage{Path: b.path}
+			byPath[b.path] = file
+		}
+		file.Total += b.statements
+		if b.covered {
+			file.Covered += b.statements
+		}
+	}
+	return coverageFiles(byPath), nil
+}
+
+func coverageFiles(byPath map[string]*FileCoverage) []FileCoverage {
+	var files []FileCoverage
+	for _, file := range byPath {
+		files = append(files, *file)
+	}
+	sortCoverage(files)
+	return files
+}
+
+// parseCobertura reads a Cobertura XML report. Files are made up of classes,
+// and a line is covered if any class covering it hit it.
+func parseCobertura(report io.Reader) ([]FileCoverage, error) {
+	var coverage struct {
+		Classes []struct {
+			Filename string `xml:"filename,attr"`
+			Lines    []struct {
+				Number int `xml:"number,attr"`
+				Hits   int `xml:"hits,attr"`
+			} `xml:"lines>line"`
+		} `xml:"packages>package>classes>class"`
+	}
+	if err := xml.NewDecoder(report).Decode(&coverage); err != nil {
+		return nil, err
+	}
+
+	lines := map[string]map[int]bool{}
+	for _, class := range coverage.Classes {
+		if lines[class.Filename] == nil {
+			lines[class.Filename] = map[int]bool{}
+		}
+		for _, line := range class.Lines {
+			lines[class.Filename][line.Number] = lines[class.Filename][line.Number] || line.Hits > 0
+		}
+	}
+	return lineCoverage(lines), nil
+}
+
+func lineCoverage(lines map[string]map[int]bool) []FileCoverage {
+	byPath := map[string]*FileCoverage{}
+	for path, fileLines := range lines {
+		file := &FileCoverage{Path: path, Total: len(fileLines)}
+		for _, covered := range fileLines {
+			if covered {
+				file.Covered++
+			}
+		}
+		byPath[path] = file
+	}
+	return coverageFiles(byPath)
+}
+
+// parseLCOV reads an LCOV tracefile. Line coverage comes from the DA
+// records, or from the LF and LH totals when a file has no DA records.
+func parseLCOV(report io.Reader) ([]FileCoverage, error) {
+	lines := map[string]map[int]bool{}
+	totals := map[string]*FileCoverage{}
+
+	var path string
+	scanner := bufio.NewScanner(report)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		record, value := text, ""
+		if i := strings.Index(text, ":"); i != -1 {
+			record, value = text[:i], text[i+1:]
+		}
+
+		switch record {
+		case "SF":
+			path = value
+			if lines[path] == nil {
+				lines[path] = map[int]bool{}
+			}
+		case "DA", "LF", "LH":
+			if path == "" {
+				return nil, fmt.Errorf("line %d has a %v record outside of a file", line, record)
+			}
+			fields := strings.Split(value, ",")
+			n, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d has an invalid %v record: %q", line, record, text)
+			}
+			if totals[path] == nil {
+				totals[path] = &FileCoverage{Path: path}
+			}
+			switch record {
+			case "DA":
+				hits := 0
+				if len(fields) > 1 {
+					hits, _ = strconv.Atoi(fields[1])
+				}
+				lines[path][n] = lines[path][n] || hits > 0
+			case "LF":
+				totals[path].Total += n
+			case "LH":
+				totals[path].Covered += n
+			}
+		case "end_of_record":
+			path = ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for path, fileLines := range lines {
+		if len(fileLines) == 0 {
+			delete(lines, path)
+		}
+	}
+	files := lineCoverage(lines)
+	for path, total := range totals {
+		if _, ok := lines[path]; !ok {
+			files = append(files, *total)
+		}
+	}
+	sortCoverage(files)
+	return files, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parseCoverageFile(t *testing.T, path string, parse func(io.Reader) ([]FileCoverage, error)) []FileCoverage {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	files, err := parse(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestParseGoCoverprofile(t *testing.T) {
+	files := parseCoverageFile(t, "test-data/coverage/cover.out", parseGoCoverprofile)
+	expected := []FileCoverage{
+		{Path: "github.com/AndrewVos/builder/build.go", Covered: 3, Total: 4},
+		{Path: "github.com/AndrewVos/builder/folds.go", Covered: 3, Total: 3},
+		{Path: "github.com/AndrewVos/builder/log_store.go", Covered: 0, Total: 4},
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected coverage:\n%+v\nGot:\n%+v", expected, files)
+	}
+}
+
+func TestParseGoCoverprofileRejectsOtherFiles(t *testing.T) {
+	_, err := parseGoCoverprofile(strings.NewReader("mode: set\nnot a coverprofile\n"))
+	if err == nil {
+		t.Error("Expected an error parsing something that isn't a coverprofile")
+	}
+}
+
+func TestParseCobertura(t *testing.T) {
+	files := parseCoverageFile(t, "test-data/coverage/cobertura.xml", parseCobertura)
+	expected := []FileCoverage{
+		{Path: "app/models.py", Covered: 3, Total: 4},
+		{Path: "app/views.py", Covered: 2, Total: 2},
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected coverage:\n%+v\nGot:\n%+v", expected, files)
+	}
+}
+
+func TestParseLCOV(t *testing.T) {
+	files := parseCoverageFile(t, "test-data/coverage/lcov.info", parseLCOV)
+	expected := []FileCoverage{
+		{Path: "src/index.js", Covered: 2, Total: 3},
+		{Path: "src/util.js", Covered: 5, Total: 10},
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected coverage:\n%+v\nGot:\n%+v", expected, files)
+	}
+}
+
+func TestBaseCoverageBuild(t *testing.T) {
+	coverage := &CoverageSummary{Covered: 1, Total: 2}
+	builds := []*Build{
+		{Id: 1, Ref: "master", Coverage: coverage},
+		{Id: 2, Ref: "master", Coverage: coverage},
+		{Id: 3, Ref: "master"},
+		{Id: 4, Ref: "feature", BaseRef: "master", Coverage: coverage},
+		{Id: 5, Ref: "master", Coverage: coverage},
+	}
+
+	if base := baseCoverageBuild(builds[3], builds); base != builds[1] {
+		t.Errorf("Expected the last covered build of master before the pull request, got %+v", base)
+	}
+	if base := baseCoverageBuild(builds[4], builds); base != nil {
+		t.Errorf("Expected builds that aren't pull requests not to have a base, got %+v", base)
+	}
+}
+
+func TestCoverageChart(t *testing.T) {
+	builds := []*Build{
+		{Id: 1, Coverage: &CoverageSummary{Covered: 1, Total: 2}},
+		{Id: 2},
+		{Id: 3, Coverage: &CoverageSummary{Covered: 1, Total: 1}},
+	}
+
+	points := coverageChart(builds)
+	if len(points) != 2 {
+		t.Fatalf("Expected builds without coverage to be left off, got %+v", points)
+	}
+	if points[0].X != 0 || points[0].Y != coverageChartHeight/2 {
+		t.Errorf("Expected 50%% on the left half way up, got %+v", points[0])
+	}
+	if points[1].X != coverageChartWidth || points[1].Y != 0 {
+		t.Errorf("Expected 100%% on the right at the top, got %+v", points[1])
+	}
+}
+
+func TestBuildCollectsCoverage(t *testing.T) {
+	defer cleanDataDirectory()
+
+	fakeGit.FakeRepo = "coverage"
+	resetMemoryDatabase()
+	createAccountWithRepository(&Account{AccessToken: "sdsd"}, "some-owner", "some-repo")
+	build := &Build{Owner: "some-owner", Repository: "some-repo"}
+
+	build.start()
+
+	files, _ := database.CoverageFiles(context.Background(), build)
+	expected := []FileCoverage{
+		{Path: "github.com/AndrewVos/builder/build.go", Covered: 3, Total: 4},
+		{Path: "github.com/AndrewVos/builder/folds.go", Covered: 3, Total: 3},
+		{Path: "github.com/AndrewVos/builder/log_store.go", Covered: 4, Total: 4},
+		{Path: "src/index.js", Covered: 2, Total: 3},
+		{Path: "src/util.js", Covered: 5, Total: 10},
+	}
+	for i := range files {
+		files[i].Id = 0
+		files[i].BuildId = 0
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected coverage:\n%+v\nGot:\n%+v", expected, files)
+	}
+
+	if output := build.ReadOutput(); strings.Contains(output, "Error") {
+		t.Errorf("Expected no errors collecting coverage, got:\n%v", output)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// canViewRepository follows the same rules as AllBuilds, along with public
+// repositories, which everyone can see.
+func canViewRepository(ctx context.Context, account *Account, repository *Repository) (bool, error) {
+	role, err := repositoryRole(ctx, account, repository)
+	return role.can(roleRead), err
+}
+
+// latestBuildsByBranch returns the most recent build of every branch,
+// newest first. builds are oldest first.
+func latestBuildsByBranch(builds []*Build) []*Build {
+	latest := map[string]*Build{}
+	for _, build := range builds {
+		latest[build.Ref] = build
+	}
+	return newestFirst(latest)
+}
+
+// openPullRequestBuilds returns the most recent build of every pull request
+// that hasn't been closed, newest first. builds are oldest first.
+func openPullRequestBuilds(builds []*Build) []*Build {
+	latest := map[string]*Build{}
+	for _, build := range builds {
+		if build.Trigger == triggerPullRequest {
+			latest[build.Ref] = build
+		}
+	}
+	for ref, build := range latest {
+		if build.PullRequestClosed {
+			delete(latest, ref)
+		}
+	}
+	return newestFirst(latest)
+}
+
+func newestFirst(byRef map[string]*Build) []*Build {
+	var builds []*Build
+	for _, build := range byRef {
+		builds = append(builds, build)
+	}
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].Id > builds[j].Id
+	})
+	return builds
+}
+
+// reversed returns builds in the opposite order, without changing builds.
+func reversed(builds []*Build) []*Build {
+	reversed := make([]*Build, len(builds))
+	for i, build := range builds {
+		reversed[len(builds)-1-i] = build
+	}
+	return reversed
+}
+
+// BuildStats are the pass rate and average duration of finished builds.
+type BuildStats struct {
+	Finished        int
+	Passed          int
+	AverageDuration time.Duration
+}
+
+func (stats BuildStats) PassRate() float64 {
+	if stats.Finished == 0 {
+		return 0
+	}
+	return float64(stats.Passed) * 100 / float64(stats.Finished)
+}
+
+func buildStats(builds []*Build) BuildStats {
+	var stats BuildStats
+	var total time.Duration
+	timed := 0
+	for _, build := range builds {
+		if !build.Complete {
+			continue
+		}
+		stats.Finished++
+		if build.Success {
+			stats.Passed++
+		}
+		if duration := build.Duration(); duration > 0 {
+			total += duration
+			timed++
+		}
+	}
+	if timed > 0 {
+		stats.AverageDuration = total / time.Duration(timed)
+	}
+	return stats
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func buildIds(builds []*Build) []int {
+	var ids []int
+	for _, build := range builds {
+		ids = append(ids, build.Id)
+	}
+	return ids
+}
+
+func TestLatestBuildsByBranch(t *testing.T) {
+	builds := []*Build{
+		{Id: 1, Ref: "master"},
+		{Id: 2, Ref: "feature"},
+		{Id: 3, Ref: "master"},
+	}
+	if ids := buildIds(latestBuildsByBranch(builds)); !reflect.DeepEqual(ids, []int{3, 2}) {
+		t.Errorf("Expected the latest build of each branch, newest first, got %v", ids)
+	}
+}
+
+func TestOpenPullRequestBuildsLeavesOutClosedPullRequests(t *testing.T) {
+	builds := []*Build{
+		{Id: 1, Ref: "feature", Trigger: triggerPullRequest},
+		{Id: 2, Ref: "feature", Trigger: triggerPush},
+		{Id: 3, Ref: "fix", Trigger: triggerPullRequest, PullRequestClosed: true},
+		{Id: 4, Ref: "other", Trigger: triggerPullRequest},
+	}
+	if ids := buildIds(openPullRequestBuilds(builds)); !reflect.DeepEqual(ids, []int{4, 1}) {
+		t.Errorf("Expected the open pull request builds, got %v", ids)
+	}
+}
+
+func TestBuildStats(t *testing.T) {
+	started := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	builds := []*Build{
+		{Complete: true, Success: true, CreatedAt: started, FinishedAt: started.Add(time.Minute)},
+		{Complete: true, Success: false, CreatedAt: started, FinishedAt: started.Add(3 * time.Minute)},
+		{Complete: true, Success: true, CreatedAt: started},
+		{Complete: false},
+	}
+
+	stats := buildStats(builds)
+	if stats.Finished != 3 || stats.Passed != 2 || stats.AverageDuration != 2*time.Minute {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if rate := stats.PassRate(); rate < 66.6 || rate > 66.7 {
+		t.Errorf("Expected two thirds of the builds to have passed, got %v", rate)
+	}
+}
+
+func TestCanViewRepository(t *testing.T) {
+	resetMemoryDatabase()
+	ctx := context.Background()
+
+	owner := &Account{Id: 1, HostUserId: 1}
+	repository := createAccountWithRepository(owner, "AndrewVos", "builder")
+	collaborator := &Account{Id: 2, HostUserId: 2}
+	database.CreateAccount(ctx, collaborator)
+	database.SaveCollaboration(ctx, collaborator.HostUserId, repository.Id, roleWrite)
+
+	for _, account := range []*Account{owner, collaborator, {Id: 3, HostUserId: 3}, nil} {
+		canView, err := canViewRepository(ctx, account, repository)
+		expected := account == owner || account == collaborator
+		if err != nil || canView != expected {
+			t.Errorf("Expected %+v to be able to view the repository: %v, got %v, %v", account, expected, canView, err)
+		}
+	}
+
+	repository.Public = true
+	if canView, _ := canViewRepository(ctx, nil, repository); !canView {
+		t.Error("Expected everyone to be able to view public repositories")
+	}
+}
@@ -0,0 +1,1265 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDatabaseContract runs the behaviour every Database implementation must
+// share. newDatabase should return an empty database.
+func testDatabaseContract(t *testing.T, newDatabase func(t *testing.T) Database) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, db Database)
+	}{
+		{"AllBuildsLoadsCommits", testAllBuildsLoadsCommits},
+		{"AllBuildsLoadsRepositoriesUserIsCollaboratorOn", testAllBuildsLoadsRepositoriesUserIsCollaboratorOn},
+		{"PublicBuildsLoadCommits", testPublicBuildsLoadCommits},
+		{"AllBuildsOnlyLoadsBuildsForAccount", testAllBuildsOnlyLoadsBuildsForAccount},
+		{"FindRepository", testFindRepository},
+		{"FindBuild", testFindBuild},
+		{"IncompleteBuilds", testIncompleteBuilds},
+		{"CreateAndFindAccountById", testCreateAndFindAccountById},
+		{"AddRepositoryToAccount", testAddRepositoryToAccount},
+		{"CreateAccountUpdatesAccessToken", testCreateAccountUpdatesAccessToken},
+		{"FindAccountsByLogin", testFindAccountsByLogin},
+		{"AllAccountsAndSetAccessToken", testAllAccountsAndSetAccessToken},
+		{"StoresEncryptedAccessTokens", testStoresEncryptedAccessTokens},
+		{"Sessions", testSessions},
+		{"AllRepositories", testAllRepositories},
+		{"RepositoryBuilds", testRepositoryBuilds},
+		{"SaveTestResults", testSaveTestResults},
+		{"BuildsLoadTestSummaries", testBuildsLoadTestSummaries},
+		{"SaveCoverage", testSaveCoverage},
+		{"BuildsLoadCoverageSummaries", testBuildsLoadCoverageSummaries},
+		{"SearchBuilds", testSearchBuilds},
+		{"SearchBuildsPages", testSearchBuildsPages},
+		{"SearchPublicBuilds", testSearchPublicBuilds},
+		{"SaveBuildFinishedAndClosed", testSaveBuildFinishedAndClosed},
+		{"RepositoryRole", testRepositoryRole},
+		{"ReplaceCollaborations", testReplaceCollaborations},
+		{"AllBuildsLoadsOrganizationAndTeamRepositories", testAllBuildsLoadsOrganizationAndTeamRepositories},
+		{"Organizations", testOrganizations},
+		{"Teams", testTeams},
+		{"SetRepositoryPublic", testSetRepositoryPublic},
+		{"RepositoryHooks", testRepositoryHooks},
+		{"DeleteRepository", testDeleteRepository},
+		{"AccountAndRepositoryHosts", testAccountAndRepositoryHosts},
+		{"AccountsAreIdentifiedByHostUser", testAccountsAreIdentifiedByHostUser},
+	}
+
+	for _, contract := range tests {
+		t.Run(contract.name, func(t *testing.T) {
+			contract.test(t, newDatabase(t))
+		})
+	}
+}
+
+func testAllBuildsLoadsCommits(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{}
+	db.CreateAccount(ctx, account)
+	repository := &Repository{Owner: "ownerrr", Repository: "repo1"}
+	db.AddRepositoryToAccount(ctx, account, repository)
+
+	commits := []Commit{
+		Commit{Sha: "csdkl22323", Message: "hellooo", Url: "something.com"},
+		Commit{Sha: "324mlkm", Message: "hi there", Url: "example.com"},
+	}
+
+	build := &Build{Owner: "ownerrr", Repository: "repo1", Commits: commits}
+	db.CreateBuild(ctx, repository, build)
+
+	builds, err := db.AllBuilds(ctx, account)
+	if err != nil || len(builds) == 0 {
+		t.Fatalf("Expected to find a build, got error %v", err)
+	}
+	build = builds[0]
+	if len(build.Commits) != len(commits) {
+		t.Fatalf("Expected build to load up %d commits, but had %d commits\n", len(commits), len(build.Commits))
+	}
+	for index, expectedCommit := range commits {
+		actual := build.Commits[index]
+		if actual.Sha != expectedCommit.Sha ||
+			actual.Message != expectedCommit.Message ||
+			actual.Url != expectedCommit.Url {
+			t.Errorf("Expected commit to look like:\n%+v\nActual:\n%+v\n", expectedCommit, actual)
+		}
+	}
+}
+
+func testAllBuildsLoadsRepositoriesUserIsCollaboratorOn(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 9999, HostUserId: 9999}
+	repository := &Repository{Owner: "someone", Repository: "repo"}
+	db.AddRepositoryToAccount(ctx, account, repository)
+
+	build := &Build{Owner: "someone", Repository: "repo1"}
+	db.CreateBuild(ctx, repository, build)
+	build = &Build{Owner: "someone", Repository: "repo2"}
+	db.CreateBuild(ctx, repository, build)
+
+	teamMember := &Account{Id: 2333, HostUserId: 2333}
+	db.CreateAccount(ctx, teamMember)
+
+	db.SaveCollaboration(ctx, teamMember.Id, repository.Id, roleWrite)
+
+	builds, err := db.AllBuilds(ctx, teamMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(builds) != 2 {
+		t.Fatalf("Expected to find the collaborated build")
+	}
+}
+
+func testPublicBuildsLoadCommits(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{}
+	db.CreateAccount(ctx, account)
+
+	repository1 := &Repository{Owner: "ownerrr", Repository: "repo1", Public: false}
+	repository2 := &Repository{Owner: "ownerrr", Repository: "repo2", Public: true}
+
+	db.AddRepositoryToAccount(ctx, account, repository1)
+	db.AddRepositoryToAccount(ctx, account, repository2)
+
+	db.CreateBuild(ctx, repository1, &Build{Owner: "ownerrr", Repository: "repo1"})
+
+	commits := []Commit{
+		Commit{Sha: "dssdsd", Message: "hellooo", Url: "something.com"},
+	}
+
+	db.CreateBuild(ctx, repository2, &Build{Owner: "ownerrr", Repository: "repo2", Commits: commits})
+
+	page, err := db.SearchBuilds(ctx, nil, BuildFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	builds := page.Builds
+
+	if len(builds) != 1 {
+		t.Fatalf("Should have only found one build")
+	}
+	build := builds[0]
+
+	if build.Owner != "ownerrr" || build.Repository != "repo2" {
+		t.Errorf("This wasn't the build on the public repo")
+	}
+	if len(build.Commits) != len(commits) {
+		t.Fatalf("Expected %d commits, but has %d commits\n", len(commits), len(build.Commits))
+	}
+	for index, expectedCommit := range commits {
+		actual := build.Commits[index]
+		if actual.Sha != expectedCommit.Sha ||
+			actual.Message != expectedCommit.Message ||
+			actual.Url != expectedCommit.Url {
+			t.Errorf("Expected commit to look like:\n%+v\nActual:\n%+v\n", expectedCommit, actual)
+		}
+	}
+}
+
+func testAllBuildsOnlyLoadsBuildsForAccount(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 1111, HostUserId: 1111}
+	db.CreateAccount(ctx, account)
+	repository := &Repository{Owner: "ownerrr", Repository: "repo1"}
+	db.AddRepositoryToAccount(ctx, account, repository)
+	db.CreateBuild(ctx, repository, &Build{Owner: "ownerrr", Repository: "repo1"})
+
+	otherAccount := &Account{Id: 2323, HostUserId: 2323}
+	db.CreateAccount(ctx, otherAccount)
+	repository = &Repository{Owner: "something", Repository: "else"}
+	db.AddRepositoryToAccount(ctx, otherAccount, repository)
+	db.CreateBuild(ctx, repository, &Build{Owner: "something", Repository: "else"})
+
+	allBuilds, err := db.AllBuilds(ctx, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allBuilds) > 1 {
+		t.Errorf("We should only return builds that this account owns, we returned %d", len(allBuilds))
+	}
+}
+
+func testFindRepository(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 1267, HostUserId: 1267}
+	db.CreateAccount(ctx, account)
+
+	b1 := &Repository{Owner: "ownerrr", Repository: "repo1"}
+	b2 := &Repository{Owner: "erm", Repository: "repo2"}
+	db.AddRepositoryToAccount(ctx, account, b1)
+	db.AddRepositoryToAccount(ctx, account, b2)
+
+	repository, err := db.FindRepository(ctx, "github", "erm", "repo2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if repository == nil || repository.Owner != "erm" || repository.Repository != "repo2" {
+		t.Errorf("Expected to find repository:\n%+v\nActual:\n%+v\n", b2, repository)
+	}
+	if repository.Account == nil || repository.Account.Id != account.Id {
+		t.Errorf("Repository.Account should be populated")
+	}
+
+	repository, err = db.FindRepository(ctx, "github", "losdsds", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repository != nil {
+		t.Errorf("Expected not to find a repository, but found:\n%+v\n", repository)
+	}
+
+	repository, err = db.FindRepositoryById(ctx, b1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repository == nil || repository.Repository != "repo1" || repository.Account == nil || repository.Account.Id != account.Id {
+		t.Errorf("Expected to find repository by id:\n%+v\nActual:\n%+v\n", b1, repository)
+	}
+	if repository, _ := db.FindRepositoryById(ctx, b2.Id+100); repository != nil {
+		t.Errorf("Expected not to find a repository, but found:\n%+v\n", repository)
+	}
+}
+
+func testFindBuild(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	db.CreateAccount(ctx, account)
+	repository := &Repository{Owner: "owner", Repository: "repo1"}
+	db.AddRepositoryToAccount(ctx, account, repository)
+	db.CreateBuild(ctx, repository, &Build{Owner: "owner", Repository: "repo1"})
+	commits := []Commit{{Sha: "abc", Message: "a commit", Url: "something.com"}}
+	created := &Build{Owner: "owner", Repository: "repo1", Commits: commits}
+	db.CreateBuild(ctx, repository, created)
+
+	build, err := db.FindBuild(ctx, created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build == nil || build.Id != created.Id || build.RepositoryId != repository.Id {
+		t.Fatalf("Expected to find build %d, got %+v", created.Id, build)
+	}
+	if len(build.Commits) != 1 || build.Commits[0].Sha != "abc" {
+		t.Errorf("Expected the build's commits to be loaded, got %+v", build.Commits)
+	}
+
+	build, err = db.FindBuild(ctx, created.Id+100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build != nil {
+		t.Errorf("Expected not to find a build, but found %+v", build)
+	}
+}
+
+func testIncompleteBuilds(t *testing.T, db Database) {
+	ctx := context.Background()
+	account := &Account{}
+	db.CreateAccount(ctx, account)
+	repository := &Repository{Owner: "ownerrr", Repository: "repo1"}
+	db.AddRepositoryToAccount(ctx, account, repository)
+
+	build := &Build{Owner: "ownerrr", Repository: "repo1"}
+	db.CreateBuild(ctx, repository, build)
+	build.Complete = true
+	db.SaveBuild(ctx, build)
+
+	build = &Build{Owner: "ownerrr", Repository: "repo1"}
+	db.CreateBuild(ctx, repository, build)
+	build.Complete = false
+	db.SaveBuild(ctx, build)
+
+	builds, err := db.IncompleteBuilds(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builds) != 1 {
+		t.Errorf("We should only return one build, because only one is incomplete\n%+v\n", builds)
+	}
+}
+
+func testCreateAndFindAccountById(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 2455252, HostUserId: 2455252, AccessToken: "23mf23f22n3kl2n3nkl2n3lnl2n3ln3lnl"}
+	db.CreateAccount(ctx, account)
+
+	found, err := db.FindAccountById(ctx, 2455252)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found == nil {
+		t.Fatalf("Account wasn't found")
+	}
+	if found.Id != account.Id {
+		t.Errorf("Account id is wrong")
+	}
+	if found.AccessToken != "23mf23f22n3kl2n3nkl2n3lnl2n3ln3lnl" {
+		t.Errorf("Account AccessToken wasn't stored")
+	}
+}
+
+func testAddRepositoryToAccount(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 595, HostUserId: 595, AccessToken: "23mf23f22n3kl2n3nkl2n3lnl2n3ln3lnl"}
+	db.CreateAccount(ctx, account)
+
+	repository := &Repository{Owner: "eer", Repository: "somename", Public: true}
+	db.AddRepositoryToAccount(ctx, account, repository)
+
+	if repository.Id == 0 {
+		t.Errorf("Repository id should have been updated")
+	}
+
+	foundAccount, err := db.FindAccountById(ctx, 595)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(foundAccount.Repositories) == 0 {
+		t.Fatalf("Repository wasn't added to account")
+	}
+	if foundAccount.Repositories[0].Account.Id != account.Id {
+		t.Errorf("Repository should have an account")
+	}
+	if foundAccount.Repositories[0].Owner != "eer" {
+		t.Errorf("Owner was %v", foundAccount.Repositories[0].Owner)
+	}
+	if foundAccount.Repositories[0].Repository != "somename" {
+		t.Errorf("Name was %v", foundAccount.Repositories[0].Repository)
+	}
+	if foundAccount.Repositories[0].Public != true {
+		t.Errorf("Public was %v", foundAccount.Repositories[0].Public)
+	}
+}
+
+func testCreateAccountUpdatesAccessToken(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account1 := &Account{Id: 2455252, HostUserId: 2455252, AccessToken: "ZZZZZZZZZZ"}
+	account2 := &Account{Id: 2455252, HostUserId: 2455252, AccessToken: "AAAAAAAAAA"}
+	db.CreateAccount(ctx, account1)
+	db.CreateAccount(ctx, account2)
+
+	foundAccount, err := db.FindAccountById(ctx, 2455252)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if foundAccount.Id != account1.Id {
+		t.Errorf("Expected account to not be created again")
+	}
+
+	if foundAccount.AccessToken != "AAAAAAAAAA" {
+		t.Errorf("Expected access token to get updated")
+	}
+}
+
+func testStoresEncryptedAccessTokens(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	withTokenKeys(testTokenKey, nil, func() {
+		encrypted, err := encryptToken(strings.Repeat("a", 64))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.CreateAccount(ctx, &Account{Id: 1, HostUserId: 1, AccessToken: encrypted}); err != nil {
+			t.Fatal(err)
+		}
+		account, err := db.FindAccountById(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if account.AccessToken != encrypted {
+			t.Errorf("Expected the encrypted token to be stored as it is, got %q", account.AccessToken)
+		}
+
+		if err := db.SetAccessToken(ctx, 1, encrypted); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func testAllAccountsAndSetAccessToken(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	db.CreateAccount(ctx, &Account{Id: 2, HostUserId: 2, AccessToken: "b", Login: "second"})
+	db.CreateAccount(ctx, &Account{Id: 1, HostUserId: 1, AccessToken: "a", Login: "first"})
+
+	if err := db.SetAccessToken(ctx, 2, "new"); err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := db.AllAccounts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 2 {
+		t.Fatalf("Expected 2 accounts, got %d", len(accounts))
+	}
+	if accounts[0].Id != 1 || accounts[0].AccessToken != "a" || accounts[0].Login != "first" {
+		t.Errorf("Expected the first account, got %+v", accounts[0])
+	}
+	if accounts[1].Id != 2 || accounts[1].AccessToken != "new" || accounts[1].Login != "second" {
+		t.Errorf("Expected the second account with its new token, got %+v", accounts[1])
+	}
+}
+
+func testFindAccountsByLogin(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	db.CreateAccount(ctx, &Account{Id: 1, HostUserId: 1, AccessToken: "a", Login: "octocat", Name: "The Octocat", AvatarUrl: "https://example.com/1.png"})
+	db.CreateAccount(ctx, &Account{Id: 2, HostUserId: 2, AccessToken: "b", Login: "hubot"})
+	db.CreateAccount(ctx, &Account{Id: 3, HostUserId: 3, AccessToken: "c"})
+	db.CreateAccount(ctx, &Account{Id: 1, HostUserId: 1, AccessToken: "d", Login: "octocat", Name: "Mona", AvatarUrl: "https://example.com/2.png"})
+	db.CreateAccount(ctx, &Account{Id: -4, HostUserId: -4, AccessToken: "e", Login: "octocat", Host: "gitlab"})
+
+	accounts, err := db.FindAccountsByLogin(ctx, "github", []string{"OctoCat", "someone", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].Id != 1 {
+		t.Fatalf("Expected to find the octocat account, got %+v", accounts)
+	}
+	if accounts[0].Name != "Mona" || accounts[0].AvatarUrl != "https://example.com/2.png" || accounts[0].AccessToken != "d" {
+		t.Errorf("Expected the profile to be updated, got %+v", accounts[0])
+	}
+
+	accounts, err = db.FindAccountsByLogin(ctx, "gitlab", []string{"octocat"})
+	if err != nil || len(accounts) != 1 || accounts[0].Id != -4 {
+		t.Errorf("Expected to find the octocat account on GitLab, got %+v %v", accounts, err)
+	}
+
+	accounts, err = db.FindAccountsByLogin(ctx, "github", nil)
+	if err != nil || len(accounts) != 0 {
+		t.Errorf("Expected no accounts without logins, got %+v %v", accounts, err)
+	}
+}
+
+func testSessions(t *testing.T, db Database) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	account := &Account{Id: 2455252, HostUserId: 2455252, AccessToken: "5T"}
+	db.CreateAccount(ctx, account)
+
+	older, _ := newSession(account, now.Add(-time.Hour))
+	current, _ := newSession(account, now)
+	expired, _ := newSession(account, now.Add(-configuration.SessionLifetime-time.Hour))
+	other, _ := newSession(&Account{Id: 1, HostUserId: 1}, now)
+	for _, session := range []*Session{older, current, expired, other} {
+		if err := db.CreateSession(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found, err := db.FindSession(ctx, current.Id)
+	if err != nil || !reflect.DeepEqual(found, current) {
+		t.Errorf("Expected to find session:\n%+v\nGot:\n%+v, %v", current, found, err)
+	}
+	if found, _ := db.FindSession(ctx, "not a real session"); found != nil {
+		t.Errorf("Expected not to find a session, got %+v", found)
+	}
+
+	sessions, err := db.AccountSessions(ctx, account.Id, now)
+	if err != nil || len(sessions) != 2 || sessions[0].Id != current.Id || sessions[1].Id != older.Id {
+		t.Errorf("Expected the sessions that haven't expired, newest first, got %+v, %v", sessions, err)
+	}
+
+	db.DeleteExpiredSessions(ctx, now)
+	if found, _ := db.FindSession(ctx, expired.Id); found != nil {
+		t.Errorf("Expected expired sessions to be deleted")
+	}
+
+	db.DeleteAccountSessions(ctx, account.Id, current.Id)
+	if found, _ := db.FindSession(ctx, older.Id); found != nil {
+		t.Errorf("Expected the other sessions of the account to be deleted")
+	}
+	if found, _ := db.FindSession(ctx, other.Id); found == nil {
+		t.Errorf("Expected the sessions of other accounts to be kept")
+	}
+
+	db.DeleteSession(ctx, current.Id)
+	if found, _ := db.FindSession(ctx, current.Id); found != nil {
+		t.Errorf("Expected the session to be deleted")
+	}
+}
+
+func testAllRepositories(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	db.CreateAccount(ctx, account)
+	db.AddRepositoryToAccount(ctx, account, &Repository{Owner: "owner", Repository: "repo1"})
+	db.AddRepositoryToAccount(ctx, &Account{Id: 2, HostUserId: 2}, &Repository{Owner: "owner", Repository: "repo2", Public: true})
+
+	repositories, err := db.AllRepositories(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repositories) != 2 {
+		t.Fatalf("Expected two repositories, got %d", len(repositories))
+	}
+	if repositories[0].Repository != "repo1" || repositories[1].Repository != "repo2" || !repositories[1].Public {
+		t.Errorf("Repositories weren't loaded properly:\n%+v\n%+v", repositories[0], repositories[1])
+	}
+}
+
+func testRepositoryBuilds(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	db.CreateAccount(ctx, account)
+	repository := &Repository{Owner: "owner", Repository: "repo1"}
+	db.AddRepositoryToAccount(ctx, account, repository)
+	other := &Repository{Owner: "owner", Repository: "repo2"}
+	db.AddRepositoryToAccount(ctx, account, other)
+
+	before := time.Now().Add(-time.Second)
+	db.CreateBuild(ctx, repository, &Build{Owner: "owner", Repository: "repo1", Commits: []Commit{{Sha: "abc"}}})
+	db.CreateBuild(ctx, other, &Build{Owner: "owner", Repository: "repo2"})
+
+	builds, err := db.RepositoryBuilds(ctx, repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builds) != 1 {
+		t.Fatalf("Expected one build for the repository, got %d", len(builds))
+	}
+	if builds[0].RepositoryId != repository.Id || len(builds[0].Commits) != 1 {
+		t.Errorf("Build wasn't loaded properly:\n%+v", builds[0])
+	}
+	if builds[0].CreatedAt.Before(before) || builds[0].CreatedAt.After(time.Now()) {
+		t.Errorf("Expected CreatedAt to be set when the build was created, got %v", builds[0].CreatedAt)
+	}
+}
+
+func testSaveTestResults(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	db.CreateAccount(ctx, account)
+	repository := &Repository{Owner: "owner", Repository: "repo1"}
+	db.AddRepositoryToAccount(ctx, account, repository)
+	first := &Build{Owner: "owner", Repository: "repo1"}
+	db.CreateBuild(ctx, repository, first)
+	second := &Build{Owner: "owner", Repository: "repo1"}
+	db.CreateBuild(ctx, repository, second)
+
+	db.SaveTestResults(ctx, second, []TestResult{
+		{ClassName: "BuildTest", Name: "test_second", Duration: 10 * time.Millisecond, Status: testPassed},
+	})
+	firstResults := []TestResult{
+		{ClassName: "BuildTest", Name: "test_passes", Duration: 1500 * time.Millisecond, Status: testPassed},
+		{ClassName: "BuildTest", Name: "test_fails", Duration: 2 * time.Millisecond, Status: testFailed, Message: "expected 1\ngot 2", Line: 12},
+	}
+	if err := db.SaveTestResults(ctx, first, firstResults); err != nil {
+		t.Fatal(err)
+	}
+	if firstResults[0].Id == 0 || firstResults[0].BuildId != first.Id {
+		t.Errorf("Expected saved results to have ids, got %+v", firstResults[0])
+	}
+
+	results, err := db.TestResults(ctx, []*Build{first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(results, firstResults) {
+		t.Errorf("Expected results:\n%+v\nGot:\n%+v", firstResults, results)
+	}
+
+	results, _ = db.TestResults(ctx, []*Build{second, first})
+	if len(results) != 3 || results[0].BuildId != first.Id || results[2].BuildId != second.Id {
+		t.Errorf("Expected the results of both builds in build order, got %+v", results)
+	}
+
+	results, err = db.TestResults(ctx, nil)
+	if err != nil || len(results) != 0 {
+		t.Errorf("Expected no results for no builds, got %+v, %v", results, err)
+	}
+}
+
+func testBuildsLoadTestSummaries(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	db.CreateAccount(ctx, account)
+	repository := &Repository{Owner: "owner", Repository: "repo1"}
+	db.AddRepositoryToAccount(ctx, account, repository)
+	tested := &Build{Owner: "owner", Repository: "repo1"}
+	db.CreateBuild(ctx, repository, tested)
+	db.CreateBuild(ctx, repository, &Build{Owner: "owner", Repository: "repo1"})
+
+	db.SaveTestResults(ctx, tested, []TestResult{
+		{Name: "a", Duration: time.Second, Status: testPassed},
+		{Name: "b", Duration: time.Second, Status: testPassed},
+		{Name: "c", Duration: 500 * time.Millisecond, Status: testFailed},
+		{Name: "d", Status: testSkipped},
+		{ClassName: "github.com/AndrewVos/builder", Status: testFailed, Duration: time.Minute},
+	})
+
+	builds, err := db.AllBuilds(ctx, account)
+	if err != nil || len(builds) != 2 {
+		t.Fatalf("Expected two builds, got %v, %v", builds, err)
+	}
+	expected := &TestSummary{Total: 4, Passed: 2, Failed: 1, Skipped: 1, Duration: 2500 * time.Millisecond}
+	if !reflect.DeepEqual(builds[0].Tests, expected) {
+		t.Errorf("Expected test summary %+v, got %+v", expected, builds[0].Tests)
+	}
+	if builds[1].Tests != nil {
+		t.Errorf("Expected builds without tests not to have a summary, got %+v", builds[1].Tests)
+	}
+}
+
+func testSaveCoverage(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	db.CreateAccount(ctx, account)
+	repository := &Repository{Owner: "owner", Repository: "repo1"}
+	db.AddRepositoryToAccount(ctx, account, repository)
+	first := &Build{Owner: "owner", Repository: "repo1"}
+	db.CreateBuild(ctx, repository, first)
+	second := &Build{Owner: "owner", Repository: "repo1"}
+	db.CreateBuild(ctx, repository, second)
+
+	db.SaveCoverage(ctx, second, []FileCoverage{{Path: "other.go", Covered: 1, Total: 1}})
+	files := []FileCoverage{
+		{Path: "build.go", Covered: 10, Total: 40},
+		{Path: "handlers.go", Covered: 0, Total: 12},
+	}
+	if err := db.SaveCoverage(ctx, first, files); err != nil {
+		t.Fatal(err)
+	}
+	if files[0].Id == 0 || files[0].BuildId != first.Id {
+		t.Errorf("Expected saved coverage to have ids, got %+v", files[0])
+	}
+
+	saved, err := db.CoverageFiles(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, files) {
+		t.Errorf("Expected coverage:\n%+v\nGot:\n%+v", files, saved)
+	}
+}
+
+func testBuildsLoadCoverageSummaries(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	db.CreateAccount(ctx, account)
+	repository := &Repository{Owner: "owner", Repository: "repo1"}
+	db.AddRepositoryToAccount(ctx, account, repository)
+	covered := &Build{Owner: "owner", Repository: "repo1", Ref: "feature", BaseRef: "master"}
+	db.CreateBuild(ctx, repository, covered)
+	db.CreateBuild(ctx, repository, &Build{Owner: "owner", Repository: "repo1"})
+
+	db.SaveCoverage(ctx, covered, []FileCoverage{
+		{Path: "a.go", Covered: 3, Total: 4},
+		{Path: "b.go", Covered: 0, Total: 4},
+	})
+
+	builds, err := db.AllBuilds(ctx, account)
+	if err != nil || len(builds) != 2 {
+		t.Fatalf("Expected two builds, got %v, %v", builds, err)
+	}
+	expected := &CoverageSummary{Covered: 3, Total: 8}
+	if !reflect.DeepEqual(builds[0].Coverage, expected) {
+		t.Errorf("Expected coverage summary %+v, got %+v", expected, builds[0].Coverage)
+	}
+	if builds[0].BaseRef != "master" {
+		t.Errorf("Expected the base ref to be saved, got %q", builds[0].BaseRef)
+	}
+	if builds[1].Coverage != nil {
+		t.Errorf("Expected builds without coverage not to have a summary, got %+v", builds[1].Coverage)
+	}
+}
+
+func testSearchBuilds(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	db.CreateAccount(ctx, account)
+	builder := &Repository{Owner: "AndrewVos", Repository: "builder"}
+	db.AddRepositoryToAccount(ctx, account, builder)
+	other := &Repository{Owner: "AndrewVos", Repository: "other"}
+	db.AddRepositoryToAccount(ctx, account, other)
+	hidden := &Repository{Owner: "someone", Repository: "builder"}
+	db.AddRepositoryToAccount(ctx, &Account{Id: 2, HostUserId: 2}, hidden)
+
+	master := &Build{Owner: "AndrewVos", Repository: "builder", Ref: "master", Author: "AndrewVos", Trigger: triggerPush,
+		Commits: []Commit{{Sha: "abc", Message: "Fix the flaky 100% test"}}}
+	db.CreateBuild(ctx, builder, master)
+	master.Result = "pass"
+	db.SaveBuild(ctx, master)
+	feature := &Build{Owner: "AndrewVos", Repository: "builder", Ref: "feature", Author: "someone", Trigger: triggerPullRequest}
+	db.CreateBuild(ctx, builder, feature)
+	feature.Result = "fail"
+	db.SaveBuild(ctx, feature)
+	otherMaster := &Build{Owner: "AndrewVos", Repository: "other", Ref: "master", Author: "AndrewVos", Trigger: triggerPush}
+	db.CreateBuild(ctx, other, otherMaster)
+	db.CreateBuild(ctx, hidden, &Build{Owner: "someone", Repository: "builder", Ref: "master"})
+
+	today := master.CreatedAt.Truncate(24 * time.Hour)
+	searches := []struct {
+		filter   BuildFilter
+		expected []*Build
+	}{
+		{BuildFilter{}, []*Build{otherMaster, feature, master}},
+		{BuildFilter{Repository: "builder"}, []*Build{feature, master}},
+		{BuildFilter{Repository: "andrewvos/other"}, []*Build{otherMaster}},
+		{BuildFilter{Branch: "master"}, []*Build{otherMaster, master}},
+		{BuildFilter{Result: "fail"}, []*Build{feature}},
+		{BuildFilter{Result: "incomplete"}, []*Build{otherMaster}},
+		{BuildFilter{Author: "andrewvos"}, []*Build{otherMaster, master}},
+		{BuildFilter{Trigger: triggerPullRequest}, []*Build{feature}},
+		{BuildFilter{Since: today, Until: today}, []*Build{otherMaster, feature, master}},
+		{BuildFilter{Since: today.AddDate(0, 0, 1)}, nil},
+		{BuildFilter{Until: today.AddDate(0, 0, -1)}, nil},
+		{BuildFilter{Query: "FLAKY"}, []*Build{master}},
+		{BuildFilter{Query: "100%"}, []*Build{master}},
+		{BuildFilter{Query: "0_"}, nil},
+		{BuildFilter{Query: "feat"}, []*Build{feature}},
+		{BuildFilter{Branch: "master", Repository: "builder"}, []*Build{master}},
+	}
+	for _, search := range searches {
+		page, err := db.SearchBuilds(ctx, account, search.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids, expected []int
+		for _, build := range page.Builds {
+			ids = append(ids, build.Id)
+		}
+		for _, build := range search.expected {
+			expected = append(expected, build.Id)
+		}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("Expected searching %+v to find builds %v, got %v", search.filter, expected, ids)
+		}
+	}
+
+	page, err := db.SearchBuilds(ctx, account, BuildFilter{Query: "flaky"})
+	if err != nil || len(page.Builds) != 1 || page.Builds[0].Author != "AndrewVos" || page.Builds[0].Trigger != triggerPush {
+		t.Errorf("Expected builds to keep their author and trigger, got %+v, %v", page, err)
+	}
+	if page.Builds[0].Commits == nil {
+		t.Errorf("Expected found builds to load their commits")
+	}
+
+	page, err = db.SearchBuilds(ctx, nil, BuildFilter{})
+	if err != nil || len(page.Builds) != 0 {
+		t.Errorf("Expected no builds without an account when nothing is public, got %+v, %v", page, err)
+	}
+}
+
+func testSearchBuildsPages(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	db.CreateAccount(ctx, account)
+	repository := &Repository{Owner: "owner", Repository: "repo1"}
+	db.AddRepositoryToAccount(ctx, account, repository)
+	var builds []*Build
+	for i := 0; i < 5; i++ {
+		build := &Build{Owner: "owner", Repository: "repo1"}
+		db.CreateBuild(ctx, repository, build)
+		builds = append(builds, build)
+	}
+
+	filter := BuildFilter{Limit: 2}
+	var pages [][]int
+	for {
+		page, err := db.SearchBuilds(ctx, account, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for _, build := range page.Builds {
+			ids = append(ids, build.Id)
+		}
+		pages = append(pages, ids)
+		if page.Next == 0 {
+			break
+		}
+		filter.Before = page.Next
+	}
+
+	expected := [][]int{
+		{builds[4].Id, builds[3].Id},
+		{builds[2].Id, builds[1].Id},
+		{builds[0].Id},
+	}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("Expected pages %v, got %v", expected, pages)
+	}
+}
+
+func testSaveBuildFinishedAndClosed(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	db.CreateAccount(ctx, account)
+	repository := &Repository{Owner: "owner", Repository: "repo1"}
+	db.AddRepositoryToAccount(ctx, account, repository)
+	build := &Build{Owner: "owner", Repository: "repo1", Trigger: triggerPullRequest}
+	db.CreateBuild(ctx, repository, build)
+
+	builds, _ := db.RepositoryBuilds(ctx, repository)
+	if !builds[0].FinishedAt.IsZero() || builds[0].PullRequestClosed || builds[0].Pruned {
+		t.Errorf("Expected a new build not to be finished, closed or pruned, got %+v", builds[0])
+	}
+
+	build.FinishedAt = build.CreatedAt.Add(90 * time.Second)
+	build.PullRequestClosed = true
+	build.Pruned = true
+	db.SaveBuild(ctx, build)
+
+	builds, _ = db.RepositoryBuilds(ctx, repository)
+	if builds[0].Duration() != 90*time.Second || !builds[0].PullRequestClosed || !builds[0].Pruned {
+		t.Errorf("Expected the build to be finished, closed and pruned, got %+v", builds[0])
+	}
+}
+
+func testRepositoryRole(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	for id := 1; id <= 7; id++ {
+		db.CreateAccount(ctx, &Account{Id: id, HostUserId: id})
+	}
+	organization := &Organization{Name: "acme"}
+	db.CreateOrganization(ctx, organization, &Account{Id: 4, HostUserId: 4})
+	db.SaveOrganizationMember(ctx, organization.Id, 5, roleRead)
+
+	repository := &Repository{Owner: "acme", Repository: "repo1", OrganizationId: organization.Id}
+	db.AddRepositoryToAccount(ctx, &Account{Id: 1, HostUserId: 1}, repository)
+	db.SaveCollaboration(ctx, 2, repository.Id, roleWrite)
+	db.SaveCollaboration(ctx, 5, repository.Id, roleWrite)
+
+	team := &Team{OrganizationId: organization.Id, Name: "readers", Role: roleRead}
+	db.CreateTeam(ctx, team)
+	db.AddTeamMember(ctx, team.Id, 6)
+	db.AddTeamRepository(ctx, team.Id, repository.Id)
+	otherTeam := &Team{OrganizationId: organization.Id, Name: "no repositories", Role: roleAdmin}
+	db.CreateTeam(ctx, otherTeam)
+	db.AddTeamMember(ctx, otherTeam.Id, 7)
+
+	expected := map[int]Role{
+		1: roleAdmin, // added the repository
+		2: roleWrite, // collaborator
+		3: roleNone,
+		4: roleAdmin, // organization admin
+		5: roleWrite, // the highest of their organization and collaborator roles
+		6: roleRead,  // team member
+		7: roleNone,  // in a team without the repository
+	}
+	for accountId, role := range expected {
+		actual, err := db.RepositoryRole(ctx, accountId, repository.Id)
+		if err != nil || actual != role {
+			t.Errorf("Expected account %d to have role %q, got %q, %v", accountId, role, actual, err)
+		}
+	}
+}
+
+func testReplaceCollaborations(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	for id := 1; id <= 4; id++ {
+		db.CreateAccount(ctx, &Account{Id: id, HostUserId: id})
+	}
+	repository := &Repository{Owner: "owner", Repository: "repo1"}
+	db.AddRepositoryToAccount(ctx, &Account{Id: 1, HostUserId: 1}, repository)
+	other := &Repository{Owner: "owner", Repository: "repo2"}
+	db.AddRepositoryToAccount(ctx, &Account{Id: 1, HostUserId: 1}, other)
+	db.SaveCollaboration(ctx, 2, repository.Id, roleWrite)
+	db.SaveCollaboration(ctx, 3, repository.Id, roleWrite)
+	db.SaveCollaboration(ctx, 2, other.Id, roleWrite)
+
+	err := db.ReplaceCollaborations(ctx, repository.Id, map[int]Role{3: roleAdmin, 4: roleRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[int]Role{2: roleNone, 3: roleAdmin, 4: roleRead}
+	for accountId, role := range expected {
+		actual, _ := db.RepositoryRole(ctx, accountId, repository.Id)
+		if actual != role {
+			t.Errorf("Expected account %d to have role %q, got %q", accountId, role, actual)
+		}
+	}
+	if role, _ := db.RepositoryRole(ctx, 2, other.Id); role != roleWrite {
+		t.Errorf("Expected collaborations on other repositories to be kept, got %q", role)
+	}
+}
+
+func testAllBuildsLoadsOrganizationAndTeamRepositories(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	owner := &Account{Id: 1, HostUserId: 1}
+	db.CreateAccount(ctx, owner)
+	organization := &Organization{Name: "acme"}
+	db.CreateOrganization(ctx, organization, owner)
+	orgRepository := &Repository{Owner: "acme", Repository: "org", OrganizationId: organization.Id}
+	db.AddRepositoryToAccount(ctx, owner, orgRepository)
+	teamRepository := &Repository{Owner: "acme", Repository: "team"}
+	db.AddRepositoryToAccount(ctx, owner, teamRepository)
+	orgBuild := &Build{Owner: "acme", Repository: "org"}
+	db.CreateBuild(ctx, orgRepository, orgBuild)
+	teamBuild := &Build{Owner: "acme", Repository: "team"}
+	db.CreateBuild(ctx, teamRepository, teamBuild)
+
+	member := &Account{Id: 2, HostUserId: 2}
+	db.CreateAccount(ctx, member)
+	db.SaveOrganizationMember(ctx, organization.Id, member.Id, roleRead)
+	teamMember := &Account{Id: 3, HostUserId: 3}
+	db.CreateAccount(ctx, teamMember)
+	team := &Team{OrganizationId: organization.Id, Name: "team", Role: roleWrite}
+	db.CreateTeam(ctx, team)
+	db.AddTeamMember(ctx, team.Id, teamMember.Id)
+	db.AddTeamRepository(ctx, team.Id, teamRepository.Id)
+
+	for account, expected := range map[*Account][]int{member: {orgBuild.Id}, teamMember: {teamBuild.Id}} {
+		builds, err := db.AllBuilds(ctx, account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for _, build := range builds {
+			ids = append(ids, build.Id)
+		}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("Expected account %d to see builds %v, got %v", account.Id, expected, ids)
+		}
+
+		page, err := db.SearchBuilds(ctx, account, BuildFilter{})
+		if err != nil || len(page.Builds) != 1 || page.Builds[0].Id != expected[0] {
+			t.Errorf("Expected account %d to find builds %v, got %+v, %v", account.Id, expected, page, err)
+		}
+	}
+}
+
+func testOrganizations(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	admin := &Account{Id: 1, HostUserId: 1, Login: "admin"}
+	db.CreateAccount(ctx, admin)
+	member := &Account{Id: 2, HostUserId: 2, Login: "member"}
+	db.CreateAccount(ctx, member)
+
+	organization := &Organization{Name: "Acme"}
+	if err := db.CreateOrganization(ctx, organization, admin); err != nil {
+		t.Fatal(err)
+	}
+	if organization.Id == 0 {
+		t.Fatalf("Expected the organization to get an id")
+	}
+	db.CreateOrganization(ctx, &Organization{Name: "Other"}, member)
+
+	found, err := db.FindOrganization(ctx, "acme")
+	if err != nil || found == nil || found.Id != organization.Id || found.Name != "Acme" || found.Host != "github" {
+		t.Errorf("Expected to find the organization without case, got %+v, %v", found, err)
+	}
+	gitlabAdmin := &Account{Id: -3, HostUserId: -3, Login: "admin", Host: "gitlab"}
+	db.CreateAccount(ctx, gitlabAdmin)
+	db.CreateOrganization(ctx, &Organization{Name: "OnGitlab"}, gitlabAdmin)
+	if found, _ := db.FindOrganization(ctx, "ongitlab"); found == nil || found.Host != "gitlab" {
+		t.Errorf("Expected the organization to be on its creator's host, got %+v", found)
+	}
+	if missing, err := db.FindOrganization(ctx, "missing"); missing != nil || err != nil {
+		t.Errorf("Expected a missing organization to be nil, got %+v, %v", missing, err)
+	}
+
+	db.SaveOrganizationMember(ctx, organization.Id, member.Id, roleRead)
+	db.SaveOrganizationMember(ctx, organization.Id, member.Id, roleWrite)
+	members, err := db.OrganizationMembers(ctx, organization.Id)
+	if err != nil || len(members) != 2 {
+		t.Fatalf("Expected two members, got %+v, %v", members, err)
+	}
+	if members[0].Account.Login != "admin" || members[0].Role != roleAdmin ||
+		members[1].Account.Login != "member" || members[1].Role != roleWrite {
+		t.Errorf("Expected the admin and the member with their updated role, got %+v %+v", members[0], members[1])
+	}
+
+	organizations, err := db.AccountOrganizations(ctx, member.Id)
+	if err != nil || len(organizations) != 2 || organizations[0].Name != "Acme" || organizations[1].Name != "Other" {
+		t.Errorf("Expected the member to be in both organizations, got %+v, %v", organizations, err)
+	}
+
+	team := &Team{OrganizationId: organization.Id, Name: "team", Role: roleRead}
+	db.CreateTeam(ctx, team)
+	db.AddTeamMember(ctx, team.Id, member.Id)
+	if err := db.DeleteOrganizationMember(ctx, organization.Id, member.Id); err != nil {
+		t.Fatal(err)
+	}
+	members, _ = db.OrganizationMembers(ctx, organization.Id)
+	if len(members) != 1 || members[0].Account.Id != admin.Id {
+		t.Errorf("Expected the member to be removed, got %+v", members)
+	}
+	teams, _ := db.OrganizationTeams(ctx, organization.Id)
+	if len(teams) != 1 || len(teams[0].MemberIds) != 0 {
+		t.Errorf("Expected the member to be removed from the team, got %+v", teams)
+	}
+}
+
+func testTeams(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	admin := &Account{Id: 1, HostUserId: 1}
+	db.CreateAccount(ctx, admin)
+	organization := &Organization{Name: "acme"}
+	db.CreateOrganization(ctx, organization, admin)
+	repository := &Repository{Owner: "acme", Repository: "repo", OrganizationId: organization.Id}
+	db.AddRepositoryToAccount(ctx, admin, repository)
+
+	writers := &Team{OrganizationId: organization.Id, Name: "writers", Role: roleWrite}
+	if err := db.CreateTeam(ctx, writers); err != nil {
+		t.Fatal(err)
+	}
+	db.CreateTeam(ctx, &Team{OrganizationId: organization.Id, Name: "Admins", Role: roleAdmin})
+	db.AddTeamMember(ctx, writers.Id, 3)
+	db.AddTeamMember(ctx, writers.Id, 2)
+	db.AddTeamMember(ctx, writers.Id, 2)
+	db.AddTeamRepository(ctx, writers.Id, repository.Id)
+	db.AddTeamRepository(ctx, writers.Id, repository.Id)
+
+	teams, err := db.OrganizationTeams(ctx, organization.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(teams) != 2 || teams[0].Name != "Admins" || teams[1].Name != "writers" {
+		t.Fatalf("Expected the teams by name, got %+v", teams)
+	}
+	if teams[1].Role != roleWrite || !reflect.DeepEqual(teams[1].MemberIds, []int{2, 3}) ||
+		!reflect.DeepEqual(teams[1].RepositoryIds, []int{repository.Id}) {
+		t.Errorf("Expected the team with its members and repositories, got %+v", teams[1])
+	}
+	if teams, _ := db.OrganizationTeams(ctx, organization.Id+1000); len(teams) != 0 {
+		t.Errorf("Expected no teams for another organization, got %+v", teams)
+	}
+}
+
+func testSetRepositoryPublic(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	db.CreateAccount(ctx, account)
+	organization := &Organization{Name: "acme"}
+	db.CreateOrganization(ctx, organization, account)
+	db.AddRepositoryToAccount(ctx, account, &Repository{Owner: "acme", Repository: "repo", OrganizationId: organization.Id})
+
+	repository, _ := db.FindRepository(ctx, "github", "acme", "repo")
+	if repository.Public || repository.OrganizationId != organization.Id {
+		t.Fatalf("Expected a private repository of the organization, got %+v", repository)
+	}
+	if err := db.SetRepositoryPublic(ctx, repository.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	if repository, _ := db.FindRepository(ctx, "github", "acme", "repo"); !repository.Public {
+		t.Errorf("Expected the repository to be public")
+	}
+}
+
+func testSearchPublicBuilds(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	db.CreateAccount(ctx, account)
+	private := &Repository{Owner: "owner", Repository: "private"}
+	db.AddRepositoryToAccount(ctx, account, private)
+	public := &Repository{Owner: "owner", Repository: "public", Public: true}
+	db.AddRepositoryToAccount(ctx, account, public)
+	db.CreateBuild(ctx, private, &Build{Owner: "owner", Repository: "private", Ref: "master"})
+	publicBuild := &Build{Owner: "owner", Repository: "public", Ref: "master"}
+	db.CreateBuild(ctx, public, publicBuild)
+
+	page, err := db.SearchBuilds(ctx, nil, BuildFilter{Branch: "master"})
+	if err != nil || len(page.Builds) != 1 || page.Builds[0].Id != publicBuild.Id {
+		t.Errorf("Expected to only find the public build, got %+v, %v", page, err)
+	}
+
+	stranger := &Account{Id: 2, HostUserId: 2}
+	db.CreateAccount(ctx, stranger)
+	page, err = db.SearchBuilds(ctx, stranger, BuildFilter{Branch: "master"})
+	if err != nil || len(page.Builds) != 1 || page.Builds[0].Id != publicBuild.Id {
+		t.Errorf("Expected someone logged in to find the public build too, got %+v, %v", page, err)
+	}
+	page, _ = db.SearchBuilds(ctx, account, BuildFilter{Branch: "master"})
+	if len(page.Builds) != 2 {
+		t.Errorf("Expected the owner to find both builds, got %+v", page)
+	}
+}
+
+func testRepositoryHooks(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	repository := &Repository{Owner: "owner", Repository: "repo"}
+	db.AddRepositoryToAccount(ctx, &Account{Id: 1, HostUserId: 1}, repository)
+	db.SaveRepositoryHooks(ctx, repository.Id, []Webhook{{Id: 3, Event: "push", Url: "http://localhost/hooks/push"}})
+
+	hooks := []Webhook{
+		{Id: 4, Event: "push", Url: "http://localhost/hooks/push"},
+		{Id: 5, Event: "pull_request", Url: "http://localhost/hooks/pull_request"},
+	}
+	if err := db.SaveRepositoryHooks(ctx, repository.Id, hooks); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := db.RepositoryHooks(ctx, repository.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, hooks) {
+		t.Errorf("Expected the hooks to be replaced, got %+v", saved)
+	}
+}
+
+func testDeleteRepository(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	db.CreateAccount(ctx, account)
+	db.CreateAccount(ctx, &Account{Id: 2, HostUserId: 2})
+	repository := &Repository{Owner: "owner", Repository: "repo"}
+	db.AddRepositoryToAccount(ctx, account, repository)
+	other := &Repository{Owner: "owner", Repository: "other"}
+	db.AddRepositoryToAccount(ctx, account, other)
+	db.SaveCollaboration(ctx, 2, repository.Id, roleWrite)
+	db.SaveRepositoryHooks(ctx, repository.Id, []Webhook{{Id: 3, Event: "push", Url: "http://localhost/hooks/push"}})
+	build := &Build{Owner: "owner", Repository: "repo"}
+	db.CreateBuild(ctx, repository, build)
+	db.SaveCommit(ctx, &Commit{BuildId: build.Id, Sha: "abc"})
+	db.CreateBuild(ctx, other, &Build{Owner: "owner", Repository: "other"})
+
+	if err := db.DeleteRepository(ctx, repository.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := db.FindRepository(ctx, "github", "owner", "repo"); found != nil {
+		t.Errorf("Expected the repository to be deleted")
+	}
+	if role, _ := db.RepositoryRole(ctx, 2, repository.Id); role != roleNone {
+		t.Errorf("Expected the collaborations to be deleted, got %q", role)
+	}
+	if hooks, _ := db.RepositoryHooks(ctx, repository.Id); len(hooks) != 0 {
+		t.Errorf("Expected the hooks to be deleted, got %+v", hooks)
+	}
+	builds, _ := db.AllBuilds(ctx, account)
+	if len(builds) != 1 || builds[0].Repository != "other" {
+		t.Errorf("Expected only the builds of the other repository to be left, got %+v", builds)
+	}
+}
+
+func testAccountAndRepositoryHosts(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	githubAccount := &Account{Id: 1, HostUserId: 1, AccessToken: "a"}
+	gitlabAccount := &Account{Id: 2, HostUserId: 1, AccessToken: "b", Host: "gitlab"}
+	db.CreateAccount(ctx, githubAccount)
+	db.CreateAccount(ctx, gitlabAccount)
+	db.AddRepositoryToAccount(ctx, githubAccount, &Repository{Owner: "owner", Repository: "on-github"})
+	gitlabRepository := &Repository{Owner: "group/subgroup", Repository: "on-gitlab"}
+	db.AddRepositoryToAccount(ctx, gitlabAccount, gitlabRepository)
+
+	found, err := db.FindAccountById(ctx, gitlabAccount.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Host != "gitlab" || len(found.Repositories) != 1 || found.Repositories[0].Host != "gitlab" {
+		t.Errorf("Expected the account and its repository to be on GitLab, got %+v", found)
+	}
+
+	found, _ = db.FindAccountById(ctx, 1)
+	if found.Host != "github" {
+		t.Errorf("Expected accounts without a host to be on Github, got %q", found.Host)
+	}
+	repository, _ := db.FindRepository(ctx, "github", "owner", "on-github")
+	if repository.Host != "github" {
+		t.Errorf("Expected repositories without a host to be on Github, got %q", repository.Host)
+	}
+
+	db.AddRepositoryToAccount(ctx, gitlabAccount, &Repository{Owner: "owner", Repository: "on-github"})
+	repository, _ = db.FindRepository(ctx, "gitlab", "owner", "on-github")
+	if repository == nil || repository.Host != "gitlab" || repository.AccountId != gitlabAccount.Id {
+		t.Errorf("Expected to find the repository with the same name on GitLab, got %+v", repository)
+	}
+	repository, _ = db.FindRepository(ctx, "", "owner", "on-github")
+	if repository == nil || repository.Host != "github" {
+		t.Errorf("Expected to find the repository on Github, got %+v", repository)
+	}
+	if repository, _ := db.FindRepository(ctx, "gitea", "owner", "on-github"); repository != nil {
+		t.Errorf("Expected not to find a repository on Gitea, got %+v", repository)
+	}
+
+	build := &Build{Owner: "group/subgroup", Repository: "on-gitlab"}
+	db.CreateBuild(ctx, gitlabRepository, build)
+	if build.Host != "gitlab" {
+		t.Errorf("Expected a new build to be on its repository's host, got %q", build.Host)
+	}
+	if found, _ := db.FindBuild(ctx, build.Id); found == nil || found.Host != "gitlab" {
+		t.Errorf("Expected builds to be loaded with their repository's host, got %+v", found)
+	}
+}
+
+func testAccountsAreIdentifiedByHostUser(t *testing.T, db Database) {
+	ctx := context.Background()
+
+	githubAccount := &Account{HostUserId: 7, AccessToken: "a", Login: "octocat"}
+	gitlabAccount := &Account{HostUserId: 7, AccessToken: "b", Login: "octocat", Host: "gitlab"}
+	db.CreateAccount(ctx, githubAccount)
+	db.CreateAccount(ctx, gitlabAccount)
+	if githubAccount.Id == 0 || gitlabAccount.Id == 0 || githubAccount.Id == gitlabAccount.Id {
+		t.Fatalf("Expected users with the same id on two hosts to get two accounts, got %v and %v", githubAccount.Id, gitlabAccount.Id)
+	}
+
+	returning := &Account{HostUserId: 7, AccessToken: "c", Login: "octocat", Host: "gitlab"}
+	if err := db.CreateAccount(ctx, returning); err != nil {
+		t.Fatal(err)
+	}
+	if returning.Id != gitlabAccount.Id {
+		t.Errorf("Expected a returning user to keep their account %v, got %v", gitlabAccount.Id, returning.Id)
+	}
+	found, _ := db.FindAccountById(ctx, gitlabAccount.Id)
+	if found == nil || found.AccessToken != "c" || found.HostUserId != 7 || found.Host != "gitlab" {
+		t.Errorf("Expected the returning user's access token to be updated, got %+v", found)
+	}
+	found, _ = db.FindAccountById(ctx, githubAccount.Id)
+	if found == nil || found.AccessToken != "a" {
+		t.Errorf("Expected the Github account to be left alone, got %+v", found)
+	}
+
+	owner := &Account{HostUserId: 1, Host: "gitlab"}
+	db.CreateAccount(ctx, owner)
+	repository := &Repository{Owner: "group", Repository: "project"}
+	db.AddRepositoryToAccount(ctx, owner, repository)
+	db.SaveCollaboration(ctx, 7, repository.Id, roleWrite)
+
+	if role, _ := db.RepositoryRole(ctx, gitlabAccount.Id, repository.Id); role != roleWrite {
+		t.Errorf("Expected the GitLab user to collaborate on the GitLab repository, got %q", role)
+	}
+	if role, _ := db.RepositoryRole(ctx, githubAccount.Id, repository.Id); role != roleNone {
+		t.Errorf("Expected the Github user with the same id not to, got %q", role)
+	}
+
+	db.CreateBuild(ctx, repository, &Build{Ref: "master"})
+	if builds, _ := db.AllBuilds(ctx, gitlabAccount); len(builds) != 1 {
+		t.Errorf("Expected the GitLab user to see the repository's build, got %+v", builds)
+	}
+	if builds, _ := db.AllBuilds(ctx, githubAccount); len(builds) != 0 {
+		t.Errorf("Expected the Github user not to see the GitLab repository's builds, got %+v", builds)
+	}
+}
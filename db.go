@@ -1,17 +1,104 @@
 package main
 
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Database is the storage used by builder. Find methods return a nil
+// result and a nil error when nothing matches.
 type Database interface {
-	AddRepositoryToAccount(account *Account, repository *Repository) error
-	SaveCommit(commit *Commit) error
-	SaveBuild(build *Build) error
-	AllBuilds(account *Account) []*Build
-	FindPublicBuilds() []*Build
-	CreateBuild(repository *Repository, build *Build) error
-	FindRepository(owner string, name string) *Repository
-	IncompleteBuilds() []*Build
-	FindAccountById(id int) *Account
-	CreateAccount(account *Account) error
-	CreateLoginForAccount(account *Account) (*Login, error)
-	LoginExists(accountId int, token string) bool
-	SaveCollaboration(accountId int, repositoryId int) error
+	AddRepositoryToAccount(ctx context.Context, account *Account, repository *Repository) error
+	SaveCommit(ctx context.Context, commit *Commit) error
+	SaveBuild(ctx context.Context, build *Build) error
+	AllBuilds(ctx context.Context, account *Account) ([]*Build, error)
+	// SearchBuilds returns a page of the public builds and the builds account
+	// can see that match filter, newest first. Account can be nil.
+	SearchBuilds(ctx context.Context, account *Account, filter BuildFilter) (*BuildPage, error)
+	FindBuild(ctx context.Context, id int) (*Build, error)
+	CreateBuild(ctx context.Context, repository *Repository, build *Build) error
+	// FindRepository finds a repository by its owner and name on a source
+	// host. The same owner and name can be used on more than one host.
+	FindRepository(ctx context.Context, host string, owner string, name string) (*Repository, error)
+	FindRepositoryById(ctx context.Context, id int) (*Repository, error)
+	SetRepositoryPublic(ctx context.Context, repositoryId int, public bool) error
+	// DeleteRepository deletes a repository along with its builds and
+	// everyone's access to it.
+	DeleteRepository(ctx context.Context, repositoryId int) error
+	// SaveRepositoryHooks replaces the webhooks builder created on a
+	// repository.
+	SaveRepositoryHooks(ctx context.Context, repositoryId int, hooks []Webhook) error
+	RepositoryHooks(ctx context.Context, repositoryId int) ([]Webhook, error)
+	IncompleteBuilds(ctx context.Context) ([]*Build, error)
+	AllRepositories(ctx context.Context) ([]*Repository, error)
+	RepositoryBuilds(ctx context.Context, repository *Repository) ([]*Build, error)
+	FindAccountById(ctx context.Context, id int) (*Account, error)
+	// CreateAccount creates the account, or updates the access token and
+	// profile of the account that already has its host and host user id.
+	// It sets the account's id, which is chosen by the database unless it
+	// was given.
+	CreateAccount(ctx context.Context, account *Account) error
+	// FindAccountsByLogin returns the accounts with the logins on a source
+	// host. Logins are matched without case.
+	FindAccountsByLogin(ctx context.Context, host string, logins []string) ([]*Account, error)
+	// AllAccounts returns every account without its repositories.
+	AllAccounts(ctx context.Context) ([]*Account, error)
+	SetAccessToken(ctx context.Context, accountId int, accessToken string) error
+	CreateSession(ctx context.Context, session *Session) error
+	FindSession(ctx context.Context, id string) (*Session, error)
+	// AccountSessions returns the sessions of an account that haven't
+	// expired, newest first.
+	AccountSessions(ctx context.Context, accountId int, now time.Time) ([]*Session, error)
+	DeleteSession(ctx context.Context, id string) error
+	// DeleteAccountSessions deletes every session of an account apart from
+	// the session with the id except.
+	DeleteAccountSessions(ctx context.Context, accountId int, except string) error
+	DeleteExpiredSessions(ctx context.Context, now time.Time) error
+	// SaveCollaboration gives the user with hostUserId on the repository's
+	// host a role on the repository.
+	SaveCollaboration(ctx context.Context, hostUserId int, repositoryId int, role Role) error
+	// ReplaceCollaborations makes roles, keyed by the ids of users on the
+	// repository's host, the only collaborations on a repository.
+	ReplaceCollaborations(ctx context.Context, repositoryId int, roles map[int]Role) error
+	// RepositoryRole returns the highest role an account has been given on a
+	// repository, by adding it, collaborating on it, or through the members
+	// and teams of the organization that owns it.
+	RepositoryRole(ctx context.Context, accountId int, repositoryId int) (Role, error)
+	// CreateOrganization creates the organization with admin as its first
+	// admin.
+	CreateOrganization(ctx context.Context, organization *Organization, admin *Account) error
+	// FindOrganization finds an organization by name, without case.
+	FindOrganization(ctx context.Context, name string) (*Organization, error)
+	AccountOrganizations(ctx context.Context, accountId int) ([]*Organization, error)
+	OrganizationMembers(ctx context.Context, organizationId int) ([]*OrganizationMember, error)
+	// SaveOrganizationMember adds a member, or changes the role of an
+	// existing member.
+	SaveOrganizationMember(ctx context.Context, organizationId int, accountId int, role Role) error
+	// DeleteOrganizationMember removes a member from the organization and
+	// from its teams.
+	DeleteOrganizationMember(ctx context.Context, organizationId int, accountId int) error
+	CreateTeam(ctx context.Context, team *Team) error
+	// OrganizationTeams returns the teams of an organization, by name, with
+	// their members and repositories.
+	OrganizationTeams(ctx context.Context, organizationId int) ([]*Team, error)
+	AddTeamMember(ctx context.Context, teamId int, accountId int) error
+	AddTeamRepository(ctx context.Context, teamId int, repositoryId int) error
+	SaveTestResults(ctx context.Context, build *Build, results []TestResult) error
+	// TestResults returns the test results of every build, in build order.
+	TestResults(ctx context.Context, builds []*Build) ([]TestResult, error)
+	SaveCoverage(ctx context.Context, build *Build, files []FileCoverage) error
+	// CoverageFiles returns the coverage of every file in a build, by path.
+	CoverageFiles(ctx context.Context, build *Build) ([]FileCoverage, error)
+	Close() error
+}
+
+func openDatabase(c Configuration) (Database, error) {
+	switch c.DatabaseDriver {
+	case "postgres":
+		return NewPostgresDatabase(c.DatabaseURL, c.DatabaseMaxConnections, c.DatabaseTimeout)
+	case "sqlite":
+		return NewSQLiteDatabase(c.DatabaseURL, c.DatabaseTimeout)
+	}
+	return nil, fmt.Errorf("Unknown database driver %q", c.DatabaseDriver)
 }
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE builds ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT now();
+
+-- +goose Down
+ALTER TABLE builds DROP COLUMN created_at;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE test_results(
+  id          SERIAL PRIMARY KEY NOT NULL,
+  build_id    INTEGER NOT NULL,
+  class_name  TEXT NOT NULL,
+  name        TEXT NOT NULL,
+  duration    BIGINT NOT NULL,
+  status      VARCHAR(20) NOT NULL,
+  message     TEXT NOT NULL
+);
+CREATE INDEX test_results_build_id ON test_results (build_id);
+
+-- +goose Down
+DROP TABLE test_results;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE test_results ADD COLUMN line INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE test_results DROP COLUMN line;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE builds ADD COLUMN base_ref VARCHAR(100);
+
+-- +goose Down
+ALTER TABLE builds DROP COLUMN base_ref;
@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE coverage_files(
+  id        SERIAL PRIMARY KEY NOT NULL,
+  build_id  INTEGER NOT NULL,
+  path      TEXT NOT NULL,
+  covered   INTEGER NOT NULL,
+  total     INTEGER NOT NULL
+);
+CREATE INDEX coverage_files_build_id ON coverage_files (build_id);
+
+-- +goose Down
+DROP TABLE coverage_files;
@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE builds ADD COLUMN author VARCHAR(100);
+ALTER TABLE builds ADD COLUMN triggered_by VARCHAR(20);
+CREATE INDEX builds_repository_id ON builds (repository_id);
+
+-- +goose Down
+DROP INDEX builds_repository_id;
+ALTER TABLE builds DROP COLUMN triggered_by;
+ALTER TABLE builds DROP COLUMN author;
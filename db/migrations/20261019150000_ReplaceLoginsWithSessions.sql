@@ -0,0 +1,18 @@
+-- +goose Up
+DROP TABLE logins;
+CREATE TABLE sessions(
+  id          VARCHAR(64) PRIMARY KEY NOT NULL,
+  account_id  INTEGER NOT NULL,
+  csrf_token  VARCHAR(64) NOT NULL,
+  created_at  TIMESTAMP NOT NULL,
+  expires_at  TIMESTAMP NOT NULL
+);
+CREATE INDEX sessions_account_id ON sessions (account_id);
+
+-- +goose Down
+DROP TABLE sessions;
+CREATE TABLE logins(
+  id          SERIAL PRIMARY KEY NOT NULL,
+  account_id  INTEGER NOT NULL,
+  token       VARCHAR(100) NOT NULL
+);
@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE repository_hooks(
+  repository_id  INTEGER NOT NULL,
+  hook_id        BIGINT NOT NULL,
+  event          VARCHAR(100) NOT NULL,
+  url            TEXT NOT NULL,
+  PRIMARY KEY (repository_id, hook_id)
+);
+
+-- +goose Down
+DROP TABLE repository_hooks;
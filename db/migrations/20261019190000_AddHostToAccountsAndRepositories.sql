@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE accounts ADD COLUMN host VARCHAR(20) NOT NULL DEFAULT 'github';
+ALTER TABLE accounts ADD COLUMN host_user_id INTEGER;
+UPDATE accounts SET host_user_id = id;
+ALTER TABLE accounts ALTER COLUMN host_user_id SET NOT NULL;
+CREATE UNIQUE INDEX accounts_host_user_id ON accounts (host, host_user_id);
+SELECT setval('accounts_id_seq', (SELECT COALESCE(MAX(id), 0) + 1 FROM accounts), false);
+ALTER TABLE repositories ADD COLUMN host VARCHAR(20) NOT NULL DEFAULT 'github';
+ALTER TABLE collaborations RENAME COLUMN account_id TO host_user_id;
+
+-- +goose Down
+ALTER TABLE collaborations RENAME COLUMN host_user_id TO account_id;
+ALTER TABLE repositories DROP COLUMN host;
+DROP INDEX accounts_host_user_id;
+ALTER TABLE accounts DROP COLUMN host_user_id;
+ALTER TABLE accounts DROP COLUMN host;
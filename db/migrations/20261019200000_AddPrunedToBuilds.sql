@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE builds ADD COLUMN pruned BOOLEAN;
+
+-- +goose Down
+ALTER TABLE builds DROP COLUMN pruned;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE accounts ALTER COLUMN access_token TYPE TEXT;
+
+-- +goose Down
+ALTER TABLE accounts ALTER COLUMN access_token TYPE VARCHAR(100);
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE organizations ADD COLUMN host VARCHAR(20) NOT NULL DEFAULT 'github';
+
+-- +goose Down
+ALTER TABLE organizations DROP COLUMN host;
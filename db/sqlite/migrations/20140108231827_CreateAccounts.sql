@@ -0,0 +1,8 @@
+-- +goose Up
+CREATE TABLE accounts(
+  id INTEGER PRIMARY KEY NOT NULL,
+  access_token VARCHAR(100) NOT NULL
+);
+
+-- +goose Down
+DROP TABLE accounts;
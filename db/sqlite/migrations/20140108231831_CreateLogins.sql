@@ -0,0 +1,9 @@
+-- +goose Up
+CREATE TABLE logins(
+  id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  account_id INTEGER NOT NULL,
+  token VARCHAR(100) NOT NULL
+);
+
+-- +goose Down
+DROP TABLE logins;
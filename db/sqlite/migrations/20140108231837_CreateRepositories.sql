@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE repositories(
+  id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  account_id INTEGER NOT NULL,
+  owner VARCHAR(100) NOT NULL,
+  repository VARCHAR(100) NOT NULL
+);
+
+-- +goose Down
+DROP TABLE repositories;
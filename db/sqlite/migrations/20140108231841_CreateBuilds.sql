@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE builds(
+  id             INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  repository_id  INTEGER NOT NULL,
+  url            VARCHAR(200),
+  owner          VARCHAR(100),
+  repository     VARCHAR(100),
+  ref            VARCHAR(100),
+  sha            VARCHAR(50),
+  complete       BOOLEAN,
+  success        BOOLEAN,
+  result         VARCHAR(30),
+  github_url     VARCHAR(200)
+);
+
+-- +goose Down
+DROP TABLE builds;
@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE commits(
+  id       INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  build_id INTEGER,
+  sha      VARCHAR(50),
+  message  TEXT,
+  url      VARCHAR(200)
+);
+
+-- +goose Down
+DROP TABLE commits;
@@ -0,0 +1,8 @@
+-- +goose Up
+CREATE TABLE collaborations(
+  account_id INTEGER NOT NULL,
+  repository_id INTEGER NOT NULL
+);
+
+-- +goose Down
+DROP TABLE collaborations;
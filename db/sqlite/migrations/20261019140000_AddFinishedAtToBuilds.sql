@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE builds ADD COLUMN finished_at TIMESTAMP;
+ALTER TABLE builds ADD COLUMN pull_request_closed BOOLEAN;
+
+-- +goose Down
+ALTER TABLE builds DROP COLUMN pull_request_closed;
+ALTER TABLE builds DROP COLUMN finished_at;
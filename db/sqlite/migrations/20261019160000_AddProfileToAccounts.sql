@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE accounts ADD COLUMN login VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN name VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN email VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN avatar_url VARCHAR(255) NOT NULL DEFAULT '';
+CREATE INDEX accounts_login ON accounts (LOWER(login));
+
+-- +goose Down
+DROP INDEX accounts_login;
+ALTER TABLE accounts DROP COLUMN avatar_url;
+ALTER TABLE accounts DROP COLUMN email;
+ALTER TABLE accounts DROP COLUMN name;
+ALTER TABLE accounts DROP COLUMN login;
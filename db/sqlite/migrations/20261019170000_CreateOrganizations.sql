@@ -0,0 +1,47 @@
+-- +goose Up
+CREATE TABLE organizations(
+  id    INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  name  VARCHAR(100) NOT NULL
+);
+CREATE UNIQUE INDEX organizations_name ON organizations (LOWER(name));
+
+CREATE TABLE organization_members(
+  organization_id  INTEGER NOT NULL,
+  account_id       INTEGER NOT NULL,
+  role             VARCHAR(10) NOT NULL,
+  PRIMARY KEY (organization_id, account_id)
+);
+CREATE INDEX organization_members_account_id ON organization_members (account_id);
+
+CREATE TABLE teams(
+  id               INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  organization_id  INTEGER NOT NULL,
+  name             VARCHAR(100) NOT NULL,
+  role             VARCHAR(10) NOT NULL
+);
+CREATE UNIQUE INDEX teams_name ON teams (organization_id, LOWER(name));
+
+CREATE TABLE team_members(
+  team_id     INTEGER NOT NULL,
+  account_id  INTEGER NOT NULL,
+  PRIMARY KEY (team_id, account_id)
+);
+CREATE INDEX team_members_account_id ON team_members (account_id);
+
+CREATE TABLE team_repositories(
+  team_id        INTEGER NOT NULL,
+  repository_id  INTEGER NOT NULL,
+  PRIMARY KEY (team_id, repository_id)
+);
+
+ALTER TABLE repositories ADD COLUMN organization_id INTEGER;
+ALTER TABLE collaborations ADD COLUMN role VARCHAR(10) NOT NULL DEFAULT 'write';
+
+-- +goose Down
+ALTER TABLE collaborations DROP COLUMN role;
+ALTER TABLE repositories DROP COLUMN organization_id;
+DROP TABLE team_repositories;
+DROP TABLE team_members;
+DROP TABLE teams;
+DROP TABLE organization_members;
+DROP TABLE organizations;
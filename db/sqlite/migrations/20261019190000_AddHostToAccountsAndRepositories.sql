@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE accounts ADD COLUMN host VARCHAR(20) NOT NULL DEFAULT 'github';
+ALTER TABLE accounts ADD COLUMN host_user_id INTEGER NOT NULL DEFAULT 0;
+UPDATE accounts SET host_user_id = id;
+CREATE UNIQUE INDEX accounts_host_user_id ON accounts (host, host_user_id);
+ALTER TABLE repositories ADD COLUMN host VARCHAR(20) NOT NULL DEFAULT 'github';
+ALTER TABLE collaborations RENAME COLUMN account_id TO host_user_id;
+
+-- +goose Down
+ALTER TABLE collaborations RENAME COLUMN host_user_id TO account_id;
+ALTER TABLE repositories DROP COLUMN host;
+DROP INDEX accounts_host_user_id;
+ALTER TABLE accounts DROP COLUMN host_user_id;
+ALTER TABLE accounts DROP COLUMN host;
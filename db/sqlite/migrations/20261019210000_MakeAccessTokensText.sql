@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE accounts ADD COLUMN access_token_text TEXT NOT NULL DEFAULT '';
+UPDATE accounts SET access_token_text = access_token;
+ALTER TABLE accounts DROP COLUMN access_token;
+ALTER TABLE accounts RENAME COLUMN access_token_text TO access_token;
+
+-- +goose Down
+ALTER TABLE accounts ADD COLUMN access_token_varchar VARCHAR(100) NOT NULL DEFAULT '';
+UPDATE accounts SET access_token_varchar = access_token;
+ALTER TABLE accounts DROP COLUMN access_token;
+ALTER TABLE accounts RENAME COLUMN access_token_varchar TO access_token;
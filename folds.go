@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Builderfile can split its output into collapsible sections by echoing
+// fold markers on lines of their own:
+//
+//	echo builder_fold:start:dependencies
+//	bundle install
+//	echo builder_fold:end:dependencies
+const foldMarkerPrefix = "builder_fold:"
+
+// Markers longer than this are never buffered, they're just output.
+const maximumFoldMarkerLength = 256
+
+type foldMarker struct {
+	start bool
+	name  string
+	// time is when the marker was written in milliseconds since the epoch,
+	// or zero when the marker hasn't been stamped.
+	time int64
+}
+
+func parseFoldMarker(line string) (foldMarker, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, foldMarkerPrefix) {
+		return foldMarker{}, false
+	}
+
+	var marker foldMarker
+	rest := strings.TrimPrefix(line, foldMarkerPrefix)
+	switch {
+	case strings.HasPrefix(rest, "start:"):
+		marker.start = true
+		marker.name = strings.TrimPrefix(rest, "start:")
+	case strings.HasPrefix(rest, "end:"):
+		marker.name = strings.TrimPrefix(rest, "end:")
+	default:
+		return foldMarker{}, false
+	}
+
+	if i := strings.LastIndex(marker.name, ":"); i != -1 {
+		if time, err := strconv.ParseInt(marker.name[i+1:], 10, 64); err == nil {
+			marker.name = marker.name[:i]
+			marker.time = time
+		}
+	}
+	if marker.name == "" {
+		return foldMarker{}, false
+	}
+	return marker, true
+}
+
+// foldStamper writes build output, adding the time to each fold marker so
+// the log records how long every fold took.
+type foldStamper struct {
+	output io.WriteCloser
+	now    func() time.Time
+	// line holds the start of the current line for as long as it could
+	// still be a fold marker.
+	line    []byte
+	midLine bool
+}
+
+func newFoldStamper(output io.WriteCloser) *foldStamper {
+	return &foldStamper{output: output, now: time.Now}
+}
+
+func (f *foldStamper) Write(p []byte) (int, error) {
+	var b []byte
+	for _, c := range p {
+		if f.midLine {
+			b = append(b, c)
+			f.midLine = c != '\n'
+			continue
+		}
+
+		f.line = append(f.line, c)
+		if c == '\n' {
+			b = append(b, f.stamp(f.line)...)
+			f.line = nil
+		} else if !f.couldBeMarker() {
+			b = append(b, f.line...)
+			f.line = nil
+			f.midLine = true
+		}
+	}
+
+	_, err := f.output.Write(b)
+	return len(p), err
+}
+
+func (f *foldStamper) couldBeMarker() bool {
+	if len(f.line) > maximumFoldMarkerLength {
+		return false
+	}
+	if len(f.line) < len(foldMarkerPrefix) {
+		return strings.HasPrefix(foldMarkerPrefix, string(f.line))
+	}
+	return strings.HasPrefix(string(f.line), foldMarkerPrefix)
+}
+
+func (f *foldStamper) stamp(line []byte) []byte {
+	text := strings.TrimRight(string(line), "\r\n")
+	marker, ok := parseFoldMarker(text)
+	if !ok || marker.time != 0 {
+		return line
+	}
+	milliseconds := f.now().UnixNano() / int64(time.Millisecond)
+	return []byte(text + ":" + strconv.FormatInt(milliseconds, 10) + string(line[len(text):]))
+}
+
+func (f *foldStamper) Close() error {
+	_, err := f.output.Write(f.line)
+	f.line = nil
+	if closeErr := f.output.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
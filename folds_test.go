@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+type closingBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closingBuffer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestParseFoldMarker(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected foldMarker
+		ok       bool
+	}{
+		{"builder_fold:start:dependencies", foldMarker{start: true, name: "dependencies"}, true},
+		{"builder_fold:end:dependencies\r", foldMarker{name: "dependencies"}, true},
+		{"builder_fold:start:unit:tests:1760000000123", foldMarker{start: true, name: "unit:tests", time: 1760000000123}, true},
+		{"builder_fold:start:", foldMarker{}, false},
+		{"builder_fold:middle:tests", foldMarker{}, false},
+		{"+ echo builder_fold:start:tests", foldMarker{}, false},
+	}
+
+	for _, test := range tests {
+		marker, ok := parseFoldMarker(test.line)
+		if marker != test.expected || ok != test.ok {
+			t.Errorf("Expected %q to parse as %+v, %v but got %+v, %v", test.line, test.expected, test.ok, marker, ok)
+		}
+	}
+}
+
+func TestFoldStamperStampsMarkersWithTheTime(t *testing.T) {
+	output := &closingBuffer{}
+	stamper := newFoldStamper(output)
+	stamper.now = func() time.Time {
+		return time.Unix(1760000000, 123000000)
+	}
+
+	log := "builder_fold:start:tests\r\n" +
+		"+ echo builder_fold:start:tests\n" +
+		"builder_folding\n" +
+		"builder_fold:end:tests\n" +
+		"builder_fol"
+	for i := range log {
+		stamper.Write([]byte{log[i]})
+	}
+	stamper.Close()
+
+	expected := "builder_fold:start:tests:1760000000123\r\n" +
+		"+ echo builder_fold:start:tests\n" +
+		"builder_folding\n" +
+		"builder_fold:end:tests:1760000000123\n" +
+		"builder_fol"
+	if output.String() != expected {
+		t.Errorf("\nExpected:\n%q\nGot:\n%q\n", expected, output.String())
+	}
+	if !output.closed {
+		t.Errorf("Expected the log to be closed")
+	}
+}
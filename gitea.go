@@ -0,0 +1,389 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Gitea is the SourceHost for a Gitea installation at GITEA_URL.
+type Gitea struct{}
+
+func (gitea Gitea) Name() string {
+	return "Gitea"
+}
+
+func giteaApiUrl(path string) string {
+	return configuration.GiteaUrl + "/api/v1" + path
+}
+
+func giteaHeader(accessToken string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + accessToken}}
+}
+
+// giteaRequest sends body to the Gitea API, see sendJson.
+func giteaRequest(method string, accessToken string, path string, body interface{}, expected int) ([]byte, error) {
+	return sendJson(method, giteaApiUrl(path), giteaHeader(accessToken), body, expected)
+}
+
+func giteaRedirectUrl() string {
+	return configuration.Host + ":" + configuration.Port + "/gitea_callback"
+}
+
+// AuthorizeUrl doesn't ask for a scope, because Gitea gives builder the same
+// access to repositories that the account has.
+func (gitea Gitea) AuthorizeUrl(state string, private bool) string {
+	query := url.Values{
+		"client_id":     {configuration.GiteaClientID},
+		"redirect_uri":  {giteaRedirectUrl()},
+		"response_type": {"code"},
+		"state":         {state},
+	}
+	return configuration.GiteaUrl + "/login/oauth/authorize?" + query.Encode()
+}
+
+func (gitea Gitea) GetAccessToken(code string) (string, error) {
+	response, err := http.PostForm(configuration.GiteaUrl+"/login/oauth/access_token", url.Values{
+		"client_id":     {configuration.GiteaClientID},
+		"client_secret": {configuration.GiteaClientSecret},
+		"code":          {code},
+		"grant_type":    {"authorization_code"},
+		"redirect_uri":  {giteaRedirectUrl()},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(response.Body)
+	var accessTokenResponse map[string]interface{}
+	json.Unmarshal(body, &accessTokenResponse)
+
+	if token, ok := accessTokenResponse["access_token"].(string); ok && token != "" {
+		return token, nil
+	}
+	if description, ok := accessTokenResponse["error_description"].(string); ok && description != "" {
+		return "", errors.New("Error retrieving access token: " + description)
+	}
+	return "", errors.New("Error retrieving access token")
+}
+
+// giteaUser is a user as Gitea describes them.
+type giteaUser struct {
+	Id        int    `json:"id"`
+	Login     string `json:"login"`
+	FullName  string `json:"full_name"`
+	Email     string `json:"email"`
+	AvatarUrl string `json:"avatar_url"`
+}
+
+func (gitea Gitea) GetUser(accessToken string) (*HostUser, error) {
+	b, err := giteaRequest("GET", accessToken, "/user", nil, 200)
+	if err != nil {
+		return nil, err
+	}
+	var user giteaUser
+	if err := json.Unmarshal(b, &user); err != nil || user.Id == 0 {
+		return nil, fmt.Errorf("Couldn't unmarshal user, json was:\n%v", string(b))
+	}
+	return &HostUser{
+		Id:        user.Id,
+		Login:     user.Login,
+		Name:      user.FullName,
+		Email:     user.Email,
+		AvatarUrl: user.AvatarUrl,
+	}, nil
+}
+
+func (gitea Gitea) CloneUrl(owner string, name string) string {
+	return configuration.GiteaUrl + "/" + owner + "/" + name + ".git"
+}
+
+// CloneCredentials send the token as the password, which Gitea accepts with
+// any username.
+func (gitea Gitea) CloneCredentials(accessToken string) Credentials {
+	return Credentials{Username: "oauth2", Password: accessToken}
+}
+
+func (gitea Gitea) IsRepositoryPrivate(owner string, name string) (bool, error) {
+	response, err := http.Get(giteaApiUrl("/repos/" + owner + "/" + name))
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+	return response.StatusCode != 200, nil
+}
+
+// RepositoryCollaborators returns every collaborator on a repository. Gitea
+// doesn't list their permissions, so each one is asked for separately.
+func (gitea Gitea) RepositoryCollaborators(accessToken string, owner string, name string) ([]Collaborator, error) {
+	var users []giteaUser
+	err := getPages(giteaApiUrl("/repos/"+owner+"/"+name+"/collaborators?limit=50"), giteaHeader(accessToken), func(b []byte) error {
+		var page []giteaUser
+		if err := json.Unmarshal(b, &page); err != nil {
+			return fmt.Errorf("Couldn't unmarshal collaborators, json was:\n%v", string(b))
+		}
+		users = append(users, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get collaborators on %v/%v: %v", owner, name, err)
+	}
+
+	var collaborators []Collaborator
+	for _, user := range users {
+		path := "/repos/" + owner + "/" + name + "/collaborators/" + url.PathEscape(user.Login) + "/permission"
+		b, err := giteaRequest("GET", accessToken, path, nil, 200)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't get the permission of %v on %v/%v: %v", user.Login, owner, name, err)
+		}
+		var permission struct {
+			Permission string `json:"permission"`
+		}
+		if err := json.Unmarshal(b, &permission); err != nil {
+			return nil, fmt.Errorf("Couldn't unmarshal permission, json was:\n%v", string(b))
+		}
+
+		collaborator := Collaborator{Id: user.Id, Login: user.Login}
+		collaborator.Permissions.Admin = permission.Permission == "admin" || permission.Permission == "owner"
+		collaborator.Permissions.Push = collaborator.Permissions.Admin || permission.Permission == "write"
+		collaborator.Permissions.Pull = true
+		collaborators = append(collaborators, collaborator)
+	}
+	return collaborators, nil
+}
+
+// Repositories works like Github's, because Gitea describes repositories the
+// same way.
+func (gitea Gitea) Repositories(accessToken string) ([]HostRepository, error) {
+	var repositories []HostRepository
+	err := getPages(giteaApiUrl("/user/repos?limit=50"), giteaHeader(accessToken), func(b []byte) error {
+		var page []HostRepository
+		if err := json.Unmarshal(b, &page); err != nil {
+			return fmt.Errorf("Couldn't unmarshal repositories, json was:\n%v", string(b))
+		}
+		repositories = append(repositories, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get repositories: %v", err)
+	}
+	return repositories, nil
+}
+
+// WantedHooks is a single hook for pushes and pull requests, which posts to
+// giteaHookHandler.
+func (gitea Gitea) WantedHooks() []Webhook {
+	return []Webhook{{Event: "push,pull_request", Url: hookUrl("gitea")}}
+}
+
+// CreateHooks gives every hook the webhook secret, which Gitea signs the
+// events it posts with.
+func (gitea Gitea) CreateHooks(accessToken string, owner string, repo string, wanted []Webhook) ([]Webhook, error) {
+	var hooks []Webhook
+	for _, hook := range wanted {
+		body := map[string]interface{}{
+			"type":   "gitea",
+			"active": true,
+			"events": strings.Split(hook.Event, ","),
+			"config": map[string]string{
+				"url":          hook.Url,
+				"content_type": "json",
+				"secret":       configuration.GiteaWebhookSecret,
+			},
+		}
+		b, err := giteaRequest("POST", accessToken, "/repos/"+owner+"/"+repo+"/hooks", body, 201)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't create the %v hook on %v/%v: %v", hook.Event, owner, repo, err)
+		}
+		// Gitea describes hooks the same way Github does.
+		var created githubHook
+		if err := json.Unmarshal(b, &created); err != nil {
+			return nil, fmt.Errorf("Couldn't unmarshal hook, json was:\n%v", string(b))
+		}
+		hooks = append(hooks, created.webhook())
+	}
+	return hooks, nil
+}
+
+func (gitea Gitea) Hooks(accessToken string, owner string, repo string) ([]Webhook, error) {
+	var hooks []Webhook
+	err := getPages(giteaApiUrl("/repos/"+owner+"/"+repo+"/hooks?limit=50"), giteaHeader(accessToken), func(b []byte) error {
+		var page []githubHook
+		if err := json.Unmarshal(b, &page); err != nil {
+			return fmt.Errorf("Couldn't unmarshal hooks, json was:\n%v", string(b))
+		}
+		for _, hook := range page {
+			hooks = append(hooks, hook.webhook())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get hooks on %v/%v: %v", owner, repo, err)
+	}
+	return hooks, nil
+}
+
+func (gitea Gitea) DeleteHook(accessToken string, owner string, repo string, id int) error {
+	request, _ := http.NewRequest("DELETE", giteaApiUrl(fmt.Sprintf("/repos/%v/%v/hooks/%v", owner, repo, id)), nil)
+	request.Header = giteaHeader(accessToken)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != 204 && response.StatusCode != 404 {
+		return fmt.Errorf("Couldn't delete hook %v on %v/%v, Gitea returned %v", id, owner, repo, response.StatusCode)
+	}
+	return nil
+}
+
+func (gitea Gitea) SetStatus(accessToken string, owner string, repo string, sha string, state CommitState, targetUrl string) error {
+	body := map[string]string{
+		"state":       string(state),
+		"target_url":  targetUrl,
+		"description": state.description(),
+		"context":     "builder",
+	}
+	_, err := giteaRequest("POST", accessToken, "/repos/"+owner+"/"+repo+"/statuses/"+sha, body, 201)
+	if err != nil {
+		return fmt.Errorf("Couldn't set the status of %v on %v/%v: %v", sha, owner, repo, err)
+	}
+	return nil
+}
+
+// validGiteaSignature checks that the body was signed with the webhook
+// secret. Nothing is valid without a secret, so nobody can post events that
+// look like they came from Gitea.
+func validGiteaSignature(body []byte, signature string) bool {
+	if configuration.GiteaWebhookSecret == "" {
+		return false
+	}
+	decoded, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(configuration.GiteaWebhookSecret))
+	mac.Write(body)
+	return hmac.Equal(decoded, mac.Sum(nil))
+}
+
+type giteaRepositoryEvent struct {
+	FullName string `json:"full_name"`
+}
+
+type giteaPushEvent struct {
+	Ref        string               `json:"ref"`
+	After      string               `json:"after"`
+	CompareUrl string               `json:"compare_url"`
+	Repository giteaRepositoryEvent `json:"repository"`
+	Pusher     giteaUser            `json:"pusher"`
+	Commits    []struct {
+		Id      string `json:"id"`
+		Message string `json:"message"`
+		Url     string `json:"url"`
+	} `json:"commits"`
+}
+
+type giteaPullRequestEvent struct {
+	Action      string               `json:"action"`
+	Repository  giteaRepositoryEvent `json:"repository"`
+	PullRequest struct {
+		HtmlUrl string    `json:"html_url"`
+		User    giteaUser `json:"user"`
+		Head    struct {
+			Ref string `json:"ref"`
+			Sha string `json:"sha"`
+		} `json:"head"`
+		Base struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+	} `json:"pull_request"`
+}
+
+// giteaHookHandler builds pushes and pull requests on Gitea repositories,
+// once it knows that Gitea sent them.
+func giteaHookHandler(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if !validGiteaSignature(body, r.Header.Get("X-Gitea-Signature")) {
+		http.Error(w, "The signature doesn't match the webhook secret", 403)
+		return
+	}
+
+	var build *Build
+	switch r.Header.Get("X-Gitea-Event") {
+	case "push":
+		var push giteaPushEvent
+		if err := json.Unmarshal(body, &push); err != nil {
+			fmt.Println("Error parsing push:", err)
+			return
+		}
+		if push.After == noCommit || !strings.HasPrefix(push.Ref, "refs/heads/") {
+			return
+		}
+		owner, name := splitFullName(push.Repository.FullName)
+		var commits []Commit
+		for _, c := range push.Commits {
+			commits = append(commits, Commit{Sha: c.Id, Message: c.Message, Url: c.Url})
+		}
+		build = &Build{
+			Host:       "gitea",
+			Owner:      owner,
+			Repository: name,
+			Ref:        strings.TrimPrefix(push.Ref, "refs/heads/"),
+			Author:     push.Pusher.Login,
+			Trigger:    triggerPush,
+			Sha:        push.After,
+			GithubUrl:  push.CompareUrl,
+			Commits:    commits,
+		}
+
+	case "pull_request":
+		var pullRequest giteaPullRequestEvent
+		if err := json.Unmarshal(body, &pullRequest); err != nil {
+			fmt.Println("Error parsing pull request:", err)
+			return
+		}
+		owner, name := splitFullName(pullRequest.Repository.FullName)
+		if pullRequest.Action == "closed" {
+			err := closePullRequest(r.Context(), "gitea", owner, name, pullRequest.PullRequest.Head.Ref)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			w.WriteHeader(200)
+			return
+		}
+		if pullRequest.Action != "opened" {
+			return
+		}
+		build = &Build{
+			Host:       "gitea",
+			Owner:      owner,
+			Repository: name,
+			Ref:        pullRequest.PullRequest.Head.Ref,
+			BaseRef:    pullRequest.PullRequest.Base.Ref,
+			Author:     pullRequest.PullRequest.User.Login,
+			Trigger:    triggerPullRequest,
+			Sha:        pullRequest.PullRequest.Head.Sha,
+			GithubUrl:  pullRequest.PullRequest.HtmlUrl,
+		}
+
+	default:
+		return
+	}
+
+	err := launcher.LaunchBuild(build)
+	if err != nil {
+		hookBuildError(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
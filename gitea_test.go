@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+const testGiteaSecret = "webhook-secret"
+
+// withFakedGitea points Gitea at a server playing Gitea.
+func withFakedGitea(ts *httptest.Server, block func()) {
+	oldUrl := configuration.GiteaUrl
+	configuration.GiteaUrl = ts.URL
+	defer func() { configuration.GiteaUrl = oldUrl }()
+	block()
+}
+
+func withGiteaWebhookSecret(secret string, block func()) {
+	oldSecret := configuration.GiteaWebhookSecret
+	configuration.GiteaWebhookSecret = secret
+	defer func() { configuration.GiteaWebhookSecret = oldSecret }()
+	block()
+}
+
+func signGiteaBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// giteaHookRequest posts the fixture as Gitea would, signed with secret.
+func giteaHookRequest(event string, bodyPath string, secret string) *http.Request {
+	body, _ := ioutil.ReadFile(bodyPath)
+	r := createFakeRequest(bodyPath)
+	r.Header = http.Header{
+		"X-Gitea-Event":     {event},
+		"X-Gitea-Signature": {signGiteaBody(secret, body)},
+	}
+	return r
+}
+
+func TestGiteaAuthorizeUrl(t *testing.T) {
+	oldUrl := configuration.GiteaUrl
+	configuration.GiteaUrl = "https://gitea.example.com"
+	defer func() { configuration.GiteaUrl = oldUrl }()
+
+	authorizeUrl, err := url.Parse(Gitea{}.AuthorizeUrl("STATE", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authorizeUrl.Query()
+	if authorizeUrl.Host != "gitea.example.com" || authorizeUrl.Path != "/login/oauth/authorize" ||
+		query.Get("state") != "STATE" || query.Get("redirect_uri") != "http://localhost:1212/gitea_callback" {
+		t.Errorf("Unexpected authorize url %v", authorizeUrl)
+	}
+}
+
+func TestGetsAccessTokenAndUserFromGitea(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/login/oauth/access_token" && r.FormValue("code") == "CODE":
+			w.Write([]byte(`{ "access_token": "TOKEN", "token_type": "bearer" }`))
+		case r.URL.Path == "/api/v1/user" && r.Header.Get("Authorization") == "Bearer TOKEN":
+			w.Write([]byte(`{ "id": 3, "login": "AndrewVos", "full_name": "Andrew Vos", "email": "andrew.vos@gmail.com" }`))
+		default:
+			w.WriteHeader(401)
+		}
+	}))
+	defer ts.Close()
+
+	withFakedGitea(ts, func() {
+		token, err := Gitea{}.GetAccessToken("CODE")
+		if err != nil || token != "TOKEN" {
+			t.Fatalf("Expected the access token, got %q, %v", token, err)
+		}
+		user, err := Gitea{}.GetUser(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := &HostUser{Id: 3, Login: "AndrewVos", Name: "Andrew Vos", Email: "andrew.vos@gmail.com"}
+		if !reflect.DeepEqual(user, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, user)
+		}
+	})
+}
+
+func TestGiteaCollaboratorsGetTheirPermissions(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		permissions := map[string]string{"admin": "admin", "writer": "write", "reader": "read"}
+		switch r.URL.Path {
+		case "/api/v1/repos/owner/repo/collaborators":
+			w.Write([]byte(`[{ "id": 1, "login": "admin" }, { "id": 2, "login": "writer" }, { "id": 3, "login": "reader" }]`))
+		case "/api/v1/repos/owner/repo/collaborators/admin/permission",
+			"/api/v1/repos/owner/repo/collaborators/writer/permission",
+			"/api/v1/repos/owner/repo/collaborators/reader/permission":
+			login := r.URL.Path[len("/api/v1/repos/owner/repo/collaborators/") : len(r.URL.Path)-len("/permission")]
+			json.NewEncoder(w).Encode(map[string]string{"permission": permissions[login]})
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	withFakedGitea(ts, func() {
+		collaborators, err := Gitea{}.RepositoryCollaborators("TOKEN", "owner", "repo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		roles := map[int]Role{}
+		for _, collaborator := range collaborators {
+			roles[collaborator.Id] = collaborator.Role()
+		}
+		expected := map[int]Role{1: roleAdmin, 2: roleWrite, 3: roleRead}
+		if !reflect.DeepEqual(roles, expected) {
+			t.Errorf("Expected %v, got %v", expected, roles)
+		}
+	})
+}
+
+func TestCreatesGiteaHooksWithTheSecret(t *testing.T) {
+	var created struct {
+		Type   string
+		Events []string
+		Config map[string]string
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/v1/repos/owner/repo/hooks" {
+			w.WriteHeader(404)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&created)
+		w.WriteHeader(201)
+		w.Write([]byte(`{ "id": 9, "type": "gitea", "events": [ "push", "pull_request" ], "config": { "url": "http://localhost:1212/hooks/gitea", "content_type": "json" } }`))
+	}))
+	defer ts.Close()
+
+	gitea := Gitea{}
+	withGiteaWebhookSecret(testGiteaSecret, func() {
+		withFakedGitea(ts, func() {
+			hooks, err := gitea.CreateHooks("TOKEN", "owner", "repo", gitea.WantedHooks())
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []Webhook{{Id: 9, Event: "push,pull_request", Url: "http://localhost:1212/hooks/gitea"}}
+			if !reflect.DeepEqual(hooks, expected) {
+				t.Errorf("Expected %+v, got %+v", expected, hooks)
+			}
+		})
+	})
+	if created.Type != "gitea" || !reflect.DeepEqual(created.Events, []string{"push", "pull_request"}) ||
+		created.Config["secret"] != testGiteaSecret || created.Config["url"] != "http://localhost:1212/hooks/gitea" {
+		t.Errorf("Created the wrong hook %+v", created)
+	}
+}
+
+func TestSetsCommitStatusOnGitea(t *testing.T) {
+	var path string
+	var body map[string]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(201)
+	}))
+	defer ts.Close()
+
+	withFakedGitea(ts, func() {
+		err := Gitea{}.SetStatus("TOKEN", "owner", "repo", "abc123", statePending, "http://localhost:1212/build/1/output")
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if path != "/api/v1/repos/owner/repo/statuses/abc123" {
+		t.Errorf("Posted to the wrong url %v", path)
+	}
+	if body["state"] != "pending" || body["context"] != "builder" || body["target_url"] != "http://localhost:1212/build/1/output" {
+		t.Errorf("Posted the wrong status %v", body)
+	}
+}
+
+func TestValidGiteaSignature(t *testing.T) {
+	body := []byte(`{ "ref": "refs/heads/master" }`)
+	withGiteaWebhookSecret(testGiteaSecret, func() {
+		if !validGiteaSignature(body, signGiteaBody(testGiteaSecret, body)) {
+			t.Errorf("Expected the signature to be valid")
+		}
+		if validGiteaSignature(body, signGiteaBody("some other secret", body)) {
+			t.Errorf("Expected a signature with another secret to be invalid")
+		}
+		if validGiteaSignature(body, "not hex") || validGiteaSignature(body, "") {
+			t.Errorf("Expected malformed signatures to be invalid")
+		}
+	})
+	withGiteaWebhookSecret("", func() {
+		if validGiteaSignature(body, signGiteaBody("", body)) {
+			t.Errorf("Expected nothing to be valid without a secret")
+		}
+	})
+}
+
+func TestGiteaHookHandlerRejectsUnsignedEvents(t *testing.T) {
+	withGiteaWebhookSecret(testGiteaSecret, func() {
+		withFakeLauncher(func(fbl *FakeBuildLauncher) {
+			w := httptest.NewRecorder()
+			giteaHookHandler(w, giteaHookRequest("push", "test-data/gitea_push.json", "some other secret"))
+			if w.Code != 403 {
+				t.Errorf("Expected a 403, got %v", w.Code)
+			}
+			if fbl.launchedBuild {
+				t.Error("Shouldn't build events that Gitea didn't sign")
+			}
+		})
+	})
+}
+
+func TestGiteaHookHandlerLaunchesPushBuilds(t *testing.T) {
+	withGiteaWebhookSecret(testGiteaSecret, func() {
+		withFakeLauncher(func(fbl *FakeBuildLauncher) {
+			giteaHookHandler(httptest.NewRecorder(), giteaHookRequest("push", "test-data/gitea_push.json", testGiteaSecret))
+
+			expectedValues := map[string]interface{}{
+				"owner":     "AndrewVos",
+				"repo":      "builder-test-green-repo",
+				"ref":       "master",
+				"baseRef":   "",
+				"author":    "AndrewVos",
+				"trigger":   "push",
+				"sha":       "576be25d7e3d5320e92472d5734b50b17c1822e0",
+				"githubURL": "https://gitea.example.com/AndrewVos/builder-test-green-repo/compare/da46166aa12075d4ed77847cc98dcb9039d01dcf...576be25d7e3d5320e92472d5734b50b17c1822e0",
+			}
+			if !reflect.DeepEqual(fbl.values, expectedValues) {
+				t.Errorf("Expected %v, got %v", expectedValues, fbl.values)
+			}
+			if len(fbl.commits) != 2 || fbl.commits[0].Sha != "92a9437adf4ac6f0114552e5149d0598fdbf0355" {
+				t.Errorf("Expected the pushed commits, got %+v", fbl.commits)
+			}
+		})
+	})
+}
+
+func TestGiteaHookHandlerLaunchesPullRequestBuilds(t *testing.T) {
+	withGiteaWebhookSecret(testGiteaSecret, func() {
+		withFakeLauncher(func(fbl *FakeBuildLauncher) {
+			giteaHookHandler(httptest.NewRecorder(), giteaHookRequest("pull_request", "test-data/gitea_pull_request.json", testGiteaSecret))
+
+			expectedValues := map[string]interface{}{
+				"owner":     "AndrewVos",
+				"repo":      "builder-test-green-repo",
+				"ref":       "pool-request",
+				"baseRef":   "master",
+				"author":    "AndrewVos",
+				"trigger":   "pull_request",
+				"sha":       "576be25d7e3d5320e92472d5734b50b17c1822e0",
+				"githubURL": "https://gitea.example.com/AndrewVos/builder-test-green-repo/pulls/1",
+			}
+			if !reflect.DeepEqual(fbl.values, expectedValues) {
+				t.Errorf("Expected %v, got %v", expectedValues, fbl.values)
+			}
+		})
+	})
+}
+
+func TestGiteaHookHandlerClosesPullRequests(t *testing.T) {
+	resetMemoryDatabase()
+	ctx := context.Background()
+
+	repository := createAccountWithRepository(&Account{HostUserId: 3, Host: "gitea"}, "AndrewVos", "builder-test-green-repo")
+	build := &Build{Owner: "AndrewVos", Repository: "builder-test-green-repo", Ref: "pool-request", Trigger: triggerPullRequest}
+	database.CreateBuild(ctx, repository, build)
+
+	withGiteaWebhookSecret(testGiteaSecret, func() {
+		withFakeLauncher(func(fbl *FakeBuildLauncher) {
+			giteaHookHandler(httptest.NewRecorder(), giteaHookRequest("pull_request", "test-data/gitea_closed_pull_request.json", testGiteaSecret))
+			if fbl.launchedBuild {
+				t.Error("Shouldn't build closed pull requests")
+			}
+		})
+	})
+
+	builds, _ := database.RepositoryBuilds(ctx, repository)
+	if len(builds) != 1 || !builds[0].PullRequestClosed {
+		t.Errorf("Expected the pull request build to be closed, got %+v", builds)
+	}
+}
+
+func TestGithubHooksDontBuildGiteaRepositories(t *testing.T) {
+	defer cleanDataDirectory()
+	resetMemoryDatabase()
+	ctx := context.Background()
+
+	repository := createAccountWithRepository(&Account{HostUserId: 1, Host: "gitea"}, "AndrewVos", "builder-test-green-repo")
+	build := &Build{Owner: "AndrewVos", Repository: "builder-test-green-repo", Ref: "pool-request", Trigger: triggerPullRequest}
+	database.CreateBuild(ctx, repository, build)
+
+	w := httptest.NewRecorder()
+	pushHandler(w, createFakeRequest("test-data/green_push.json"))
+	if w.Code != 404 {
+		t.Errorf("Expected the Github push hook to refuse the Gitea repository, got %v", w.Code)
+	}
+	pullRequestHandler(httptest.NewRecorder(), createFakeRequest("test-data/closed_pull_request.json"))
+
+	builds, _ := database.RepositoryBuilds(ctx, repository)
+	if len(builds) != 1 || builds[0].PullRequestClosed {
+		t.Errorf("Expected the Github hooks to leave the Gitea repository's builds alone, got %+v", builds)
+	}
+}
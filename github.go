@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Github is the SourceHost for github.com, or a Github Enterprise
+// installation when its urls are configured.
+type Github struct{}
+
+func (github Github) Name() string {
+	return "Github"
+}
+
+// githubHeader authenticates requests to the Github API with an access
+// token, which is kept out of urls so it can't end up in logs.
+func githubHeader(accessToken string) http.Header {
+	return http.Header{"Authorization": {"token " + accessToken}}
+}
+
+func (github Github) AuthorizeUrl(state string, private bool) string {
+	query := url.Values{
+		"client_id": {configuration.GithubClientID},
+		"state":     {state},
+	}
+	// Private repositories can only be built with the repo scope.
+	if private {
+		query.Set("scope", "repo")
+	}
+	return configuration.GithubWebUrl + "/login/oauth/authorize?" + query.Encode()
+}
+
+func (github Github) CloneUrl(owner string, name string) string {
+	return configuration.GithubGitUrl + "/" + owner + "/" + name
+}
+
+func (github Github) CloneCredentials(accessToken string) Credentials {
+	return Credentials{Username: "x-access-token", Password: accessToken}
+}
+
+// WantedHooks are a hook for pushes and another for pull requests, which
+// post to pushHandler and pullRequestHandler.
+func (github Github) WantedHooks() []Webhook {
+	var hooks []Webhook
+	for _, event := range []string{"push", "pull_request"} {
+		hooks = append(hooks, Webhook{Event: event, Url: hookUrl(event)})
+	}
+	return hooks
+}
+
+func (github Github) CreateHooks(accessToken string, owner string, repo string, wanted []Webhook) ([]Webhook, error) {
+	url := configuration.GithubApiUrl + "/repos/" + owner + "/" + repo + "/hooks"
+
+	var hooks []Webhook
+	for _, hook := range wanted {
+		event := hook.Event
+		body := `{
+      "name": "web",
+      "active": true,
+      "events": [ "` + event + `" ],
+      "config": {
+        "url": "` + hook.Url + `",
+        "content_type": "json"
+      }
+    }`
+
+		client := &http.Client{}
+		request, _ := http.NewRequest("POST", url, strings.NewReader(body))
+		request.Header = githubHeader(accessToken)
+		response, err := client.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if response.StatusCode == 401 {
+			return nil, errors.New("Access Token appears to be invalid")
+		}
+		if response.StatusCode != 201 {
+			return nil, fmt.Errorf("Couldn't create the %v hook on %v/%v, Github returned %v:\n%v", event, owner, repo, response.StatusCode, string(b))
+		}
+		var hook githubHook
+		if err := json.Unmarshal(b, &hook); err != nil {
+			return nil, fmt.Errorf("Couldn't unmarshal hook, json was:\n%v", string(b))
+		}
+		hooks = append(hooks, hook.webhook())
+	}
+	return hooks, nil
+}
+
+// githubHook is a webhook as Github describes it.
+type githubHook struct {
+	Id     int      `json:"id"`
+	Events []string `json:"events"`
+	Config struct {
+		Url string `json:"url"`
+	} `json:"config"`
+}
+
+func (hook githubHook) webhook() Webhook {
+	return Webhook{Id: hook.Id, Event: strings.Join(hook.Events, ","), Url: hook.Config.Url}
+}
+
+func (github Github) Hooks(accessToken string, owner string, repo string) ([]Webhook, error) {
+	url := fmt.Sprintf("%v/repos/%v/%v/hooks?per_page=100", configuration.GithubApiUrl, owner, repo)
+	var hooks []Webhook
+	err := getPages(url, githubHeader(accessToken), func(b []byte) error {
+		var page []githubHook
+		if err := json.Unmarshal(b, &page); err != nil {
+			return fmt.Errorf("Couldn't unmarshal hooks, json was:\n%v", string(b))
+		}
+		for _, hook := range page {
+			hooks = append(hooks, hook.webhook())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get hooks on %v/%v: %v", owner, repo, err)
+	}
+	return hooks, nil
+}
+
+func (github Github) DeleteHook(accessToken string, owner string, repo string, id int) error {
+	url := fmt.Sprintf("%v/repos/%v/%v/hooks/%v", configuration.GithubApiUrl, owner, repo, id)
+	request, _ := http.NewRequest("DELETE", url, nil)
+	request.Header = githubHeader(accessToken)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != 204 && response.StatusCode != 404 {
+		return fmt.Errorf("Couldn't delete hook %v on %v/%v, Github returned %v", id, owner, repo, response.StatusCode)
+	}
+	return nil
+}
+
+func (github Github) Repositories(accessToken string) ([]HostRepository, error) {
+	url := fmt.Sprintf("%v/user/repos?per_page=100", configuration.GithubApiUrl)
+	var repositories []HostRepository
+	err := getPages(url, githubHeader(accessToken), func(b []byte) error {
+		var page []HostRepository
+		if err := json.Unmarshal(b, &page); err != nil {
+			return fmt.Errorf("Couldn't unmarshal repositories, json was:\n%v", string(b))
+		}
+		repositories = append(repositories, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get repositories: %v", err)
+	}
+	return repositories, nil
+}
+
+func (github Github) GetAccessToken(code string) (string, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"client_id":     configuration.GithubClientID,
+		"client_secret": configuration.GithubClientSecret,
+		"code":          code,
+	})
+
+	client := &http.Client{}
+	request, _ := http.NewRequest("POST", configuration.GithubWebUrl+"/login/oauth/access_token", bytes.NewReader(body))
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		fmt.Println(err)
+		return "", err
+	}
+
+	defer response.Body.Close()
+	body, _ = ioutil.ReadAll(response.Body)
+	var accessTokenResponse map[string]string
+	json.Unmarshal(body, &accessTokenResponse)
+
+	if token, ok := accessTokenResponse["access_token"]; ok {
+		return token, nil
+	}
+	if description := accessTokenResponse["error_description"]; description != "" {
+		return "", errors.New("Error retrieving access token: " + description)
+	}
+	return "", errors.New("Error retrieving access token")
+}
+
+func (github Github) GetUser(accessToken string) (*HostUser, error) {
+	request, _ := http.NewRequest("GET", configuration.GithubApiUrl+"/user", nil)
+	request.Header = githubHeader(accessToken)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	user := &HostUser{}
+	if err := json.Unmarshal(b, user); err != nil || user.Id == 0 {
+		return nil, fmt.Errorf("Couldn't unmarshal user, json was:\n%v", string(b))
+	}
+	return user, nil
+}
+
+func (github Github) IsRepositoryPrivate(owner string, name string) (bool, error) {
+	url := fmt.Sprintf("%v/repos/%v/%v", configuration.GithubApiUrl, owner, name)
+	response, err := http.Get(url)
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+	return response.StatusCode != 200, nil
+}
+
+// RepositoryCollaborators returns every collaborator on a repository,
+// following Github's pages of results.
+func (github Github) RepositoryCollaborators(accessToken string, owner string, name string) ([]Collaborator, error) {
+	url := fmt.Sprintf("%v/repos/%v/%v/collaborators?per_page=100", configuration.GithubApiUrl, owner, name)
+	var collaborators []Collaborator
+	err := getPages(url, githubHeader(accessToken), func(b []byte) error {
+		var page []Collaborator
+		if err := json.Unmarshal(b, &page); err != nil {
+			return fmt.Errorf("Couldn't unmarshal collaborators, json was:\n%v", string(b))
+		}
+		collaborators = append(collaborators, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get collaborators on %v/%v: %v", owner, name, err)
+	}
+	return collaborators, nil
+}
+
+func (github Github) SetStatus(accessToken string, owner string, repo string, sha string, state CommitState, targetUrl string) error {
+	body, _ := json.Marshal(map[string]string{
+		"state":       string(state),
+		"target_url":  targetUrl,
+		"description": state.description(),
+		"context":     "builder",
+	})
+	url := fmt.Sprintf("%v/repos/%v/%v/statuses/%v", configuration.GithubApiUrl, owner, repo, sha)
+	request, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+	request.Header = githubHeader(accessToken)
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != 201 {
+		return fmt.Errorf("Couldn't set the status of %v on %v/%v, Github returned %v", sha, owner, repo, response.StatusCode)
+	}
+	return nil
+}
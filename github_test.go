@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreatesPushAndPullRequestHooks(t *testing.T) {
+	var paths []string
+	var bodies []string
+	var authorizations []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(201)
+		fmt.Fprintf(w, `{ "id": %d, "events": [ "push" ], "config": { "url": "http://localhost:1212/hooks/push" } }`, len(paths))
+	}))
+	oldUrl := configuration.GithubApiUrl
+	configuration.GithubApiUrl = ts.URL
+
+	defer func() {
+		configuration.GithubApiUrl = oldUrl
+		ts.Close()
+	}()
+
+	supportedEvents := []string{"push", "pull_request"}
+	github := Github{}
+	hooks, err := github.CreateHooks("lolsszz", "AndrewVos", "builder", github.WantedHooks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 2 || hooks[0].Id != 1 || hooks[1].Id != 2 {
+		t.Errorf("Expected the created hooks to be returned, got %+v", hooks)
+	}
+
+	for i, event := range supportedEvents {
+		expectedPath := "/repos/AndrewVos/builder/hooks"
+		if paths[i] != expectedPath {
+			t.Errorf("Got wrong post address\nExpected: %v\nActual: %v", expectedPath, paths[i])
+		}
+		if authorizations[i] != "token lolsszz" {
+			t.Errorf("Expected the access token in the Authorization header, got %q", authorizations[i])
+		}
+		expectedBody := `{
+      "name": "web",
+      "active": true,
+      "events": [ "` + event + `" ],
+      "config": {
+        "url": "http://localhost:1212/hooks/` + event + `",
+        "content_type": "json"
+      }
+    }`
+		if bodies[i] != expectedBody {
+			t.Errorf("Didn't post expected body\nExpected:\n%v\nActual:\n%v", expectedBody, bodies[i])
+		}
+	}
+}
+
+func TestCanTellIfARepositoryIsPrivate(t *testing.T) {
+	github := Github{}
+
+	status := 0
+	serverThatReturnsStatus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	oldUrl := configuration.GithubApiUrl
+	configuration.GithubApiUrl = serverThatReturnsStatus.URL
+	defer func() {
+		configuration.GithubApiUrl = oldUrl
+		serverThatReturnsStatus.Close()
+	}()
+
+	status = 200
+	if private, err := github.IsRepositoryPrivate("bla", "reponame"); private || err != nil {
+		t.Errorf("repository isn't actually private, got %v", err)
+	}
+
+	status = 404
+	if private, err := github.IsRepositoryPrivate("blaaaa", "ergh"); !private || err != nil {
+		t.Errorf("repository returned 404, so it should be private, got %v", err)
+	}
+}
+
+func TestIsRepositoryPrivateFailsWhenGithubCantBeReached(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.Close()
+
+	withFakedGithubApiDomain(ts.URL, func() {
+		if _, err := (Github{}).IsRepositoryPrivate("owner", "repo"); err == nil {
+			t.Errorf("Expected an error when Github can't be reached")
+		}
+	})
+}
+
+func withFakedGithubApiDomain(domain string, block func()) {
+	oldUrl := configuration.GithubApiUrl
+	configuration.GithubApiUrl = domain
+	block()
+	configuration.GithubApiUrl = oldUrl
+}
+
+// withFakedGithub points both the Github API and website at a server
+// playing Github, like a Github Enterprise installation.
+func withFakedGithub(ts *httptest.Server, block func()) {
+	oldApiUrl, oldWebUrl := configuration.GithubApiUrl, configuration.GithubWebUrl
+	configuration.GithubApiUrl, configuration.GithubWebUrl = ts.URL+"/api/v3", ts.URL
+	defer func() { configuration.GithubApiUrl, configuration.GithubWebUrl = oldApiUrl, oldWebUrl }()
+	block()
+}
+
+func TestListsRepoCollaborators(t *testing.T) {
+	github := Github{}
+
+	response := `
+    [
+      { "login": "AndrewVos", "id": 363618 },
+      { "login": "andrewvo", "id": 1605821 }
+    ]
+  `
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expectedUrl := fmt.Sprintf("/repos/%v/%v/collaborators?per_page=100", "owner1", "repo3")
+		if r.URL.RequestURI() == expectedUrl && r.Header.Get("Authorization") == "token TOKEN" {
+			w.Write([]byte(response))
+		}
+	}))
+	defer ts.Close()
+
+	withFakedGithubApiDomain(ts.URL, func() {
+		collaborators, err := github.RepositoryCollaborators("TOKEN", "owner1", "repo3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(collaborators) != 2 {
+			t.Fatalf("Expected to have 2 collaborators, not %d\n", len(collaborators))
+		}
+		if collaborators[0].Login != "AndrewVos" || collaborators[0].Id != 363618 {
+			t.Errorf("Collaborator 0 was wrong. Got:\n%+v", collaborators[0])
+		}
+		if collaborators[1].Login != "andrewvo" || collaborators[1].Id != 1605821 {
+			t.Errorf("Collaborator 1 was wrong. Got:\n%+v", collaborators[1])
+		}
+	})
+}
+
+func TestRepoCollaboratorsFollowsPages(t *testing.T) {
+	github := Github{}
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token TOKEN" {
+			w.WriteHeader(401)
+			return
+		}
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(`[{ "login": "andrewvo", "id": 1605821 }]`))
+			return
+		}
+		next := ts.URL + r.URL.Path + "?per_page=100&page=2"
+		w.Header().Set("Link", fmt.Sprintf(`<%v>; rel="next", <%v>; rel="last"`, next, next))
+		w.Write([]byte(`[{ "login": "AndrewVos", "id": 363618 }]`))
+	}))
+	defer ts.Close()
+
+	withFakedGithubApiDomain(ts.URL, func() {
+		collaborators, err := github.RepositoryCollaborators("TOKEN", "owner1", "repo3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(collaborators) != 2 || collaborators[0].Id != 363618 || collaborators[1].Id != 1605821 {
+			t.Errorf("Expected collaborators from both pages, got %+v", collaborators)
+		}
+	})
+}
+
+func TestRepoCollaboratorsFailsWhenGithubDoes(t *testing.T) {
+	github := Github{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(401)
+		w.Write([]byte(`{ "message": "Bad credentials" }`))
+	}))
+	defer ts.Close()
+
+	withFakedGithubApiDomain(ts.URL, func() {
+		collaborators, err := github.RepositoryCollaborators("TOKEN", "owner1", "repo3")
+		if err == nil {
+			t.Errorf("Expected an error, got %+v", collaborators)
+		}
+	})
+}
+
+func TestListsRepoHooks(t *testing.T) {
+	github := Github{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/owner1/repo3/hooks" || r.Header.Get("Authorization") != "token TOKEN" {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write([]byte(`[{ "id": 12, "events": [ "push" ], "config": { "url": "http://localhost:1212/hooks/push" } }]`))
+	}))
+	defer ts.Close()
+
+	withFakedGithubApiDomain(ts.URL, func() {
+		hooks, err := github.Hooks("TOKEN", "owner1", "repo3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := Webhook{Id: 12, Event: "push", Url: "http://localhost:1212/hooks/push"}
+		if len(hooks) != 1 || hooks[0] != expected {
+			t.Errorf("Expected the hook, got %+v", hooks)
+		}
+	})
+}
+
+func TestDeletesRepoHooks(t *testing.T) {
+	github := Github{}
+
+	status := 0
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.RequestURI()+" "+r.Header.Get("Authorization"))
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	withFakedGithubApiDomain(ts.URL, func() {
+		for code, fails := range map[int]bool{204: false, 404: false, 500: true} {
+			status = code
+			if err := github.DeleteHook("TOKEN", "owner1", "repo3", 12); (err != nil) != fails {
+				t.Errorf("Expected a %v to fail: %v, got %v", code, fails, err)
+			}
+		}
+	})
+	if paths[0] != "DELETE /repos/owner1/repo3/hooks/12 token TOKEN" {
+		t.Errorf("Expected the hook to be deleted, got %v", paths)
+	}
+}
+
+func TestListsUserRepositories(t *testing.T) {
+	github := Github{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user/repos" || r.Header.Get("Authorization") != "token TOKEN" {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write([]byte(`[{ "name": "builder", "full_name": "AndrewVos/builder", "private": true, "owner": { "login": "AndrewVos" } }]`))
+	}))
+	defer ts.Close()
+
+	withFakedGithubApiDomain(ts.URL, func() {
+		repositories, err := github.Repositories("TOKEN")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(repositories) != 1 || repositories[0].Name != "builder" ||
+			repositories[0].FullName != "AndrewVos/builder" || !repositories[0].Private {
+			t.Errorf("Expected the repository, got %+v", repositories)
+		}
+	})
+}
+
+func TestGetsAccessTokenFromGithub(t *testing.T) {
+	github := Github{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Method != "POST" || r.URL.Path != "/login/oauth/access_token" || body["code"] != "CODE" || body["client_secret"] != "SECRET" {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write([]byte(`{ "access_token": "TOKEN", "token_type": "bearer" }`))
+	}))
+	defer ts.Close()
+
+	oldSecret := configuration.GithubClientSecret
+	configuration.GithubClientSecret = "SECRET"
+	defer func() { configuration.GithubClientSecret = oldSecret }()
+
+	withFakedGithub(ts, func() {
+		token, err := github.GetAccessToken("CODE")
+		if err != nil || token != "TOKEN" {
+			t.Errorf("Expected the access token, got %q, %v", token, err)
+		}
+	})
+}
+
+func TestGetsUserFromGithub(t *testing.T) {
+	github := Github{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/user" || r.Header.Get("Authorization") != "token TOKEN" {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write([]byte(`{ "id": 363618, "login": "AndrewVos", "name": "Andrew Vos" }`))
+	}))
+	defer ts.Close()
+
+	withFakedGithub(ts, func() {
+		user, err := github.GetUser("TOKEN")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Id != 363618 || user.Login != "AndrewVos" || user.Name != "Andrew Vos" {
+			t.Errorf("Expected the user, got %+v", user)
+		}
+	})
+}
+
+func TestSetsCommitStatusOnGithub(t *testing.T) {
+	github := Github{}
+
+	var path, authorization string
+	var body map[string]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.RequestURI()
+		authorization = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(201)
+	}))
+	defer ts.Close()
+
+	withFakedGithubApiDomain(ts.URL, func() {
+		err := github.SetStatus("TOKEN", "owner1", "repo3", "abc123", stateFailure, "http://localhost:1212/build/1/output")
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if path != "/repos/owner1/repo3/statuses/abc123" || authorization != "token TOKEN" {
+		t.Errorf("Posted to the wrong url %v with %q", path, authorization)
+	}
+	if body["state"] != "failure" || body["target_url"] != "http://localhost:1212/build/1/output" || body["context"] != "builder" {
+		t.Errorf("Posted the wrong status %v", body)
+	}
+}
+
+func TestCloneCredentialsForGithub(t *testing.T) {
+	credentials := Github{}.CloneCredentials("secret-token")
+	if credentials.Username != "x-access-token" || credentials.Password != "secret-token" {
+		t.Errorf("Expected the token to be the password, got %+v", credentials)
+	}
+}
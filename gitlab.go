@@ -0,0 +1,418 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Gitlab is the SourceHost for gitlab.com, or a GitLab installation at
+// GITLAB_URL.
+type Gitlab struct{}
+
+func (gitlab Gitlab) Name() string {
+	return "GitLab"
+}
+
+func gitlabApiUrl(path string) string {
+	return configuration.GitlabUrl + "/api/v4" + path
+}
+
+// gitlabProject is how GitLab's API refers to the repository owner/name.
+func gitlabProject(owner string, name string) string {
+	return url.PathEscape(owner + "/" + name)
+}
+
+func gitlabHeader(accessToken string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + accessToken}}
+}
+
+// gitlabRequest sends body to the GitLab API, see sendJson.
+func gitlabRequest(method string, accessToken string, path string, body interface{}, expected int) ([]byte, error) {
+	return sendJson(method, gitlabApiUrl(path), gitlabHeader(accessToken), body, expected)
+}
+
+func gitlabRedirectUrl() string {
+	return configuration.Host + ":" + configuration.Port + "/gitlab_callback"
+}
+
+// AuthorizeUrl always asks for the api scope, which GitLab needs for
+// webhooks and statuses, so there's nothing more to ask for private
+// repositories.
+func (gitlab Gitlab) AuthorizeUrl(state string, private bool) string {
+	query := url.Values{
+		"client_id":     {configuration.GitlabClientID},
+		"redirect_uri":  {gitlabRedirectUrl()},
+		"response_type": {"code"},
+		"state":         {state},
+		"scope":         {"api"},
+	}
+	return configuration.GitlabUrl + "/oauth/authorize?" + query.Encode()
+}
+
+func (gitlab Gitlab) GetAccessToken(code string) (string, error) {
+	response, err := http.PostForm(configuration.GitlabUrl+"/oauth/token", url.Values{
+		"client_id":     {configuration.GitlabClientID},
+		"client_secret": {configuration.GitlabClientSecret},
+		"code":          {code},
+		"grant_type":    {"authorization_code"},
+		"redirect_uri":  {gitlabRedirectUrl()},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(response.Body)
+	var accessTokenResponse map[string]interface{}
+	json.Unmarshal(body, &accessTokenResponse)
+
+	if token, ok := accessTokenResponse["access_token"].(string); ok && token != "" {
+		return token, nil
+	}
+	if description, ok := accessTokenResponse["error_description"].(string); ok && description != "" {
+		return "", errors.New("Error retrieving access token: " + description)
+	}
+	return "", errors.New("Error retrieving access token")
+}
+
+// gitlabUser is a user as GitLab describes them.
+type gitlabUser struct {
+	Id          int    `json:"id"`
+	Username    string `json:"username"`
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	AvatarUrl   string `json:"avatar_url"`
+	AccessLevel int    `json:"access_level"`
+}
+
+func (gitlab Gitlab) GetUser(accessToken string) (*HostUser, error) {
+	b, err := gitlabRequest("GET", accessToken, "/user", nil, 200)
+	if err != nil {
+		return nil, err
+	}
+	var user gitlabUser
+	if err := json.Unmarshal(b, &user); err != nil || user.Id == 0 {
+		return nil, fmt.Errorf("Couldn't unmarshal user, json was:\n%v", string(b))
+	}
+	return &HostUser{
+		Id:        user.Id,
+		Login:     user.Username,
+		Name:      user.Name,
+		Email:     user.Email,
+		AvatarUrl: user.AvatarUrl,
+	}, nil
+}
+
+func (gitlab Gitlab) CloneUrl(owner string, name string) string {
+	return configuration.GitlabUrl + "/" + owner + "/" + name + ".git"
+}
+
+func (gitlab Gitlab) CloneCredentials(accessToken string) Credentials {
+	return Credentials{Username: "oauth2", Password: accessToken}
+}
+
+func (gitlab Gitlab) IsRepositoryPrivate(owner string, name string) (bool, error) {
+	response, err := http.Get(gitlabApiUrl("/projects/" + gitlabProject(owner, name)))
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+	return response.StatusCode != 200, nil
+}
+
+// RepositoryCollaborators returns every member of a project, including the
+// ones it inherits from its groups. Maintainers and owners are admins, and
+// developers can push.
+func (gitlab Gitlab) RepositoryCollaborators(accessToken string, owner string, name string) ([]Collaborator, error) {
+	url := gitlabApiUrl("/projects/" + gitlabProject(owner, name) + "/members/all?per_page=100")
+	var collaborators []Collaborator
+	err := getPages(url, gitlabHeader(accessToken), func(b []byte) error {
+		var page []gitlabUser
+		if err := json.Unmarshal(b, &page); err != nil {
+			return fmt.Errorf("Couldn't unmarshal members, json was:\n%v", string(b))
+		}
+		for _, member := range page {
+			collaborator := Collaborator{Id: member.Id, Login: member.Username}
+			collaborator.Permissions.Admin = member.AccessLevel >= 40
+			collaborator.Permissions.Push = member.AccessLevel >= 30
+			collaborator.Permissions.Pull = true
+			collaborators = append(collaborators, collaborator)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get members of %v/%v: %v", owner, name, err)
+	}
+	return collaborators, nil
+}
+
+func (gitlab Gitlab) Repositories(accessToken string) ([]HostRepository, error) {
+	url := gitlabApiUrl("/projects?membership=true&simple=true&per_page=100")
+	var repositories []HostRepository
+	err := getPages(url, gitlabHeader(accessToken), func(b []byte) error {
+		var page []struct {
+			Path              string `json:"path"`
+			PathWithNamespace string `json:"path_with_namespace"`
+			Visibility        string `json:"visibility"`
+		}
+		if err := json.Unmarshal(b, &page); err != nil {
+			return fmt.Errorf("Couldn't unmarshal projects, json was:\n%v", string(b))
+		}
+		for _, project := range page {
+			repositories = append(repositories, HostRepository{
+				Name:     project.Path,
+				FullName: project.PathWithNamespace,
+				Private:  project.Visibility != "public",
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get projects: %v", err)
+	}
+	return repositories, nil
+}
+
+// gitlabHookEvents are the events of the hook builder puts on projects.
+const gitlabHookEvents = "push,merge_request"
+
+// WantedHooks is a single hook for pushes and merge requests, which posts to
+// gitlabHookHandler.
+func (gitlab Gitlab) WantedHooks() []Webhook {
+	return []Webhook{{Event: gitlabHookEvents, Url: hookUrl("gitlab")}}
+}
+
+// gitlabHook is a project webhook as GitLab describes it.
+type gitlabHook struct {
+	Id                  int    `json:"id"`
+	Url                 string `json:"url"`
+	PushEvents          bool   `json:"push_events"`
+	MergeRequestsEvents bool   `json:"merge_requests_events"`
+}
+
+func (hook gitlabHook) webhook() Webhook {
+	var events []string
+	if hook.PushEvents {
+		events = append(events, "push")
+	}
+	if hook.MergeRequestsEvents {
+		events = append(events, "merge_request")
+	}
+	return Webhook{Id: hook.Id, Event: strings.Join(events, ","), Url: hook.Url}
+}
+
+func (gitlab Gitlab) CreateHooks(accessToken string, owner string, repo string, wanted []Webhook) ([]Webhook, error) {
+	var hooks []Webhook
+	for _, hook := range wanted {
+		events := strings.Split(hook.Event, ",")
+		body := map[string]interface{}{
+			"url":         hook.Url,
+			"push_events": false,
+			"token":       configuration.GitlabWebhookSecret,
+		}
+		for _, event := range events {
+			switch event {
+			case "push":
+				body["push_events"] = true
+			case "merge_request":
+				body["merge_requests_events"] = true
+			}
+		}
+		b, err := gitlabRequest("POST", accessToken, "/projects/"+gitlabProject(owner, repo)+"/hooks", body, 201)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't create the %v hook on %v/%v: %v", hook.Event, owner, repo, err)
+		}
+		var created gitlabHook
+		if err := json.Unmarshal(b, &created); err != nil {
+			return nil, fmt.Errorf("Couldn't unmarshal hook, json was:\n%v", string(b))
+		}
+		hooks = append(hooks, created.webhook())
+	}
+	return hooks, nil
+}
+
+func (gitlab Gitlab) Hooks(accessToken string, owner string, repo string) ([]Webhook, error) {
+	url := gitlabApiUrl("/projects/" + gitlabProject(owner, repo) + "/hooks?per_page=100")
+	var hooks []Webhook
+	err := getPages(url, gitlabHeader(accessToken), func(b []byte) error {
+		var page []gitlabHook
+		if err := json.Unmarshal(b, &page); err != nil {
+			return fmt.Errorf("Couldn't unmarshal hooks, json was:\n%v", string(b))
+		}
+		for _, hook := range page {
+			hooks = append(hooks, hook.webhook())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get hooks on %v/%v: %v", owner, repo, err)
+	}
+	return hooks, nil
+}
+
+func (gitlab Gitlab) DeleteHook(accessToken string, owner string, repo string, id int) error {
+	path := fmt.Sprintf("/projects/%v/hooks/%v", gitlabProject(owner, repo), id)
+	request, _ := http.NewRequest("DELETE", gitlabApiUrl(path), nil)
+	request.Header = gitlabHeader(accessToken)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != 204 && response.StatusCode != 200 && response.StatusCode != 404 {
+		return fmt.Errorf("Couldn't delete hook %v on %v/%v, GitLab returned %v", id, owner, repo, response.StatusCode)
+	}
+	return nil
+}
+
+// gitlabStates are GitLab's names for the states of a commit.
+var gitlabStates = map[CommitState]string{
+	statePending: "running",
+	stateSuccess: "success",
+	stateFailure: "failed",
+}
+
+func (gitlab Gitlab) SetStatus(accessToken string, owner string, repo string, sha string, state CommitState, targetUrl string) error {
+	body := map[string]string{
+		"state":       gitlabStates[state],
+		"target_url":  targetUrl,
+		"description": state.description(),
+		"name":        "builder",
+	}
+	_, err := gitlabRequest("POST", accessToken, "/projects/"+gitlabProject(owner, repo)+"/statuses/"+sha, body, 201)
+	if err != nil {
+		return fmt.Errorf("Couldn't set the status of %v on %v/%v: %v", sha, owner, repo, err)
+	}
+	return nil
+}
+
+type gitlabProjectEvent struct {
+	PathWithNamespace string `json:"path_with_namespace"`
+	WebUrl            string `json:"web_url"`
+}
+
+type gitlabPushEvent struct {
+	Ref          string             `json:"ref"`
+	Before       string             `json:"before"`
+	After        string             `json:"after"`
+	UserUsername string             `json:"user_username"`
+	Project      gitlabProjectEvent `json:"project"`
+	Commits      []struct {
+		Id      string `json:"id"`
+		Message string `json:"message"`
+		Url     string `json:"url"`
+	} `json:"commits"`
+}
+
+type gitlabMergeRequestEvent struct {
+	User struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	Project          gitlabProjectEvent `json:"project"`
+	ObjectAttributes struct {
+		Action       string `json:"action"`
+		SourceBranch string `json:"source_branch"`
+		TargetBranch string `json:"target_branch"`
+		Url          string `json:"url"`
+		LastCommit   struct {
+			Id string `json:"id"`
+		} `json:"last_commit"`
+	} `json:"object_attributes"`
+}
+
+// gitlabHookHandler builds pushes and merge requests on GitLab projects.
+// validGitlabToken checks the token GitLab sends with every event against
+// the webhook secret. Nothing is valid without a secret, so nobody can post
+// events that look like they came from GitLab.
+func validGitlabToken(token string) bool {
+	if configuration.GitlabWebhookSecret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(configuration.GitlabWebhookSecret)) == 1
+}
+
+func gitlabHookHandler(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if !validGitlabToken(r.Header.Get("X-Gitlab-Token")) {
+		http.Error(w, "The token doesn't match the webhook secret", 403)
+		return
+	}
+
+	var build *Build
+	switch r.Header.Get("X-Gitlab-Event") {
+	case "Push Hook":
+		var push gitlabPushEvent
+		if err := json.Unmarshal(body, &push); err != nil {
+			fmt.Println("Error parsing push:", err)
+			return
+		}
+		if push.After == noCommit {
+			return
+		}
+		owner, name := splitFullName(push.Project.PathWithNamespace)
+		var commits []Commit
+		for _, c := range push.Commits {
+			commits = append(commits, Commit{Sha: c.Id, Message: c.Message, Url: c.Url})
+		}
+		build = &Build{
+			Host:       "gitlab",
+			Owner:      owner,
+			Repository: name,
+			Ref:        strings.Replace(push.Ref, "refs/heads/", "", -1),
+			Author:     push.UserUsername,
+			Trigger:    triggerPush,
+			Sha:        push.After,
+			GithubUrl:  push.Project.WebUrl + "/-/compare/" + push.Before + "..." + push.After,
+			Commits:    commits,
+		}
+
+	case "Merge Request Hook":
+		var mergeRequest gitlabMergeRequestEvent
+		if err := json.Unmarshal(body, &mergeRequest); err != nil {
+			fmt.Println("Error parsing merge request:", err)
+			return
+		}
+		owner, name := splitFullName(mergeRequest.Project.PathWithNamespace)
+		attributes := mergeRequest.ObjectAttributes
+		switch attributes.Action {
+		case "close", "merge":
+			err := closePullRequest(r.Context(), "gitlab", owner, name, attributes.SourceBranch)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			w.WriteHeader(200)
+			return
+		case "open":
+		default:
+			return
+		}
+		build = &Build{
+			Host:       "gitlab",
+			Owner:      owner,
+			Repository: name,
+			Ref:        attributes.SourceBranch,
+			BaseRef:    attributes.TargetBranch,
+			Author:     mergeRequest.User.Username,
+			Trigger:    triggerPullRequest,
+			Sha:        attributes.LastCommit.Id,
+			GithubUrl:  attributes.Url,
+		}
+
+	default:
+		return
+	}
+
+	err := launcher.LaunchBuild(build)
+	if err != nil {
+		hookBuildError(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// withFakedGitlab points GitLab at a server playing GitLab.
+func withFakedGitlab(ts *httptest.Server, block func()) {
+	oldUrl := configuration.GitlabUrl
+	configuration.GitlabUrl = ts.URL
+	defer func() { configuration.GitlabUrl = oldUrl }()
+	block()
+}
+
+const testGitlabSecret = "webhook-secret"
+
+func withGitlabWebhookSecret(secret string, block func()) {
+	oldSecret := configuration.GitlabWebhookSecret
+	configuration.GitlabWebhookSecret = secret
+	defer func() { configuration.GitlabWebhookSecret = oldSecret }()
+	block()
+}
+
+// gitlabHookRequest posts the fixture as GitLab would, with token.
+func gitlabHookRequest(event string, bodyPath string, token string) *http.Request {
+	r := createFakeRequest(bodyPath)
+	r.Header = http.Header{
+		"X-Gitlab-Event": {event},
+		"X-Gitlab-Token": {token},
+	}
+	return r
+}
+
+func TestGitlabAuthorizeUrl(t *testing.T) {
+	authorizeUrl, err := url.Parse(Gitlab{}.AuthorizeUrl("STATE", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authorizeUrl.Query()
+	if authorizeUrl.Path != "/oauth/authorize" || query.Get("state") != "STATE" ||
+		query.Get("scope") != "api" || query.Get("redirect_uri") != "http://localhost:1212/gitlab_callback" {
+		t.Errorf("Unexpected authorize url %v", authorizeUrl)
+	}
+}
+
+func TestGetsAccessTokenFromGitlab(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.URL.Path != "/oauth/token" || r.PostForm.Get("code") != "CODE" || r.PostForm.Get("grant_type") != "authorization_code" {
+			w.WriteHeader(400)
+			w.Write([]byte(`{ "error": "invalid_grant", "error_description": "The code is wrong" }`))
+			return
+		}
+		w.Write([]byte(`{ "access_token": "TOKEN", "token_type": "Bearer" }`))
+	}))
+	defer ts.Close()
+
+	withFakedGitlab(ts, func() {
+		token, err := Gitlab{}.GetAccessToken("CODE")
+		if err != nil || token != "TOKEN" {
+			t.Errorf("Expected the access token, got %q, %v", token, err)
+		}
+		if _, err := (Gitlab{}).GetAccessToken("WRONG"); err == nil || err.Error() != "Error retrieving access token: The code is wrong" {
+			t.Errorf("Expected GitLab's error, got %v", err)
+		}
+	})
+}
+
+func TestGetsUserFromGitlab(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/user" || r.Header.Get("Authorization") != "Bearer TOKEN" {
+			w.WriteHeader(401)
+			return
+		}
+		w.Write([]byte(`{ "id": 4, "username": "AndrewVos", "name": "Andrew Vos", "avatar_url": "https://gitlab.com/avatar.png" }`))
+	}))
+	defer ts.Close()
+
+	withFakedGitlab(ts, func() {
+		user, err := Gitlab{}.GetUser("TOKEN")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := &HostUser{Id: 4, Login: "AndrewVos", Name: "Andrew Vos", AvatarUrl: "https://gitlab.com/avatar.png"}
+		if !reflect.DeepEqual(user, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, user)
+		}
+	})
+}
+
+func TestGitlabMembersBecomeCollaborators(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/v4/projects/group%2Fsubgroup%2Fproject/members/all" {
+			w.WriteHeader(404)
+			return
+		}
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(`[{ "id": 3, "username": "reporter", "access_level": 20 }]`))
+			return
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<%v%v?per_page=100&page=2>; rel="next"`, ts.URL, r.URL.EscapedPath()))
+		w.Write([]byte(`[{ "id": 1, "username": "owner", "access_level": 50 }, { "id": 2, "username": "developer", "access_level": 30 }]`))
+	}))
+	defer ts.Close()
+
+	withFakedGitlab(ts, func() {
+		collaborators, err := Gitlab{}.RepositoryCollaborators("TOKEN", "group/subgroup", "project")
+		if err != nil {
+			t.Fatal(err)
+		}
+		roles := map[int]Role{}
+		for _, collaborator := range collaborators {
+			roles[collaborator.Id] = collaborator.Role()
+		}
+		expected := map[int]Role{1: roleAdmin, 2: roleWrite, 3: roleRead}
+		if !reflect.DeepEqual(roles, expected) {
+			t.Errorf("Expected %v, got %v", expected, roles)
+		}
+	})
+}
+
+func TestListsGitlabProjects(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/projects" || r.URL.Query().Get("membership") != "true" {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write([]byte(`[
+      { "path": "builder", "path_with_namespace": "AndrewVos/builder", "visibility": "private" },
+      { "path": "open", "path_with_namespace": "group/open", "visibility": "public" }
+    ]`))
+	}))
+	defer ts.Close()
+
+	withFakedGitlab(ts, func() {
+		repositories, err := Gitlab{}.Repositories("TOKEN")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []HostRepository{
+			{Name: "builder", FullName: "AndrewVos/builder", Private: true},
+			{Name: "open", FullName: "group/open", Private: false},
+		}
+		if !reflect.DeepEqual(repositories, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, repositories)
+		}
+	})
+}
+
+func TestCreatesAndListsGitlabHooks(t *testing.T) {
+	var created map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/v4/projects/owner%2Frepo/hooks" {
+			w.WriteHeader(404)
+			return
+		}
+		if r.Method == "POST" {
+			json.NewDecoder(r.Body).Decode(&created)
+			w.WriteHeader(201)
+			w.Write([]byte(`{ "id": 7, "url": "http://localhost:1212/hooks/gitlab", "push_events": true, "merge_requests_events": true }`))
+			return
+		}
+		w.Write([]byte(`[{ "id": 7, "url": "http://localhost:1212/hooks/gitlab", "push_events": true, "merge_requests_events": true }]`))
+	}))
+	defer ts.Close()
+
+	gitlab := Gitlab{}
+	expected := []Webhook{{Id: 7, Event: "push,merge_request", Url: "http://localhost:1212/hooks/gitlab"}}
+	withFakedGitlab(ts, func() {
+		var hooks []Webhook
+		var err error
+		withGitlabWebhookSecret(testGitlabSecret, func() {
+			hooks, err = gitlab.CreateHooks("TOKEN", "owner", "repo", gitlab.WantedHooks())
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(hooks, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, hooks)
+		}
+		if created["url"] != "http://localhost:1212/hooks/gitlab" || created["push_events"] != true || created["merge_requests_events"] != true || created["token"] != testGitlabSecret {
+			t.Errorf("Created the wrong hook %v", created)
+		}
+
+		hooks, err = gitlab.Hooks("TOKEN", "owner", "repo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(hooks, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, hooks)
+		}
+	})
+}
+
+func TestSetsCommitStatusOnGitlab(t *testing.T) {
+	var path string
+	var body map[string]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.EscapedPath()
+		b, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(b, &body)
+		w.WriteHeader(201)
+	}))
+	defer ts.Close()
+
+	withFakedGitlab(ts, func() {
+		err := Gitlab{}.SetStatus("TOKEN", "owner", "repo", "abc123", stateFailure, "http://localhost:1212/build/1/output")
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if path != "/api/v4/projects/owner%2Frepo/statuses/abc123" {
+		t.Errorf("Posted to the wrong url %v", path)
+	}
+	if body["state"] != "failed" || body["name"] != "builder" || body["target_url"] != "http://localhost:1212/build/1/output" {
+		t.Errorf("Posted the wrong status %v", body)
+	}
+}
+
+func TestValidGitlabToken(t *testing.T) {
+	withGitlabWebhookSecret(testGitlabSecret, func() {
+		if !validGitlabToken(testGitlabSecret) {
+			t.Errorf("Expected the secret to be valid")
+		}
+		if validGitlabToken("some other secret") || validGitlabToken("") {
+			t.Errorf("Expected other tokens to be invalid")
+		}
+	})
+	withGitlabWebhookSecret("", func() {
+		if validGitlabToken("") {
+			t.Errorf("Expected nothing to be valid without a secret")
+		}
+	})
+}
+
+func TestGitlabHookHandlerRejectsEventsWithTheWrongToken(t *testing.T) {
+	withGitlabWebhookSecret(testGitlabSecret, func() {
+		withFakeLauncher(func(fbl *FakeBuildLauncher) {
+			w := httptest.NewRecorder()
+			gitlabHookHandler(w, gitlabHookRequest("Push Hook", "test-data/gitlab_push.json", "some other secret"))
+			if w.Code != 403 {
+				t.Errorf("Expected a 403, got %v", w.Code)
+			}
+			if fbl.launchedBuild {
+				t.Error("Shouldn't build events without the webhook secret")
+			}
+		})
+	})
+}
+
+func TestGitlabHookHandlerLaunchesPushBuilds(t *testing.T) {
+	withGitlabWebhookSecret(testGitlabSecret, func() {
+		withFakeLauncher(func(fbl *FakeBuildLauncher) {
+			gitlabHookHandler(httptest.NewRecorder(), gitlabHookRequest("Push Hook", "test-data/gitlab_push.json", testGitlabSecret))
+
+			expectedValues := map[string]interface{}{
+				"owner":     "vos-group/tools",
+				"repo":      "builder-test-green-repo",
+				"ref":       "master",
+				"baseRef":   "",
+				"author":    "AndrewVos",
+				"trigger":   "push",
+				"sha":       "576be25d7e3d5320e92472d5734b50b17c1822e0",
+				"githubURL": "https://gitlab.com/vos-group/tools/builder-test-green-repo/-/compare/da46166aa12075d4ed77847cc98dcb9039d01dcf...576be25d7e3d5320e92472d5734b50b17c1822e0",
+			}
+			if !reflect.DeepEqual(fbl.values, expectedValues) {
+				t.Errorf("Expected %v, got %v", expectedValues, fbl.values)
+			}
+			if len(fbl.commits) != 2 || fbl.commits[1].Sha != "576be25d7e3d5320e92472d5734b50b17c1822e0" || fbl.commits[1].Message != "output something" {
+				t.Errorf("Expected the pushed commits, got %+v", fbl.commits)
+			}
+		})
+	})
+}
+
+func TestGitlabHookHandlerIgnoresDeletedBranches(t *testing.T) {
+	withGitlabWebhookSecret(testGitlabSecret, func() {
+		withFakeLauncher(func(fbl *FakeBuildLauncher) {
+			gitlabHookHandler(httptest.NewRecorder(), gitlabHookRequest("Push Hook", "test-data/gitlab_delete_branch_push.json", testGitlabSecret))
+			if fbl.launchedBuild {
+				t.Error("Shouldn't build deleted branch pushes")
+			}
+		})
+	})
+}
+
+func TestGitlabHookHandlerLaunchesMergeRequestBuilds(t *testing.T) {
+	withGitlabWebhookSecret(testGitlabSecret, func() {
+		withFakeLauncher(func(fbl *FakeBuildLauncher) {
+			gitlabHookHandler(httptest.NewRecorder(), gitlabHookRequest("Merge Request Hook", "test-data/gitlab_merge_request.json", testGitlabSecret))
+
+			expectedValues := map[string]interface{}{
+				"owner":     "vos-group/tools",
+				"repo":      "builder-test-green-repo",
+				"ref":       "some-branch",
+				"baseRef":   "master",
+				"author":    "AndrewVos",
+				"trigger":   "pull_request",
+				"sha":       "576be25d7e3d5320e92472d5734b50b17c1822e0",
+				"githubURL": "https://gitlab.com/vos-group/tools/builder-test-green-repo/-/merge_requests/1",
+			}
+			if !reflect.DeepEqual(fbl.values, expectedValues) {
+				t.Errorf("Expected %v, got %v", expectedValues, fbl.values)
+			}
+		})
+	})
+}
+
+func TestGitlabHookHandlerClosesMergedMergeRequests(t *testing.T) {
+	resetMemoryDatabase()
+	ctx := context.Background()
+
+	repository := createAccountWithRepository(&Account{HostUserId: 1, Host: "gitlab"}, "vos-group/tools", "builder-test-green-repo")
+	build := &Build{Owner: "vos-group/tools", Repository: "builder-test-green-repo", Ref: "some-branch", Trigger: triggerPullRequest}
+	database.CreateBuild(ctx, repository, build)
+
+	withGitlabWebhookSecret(testGitlabSecret, func() {
+		withFakeLauncher(func(fbl *FakeBuildLauncher) {
+			gitlabHookHandler(httptest.NewRecorder(), gitlabHookRequest("Merge Request Hook", "test-data/gitlab_merged_merge_request.json", testGitlabSecret))
+			if fbl.launchedBuild {
+				t.Error("Shouldn't build merged merge requests")
+			}
+		})
+	})
+
+	builds, _ := database.RepositoryBuilds(ctx, repository)
+	if len(builds) != 1 || !builds[0].PullRequestClosed {
+		t.Errorf("Expected the merge request build to be closed, got %+v", builds)
+	}
+}
+
+func TestGitlabLoginCreatesAGitlabAccount(t *testing.T) {
+	resetMemoryDatabase()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/token":
+			w.Write([]byte(`{ "access_token": "TOKEN" }`))
+		case "/api/v4/user":
+			w.Write([]byte(`{ "id": 4, "username": "AndrewVos" }`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	withFakedGitlab(ts, func() {
+		r, _ := http.NewRequest("GET", "/gitlab_callback?code=CODE&state=STATE", nil)
+		r.AddCookie(&http.Cookie{Name: oauthStateCookie, Value: "STATE.Lw"})
+		w := httptest.NewRecorder()
+		gitlabLoginHandler(w, r)
+		if w.Code != 302 {
+			t.Fatalf("Expected to be logged in, got %v", w.Code)
+		}
+	})
+
+	account := findUsersAccount("gitlab", 4)
+	if account == nil || account.Host != "gitlab" || account.Login != "AndrewVos" {
+		t.Errorf("Expected a GitLab account, got %+v", account)
+	}
+}
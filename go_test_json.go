@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+// goTestEvent is a line of "go test -json" output. See "go doc test2json".
+type goTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// parseGoTestJSON streams "go test -json" output into a result for every
+// package and every test. Lines that aren't json, like the build errors older
+// versions of go print, are skipped. Tests that never finished, because the
+// package panicked or timed out, have failed. The output of failures is their
+// Message.
+func parseGoTestJSON(report io.Reader) ([]TestResult, error) {
+	type key struct{ pkg, test string }
+	results := map[key]*TestResult{}
+	var order []key
+
+	reader := bufio.NewReader(report)
+	for {
+		line, err := reader.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+
+		var event goTestEvent
+		if bytes.HasPrefix(line, []byte("{")) && json.Unmarshal(line, &event) == nil && event.Package != "" {
+			k := key{event.Package, event.Test}
+			result, ok := results[k]
+			if !ok {
+				result = &TestResult{ClassName: event.Package, Name: event.Test}
+				results[k] = result
+				order = append(order, k)
+			}
+
+			switch event.Action {
+			case "output":
+				result.Message += event.Output
+			case "pass":
+				result.Status = testPassed
+			case "fail":
+				result.Status = testFailed
+			case "skip":
+				result.Status = testSkipped
+			}
+			if event.Elapsed > 0 {
+				result.Duration = time.Duration(event.Elapsed * float64(time.Second))
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var parsed []TestResult
+	for _, k := range order {
+		result := *results[k]
+		if result.Status == "" {
+			result.Status = testFailed
+		}
+		// Only failures are shown, so the output of other tests isn't kept.
+		if result.Status != testFailed {
+			result.Message = ""
+		}
+		parsed = append(parsed, result)
+	}
+	return parsed, nil
+}
+
+// findTestOutputLines sets the Line of every failing test to the line of
+// the build output the test starts on. Go tests start with "=== RUN", or
+// failures are printed with "--- FAIL". Tests with the same name in more
+// than one package are told apart by the package each match belongs to.
+func findTestOutputLines(buildOutput []byte, results []TestResult) {
+	for i := range results {
+		result := &results[i]
+		if result.Status != testFailed || result.isPackage() || result.Line != 0 {
+			continue
+		}
+
+		for _, prefix := range []string{"=== RUN   ", "--- FAIL: "} {
+			offset := findTestName(buildOutput, prefix+result.Name, 0)
+			for offset != -1 && testOutputPackage(buildOutput, offset) != result.ClassName {
+				offset = findTestName(buildOutput, prefix+result.Name, offset+1)
+			}
+			if offset != -1 {
+				result.Line = bytes.Count(buildOutput[:offset], []byte("\n")) + 1
+				break
+			}
+		}
+	}
+}
+
+// testOutputPackage returns the package the test output at offset belongs
+// to. Lines of "go test -json" output name their package, and "go test"
+// prints a line like "ok  \t<package>\t0.1s" after the tests of each package.
+func testOutputPackage(buildOutput []byte, offset int) string {
+	rest := buildOutput[bytes.LastIndexByte(buildOutput[:offset], '\n')+1:]
+	for first := true; len(rest) > 0; first = false {
+		line := rest
+		if end := bytes.IndexByte(rest, '\n'); end != -1 {
+			line, rest = rest[:end], rest[end+1:]
+		} else {
+			rest = nil
+		}
+
+		if first {
+			const packageField = `"Package":"`
+			if i := bytes.Index(line, []byte(packageField)); i != -1 {
+				name := line[i+len(packageField):]
+				if end := bytes.IndexByte(name, '"'); end != -1 {
+					return string(name[:end])
+				}
+			}
+		}
+		fields := strings.Split(string(line), "\t")
+		if len(fields) >= 2 && (fields[0] == "ok  " || fields[0] == "FAIL" || fields[0] == "?   ") {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// findTestName returns the offset of text in the output after from. Matches
+// that are only the start of a longer test name are skipped, so looking for
+// TestBuild doesn't find TestBuildUrl.
+func findTestName(buildOutput []byte, text string, from int) int {
+	for from < len(buildOutput) {
+		i := bytes.Index(buildOutput[from:], []byte(text))
+		if i == -1 {
+			return -1
+		}
+		end := from + i + len(text)
+		if end == len(buildOutput) || strings.ContainsRune(" \r\n\\\"", rune(buildOutput[end])) {
+			return from + i
+		}
+		from = end
+	}
+	return -1
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseGoTestJSONFixture(t *testing.T) []TestResult {
+	file, err := os.Open("test-data/go_test/report.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	results, err := parseGoTestJSON(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestParseGoTestJSON(t *testing.T) {
+	results := parseGoTestJSONFixture(t)
+
+	expected := []struct {
+		pkg      string
+		test     string
+		status   string
+		duration time.Duration
+	}{
+		{"github.com/AndrewVos/builder", "", testFailed, 1500 * time.Millisecond},
+		{"github.com/AndrewVos/builder", "TestBuildUrl", testFailed, 250 * time.Millisecond},
+		{"github.com/AndrewVos/builder", "TestBuildUrlPort80", testPassed, 0},
+		{"github.com/AndrewVos/builder", "TestPostgresDatabase", testSkipped, 0},
+		{"github.com/AndrewVos/builder/assets", "", testSkipped, 0},
+		{"github.com/AndrewVos/builder/panics", "", testFailed, 100 * time.Millisecond},
+		{"github.com/AndrewVos/builder/panics", "TestBuildUrl", testFailed, 0},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %+v", len(expected), results)
+	}
+	for i, e := range expected {
+		result := results[i]
+		if result.ClassName != e.pkg || result.Name != e.test || result.Status != e.status || result.Duration != e.duration {
+			t.Errorf("Expected result %d to be %+v, got %+v", i, e, result)
+		}
+	}
+
+	failure := results[1].Message
+	if !strings.HasPrefix(failure, "=== RUN   TestBuildUrl\n") || !strings.Contains(failure, "build_test.go:27") {
+		t.Errorf("Expected the test output to be kept, got %q", failure)
+	}
+	if !strings.Contains(results[0].Message, "FAIL\tgithub.com/AndrewVos/builder\t1.500s") {
+		t.Errorf("Expected the package output to be kept, got %q", results[0].Message)
+	}
+	if results[2].Message != "" || results[3].Message != "" {
+		t.Errorf("Expected the output of tests that didn't fail to be dropped, got %q and %q", results[2].Message, results[3].Message)
+	}
+}
+
+func TestFindTestOutputLines(t *testing.T) {
+	results := parseGoTestJSONFixture(t)
+	buildOutput, _ := ioutil.ReadFile("test-data/go_test/output.log")
+
+	findTestOutputLines(buildOutput, results)
+
+	lines := map[string]int{}
+	for _, result := range results {
+		lines[result.ClassName+" "+result.Name] = result.Line
+	}
+	expected := map[string]int{
+		"github.com/AndrewVos/builder ":                     0,
+		"github.com/AndrewVos/builder TestBuildUrl":         2,
+		"github.com/AndrewVos/builder TestBuildUrlPort80":   0,
+		"github.com/AndrewVos/builder TestPostgresDatabase": 0,
+		"github.com/AndrewVos/builder/assets ":              0,
+		"github.com/AndrewVos/builder/panics ":              0,
+		"github.com/AndrewVos/builder/panics TestBuildUrl":  15,
+	}
+	for test, line := range expected {
+		if lines[test] != line {
+			t.Errorf("Expected %v to be on line %d, got %d", test, line, lines[test])
+		}
+	}
+}
+
+func TestFindTestOutputLinesMatchesTheTestsPackage(t *testing.T) {
+	buildOutput := []byte("=== RUN   TestBuildUrl\n" +
+		"--- FAIL: TestBuildUrl (0.00s)\n" +
+		"FAIL\texample.com/b\t0.100s\n" +
+		`{"Action":"output","Package":"example.com/c","Test":"TestBuildUrl","Output":"=== RUN   TestBuildUrl\n"}` + "\n" +
+		"=== RUN   TestBuildUrl\n" +
+		"--- FAIL: TestBuildUrl (0.00s)\n" +
+		"FAIL\texample.com/a\t0.100s\n")
+	results := []TestResult{
+		{ClassName: "example.com/a", Name: "TestBuildUrl", Status: testFailed},
+		{ClassName: "example.com/b", Name: "TestBuildUrl", Status: testFailed},
+		{ClassName: "example.com/c", Name: "TestBuildUrl", Status: testFailed},
+	}
+
+	findTestOutputLines(buildOutput, results)
+
+	for i, line := range []int{5, 1, 4} {
+		if results[i].Line != line {
+			t.Errorf("Expected %v to be on line %d, got %d", results[i].ClassName, line, results[i].Line)
+		}
+	}
+}
+
+func TestBuildCollectsGoTestResults(t *testing.T) {
+	defer cleanDataDirectory()
+
+	fakeGit.FakeRepo = "gotest"
+	resetMemoryDatabase()
+	createAccountWithRepository(&Account{AccessToken: "sdsd"}, "some-owner", "some-repo")
+	build := &Build{Owner: "some-owner", Repository: "some-repo"}
+
+	build.start()
+
+	results, _ := database.TestResults(context.Background(), []*Build{build})
+	if len(results) != 7 {
+		t.Fatalf("Expected the go test results to be saved, got %+v", results)
+	}
+
+	failure := results[1]
+	outputLines := strings.Split(build.ReadOutput(), "\n")
+	if failure.Line == 0 || !strings.HasPrefix(outputLines[failure.Line-1], "=== RUN   TestBuildUrl") {
+		t.Errorf("Expected TestBuildUrl to point at its line of the output, got line %d of:\n%v", failure.Line, build.ReadOutput())
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,48 +9,76 @@ import (
 	"github.com/hoisie/mustache"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var launcher BuildLauncher = &Builder{}
-var database Database = &PostgresDatabase{}
+var database Database
+var logStore LogStore = &FileLogStore{}
 
 type BuildLauncher interface {
-	LaunchBuild(owner string, repo string, ref string, sha string, githubURL string, commits []Commit) error
+	// LaunchBuild saves the build and starts running it in the background.
+	// It returns errUnknownRepository when the build's repository hasn't
+	// been added on the build's host.
+	LaunchBuild(build *Build) error
 }
 
+var errUnknownRepository = errors.New("The repository hasn't been added to builder")
+
 type Builder struct {
 }
 
-func (builder *Builder) LaunchBuild(owner string, repo string, ref string, sha string, githubURL string, commits []Commit) error {
-	repository := database.FindRepository(owner, repo)
+func (builder *Builder) LaunchBuild(build *Build) error {
+	ctx := context.Background()
+	repository, err := database.FindRepository(ctx, build.Host, build.Owner, build.Repository)
+	if err != nil {
+		return err
+	}
 	if repository == nil {
-		return errors.New(fmt.Sprintf("Couldn't find access token to build %v/%v\n", owner, repo))
+		return errUnknownRepository
 	}
 
-	build := &Build{
-		Owner:      owner,
-		Repository: repo,
-		Ref:        ref,
-		Sha:        sha,
-		GithubUrl:  githubURL,
-		Commits:    commits,
-	}
-	err := database.CreateBuild(repository, build)
+	err = database.CreateBuild(ctx, repository, build)
 	if err != nil {
 		return err
 	}
-	build.start()
+	go build.start()
 	return nil
 }
 
 func defaultViewContext(r *http.Request) map[string]interface{} {
-	account := currentAccount(r)
+	session := currentSession(r)
+	var account *Account
+	if session != nil {
+		var err error
+		account, err = database.FindAccountById(r.Context(), session.AccountId)
+		if err != nil {
+			fmt.Println("Error finding account:", err)
+		}
+	}
 
 	context := map[string]interface{}{
-		"client_id": configuration.GithubClientID,
-		"logged_in": (account != nil),
+		"client_id":    configuration.GithubClientID,
+		"logged_in":    (session != nil),
+		"development":  configuration.Development,
+		"return_to":    url.QueryEscape(r.URL.RequestURI()),
+		"gitlab_login": configuration.GitlabClientID != "",
+		"gitea_login":  configuration.GiteaClientID != "" && configuration.GiteaUrl != "",
+	}
+	if session != nil {
+		context["csrf_token"] = session.CsrfToken
+	}
+	if account != nil {
+		context["account"] = map[string]string{
+			"login":      account.Login,
+			"name":       account.DisplayName(),
+			"email":      account.Email,
+			"avatar_url": account.AvatarUrl,
+		}
 	}
 	return context
 }
@@ -62,23 +91,139 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 	context["js"] = map[string]string{
 		"name": "home.js",
 	}
+
+	filter, err := parseBuildFilter(r.URL.Query())
+	if err != nil {
+		context["filter_error"] = err.Error()
+	}
+	values := filter.values()
+	var hidden []map[string]string
+	for _, name := range buildFilterParams {
+		if value := values.Get(name); value != "" && name != "q" {
+			hidden = append(hidden, map[string]string{"name": name, "value": value})
+		}
+	}
+	context["query"] = filter.Query
+	context["hidden_filters"] = hidden
+	context["chips"] = filter.chips()
+	context["quick_filters"] = filter.quickFilters()
+	context["filters"] = values.Encode()
 	body := mustache.RenderFileInLayout("views/home.mustache", "views/layout.mustache", context)
 	w.Write([]byte(body))
 }
 
 func settingsHandler(w http.ResponseWriter, r *http.Request) {
-	account := currentAccount(r)
-	if account == nil {
+	session := currentSession(r)
+	if session == nil {
 		http.Redirect(w, r, "/", 302)
 		return
 	}
 
+	sessions, err := database.AccountSessions(r.Context(), session.AccountId, time.Now())
+	if err != nil {
+		fmt.Println("Error getting sessions:", err)
+		w.WriteHeader(500)
+		return
+	}
+	var rows []map[string]interface{}
+	for _, s := range sessions {
+		rows = append(rows, map[string]interface{}{
+			"created_at": s.CreatedAt.Format("2006-01-02 15:04"),
+			"expires_at": s.ExpiresAt.Format("2006-01-02"),
+			"current":    s.Id == session.Id,
+		})
+	}
+
+	organizations, err := database.AccountOrganizations(r.Context(), session.AccountId)
+	if err != nil {
+		fmt.Println("Error getting organizations:", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	var repositories []map[string]interface{}
+	var githubRepositories []map[string]string
+	host := ""
+	if account := currentAccount(r); account != nil {
+		host = account.Host
+		for _, repository := range account.Repositories {
+			repositories = append(repositories, map[string]interface{}{
+				"owner":      repository.Owner,
+				"repository": repository.Repository,
+				"public":     repository.Public,
+				"url":        repository.Url(),
+				"host":       hostName(repository.Host),
+			})
+		}
+
+		// The source host being down shouldn't stop anyone from changing
+		// their settings, so they can still type in a repository.
+		available, err := availableRepositories(account)
+		if err != nil {
+			fmt.Println("Error getting repositories:", err)
+		}
+		for _, repository := range available {
+			githubRepositories = append(githubRepositories, map[string]string{"full_name": repository.FullName})
+		}
+	}
+
 	context := defaultViewContext(r)
+	context["sessions"] = rows
+	context["organizations"] = organizations
+	context["has_organizations"] = len(organizations) > 0
+	context["repositories"] = repositories
+	context["has_repositories"] = len(repositories) > 0
+	context["github_repositories"] = githubRepositories
+	context["has_github_repositories"] = len(githubRepositories) > 0
+	context["host_name"] = sourceHost(host).Name()
+	// Only Github asks for access to private repositories separately.
+	context["asks_for_private_access"] = hostName(host) == "github"
 	body := mustache.RenderFileInLayout("views/settings.mustache", "views/layout.mustache", context)
 	w.Write([]byte(body))
 }
 
+// availableRepositories returns the account's repositories on its source
+// host that it hasn't added to builder yet.
+func availableRepositories(account *Account) ([]HostRepository, error) {
+	token, err := account.HostToken()
+	if err != nil {
+		return nil, err
+	}
+	repositories, err := sourceHost(account.Host).Repositories(token)
+	if err != nil {
+		return nil, err
+	}
+
+	added := map[string]bool{}
+	for _, repository := range account.Repositories {
+		added[strings.ToLower(repository.Owner+"/"+repository.Repository)] = true
+	}
+	var available []HostRepository
+	for _, repository := range repositories {
+		if !added[strings.ToLower(repository.FullName)] {
+			available = append(available, repository)
+		}
+	}
+	return available, nil
+}
+
 func buildOutputHandler(w http.ResponseWriter, r *http.Request) {
+	build, role, err := findBuildAndRole(r)
+	if err != nil {
+		fmt.Println("Error finding build:", err)
+		w.WriteHeader(500)
+		return
+	}
+	if build == nil || !role.can(roleRead) {
+		http.NotFound(w, r)
+		return
+	}
+	if err := findBuildAuthors(r.Context(), []*Build{build}); err != nil {
+		fmt.Println("Error finding build author:", err)
+		w.WriteHeader(500)
+		return
+	}
+
 	context := defaultViewContext(r)
 	context["css"] = map[string]string{
 		"name": "build_output.css",
@@ -86,7 +231,18 @@ func buildOutputHandler(w http.ResponseWriter, r *http.Request) {
 	context["js"] = map[string]string{
 		"name": "build_output.js",
 	}
-	context["build_id"] = r.URL.Query().Get(":id")
+	context["build_id"] = build.Id
+	context["build"] = map[string]interface{}{
+		"repository":        build.Owner + "/" + build.Repository,
+		"ref":               build.Ref,
+		"author":            build.Author,
+		"author_name":       build.AuthorName,
+		"author_avatar_url": build.AuthorAvatarUrl,
+		"can_write":         role.can(roleWrite),
+		"running":           !build.Complete,
+		"rebuild_url":       "/build/" + strconv.Itoa(build.Id) + "/rebuild",
+		"cancel_url":        "/build/" + strconv.Itoa(build.Id) + "/cancel",
+	}
 	body := mustache.RenderFileInLayout("views/build_output.mustache", "views/layout.mustache", context)
 	w.Write([]byte(body))
 }
@@ -124,16 +280,24 @@ func pushHandler(w http.ResponseWriter, r *http.Request) {
 		commits = append(commits, commit)
 	}
 
-	err = launcher.LaunchBuild(
-		owner,
-		name,
-		strings.Replace(ref, "refs/heads/", "", -1),
-		sha,
-		githubURL,
-		commits,
-	)
+	author, _ := push.Get("head_commit").Get("author").Get("username").String()
+	if author == "" {
+		author, _ = push.Get("head_commit").Get("author").Get("name").String()
+	}
+
+	err = launcher.LaunchBuild(&Build{
+		Host:       "github",
+		Owner:      owner,
+		Repository: name,
+		Ref:        strings.Replace(ref, "refs/heads/", "", -1),
+		Author:     author,
+		Trigger:    triggerPush,
+		Sha:        sha,
+		GithubUrl:  githubURL,
+		Commits:    commits,
+	})
 	if err != nil {
-		fmt.Println(err)
+		hookBuildError(w, err)
 		return
 	}
 
@@ -151,65 +315,477 @@ func pullRequestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	action, _ := pullRequest.Get("action").String()
+	fullName, _ := pullRequest.Get("repository").Get("full_name").String()
+	ref, _ := pullRequest.Get("pull_request").Get("head").Get("ref").String()
+
+	if action == "closed" {
+		err = closePullRequest(r.Context(), "github", strings.Split(fullName, "/")[0], strings.Split(fullName, "/")[1], ref)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		w.WriteHeader(200)
+		return
+	}
 	if action != "opened" {
 		return
 	}
 
-	fullName, _ := pullRequest.Get("repository").Get("full_name").String()
-	ref, _ := pullRequest.Get("pull_request").Get("head").Get("ref").String()
+	baseRef, _ := pullRequest.Get("pull_request").Get("base").Get("ref").String()
 	sha, _ := pullRequest.Get("pull_request").Get("head").Get("sha").String()
 	githubURL, _ := pullRequest.Get("pull_request").Get("_links").Get("self").Get("href").String()
 
-	err = launcher.LaunchBuild(
-		strings.Split(fullName, "/")[0],
-		strings.Split(fullName, "/")[1],
-		ref,
-		sha,
-		githubURL,
-		nil,
-	)
+	author, _ := pullRequest.Get("pull_request").Get("user").Get("login").String()
+
+	err = launcher.LaunchBuild(&Build{
+		Host:       "github",
+		Owner:      strings.Split(fullName, "/")[0],
+		Repository: strings.Split(fullName, "/")[1],
+		Ref:        ref,
+		BaseRef:    baseRef,
+		Author:     author,
+		Trigger:    triggerPullRequest,
+		Sha:        sha,
+		GithubUrl:  githubURL,
+	})
 	if err != nil {
-		fmt.Println(err)
+		hookBuildError(w, err)
 		return
 	}
 
 	w.WriteHeader(200)
 }
 
+// hookBuildError responds to a webhook whose build couldn't be launched.
+// Hosts are told when a repository isn't one of builder's repositories on
+// that host, so hooks can't build repositories on other hosts.
+func hookBuildError(w http.ResponseWriter, err error) {
+	if err == errUnknownRepository {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	fmt.Println(err)
+}
+
+// closePullRequest marks the builds of a pull request as closed, so they
+// aren't shown as open any more.
+func closePullRequest(ctx context.Context, host string, owner string, name string, ref string) error {
+	repository, err := database.FindRepository(ctx, host, owner, name)
+	if err != nil || repository == nil {
+		return err
+	}
+	builds, err := database.RepositoryBuilds(ctx, repository)
+	if err != nil {
+		return err
+	}
+	for _, build := range builds {
+		if build.Trigger == triggerPullRequest && build.Ref == ref && !build.PullRequestClosed {
+			build.PullRequestClosed = true
+			if err := database.SaveBuild(ctx, build); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func buildsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseBuildFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	page, err := database.SearchBuilds(r.Context(), currentAccount(r), filter)
+	if err != nil {
+		fmt.Println("Error searching builds:", err)
+		w.WriteHeader(500)
+		return
+	}
+	if err := findBuildAuthors(r.Context(), page.Builds); err != nil {
+		fmt.Println("Error finding build authors:", err)
+		w.WriteHeader(500)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	b, _ := json.Marshal(database.AllBuilds(currentAccount(r)))
+	b, _ := json.Marshal(map[string]interface{}{
+		"builds": page.Builds,
+		"next":   page.Next,
+	})
 	w.Write(b)
 }
 
+// findBuild returns the build with the :id in the url, if the current
+// account can see it. Everyone can see the builds of public repositories.
+func findBuild(r *http.Request) (*Build, error) {
+	build, role, err := findBuildAndRole(r)
+	if err != nil || !role.can(roleRead) {
+		return nil, err
+	}
+	return build, nil
+}
+
+// findBuildAndRole returns the build with the :id in the url, and the role
+// the current account has on its repository.
+func findBuildAndRole(r *http.Request) (*Build, Role, error) {
+	id, _ := strconv.Atoi(r.URL.Query().Get(":id"))
+	build, err := database.FindBuild(r.Context(), id)
+	if err != nil || build == nil {
+		return nil, roleNone, err
+	}
+	repository, err := database.FindRepositoryById(r.Context(), build.RepositoryId)
+	if err != nil || repository == nil {
+		return nil, roleNone, err
+	}
+	role, err := repositoryRole(r.Context(), currentAccount(r), repository)
+	if err != nil {
+		return nil, roleNone, err
+	}
+	return build, role, nil
+}
+
 func buildOutputRawHandler(w http.ResponseWriter, r *http.Request) {
+	cursor, err := parseLogCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	build, err := findBuild(r)
+	if err != nil {
+		fmt.Println("Error finding build:", err)
+		w.WriteHeader(500)
+		return
+	}
+	if build == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	chunk, err := logStore.ReadFrom(build, cursor.Offset)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Println("Error reading build output:", err)
+		w.WriteHeader(500)
+		return
+	}
+	converted, next := renderLog(chunk, cursor, build.Complete)
+	output := map[string]interface{}{
+		"output":   converted,
+		"cursor":   next.String(),
+		"complete": build.Complete,
+		"success":  build.Success,
+	}
 	w.Header().Set("Content-Type", "application/json")
+	b, _ := json.Marshal(output)
+	w.Write(b)
+}
 
-	id, _ := strconv.Atoi(r.URL.Query().Get(":id"))
-	start, _ := strconv.Atoi(r.URL.Query().Get("start"))
-	for _, build := range database.AllBuilds(currentAccount(r)) {
-		if build.Id == id {
-			raw := build.ReadOutput()
-			raw = raw[start:]
-			converted := ""
-			if len(raw) != 0 {
-				converted = AnsiToHtml(raw)
+func buildTestsHandler(w http.ResponseWriter, r *http.Request) {
+	build, err := findBuild(r)
+	if err != nil {
+		fmt.Println("Error finding build:", err)
+		w.WriteHeader(500)
+		return
+	}
+	if build == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	results, err := database.TestResults(r.Context(), []*Build{build})
+	if err != nil {
+		fmt.Println("Error getting test results:", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	b, _ := json.Marshal(map[string]interface{}{
+		"summary": build.Tests,
+		"results": results,
+	})
+	w.Write(b)
+}
+
+// The test history shows this many builds of a branch.
+const testHistoryBuilds = 20
+
+func buildTestHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	build, err := findBuild(r)
+	if err != nil {
+		fmt.Println("Error finding build:", err)
+		w.WriteHeader(500)
+		return
+	}
+	if build == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	repositoryBuilds, err := database.RepositoryBuilds(r.Context(), &Repository{Id: build.RepositoryId})
+	if err != nil {
+		fmt.Println("Error getting repository builds:", err)
+		w.WriteHeader(500)
+		return
+	}
+	var builds []*Build
+	for _, b := range repositoryBuilds {
+		if b.Ref == build.Ref && b.Id <= build.Id {
+			builds = append(builds, b)
+		}
+	}
+	if len(builds) > testHistoryBuilds {
+		builds = builds[len(builds)-testHistoryBuilds:]
+	}
+
+	results, err := database.TestResults(r.Context(), builds)
+	if err != nil {
+		fmt.Println("Error getting test results:", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	var columns []map[string]interface{}
+	for _, b := range builds {
+		columns = append(columns, map[string]interface{}{"id": b.Id, "url": b.Url})
+	}
+	var tests []map[string]interface{}
+	for _, history := range testHistories(builds, results) {
+		var statuses []map[string]interface{}
+		for i, result := range history.Results {
+			status := result.Status
+			if status == "" {
+				status = "missing"
 			}
-			output := map[string]interface{}{
-				"length": len(raw),
-				"output": converted,
+			statuses = append(statuses, map[string]interface{}{
+				"build_id": builds[i].Id,
+				"status":   status,
+				"message":  result.Message,
+			})
+		}
+		tests = append(tests, map[string]interface{}{
+			"class_name": history.ClassName,
+			"name":       history.Name,
+			"flaky":      history.Flaky,
+			"results":    statuses,
+		})
+	}
+
+	context := defaultViewContext(r)
+	context["css"] = map[string]string{
+		"name": "test_history.css",
+	}
+	context["build_id"] = build.Id
+	context["repository"] = build.Repository
+	context["ref"] = build.Ref
+	context["builds"] = columns
+	context["tests"] = tests
+	body := mustache.RenderFileInLayout("views/test_history.mustache", "views/layout.mustache", context)
+	w.Write([]byte(body))
+}
+
+func buildCoverageHandler(w http.ResponseWriter, r *http.Request) {
+	build, err := findBuild(r)
+	if err != nil {
+		fmt.Println("Error finding build:", err)
+		w.WriteHeader(500)
+		return
+	}
+	if build == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	files, err := database.CoverageFiles(r.Context(), build)
+	if err != nil {
+		fmt.Println("Error getting coverage:", err)
+		w.WriteHeader(500)
+		return
+	}
+	repositoryBuilds, err := database.RepositoryBuilds(r.Context(), &Repository{Id: build.RepositoryId})
+	if err != nil {
+		fmt.Println("Error getting repository builds:", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	output := map[string]interface{}{
+		"summary": nil,
+		"files":   files,
+		"base":    nil,
+		"delta":   nil,
+	}
+	if build.Coverage != nil {
+		output["summary"] = map[string]interface{}{
+			"covered": build.Coverage.Covered,
+			"total":   build.Coverage.Total,
+			"percent": build.Coverage.Percent(),
+		}
+		if base := baseCoverageBuild(build, repositoryBuilds); base != nil {
+			output["base"] = map[string]interface{}{
+				"id":      base.Id,
+				"ref":     base.Ref,
+				"percent": base.Coverage.Percent(),
 			}
-			b, _ := json.Marshal(output)
-			w.Write(b)
+			output["delta"] = build.Coverage.Percent() - base.Coverage.Percent()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	b, _ := json.Marshal(output)
+	w.Write(b)
+}
+
+// The coverage trend shows this many builds of a branch.
+const coverageTrendBuilds = 50
+
+func buildCoverageTrendHandler(w http.ResponseWriter, r *http.Request) {
+	build, err := findBuild(r)
+	if err != nil {
+		fmt.Println("Error finding build:", err)
+		w.WriteHeader(500)
+		return
+	}
+	if build == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	repositoryBuilds, err := database.RepositoryBuilds(r.Context(), &Repository{Id: build.RepositoryId})
+	if err != nil {
+		fmt.Println("Error getting repository builds:", err)
+		w.WriteHeader(500)
+		return
+	}
+	var builds []*Build
+	for _, b := range repositoryBuilds {
+		if b.Ref == build.Ref && b.Coverage != nil {
+			builds = append(builds, b)
+		}
+	}
+	if len(builds) > coverageTrendBuilds {
+		builds = builds[len(builds)-coverageTrendBuilds:]
+	}
+
+	var line []string
+	var points []map[string]interface{}
+	for _, point := range coverageChart(builds) {
+		line = append(line, fmt.Sprintf("%.1f,%.1f", point.X, point.Y))
+		points = append(points, map[string]interface{}{
+			"id":      point.Build.Id,
+			"url":     point.Build.Url,
+			"percent": fmt.Sprintf("%.1f", point.Percent),
+			"x":       fmt.Sprintf("%.1f", point.X),
+			"y":       fmt.Sprintf("%.1f", point.Y),
+		})
+	}
+
+	context := defaultViewContext(r)
+	context["css"] = map[string]string{
+		"name": "coverage_trend.css",
+	}
+	context["build_id"] = build.Id
+	context["repository"] = build.Repository
+	context["ref"] = build.Ref
+	context["width"] = coverageChartWidth
+	context["height"] = coverageChartHeight
+	context["line"] = strings.Join(line, " ")
+	context["points"] = points
+	body := mustache.RenderFileInLayout("views/coverage_trend.mustache", "views/layout.mustache", context)
+	w.Write([]byte(body))
+}
+
+// The repository page shows this many of the most recent builds.
+const repositoryHistoryBuilds = 50
+
+func repositoryHandler(w http.ResponseWriter, r *http.Request) {
+	repository, err := database.FindRepository(r.Context(), requestedHost(r), r.URL.Query().Get(":owner"), r.URL.Query().Get(":repo"))
+	if err != nil {
+		fmt.Println("Error finding repository:", err)
+		w.WriteHeader(500)
+		return
+	}
+	account := currentAccount(r)
+	role := roleNone
+	if repository != nil {
+		role, err = repositoryRole(r.Context(), account, repository)
+		if err != nil {
+			fmt.Println("Error checking repository access:", err)
+			w.WriteHeader(500)
 			return
 		}
 	}
+	if !role.can(roleRead) {
+		http.NotFound(w, r)
+		return
+	}
+
+	builds, err := database.RepositoryBuilds(r.Context(), repository)
+	if err != nil {
+		fmt.Println("Error getting repository builds:", err)
+		w.WriteHeader(500)
+		return
+	}
+	if err := findBuildAuthors(r.Context(), builds); err != nil {
+		fmt.Println("Error finding build authors:", err)
+		w.WriteHeader(500)
+		return
+	}
+	history := builds
+	if len(history) > repositoryHistoryBuilds {
+		history = history[len(history)-repositoryHistoryBuilds:]
+	}
+	stats := buildStats(history)
+
+	context := defaultViewContext(r)
+	context["css"] = map[string]string{
+		"name": "repository.css",
+	}
+	context["owner"] = repository.Owner
+	context["repository"] = repository.Repository
+	context["host"] = hostName(repository.Host)
+	context["public"] = repository.Public
+	context["admin"] = role.can(roleAdmin)
+	context["host_name"] = sourceHost(repository.Host).Name()
+	var hooks []map[string]string
+	for _, hook := range sourceHost(repository.Host).WantedHooks() {
+		hooks = append(hooks, map[string]string{
+			"event": hook.Event,
+			"url":   hook.Url,
+		})
+	}
+	context["hooks"] = hooks
+	context["branches"] = dashboardBuilds(latestBuildsByBranch(builds))
+	context["pull_requests"] = dashboardBuilds(openPullRequestBuilds(builds))
+	context["history"] = dashboardBuilds(reversed(history))
+	context["finished"] = stats.Finished
+	context["pass_rate"] = fmt.Sprintf("%.0f", stats.PassRate())
+	context["average_duration"] = stats.AverageDuration.String()
+	body := mustache.RenderFileInLayout("views/repository.mustache", "views/layout.mustache", context)
+	w.Write([]byte(body))
 }
 
-func logoutHandler(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{Name: "account_id", Value: "empty", MaxAge: -1})
-	http.SetCookie(w, &http.Cookie{Name: "token", Value: "empty", MaxAge: -1})
-	http.Redirect(w, r, "/", 302)
+func dashboardBuilds(builds []*Build) []map[string]interface{} {
+	var rows []map[string]interface{}
+	for _, build := range builds {
+		duration := ""
+		if d := build.Duration(); d > 0 {
+			duration = d.String()
+		}
+		rows = append(rows, map[string]interface{}{
+			"id":                build.Id,
+			"url":               build.Url,
+			"ref":               build.Ref,
+			"branch_url":        "/?" + BuildFilter{Repository: build.Owner + "/" + build.Repository, Branch: build.Ref}.values().Encode(),
+			"base_ref":          build.BaseRef,
+			"author":            build.Author,
+			"author_name":       build.AuthorName,
+			"author_avatar_url": build.AuthorAvatarUrl,
+			"result":            build.Result,
+			"github_url":        build.GithubUrl,
+			"created_at":        build.CreatedAt.Format("2006-01-02 15:04"),
+			"duration":          duration,
+		})
+	}
+	return rows
 }
 
 func addRepositoryHandler(w http.ResponseWriter, r *http.Request) {
@@ -218,69 +794,259 @@ func addRepositoryHandler(w http.ResponseWriter, r *http.Request) {
 	if account != nil {
 		owner := r.PostFormValue("owner")
 		repositoryName := r.PostFormValue("repository")
+		// Repositories picked from the list on Github come as owner/name.
+		if fullName := r.PostFormValue("full_name"); fullName != "" {
+			owner, repositoryName = splitFullName(fullName)
+		}
+		if owner == "" || repositoryName == "" {
+			http.Error(w, "Both an owner and a repository are needed", 400)
+			return
+		}
 
-		err := git.CreateHooks(account.AccessToken, owner, repositoryName)
+		existing, err := database.FindRepository(r.Context(), account.Host, owner, repositoryName)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Println("Error finding repository:", err)
+			w.WriteHeader(500)
+			return
+		}
+		if existing != nil {
+			http.Error(w, owner+"/"+repositoryName+" has already been added to builder", 400)
+			return
+		}
+
+		// Only admins of an organization can add repositories to it.
+		organizationId := 0
+		if name := r.PostFormValue("organization"); name != "" {
+			organization, err := database.FindOrganization(r.Context(), name)
+			if err != nil {
+				fmt.Println("Error finding organization:", err)
+				w.WriteHeader(500)
+				return
+			}
+			role := roleNone
+			if organization != nil {
+				role, err = organizationRole(r.Context(), account, organization)
+				if err != nil {
+					fmt.Println("Error finding organization role:", err)
+					w.WriteHeader(500)
+					return
+				}
+			}
+			if !role.can(roleAdmin) {
+				http.Error(w, "Only admins of the organization can add repositories to it", 403)
+				return
+			}
+			organizationId = organization.Id
+		}
+
+		token, err := account.HostToken()
+		if err != nil {
+			fmt.Println("Error decrypting access token:", err)
 			w.WriteHeader(500)
 			return
 		}
+		host := sourceHost(account.Host)
+		private, err := host.IsRepositoryPrivate(owner, repositoryName)
+		if err != nil {
+			fmt.Println("Error checking whether the repository is private:", err)
+			http.Error(w, "Couldn't find the repository on "+host.Name(), 502)
+			return
+		}
+		hooks, err := installHooks(host, token, owner, repositoryName)
+		if err != nil {
+			fmt.Println("Error installing hooks:", err)
+			http.Error(w, "Couldn't create the webhooks on "+host.Name(), 502)
+			return
+		}
 
 		repository := &Repository{
 			Owner:      owner,
 			Repository: repositoryName,
-			Public:     !git.IsRepositoryPrivate(owner, repositoryName),
+			Public:     !private,
+			Host:       account.Host,
+
+			OrganizationId: organizationId,
 		}
-		err = database.AddRepositoryToAccount(account, repository)
+		err = database.AddRepositoryToAccount(r.Context(), account, repository)
 		if err != nil {
 			fmt.Println(err)
 			w.WriteHeader(500)
 			return
 		}
 
-		collaborators := git.RepositoryCollaborators(account.AccessToken, owner, repositoryName)
+		err = database.SaveRepositoryHooks(r.Context(), repository.Id, hooks)
+		if err != nil {
+			fmt.Println("Error saving hooks:", err)
+		}
 
-		for _, collaborator := range collaborators {
-			database.SaveCollaboration(collaborator.Id, repository.Id)
+		err = syncCollaborators(r.Context(), account, repository)
+		if err != nil {
+			fmt.Println("Error syncing collaborators:", err)
 		}
 	}
 	http.Redirect(w, r, "/settings", 302)
 }
 
-func githubLoginHandler(w http.ResponseWriter, r *http.Request) {
-	code := r.URL.Query().Get("code")
-
-	accessToken, err := git.GetAccessToken(
-		configuration.GithubClientID,
-		configuration.GithubClientSecret,
-		code)
+// findBuildWithRole returns the build in the url if the current account has
+// at least the role on its repository. Otherwise it writes the error and
+// returns nil. Builds the account can't see aren't found.
+func findBuildWithRole(w http.ResponseWriter, r *http.Request, required Role) (*Build, *Account) {
+	build, role, err := findBuildAndRole(r)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Println("Error finding build:", err)
+		w.WriteHeader(500)
+		return nil, nil
+	}
+	if build == nil || !role.can(roleRead) {
+		http.NotFound(w, r)
+		return nil, nil
+	}
+	account := currentAccount(r)
+	if !role.can(required) {
+		http.Error(w, "You need "+string(required)+" access to the repository to do that", 403)
+		return nil, nil
+	}
+	return build, account
+}
+
+// rebuildHandler builds the same commit again, as the current account.
+func rebuildHandler(w http.ResponseWriter, r *http.Request) {
+	build, account := findBuildWithRole(w, r, roleWrite)
+	if build == nil {
 		return
 	}
 
-	githubUserID, err := git.GetUserID(accessToken)
+	var commits []Commit
+	for _, commit := range build.Commits {
+		commits = append(commits, Commit{Sha: commit.Sha, Message: commit.Message, Url: commit.Url})
+	}
+	rebuild := &Build{
+		Host:       build.Host,
+		Owner:      build.Owner,
+		Repository: build.Repository,
+		Ref:        build.Ref,
+		BaseRef:    build.BaseRef,
+		Author:     account.Login,
+		Trigger:    build.Trigger,
+		Sha:        build.Sha,
+		GithubUrl:  build.GithubUrl,
+		Commits:    commits,
+	}
+	err := launcher.LaunchBuild(rebuild)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Println("Error launching build:", err)
+		w.WriteHeader(500)
+		return
+	}
+	http.Redirect(w, r, "/build/"+strconv.Itoa(rebuild.Id)+"/output", 302)
+}
+
+func cancelBuildHandler(w http.ResponseWriter, r *http.Request) {
+	build, _ := findBuildWithRole(w, r, roleWrite)
+	if build == nil {
+		return
+	}
+	if build.Complete {
+		http.Error(w, "The build has already finished", 400)
 		return
 	}
 
-	account := &Account{
-		Id:          githubUserID,
-		AccessToken: accessToken,
+	// Builds that aren't running any more, because builder restarted, are
+	// failed straight away.
+	if !cancelBuild(build.Id) {
+		build.fail()
 	}
+	http.Redirect(w, r, "/build/"+strconv.Itoa(build.Id)+"/output", 302)
+}
 
-	err = database.CreateAccount(account)
+// findRepositoryWithRole returns the repository in the url if the current
+// account has at least the role on it. Otherwise it writes the error and
+// returns nil. Repositories the account can't see aren't found.
+func findRepositoryWithRole(w http.ResponseWriter, r *http.Request, required Role) *Repository {
+	repository, err := database.FindRepository(r.Context(), requestedHost(r), r.URL.Query().Get(":owner"), r.URL.Query().Get(":repo"))
 	if err != nil {
+		fmt.Println("Error finding repository:", err)
+		w.WriteHeader(500)
+		return nil
+	}
+	role := roleNone
+	if repository != nil {
+		role, err = repositoryRole(r.Context(), currentAccount(r), repository)
+		if err != nil {
+			fmt.Println("Error finding repository role:", err)
+			w.WriteHeader(500)
+			return nil
+		}
+	}
+	if !role.can(roleRead) {
+		http.NotFound(w, r)
+		return nil
+	}
+	if !role.can(required) {
+		http.Error(w, "You need "+string(required)+" access to the repository to do that", 403)
+		return nil
+	}
+	return repository
+}
+
+// requestedHost is the source host of the repository in the url. Pages of
+// repositories that aren't on Github have the host in a "host" parameter.
+func requestedHost(r *http.Request) string {
+	return hostName(r.FormValue("host"))
+}
+
+// findRepositoryOwner returns the account that added the repository.
+// Otherwise it writes the error and returns nil.
+func findRepositoryOwner(w http.ResponseWriter, r *http.Request, repository *Repository) *Account {
+	owner, err := database.FindAccountById(r.Context(), repository.AccountId)
+	if err != nil {
+		fmt.Println("Error finding account:", err)
+		w.WriteHeader(500)
+		return nil
+	}
+	if owner == nil {
+		http.Error(w, "The account that added the repository doesn't exist any more", 400)
+		return nil
+	}
+	return owner
+}
+
+// repositorySettingsHandler lets admins of a repository change who can see
+// its builds.
+func repositorySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	repository := findRepositoryWithRole(w, r, roleAdmin)
+	if repository == nil {
 		return
 	}
 
-	login, err := database.CreateLoginForAccount(account)
+	err := database.SetRepositoryPublic(r.Context(), repository.Id, r.PostFormValue("public") == "true")
 	if err != nil {
+		fmt.Println("Error saving repository:", err)
+		w.WriteHeader(500)
+		return
+	}
+	http.Redirect(w, r, repository.Url(), 302)
+}
+
+func developmentLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !configuration.Development {
+		http.NotFound(w, r)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{Name: "account_id", Value: strconv.Itoa(githubUserID)})
-	http.SetCookie(w, &http.Cookie{Name: "token", Value: login.Token})
+	account := &Account{HostUserId: 1}
+	err := database.CreateAccount(r.Context(), account)
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(500)
+		return
+	}
+
+	err = logIn(w, r, account)
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(500)
+		return
+	}
 	http.Redirect(w, r, "/", 302)
 }
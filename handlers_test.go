@@ -2,10 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -15,16 +21,19 @@ type FakeBuildLauncher struct {
 	launchedBuild bool
 }
 
-func (fbl *FakeBuildLauncher) LaunchBuild(owner string, repo string, ref string, sha string, githubURL string, commits []Commit) error {
+func (fbl *FakeBuildLauncher) LaunchBuild(build *Build) error {
 	fbl.launchedBuild = true
 	fbl.values = map[string]interface{}{
-		"owner":     owner,
-		"repo":      repo,
-		"ref":       ref,
-		"sha":       sha,
-		"githubURL": githubURL,
+		"owner":     build.Owner,
+		"repo":      build.Repository,
+		"ref":       build.Ref,
+		"baseRef":   build.BaseRef,
+		"author":    build.Author,
+		"trigger":   build.Trigger,
+		"sha":       build.Sha,
+		"githubURL": build.GithubUrl,
 	}
-	fbl.commits = commits
+	fbl.commits = build.Commits
 	return nil
 }
 
@@ -50,6 +59,9 @@ func TestPushHandlerLaunchesBuildWithCorrectValues(t *testing.T) {
 			"owner":     "AndrewVos",
 			"repo":      "builder-test-green-repo",
 			"ref":       "master",
+			"baseRef":   "",
+			"author":    "AndrewVos",
+			"trigger":   "push",
 			"sha":       "576be25d7e3d5320e92472d5734b50b17c1822e0",
 			"githubURL": "https://github.com/AndrewVos/builder-test-green-repo/compare/da46166aa120...576be25d7e3d",
 		}
@@ -99,6 +111,9 @@ func TestPullRequestHandlerLaunchesBuildWithCorrectValues(t *testing.T) {
 			"owner":     "AndrewVos",
 			"repo":      "builder-test-green-repo",
 			"ref":       "pool-request",
+			"baseRef":   "master",
+			"author":    "AndrewVos",
+			"trigger":   "pull_request",
 			"sha":       "7f39d6495acae9db022cc20e7f0d940158e0337d",
 			"githubURL": "https://api.github.com/repos/AndrewVos/builder-test-green-repo/pulls/2",
 		}
@@ -112,24 +127,30 @@ func TestPullRequestHandlerLaunchesBuildWithCorrectValues(t *testing.T) {
 }
 
 func TestAddRepositoryHandlerCreatesHooksAndRepository(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+
 	formValues := url.Values{}
 	formValues.Set("owner", "RepoOwnerrr")
 	formValues.Set("repository", "RailsTurboLinks")
 
-	fakeDatabase.FindAccountByIdToReturn = &Account{
+	account := &Account{
 		Id:          3232,
+		HostUserId:  3232,
 		AccessToken: "sdfwef",
 	}
+	database.CreateAccount(context.Background(), account)
 
 	fakeGit.IsRepositoryPrivateResult = false
 
+	pusher := Collaborator{Id: 192}
+	pusher.Permissions.Push = true
 	fakeGit.CollaboratorsToReturn = []Collaborator{
-		Collaborator{Id: 192},
+		pusher,
+		Collaborator{Id: 193},
 	}
 
-	r, _ := http.NewRequest("", "", nil)
-	r.AddCookie(&http.Cookie{Name: "account_id", Value: "123"})
-	r.AddCookie(&http.Cookie{Name: "token", Value: "nothing"})
+	r := loginRequest("POST", "/repository", account)
 	r.PostForm = formValues
 	addRepositoryHandler(httptest.NewRecorder(), r)
 
@@ -145,60 +166,557 @@ func TestAddRepositoryHandlerCreatesHooksAndRepository(t *testing.T) {
 		}
 	}
 
-	if fakeDatabase.SavedRepository.Owner != expectedValues["owner"] {
-		t.Errorf("Expected Owner to be %q, but was %q\n", expectedValues["owner"], fakeDatabase.SavedRepository.Owner)
+	repository, _ := database.FindRepository(context.Background(), "github", "RepoOwnerrr", "RailsTurboLinks")
+	if repository == nil {
+		t.Fatalf("Expected repository to be saved")
 	}
-	if fakeDatabase.SavedRepository.Repository != expectedValues["repository"] {
-		t.Errorf("Expected Repository to be %q, but was %q\n", expectedValues["repository"], fakeDatabase.SavedRepository.Repository)
+	if repository.AccountId != account.Id {
+		t.Errorf("Expected AccountId to be %v, but was %v\n", account.Id, repository.AccountId)
 	}
-	if fakeDatabase.SavedRepository.Public != true {
-		t.Errorf("Expected Public to be %v, but was %v\n", true, fakeDatabase.SavedRepository.Public)
+	if repository.Public != true {
+		t.Errorf("Expected Public to be %v, but was %v\n", true, repository.Public)
 	}
 
-	if len(fakeDatabase.AddedCollaborations) != 1 {
-		t.Errorf("Should have added one collaborator")
-	} else if fakeDatabase.AddedCollaborations[0]["account_id"] != 192 || fakeDatabase.AddedCollaborations[0]["repository_id"] != 0 {
-		t.Errorf("Saved with wrong values %v", fakeDatabase.AddedCollaborations)
+	expectedCollaborations := []collaboration{
+		{HostUserId: 192, RepositoryId: repository.Id, Role: roleWrite},
+		{HostUserId: 193, RepositoryId: repository.Id, Role: roleRead},
+	}
+	if !reflect.DeepEqual(memoryDatabase.collaborations, expectedCollaborations) {
+		t.Errorf("Saved with wrong values %v", memoryDatabase.collaborations)
 	}
 }
 
-func TestGithubLoginHandlerCreatesNewAccount(t *testing.T) {
-	resetFakeDatabase()
+func TestAddRepositoryHandlerAddsRepositoriesPickedFromGithub(t *testing.T) {
+	resetMemoryDatabase()
 	resetFakeGit()
-	fakeGit.AccessTokenToReturn = "some-access-token-123"
-	fakeGit.UserIdToReturn = 56733
-	fakeDatabase.LoginToReturn = &Login{AccountId: 121212, Token: "tokennn"}
+	account := &Account{Id: 1, HostUserId: 1}
+	database.CreateAccount(context.Background(), account)
 
-	r, _ := http.NewRequest("GET", "http://bla.com/?code=QUERY_CODE", nil)
-	githubLoginHandler(httptest.NewRecorder(), r)
-	if fakeDatabase.CreatedAccount == nil {
-		t.Fatal("Expected an account to be created")
+	w := httptest.NewRecorder()
+	addRepositoryHandler(w, postForm(loginRequest("POST", "/repository", account), url.Values{"full_name": {"octocat/hello"}}))
+
+	if w.Code != 302 {
+		t.Errorf("Expected to be redirected, got %v", w.Code)
 	}
-	if fakeDatabase.CreatedAccount.Id != 56733 {
-		t.Fatalf("Github user ID wasn't stored")
+	if repository, _ := database.FindRepository(context.Background(), "github", "octocat", "hello"); repository == nil {
+		t.Errorf("Expected the picked repository to be added")
 	}
-	if fakeDatabase.CreatedAccount.AccessToken != "some-access-token-123" {
-		t.Fatalf("Access Token wasn't stored")
+}
+
+func TestAddRepositoryHandlerDoesntAddRepositoriesTwice(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	account := &Account{Id: 1, HostUserId: 1}
+	database.CreateAccount(context.Background(), account)
+	values := url.Values{"owner": {"octocat"}, "repository": {"hello"}}
+
+	addRepositoryHandler(httptest.NewRecorder(), postForm(loginRequest("POST", "/repository", account), values))
+	w := httptest.NewRecorder()
+	addRepositoryHandler(w, postForm(loginRequest("POST", "/repository", account), values))
+
+	if w.Code != 400 {
+		t.Errorf("Expected adding the repository again to fail, got %v", w.Code)
+	}
+	if repositories, _ := database.AllRepositories(context.Background()); len(repositories) != 1 {
+		t.Errorf("Expected one repository, got %d", len(repositories))
+	}
+	if len(fakeGit.Webhooks) != 2 {
+		t.Errorf("Expected one hook for each event, got %+v", fakeGit.Webhooks)
 	}
 }
 
-func TestGithubLoginHandlerSetsCookieWithValidLogin(t *testing.T) {
-	resetFakeDatabase()
+func TestAddRepositoryHandlerFailsWhenTheHostCantBeReached(t *testing.T) {
+	resetMemoryDatabase()
 	resetFakeGit()
-	fakeDatabase.LoginToReturn = &Login{AccountId: 121212, Token: "tokennn"}
+	account := &Account{Id: 1, HostUserId: 1}
+	database.CreateAccount(context.Background(), account)
+	fakeGit.IsRepositoryPrivateError = errors.New("connection refused")
+
+	w := httptest.NewRecorder()
+	addRepositoryHandler(w, postForm(loginRequest("POST", "/repository", account), url.Values{"full_name": {"octocat/hello"}}))
+
+	if w.Code != 502 {
+		t.Errorf("Expected adding the repository to fail, got %v", w.Code)
+	}
+	if repositories, _ := database.AllRepositories(context.Background()); len(repositories) != 0 || len(fakeGit.Webhooks) != 0 {
+		t.Errorf("Expected nothing to be added, got %+v and %+v", repositories, fakeGit.Webhooks)
+	}
+}
+
+func TestDevelopmentLoginHandlerOnlyWorksInDevelopment(t *testing.T) {
+	resetMemoryDatabase()
+
+	r, _ := http.NewRequest("GET", "/development_login", nil)
+	w := httptest.NewRecorder()
+	developmentLoginHandler(w, r)
+	if w.Code != 404 {
+		t.Errorf("Expected development login to be missing outside of development, got %v", w.Code)
+	}
+
+	configuration.Development = true
+	defer func() { configuration.Development = false }()
+
+	w = httptest.NewRecorder()
+	developmentLoginHandler(w, r)
+	if account := findUsersAccount("github", 1); account == nil {
+		t.Errorf("Expected a developer account to be created")
+	}
+	if len(w.Header()["Set-Cookie"]) != 1 {
+		t.Errorf("Expected a session cookie to be set, got %v", w.Header()["Set-Cookie"])
+	}
+}
+
+func TestBuildOutputRawHandlerResumesFromTheCursor(t *testing.T) {
+	resetMemoryDatabase()
+	defer cleanDataDirectory()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	repository := createAccountWithRepository(account, "AndrewVos", "builder")
+	build := &Build{Owner: "AndrewVos", Repository: "builder"}
+	database.CreateBuild(context.Background(), repository, build)
+
+	output, _ := logStore.Create(build)
+	output.Write([]byte("\x1b[32mone\ntw"))
+
+	request := func(cursor string) map[string]interface{} {
+		query := url.Values{":id": {strconv.Itoa(build.Id)}, "cursor": {cursor}}
+		w := httptest.NewRecorder()
+		buildOutputRawHandler(w, loginRequest("GET", "/build/output/raw?"+query.Encode(), account))
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response
+	}
+
+	response := request("")
+	expected := `<div class="line"><span class="green">one</span></div>`
+	if response["output"] != expected || response["complete"] != false {
+		t.Errorf("Expected only the complete lines, got %v", response)
+	}
+
+	output.Write([]byte("o\nthree"))
+	output.Close()
+	build.Complete = true
+	database.SaveBuild(context.Background(), build)
+
+	response = request(response["cursor"].(string))
+	expected = `<div class="line"><span class="green">two</span></div><div class="line"><span class="green">three</span></div>`
+	if response["output"] != expected || response["complete"] != true {
+		t.Errorf("Expected the rest of the log, got %v", response)
+	}
+}
+
+func TestBuildTestsHandlerReturnsTheTestResults(t *testing.T) {
+	resetMemoryDatabase()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	repository := createAccountWithRepository(account, "AndrewVos", "builder")
+	build := &Build{Owner: "AndrewVos", Repository: "builder"}
+	database.CreateBuild(context.Background(), repository, build)
+	database.SaveTestResults(context.Background(), build, []TestResult{
+		{ClassName: "BuildTest", Name: "test_fails", Status: testFailed, Message: "expected pass"},
+	})
 
-	r, _ := http.NewRequest("GET", "http://bla.com/?code=QUERY_CODE", nil)
+	query := url.Values{":id": {strconv.Itoa(build.Id)}}
 	w := httptest.NewRecorder()
+	buildTestsHandler(w, loginRequest("GET", "/build/tests?"+query.Encode(), account))
 
-	fakeGit.AccessTokenToReturn = "some-access-token-123"
-	fakeGit.UserIdToReturn = 56733
+	var response struct {
+		Summary *TestSummary
+		Results []TestResult
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response.Summary == nil || response.Summary.Failed != 1 {
+		t.Errorf("Expected a summary with one failure, got %+v", response.Summary)
+	}
+	if len(response.Results) != 1 || response.Results[0].Message != "expected pass" {
+		t.Errorf("Expected the failing test, got %+v", response.Results)
+	}
+
+	w = httptest.NewRecorder()
+	buildTestsHandler(w, loginRequest("GET", "/build/tests?"+query.Encode(), &Account{Id: 2, HostUserId: 2}))
+	if w.Code != 404 {
+		t.Errorf("Expected other accounts not to see the results, got %v", w.Code)
+	}
+}
+
+func TestBuildCoverageHandlerComparesPullRequestsToTheBaseBranch(t *testing.T) {
+	resetMemoryDatabase()
+	ctx := context.Background()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	repository := createAccountWithRepository(account, "AndrewVos", "builder")
+	master := &Build{Owner: "AndrewVos", Repository: "builder", Ref: "master"}
+	database.CreateBuild(ctx, repository, master)
+	database.SaveCoverage(ctx, master, []FileCoverage{{Path: "build.go", Covered: 1, Total: 2}})
+	pullRequest := &Build{Owner: "AndrewVos", Repository: "builder", Ref: "feature", BaseRef: "master"}
+	database.CreateBuild(ctx, repository, pullRequest)
+	database.SaveCoverage(ctx, pullRequest, []FileCoverage{{Path: "build.go", Covered: 3, Total: 4}})
+
+	query := url.Values{":id": {strconv.Itoa(pullRequest.Id)}}
+	w := httptest.NewRecorder()
+	buildCoverageHandler(w, loginRequest("GET", "/build/coverage?"+query.Encode(), account))
+
+	var response struct {
+		Summary struct{ Percent float64 }
+		Files   []FileCoverage
+		Base    struct{ Id int }
+		Delta   *float64
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response.Summary.Percent != 75 {
+		t.Errorf("Expected 75%% coverage, got %v", response.Summary.Percent)
+	}
+	if len(response.Files) != 1 || response.Files[0].Path != "build.go" {
+		t.Errorf("Expected the covered files, got %+v", response.Files)
+	}
+	if response.Base.Id != master.Id || response.Delta == nil || *response.Delta != 25 {
+		t.Errorf("Expected +25%% compared to master, got %s", w.Body.String())
+	}
+}
+
+func TestBuildsHandlerSearchesBuilds(t *testing.T) {
+	resetMemoryDatabase()
+
+	account := &Account{Id: 1, HostUserId: 1}
+	repository := createAccountWithRepository(account, "AndrewVos", "builder")
+	master := &Build{Owner: "AndrewVos", Repository: "builder", Ref: "master"}
+	database.CreateBuild(context.Background(), repository, master)
+	database.CreateBuild(context.Background(), repository, &Build{Owner: "AndrewVos", Repository: "builder", Ref: "feature"})
+
+	w := httptest.NewRecorder()
+	buildsHandler(w, loginRequest("GET", "/builds?branch=master", account))
+
+	var response struct {
+		Builds []*Build
+		Next   int
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if len(response.Builds) != 1 || response.Builds[0].Id != master.Id || response.Next != 0 {
+		t.Errorf("Expected to find the master build, got %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	buildsHandler(w, loginRequest("GET", "/builds?result=maybe", account))
+	if w.Code != 400 {
+		t.Errorf("Expected an invalid filter to be a bad request, got %v", w.Code)
+	}
+}
+
+func TestBuildsHandlerShowsTheProfileOfAuthorsWhoHaveLoggedIn(t *testing.T) {
+	resetMemoryDatabase()
 
-	githubLoginHandler(w, r)
+	account := &Account{Id: 1, HostUserId: 1, Login: "AndrewVos", Name: "Andrew Vos", AvatarUrl: "https://example.com/avatar.png"}
+	repository := createAccountWithRepository(account, "AndrewVos", "builder")
+	database.CreateBuild(context.Background(), repository, &Build{Owner: "AndrewVos", Repository: "builder", Ref: "master", Author: "andrewvos"})
+	database.CreateBuild(context.Background(), repository, &Build{Owner: "AndrewVos", Repository: "builder", Ref: "feature", Author: "someone"})
+
+	w := httptest.NewRecorder()
+	buildsHandler(w, loginRequest("GET", "/builds", account))
+
+	var response struct {
+		Builds []*Build
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if len(response.Builds) != 2 {
+		t.Fatalf("Expected two builds, got %s", w.Body.String())
+	}
+	if b := response.Builds[1]; b.AuthorName != "Andrew Vos" || b.AuthorAvatarUrl != "https://example.com/avatar.png" {
+		t.Errorf("Expected the build to have the author's profile, got %+v", b)
+	}
+	if b := response.Builds[0]; b.AuthorName != "" || b.AuthorAvatarUrl != "" {
+		t.Errorf("Expected no profile for an author who hasn't logged in, got %+v", b)
+	}
+}
+
+func TestBuildAuthorsAreFoundOnTheBuildsHost(t *testing.T) {
+	resetMemoryDatabase()
+	ctx := context.Background()
+
+	database.CreateAccount(ctx, &Account{Id: 1, HostUserId: 1, Login: "alice", Name: "Alice on Github"})
+	database.CreateAccount(ctx, &Account{Id: -1, HostUserId: -1, Login: "alice", Name: "Alice on GitLab", Host: "gitlab"})
+	builds := []*Build{
+		{Host: "github", Author: "alice"},
+		{Host: "gitlab", Author: "Alice"},
+		{Host: "gitea", Author: "alice"},
+	}
+
+	if err := findBuildAuthors(ctx, builds); err != nil {
+		t.Fatal(err)
+	}
+	if builds[0].AuthorName != "Alice on Github" || builds[1].AuthorName != "Alice on GitLab" || builds[2].AuthorName != "" {
+		t.Errorf("Expected each author from their build's host, got %q, %q and %q", builds[0].AuthorName, builds[1].AuthorName, builds[2].AuthorName)
+	}
+}
 
-	if w.Header()["Set-Cookie"][0] != "account_id=56733" {
-		t.Errorf("Cookie account_id wasn't set properly, got %v", w.Header()["Set-Cookie"][0])
+func TestRepositoryHandlerFollowsBuildAccessRules(t *testing.T) {
+	resetMemoryDatabase()
+	ctx := context.Background()
+
+	owner := &Account{Id: 1, HostUserId: 1}
+	createAccountWithRepository(owner, "AndrewVos", "builder")
+	stranger := &Account{Id: 2, HostUserId: 2}
+	database.CreateAccount(ctx, stranger)
+
+	query := url.Values{":owner": {"AndrewVos"}, ":repo": {"builder"}}
+	w := httptest.NewRecorder()
+	repositoryHandler(w, loginRequest("GET", "/AndrewVos/builder?"+query.Encode(), owner))
+	if w.Code != 200 {
+		t.Errorf("Expected the owner to see the repository, got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	repositoryHandler(w, loginRequest("GET", "/AndrewVos/builder?"+query.Encode(), stranger))
+	if w.Code != 404 {
+		t.Errorf("Expected other accounts not to see the repository, got %v", w.Code)
+	}
+
+	query = url.Values{":owner": {"AndrewVos"}, ":repo": {"missing"}}
+	w = httptest.NewRecorder()
+	repositoryHandler(w, loginRequest("GET", "/AndrewVos/missing?"+query.Encode(), owner))
+	if w.Code != 404 {
+		t.Errorf("Expected missing repositories not to be found, got %v", w.Code)
+	}
+}
+
+func TestRepositoryHandlerFindsRepositoriesOnTheHostInTheUrl(t *testing.T) {
+	resetMemoryDatabase()
+
+	githubAccount := &Account{Id: 1, HostUserId: 1}
+	createAccountWithRepository(githubAccount, "AndrewVos", "builder")
+	gitlabAccount := &Account{Id: 2, HostUserId: 2, Host: "gitlab"}
+	gitlabRepository := createAccountWithRepository(gitlabAccount, "AndrewVos", "builder")
+
+	query := url.Values{":owner": {"AndrewVos"}, ":repo": {"builder"}, "host": {"gitlab"}}
+	w := httptest.NewRecorder()
+	repositoryHandler(w, loginRequest("GET", "/AndrewVos/builder?"+query.Encode(), githubAccount))
+	if w.Code != 404 {
+		t.Errorf("Expected the GitLab repository not to be visible to the Github account, got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	repositoryHandler(w, loginRequest("GET", "/AndrewVos/builder?"+query.Encode(), gitlabAccount))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "host:gitlab") {
+		t.Errorf("Expected to see the GitLab repository, got %v:\n%v", w.Code, w.Body.String())
+	}
+	if url := gitlabRepository.Url(); url != "/AndrewVos/builder?host=gitlab" {
+		t.Errorf("Expected the url to name the host, got %q", url)
+	}
+}
+
+func TestPullRequestHandlerClosesPullRequestBuilds(t *testing.T) {
+	resetMemoryDatabase()
+	ctx := context.Background()
+
+	repository := createAccountWithRepository(&Account{Id: 1, HostUserId: 1}, "AndrewVos", "builder-test-green-repo")
+	build := &Build{Owner: "AndrewVos", Repository: "builder-test-green-repo", Ref: "pool-request", Trigger: triggerPullRequest}
+	database.CreateBuild(ctx, repository, build)
+
+	withFakeLauncher(func(fbl *FakeBuildLauncher) {
+		pullRequestHandler(httptest.NewRecorder(), createFakeRequest("test-data/closed_pull_request.json"))
+	})
+
+	builds, _ := database.RepositoryBuilds(ctx, repository)
+	if len(builds) != 1 || !builds[0].PullRequestClosed {
+		t.Errorf("Expected the pull request build to be closed, got %+v", builds[0])
+	}
+}
+
+func createPublicAndPrivateBuilds() (*Build, *Build) {
+	ctx := context.Background()
+	account := &Account{Id: 1, HostUserId: 1}
+	database.CreateAccount(ctx, account)
+	public := &Repository{Owner: "AndrewVos", Repository: "public", Public: true}
+	database.AddRepositoryToAccount(ctx, account, public)
+	private := &Repository{Owner: "AndrewVos", Repository: "private"}
+	database.AddRepositoryToAccount(ctx, account, private)
+
+	publicBuild := &Build{Owner: "AndrewVos", Repository: "public"}
+	database.CreateBuild(ctx, public, publicBuild)
+	privateBuild := &Build{Owner: "AndrewVos", Repository: "private"}
+	database.CreateBuild(ctx, private, privateBuild)
+	return publicBuild, privateBuild
+}
+
+func buildRequest(path string, build *Build, account *Account) *http.Request {
+	query := url.Values{":id": {strconv.Itoa(build.Id)}}
+	if account != nil {
+		return loginRequest("GET", path+"?"+query.Encode(), account)
+	}
+	return httptest.NewRequest("GET", path+"?"+query.Encode(), nil)
+}
+
+func TestAnonymousVisitorsOnlySeePublicBuilds(t *testing.T) {
+	resetMemoryDatabase()
+	publicBuild, _ := createPublicAndPrivateBuilds()
+
+	w := httptest.NewRecorder()
+	buildsHandler(w, httptest.NewRequest("GET", "/builds", nil))
+	var response struct {
+		Builds []*Build
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if len(response.Builds) != 1 || response.Builds[0].Id != publicBuild.Id {
+		t.Errorf("Expected anonymous visitors to only see the public build, got %s", w.Body.String())
+	}
+}
+
+func TestBuildOutputAccessRules(t *testing.T) {
+	defer cleanDataDirectory()
+	resetMemoryDatabase()
+	publicBuild, privateBuild := createPublicAndPrivateBuilds()
+	owner := &Account{Id: 1, HostUserId: 1}
+	stranger := &Account{Id: 2, HostUserId: 2}
+	database.CreateAccount(context.Background(), stranger)
+
+	handlers := map[string]http.HandlerFunc{
+		"/build/output":     buildOutputHandler,
+		"/build/output/raw": buildOutputRawHandler,
+	}
+	accesses := []struct {
+		build    *Build
+		account  *Account
+		expected int
+	}{
+		{publicBuild, nil, 200},
+		{publicBuild, stranger, 200},
+		{publicBuild, owner, 200},
+		{privateBuild, nil, 404},
+		{privateBuild, stranger, 404},
+		{privateBuild, owner, 200},
+	}
+	for path, handler := range handlers {
+		for _, access := range accesses {
+			w := httptest.NewRecorder()
+			handler(w, buildRequest(path, access.build, access.account))
+			if w.Code != access.expected {
+				t.Errorf("Expected %v of the %v build as %+v to be %v, got %v",
+					path, access.build.Repository, access.account, access.expected, w.Code)
+			}
+		}
+	}
+}
+
+// createOrganizationBuild creates a build of a private repository in an
+// organization, with a member for each role.
+func createOrganizationBuild() (*Build, map[Role]*Account) {
+	ctx := context.Background()
+	members := map[Role]*Account{}
+	for i, role := range roles {
+		members[role] = &Account{Id: i + 1, HostUserId: i + 1, Login: string(role) + "-member"}
+		database.CreateAccount(ctx, members[role])
+	}
+	organization := &Organization{Name: "acme"}
+	database.CreateOrganization(ctx, organization, members[roleAdmin])
+	database.SaveOrganizationMember(ctx, organization.Id, members[roleWrite].Id, roleWrite)
+	database.SaveOrganizationMember(ctx, organization.Id, members[roleRead].Id, roleRead)
+
+	repository := &Repository{Owner: "acme", Repository: "widgets", OrganizationId: organization.Id}
+	database.AddRepositoryToAccount(ctx, members[roleAdmin], repository)
+	build := &Build{Owner: "acme", Repository: "widgets", Ref: "master", Sha: "abc", Trigger: triggerPush, Author: "someone"}
+	database.CreateBuild(ctx, repository, build)
+	database.SaveCommit(ctx, &Commit{BuildId: build.Id, Sha: "abc", Message: "Add widgets"})
+	return build, members
+}
+
+func TestRebuildHandlerNeedsWriteAccess(t *testing.T) {
+	resetMemoryDatabase()
+	build, members := createOrganizationBuild()
+	stranger := &Account{Id: 10, HostUserId: 10}
+	database.CreateAccount(context.Background(), stranger)
+
+	for account, code := range map[*Account]int{stranger: 404, members[roleRead]: 403} {
+		withFakeLauncher(func(fbl *FakeBuildLauncher) {
+			w := httptest.NewRecorder()
+			rebuildHandler(w, buildRequest("/build/1/rebuild", build, account))
+			if w.Code != code || fbl.launchedBuild {
+				t.Errorf("Expected account %d to get %v without a build, got %v", account.Id, code, w.Code)
+			}
+		})
+	}
+
+	withFakeLauncher(func(fbl *FakeBuildLauncher) {
+		w := httptest.NewRecorder()
+		rebuildHandler(w, buildRequest("/build/1/rebuild", build, members[roleWrite]))
+		if w.Code != 302 || !fbl.launchedBuild {
+			t.Fatalf("Expected writers to rebuild, got %v", w.Code)
+		}
+		if fbl.values["sha"] != "abc" || fbl.values["ref"] != "master" || fbl.values["author"] != "write-member" || fbl.values["trigger"] != triggerPush {
+			t.Errorf("Expected the same commit to be built by the writer, got %v", fbl.values)
+		}
+		if len(fbl.commits) != 1 || fbl.commits[0].Message != "Add widgets" || fbl.commits[0].Id != 0 {
+			t.Errorf("Expected copies of the commits, got %+v", fbl.commits)
+		}
+	})
+}
+
+func TestCancelBuildHandlerNeedsWriteAccess(t *testing.T) {
+	resetMemoryDatabase()
+	defer cleanDataDirectory()
+	build, members := createOrganizationBuild()
+
+	w := httptest.NewRecorder()
+	cancelBuildHandler(w, buildRequest("/build/1/cancel", build, members[roleRead]))
+	if w.Code != 403 {
+		t.Errorf("Expected readers to be forbidden, got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	cancelBuildHandler(w, buildRequest("/build/1/cancel", build, members[roleWrite]))
+	if w.Code != 302 {
+		t.Fatalf("Expected writers to cancel builds, got %v", w.Code)
+	}
+	builds, _ := database.AllBuilds(context.Background(), members[roleWrite])
+	if !builds[0].Complete || builds[0].Success {
+		t.Errorf("Expected a build that wasn't running to be failed, got %+v", builds[0])
+	}
+
+	w = httptest.NewRecorder()
+	cancelBuildHandler(w, buildRequest("/build/1/cancel", builds[0], members[roleWrite]))
+	if w.Code != 400 {
+		t.Errorf("Expected finished builds to not be cancelled, got %v", w.Code)
+	}
+}
+
+func TestRepositorySettingsHandlerNeedsAdminAccess(t *testing.T) {
+	resetMemoryDatabase()
+	_, members := createOrganizationBuild()
+	query := "?" + url.Values{":owner": {"acme"}, ":repo": {"widgets"}}.Encode()
+
+	for role, code := range map[Role]int{roleRead: 403, roleWrite: 403, roleAdmin: 302} {
+		w := httptest.NewRecorder()
+		r := postForm(loginRequest("POST", "/acme/widgets/settings"+query, members[role]), url.Values{"public": {"true"}})
+		repositorySettingsHandler(w, r)
+		if w.Code != code {
+			t.Errorf("Expected %v to get %v, got %v", role, code, w.Code)
+		}
+	}
+	if repository, _ := database.FindRepository(context.Background(), "github", "acme", "widgets"); !repository.Public {
+		t.Errorf("Expected the admin to make the repository public")
+	}
+}
+
+func TestAddRepositoryHandlerOnlyLetsAdminsAddToOrganizations(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	_, members := createOrganizationBuild()
+
+	for role, code := range map[Role]int{roleWrite: 403, roleAdmin: 302} {
+		w := httptest.NewRecorder()
+		r := postForm(loginRequest("POST", "/repository", members[role]), url.Values{
+			"owner":        {"acme"},
+			"repository":   {"gadgets-" + string(role)},
+			"organization": {"acme"},
+		})
+		addRepositoryHandler(w, r)
+		if w.Code != code {
+			t.Errorf("Expected %v to get %v, got %v", role, code, w.Code)
+		}
+	}
+	if repository, _ := database.FindRepository(context.Background(), "github", "acme", "gadgets-write"); repository != nil {
+		t.Errorf("Expected the writer's repository to not be added")
 	}
-	if w.Header()["Set-Cookie"][1] != "token=tokennn" {
-		t.Errorf("Cookie token wasn't set properly")
+	repository, _ := database.FindRepository(context.Background(), "github", "acme", "gadgets-admin")
+	if repository == nil || repository.OrganizationId == 0 {
+		t.Errorf("Expected the admin's repository to belong to the organization, got %+v", repository)
 	}
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type junitSuite struct {
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure"`
+	Error     *junitFailure `xml:"error"`
+	Skipped   *junitFailure `xml:"skipped"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// text is the failure message followed by the details, which usually hold
+// a stack trace.
+func (f *junitFailure) text() string {
+	message := strings.TrimSpace(f.Message)
+	body := strings.TrimSpace(f.Body)
+	switch {
+	case body == "":
+		return message
+	case message == "" || strings.Contains(body, message):
+		return body
+	}
+	return message + "\n" + body
+}
+
+// parseJUnit reads a JUnit XML report. Reports can either have a
+// testsuites or a testsuite root element, and suites can be nested.
+func parseJUnit(report io.Reader) ([]TestResult, error) {
+	var root struct {
+		junitSuite
+		XMLName xml.Name
+	}
+	if err := xml.NewDecoder(report).Decode(&root); err != nil {
+		return nil, err
+	}
+
+	var results []TestResult
+	var walk func(suite junitSuite)
+	walk = func(suite junitSuite) {
+		for _, c := range suite.Cases {
+			results = append(results, c.result())
+		}
+		for _, child := range suite.Suites {
+			walk(child)
+		}
+	}
+	walk(root.junitSuite)
+	return results, nil
+}
+
+func (c junitCase) result() TestResult {
+	seconds, _ := strconv.ParseFloat(strings.Replace(c.Time, ",", "", -1), 64)
+	result := TestResult{
+		ClassName: c.ClassName,
+		Name:      c.Name,
+		Duration:  time.Duration(seconds * float64(time.Second)),
+		Status:    testPassed,
+	}
+
+	switch {
+	case c.Failure != nil:
+		result.Status = testFailed
+		result.Message = c.Failure.text()
+	case c.Error != nil:
+		result.Status = testFailed
+		result.Message = c.Error.text()
+	case c.Skipped != nil:
+		result.Status = testSkipped
+		result.Message = c.Skipped.text()
+	}
+	return result
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseJUnitFixture(t *testing.T, path string) []TestResult {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	results, err := parseJUnit(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestParseJUnitReadsATestsuite(t *testing.T) {
+	results := parseJUnitFixture(t, "test-data/junit/rspec.xml")
+
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 results, got %+v", results)
+	}
+
+	failure := results[0]
+	if failure.ClassName != "spec.build_spec" ||
+		failure.Name != "Build passes when the Builderfile exits cleanly" ||
+		failure.Status != testFailed ||
+		failure.Duration != 12034*time.Microsecond {
+		t.Errorf("Expected the failing test to be read, got %+v", failure)
+	}
+	if !strings.HasPrefix(failure.Message, "expected: \"pass\"") ||
+		!strings.Contains(failure.Message, "./spec/build_spec.rb:12") {
+		t.Errorf("Expected the failure message to hold the details, got %q", failure.Message)
+	}
+
+	statuses := []string{results[1].Status, results[2].Status, results[3].Status}
+	if expected := []string{testPassed, testSkipped, testPassed}; !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("Expected statuses %v, got %v", expected, statuses)
+	}
+}
+
+func TestParseJUnitReadsNestedTestsuites(t *testing.T) {
+	results := parseJUnitFixture(t, "test-data/junit/surefire.xml")
+
+	var names []string
+	for _, result := range results {
+		names = append(names, result.Name)
+	}
+	expected := []string{"startsBuild", "connectsToDatabase", "nestedSuitesAreRead"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Expected tests %v, got %v", expected, names)
+	}
+
+	if results[0].Duration != 1250100*time.Millisecond {
+		t.Errorf("Expected times with thousands separators to be read, got %v", results[0].Duration)
+	}
+	errored := results[1]
+	if errored.Status != testFailed || !strings.HasPrefix(errored.Message, "java.net.ConnectException: Connection refused\n") {
+		t.Errorf("Expected errors to be failures, got %+v", errored)
+	}
+}
+
+func TestParseJUnitRejectsInvalidReports(t *testing.T) {
+	if _, err := parseJUnit(strings.NewReader("<testsuite><testcase")); err == nil {
+		t.Errorf("Expected an error for an invalid report")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+)
+
+// logCursor records how far through a log renderLog got, along with the
+// renderer state at that point, so the next chunk of the log can be
+// rendered as if the whole log had been rendered in one go.
+type logCursor struct {
+	Offset   int64      `json:"offset"`
+	Style    []int      `json:"style,omitempty"`
+	Folds    []openFold `json:"folds,omitempty"`
+	LastFold int        `json:"last_fold,omitempty"`
+}
+
+func (c logCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// parseLogCursor reads a cursor returned by String. An empty string is the
+// start of the log.
+func parseLogCursor(s string) (logCursor, error) {
+	var cursor logCursor
+	if s == "" {
+		return cursor, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(b, &cursor)
+	return cursor, err
+}
+
+// renderLog renders chunk, the log starting at cursor.Offset, and returns
+// the html along with the cursor to render the rest of the log from.
+//
+// While the log is still being written the last line might not be finished,
+// and could end half way through a character or an escape sequence, so only
+// complete lines are rendered. Once the log is complete everything is.
+func renderLog(chunk []byte, cursor logCursor, complete bool) (string, logCursor) {
+	if !complete {
+		chunk = chunk[:bytes.LastIndexByte(chunk, '\n')+1]
+	}
+	if len(chunk) == 0 {
+		return "", cursor
+	}
+
+	r := &ansiRenderer{
+		folds:    append([]openFold(nil), cursor.Folds...),
+		lastFold: cursor.LastFold,
+	}
+	r.style.apply(cursor.Style)
+	r.write(string(chunk))
+	if chunk[len(chunk)-1] != '\n' {
+		r.endLine()
+	}
+
+	return r.output.String(), logCursor{
+		Offset:   cursor.Offset + int64(len(chunk)),
+		Style:    r.style.parameters(),
+		Folds:    r.folds,
+		LastFold: r.lastFold,
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderLogResumesFromEveryOffset(t *testing.T) {
+	logs, _ := filepath.Glob("test-data/logs/*.log")
+	if len(logs) == 0 {
+		t.Fatal("Expected some logs in test-data/logs")
+	}
+
+	for _, path := range logs {
+		log, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		whole, _ := renderLog(log, logCursor{}, true)
+
+		for split := 0; split <= len(log); split++ {
+			first, cursor := renderLog(log[:split], logCursor{}, false)
+			cursor, err := parseLogCursor(cursor.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			rest, _ := renderLog(log[cursor.Offset:], cursor, true)
+
+			if first+rest != whole {
+				t.Errorf("%v: rendering in two chunks split at byte %d doesn't match rendering it in one.\nExpected:\n%v\nGot:\n%v\n", path, split, whole, first+rest)
+				break
+			}
+		}
+	}
+}
+
+func TestRenderLogOnlyRendersCompleteLinesUntilTheLogIsComplete(t *testing.T) {
+	log := []byte("one\n\x1b[31mtwo\nthr")
+
+	html, cursor := renderLog(log, logCursor{}, false)
+	expected := `<div class="line">one</div><div class="line"><span class="red">two</span></div>`
+	if html != expected {
+		t.Errorf("\nExpected:\n%v\nGot:\n%v\n", expected, html)
+	}
+	if cursor.Offset != int64(strings.Index(string(log), "thr")) {
+		t.Errorf("Expected the cursor to point at the unfinished line, got %d", cursor.Offset)
+	}
+
+	html, _ = renderLog(log[cursor.Offset:], cursor, false)
+	if html != "" {
+		t.Errorf("Expected nothing to be rendered until the line is finished, got %v", html)
+	}
+
+	html, _ = renderLog(log[cursor.Offset:], cursor, true)
+	expected = `<div class="line"><span class="red">thr</span></div>`
+	if html != expected {
+		t.Errorf("\nExpected:\n%v\nGot:\n%v\n", expected, html)
+	}
+}
+
+func TestParseLogCursor(t *testing.T) {
+	cursor, err := parseLogCursor("")
+	if err != nil || cursor.Offset != 0 || cursor.Style != nil {
+		t.Errorf("Expected an empty cursor to be the start of the log, got %v, %v", cursor, err)
+	}
+
+	if _, err := parseLogCursor("not a cursor"); err == nil {
+		t.Errorf("Expected an invalid cursor to be an error")
+	}
+}
+
+func TestRenderLogTurnsFoldMarkersIntoSections(t *testing.T) {
+	log, _ := ioutil.ReadFile("test-data/logs/folds.log")
+
+	html, cursor := renderLog(log, logCursor{}, true)
+	expected := `<div class="line">$ ./Builderfile</div>` +
+		`<div class="line fold-start" data-fold-start="1">dependencies</div>` +
+		`<div class="line" data-fold="1">Fetching gem metadata from https://rubygems.org/<span class="green">.</span></div>` +
+		`<div class="line fold-start" data-fold="1" data-fold-start="2">bundler</div>` +
+		`<div class="line" data-fold="1 2"><span class="yellow">Installing rake 13.0.6</span></div>` +
+		`<div class="line fold-end" data-fold="1 2" data-fold-end="2" data-duration="1s"></div>` +
+		`<div class="line" data-fold="1">Bundle complete! 12 Gemfile dependencies, 40 gems now installed.</div>` +
+		`<div class="line fold-end" data-fold="1" data-fold-end="1" data-duration="12.3s"></div>` +
+		`<div class="line fold-start" data-fold-start="3">tests</div>` +
+		`<div class="line" data-fold="3"><span class="red">F</span></div>` +
+		`<div class="line" data-fold="3"><span class="red">1 example, 1 failure</span></div>`
+	if html != expected {
+		t.Errorf("\nExpected:\n%v\nGot:\n%v\n", expected, html)
+	}
+
+	if len(cursor.Folds) != 1 || cursor.Folds[0].Name != "tests" {
+		t.Errorf("Expected the tests fold to still be open, got %+v", cursor.Folds)
+	}
+}
+
+func TestRenderLogIgnoresUnmatchedFoldEnds(t *testing.T) {
+	html, _ := renderLog([]byte("builder_fold:end:tests\n"), logCursor{}, true)
+	expected := `<div class="line">builder_fold:end:tests</div>`
+	if html != expected {
+		t.Errorf("\nExpected:\n%v\nGot:\n%v\n", expected, html)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// LogStore keeps build output. A build writes to the log returned by Create
+// while it runs, and Complete is called once the build has finished, after
+// which the log won't change again.
+type LogStore interface {
+	Create(build *Build) (io.WriteCloser, error)
+	Complete(build *Build) error
+	// ReadFrom returns the log starting at offset bytes into it.
+	ReadFrom(build *Build, offset int64) ([]byte, error)
+	Delete(build *Build) error
+}
+
+func openLogStore(c Configuration) (LogStore, error) {
+	switch c.LogStore {
+	case "file":
+		return &FileLogStore{}, nil
+	case "s3":
+		return &S3LogStore{
+			Endpoint:        c.S3Endpoint,
+			Bucket:          c.S3Bucket,
+			Region:          c.S3Region,
+			AccessKeyId:     c.S3AccessKeyId,
+			SecretAccessKey: c.S3SecretAccessKey,
+			Prefix:          c.S3Prefix,
+		}, nil
+	}
+	return nil, fmt.Errorf("Unknown log store %q", c.LogStore)
+}
+
+// FileLogStore keeps logs next to the build. Completed logs are gzipped.
+type FileLogStore struct{}
+
+func (f *FileLogStore) compressedPath(build *Build) string {
+	return build.LogPath() + ".gz"
+}
+
+func (f *FileLogStore) Create(build *Build) (io.WriteCloser, error) {
+	err := os.MkdirAll(build.Path(), 0700)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(build.LogPath())
+}
+
+func (f *FileLogStore) Complete(build *Build) error {
+	err := compressFile(build.LogPath(), f.compressedPath(build))
+	if err != nil {
+		return err
+	}
+	return os.Remove(build.LogPath())
+}
+
+func (f *FileLogStore) ReadFrom(build *Build, offset int64) ([]byte, error) {
+	file, err := os.Open(build.LogPath())
+	if err == nil {
+		defer file.Close()
+		_, err = file.Seek(offset, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(file)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	compressed, err := os.Open(f.compressedPath(build))
+	if err != nil {
+		return nil, err
+	}
+	defer compressed.Close()
+	return readCompressedFrom(compressed, offset)
+}
+
+func (f *FileLogStore) Delete(build *Build) error {
+	for _, path := range []string{build.LogPath(), f.compressedPath(build)} {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func compressFile(source string, destination string) error {
+	input, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	output, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+
+	writer := gzip.NewWriter(output)
+	_, err = io.Copy(writer, input)
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(destination)
+	}
+	return err
+}
+
+func readCompressedFrom(compressed io.Reader, offset int64) ([]byte, error) {
+	reader, err := gzip.NewReader(compressed)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	_, err = io.CopyN(ioutil.Discard, reader, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func testLogStore(t *testing.T, store LogStore) {
+	build := &Build{Id: 7}
+
+	output, err := store.Create(build)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output.Write([]byte("hello "))
+
+	if b, _ := store.ReadFrom(build, 0); string(b) != "hello " {
+		t.Errorf("Expected to read the running log, got %q", string(b))
+	}
+
+	output.Write([]byte("world"))
+	output.Close()
+
+	if b, _ := store.ReadFrom(build, 6); string(b) != "world" {
+		t.Errorf("Expected to read the running log from an offset, got %q", string(b))
+	}
+
+	if err := store.Complete(build); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(build.LogPath()); !os.IsNotExist(err) {
+		t.Errorf("Expected the uncompressed log to be removed")
+	}
+
+	if b, _ := store.ReadFrom(build, 0); string(b) != "hello world" {
+		t.Errorf("Expected to read the completed log, got %q", string(b))
+	}
+	if b, _ := store.ReadFrom(build, 6); string(b) != "world" {
+		t.Errorf("Expected to read the completed log from an offset, got %q", string(b))
+	}
+	if b, _ := store.ReadFrom(build, 100); len(b) != 0 {
+		t.Errorf("Expected nothing past the end of the log, got %q", string(b))
+	}
+
+	if err := store.Delete(build); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadFrom(build, 0); !os.IsNotExist(err) {
+		t.Errorf("Expected the log to be deleted, got %v", err)
+	}
+}
+
+func TestFileLogStore(t *testing.T) {
+	defer cleanDataDirectory()
+
+	store := &FileLogStore{}
+	testLogStore(t, store)
+}
+
+func TestFileLogStoreGzipsCompletedLogs(t *testing.T) {
+	defer cleanDataDirectory()
+
+	store := &FileLogStore{}
+	build := &Build{Id: 8}
+	output, _ := store.Create(build)
+	output.Write([]byte("some output"))
+	output.Close()
+	store.Complete(build)
+
+	if _, err := os.Stat(build.LogPath() + ".gz"); err != nil {
+		t.Errorf("Expected a gzipped log, got %v", err)
+	}
+}
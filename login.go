@@ -1,7 +1,155 @@
 package main
 
-type Login struct {
-	Id        int
-	AccountId int
-	Token     string
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/hoisie/mustache"
+	"net/http"
+	"strings"
+)
+
+// oauthStateCookie holds the state sent to the source host while logging
+// in, along with the page to go back to afterwards.
+const oauthStateCookie = "oauth_state"
+
+// safeReturnTo only allows returning to pages on builder, so a login link
+// can't be used to send people somewhere else.
+func safeReturnTo(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+		return "/"
+	}
+	return returnTo
+}
+
+// authorizeHandler starts logging in with a source host. The state sent to
+// the host is also kept in a cookie, so the callback can check that it was
+// this browser that started the login.
+func authorizeHandler(hostName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, err := randomToken()
+		if err != nil {
+			fmt.Println("Error creating oauth state:", err)
+			loginError(w, r, 500, "Something went wrong starting to log in. Try again.")
+			return
+		}
+		returnTo := safeReturnTo(r.URL.Query().Get("return_to"))
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     oauthStateCookie,
+			Value:    state + "." + base64.RawURLEncoding.EncodeToString([]byte(returnTo)),
+			Path:     "/",
+			MaxAge:   10 * 60,
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(configuration.Host, "https://"),
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		private := r.URL.Query().Get("scope") == "repo"
+		http.Redirect(w, r, sourceHost(hostName).AuthorizeUrl(state, private), 302)
+	}
+}
+
+var githubAuthorizeHandler = authorizeHandler("github")
+var gitlabAuthorizeHandler = authorizeHandler("gitlab")
+var giteaAuthorizeHandler = authorizeHandler("gitea")
+
+// oauthState reads the state and return page that authorizeHandler
+// left in the state cookie.
+func oauthState(r *http.Request) (string, string, bool) {
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", false
+	}
+	returnTo, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", "", false
+	}
+	return parts[0], safeReturnTo(string(returnTo)), true
+}
+
+// callbackHandler finishes logging in with a source host, creating the
+// account the first time someone logs in.
+func callbackHandler(hostName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := sourceHost(hostName)
+		http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Value: "", Path: "/", MaxAge: -1})
+
+		state, returnTo, ok := oauthState(r)
+		query := r.URL.Query()
+		if !ok || subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state)) != 1 {
+			loginError(w, r, 400, "This login link has expired or wasn't started by this browser. Try logging in again.")
+			return
+		}
+		if query.Get("error") != "" {
+			message := host.Name() + " didn't log you in."
+			if description := query.Get("error_description"); description != "" {
+				message = host.Name() + " didn't log you in: " + description
+			}
+			loginError(w, r, 403, message)
+			return
+		}
+
+		accessToken, err := host.GetAccessToken(query.Get("code"))
+		if err != nil {
+			fmt.Println("Error getting access token:", err)
+			loginError(w, r, 502, host.Name()+" didn't give builder access to your account. Try logging in again.")
+			return
+		}
+
+		user, err := host.GetUser(accessToken)
+		if err != nil {
+			fmt.Println("Error getting user:", err)
+			loginError(w, r, 502, "Builder couldn't find out who you are on "+host.Name()+". Try logging in again.")
+			return
+		}
+
+		encryptedToken, err := encryptToken(accessToken)
+		if err != nil {
+			fmt.Println("Error encrypting access token:", err)
+			loginError(w, r, 500, "Builder couldn't save your account. Try logging in again.")
+			return
+		}
+
+		account := &Account{
+			HostUserId:  user.Id,
+			AccessToken: encryptedToken,
+			Login:       user.Login,
+			Name:        user.Name,
+			Email:       user.Email,
+			AvatarUrl:   user.AvatarUrl,
+			Host:        hostName,
+		}
+
+		err = database.CreateAccount(r.Context(), account)
+		if err != nil {
+			fmt.Println("Error saving account:", err)
+			loginError(w, r, 500, "Builder couldn't save your account. Try logging in again.")
+			return
+		}
+
+		err = logIn(w, r, account)
+		if err != nil {
+			fmt.Println("Error logging in:", err)
+			loginError(w, r, 500, "Builder couldn't log you in. Try logging in again.")
+			return
+		}
+		http.Redirect(w, r, returnTo, 302)
+	}
+}
+
+var githubLoginHandler = callbackHandler("github")
+var gitlabLoginHandler = callbackHandler("gitlab")
+var giteaLoginHandler = callbackHandler("gitea")
+
+func loginError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	context := defaultViewContext(r)
+	context["message"] = message
+	body := mustache.RenderFileInLayout("views/login_error.mustache", "views/layout.mustache", context)
+	w.WriteHeader(status)
+	w.Write([]byte(body))
 }
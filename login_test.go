@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// startGithubLogin goes through githubAuthorizeHandler and returns the
+// state cookie it set along with the url Github was sent to.
+func startGithubLogin(t *testing.T, path string) (*http.Cookie, *url.URL) {
+	r, _ := http.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	githubAuthorizeHandler(w, r)
+
+	if w.Code != 302 {
+		t.Fatalf("Expected a redirect to Github, got %v", w.Code)
+	}
+	location, _ := url.Parse(w.Header().Get("Location"))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oauthStateCookie {
+		t.Fatalf("Expected a state cookie to be set, got %v", w.Header()["Set-Cookie"])
+	}
+	return cookies[0], location
+}
+
+// githubCallback is Github sending the browser back to builder after a login
+// started with startGithubLogin.
+func githubCallback(t *testing.T, returnTo string, query url.Values) *httptest.ResponseRecorder {
+	cookie, location := startGithubLogin(t, "/login/github?return_to="+url.QueryEscape(returnTo))
+	if query.Get("state") == "" {
+		query.Set("state", location.Query().Get("state"))
+	}
+
+	r, _ := http.NewRequest("GET", "http://bla.com/github_callback?"+query.Encode(), nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	githubLoginHandler(w, r)
+	return w
+}
+
+func TestGithubAuthorizeHandlerSendsState(t *testing.T) {
+	configuration.GithubClientID = "client-id"
+	defer func() { configuration.GithubClientID = "" }()
+
+	cookie, location := startGithubLogin(t, "/login/github?scope=repo&return_to=%2Fsettings")
+
+	if location.Host != "github.com" || location.Path != "/login/oauth/authorize" {
+		t.Errorf("Expected to be sent to Github, got %v", location)
+	}
+	query := location.Query()
+	if query.Get("client_id") != "client-id" || query.Get("scope") != "repo" {
+		t.Errorf("Expected the client id and scope to be sent, got %v", query)
+	}
+	if query.Get("state") == "" {
+		t.Fatalf("Expected a state to be sent")
+	}
+	state, returnTo, ok := oauthState(&http.Request{Header: http.Header{"Cookie": {cookie.String()}}})
+	if !ok || state != query.Get("state") || returnTo != "/settings" {
+		t.Errorf("Expected the cookie to hold the state and return page, got %q %q", state, returnTo)
+	}
+	if !cookie.HttpOnly || cookie.MaxAge <= 0 {
+		t.Errorf("Expected a short lived HttpOnly cookie, got %v", cookie)
+	}
+}
+
+func TestGithubAuthorizeHandlerSendsPeopleToTheConfiguredGithub(t *testing.T) {
+	configuration.GithubWebUrl = "https://github.example.com"
+	defer func() { configuration.GithubWebUrl = "https://github.com" }()
+
+	_, location := startGithubLogin(t, "/login/github")
+
+	if location.Host != "github.example.com" || location.Path != "/login/oauth/authorize" {
+		t.Errorf("Expected to be sent to Github Enterprise, got %v", location)
+	}
+}
+
+func TestGithubAuthorizeHandlerOnlyAsksForTheRepoScope(t *testing.T) {
+	_, location := startGithubLogin(t, "/login/github?scope=admin:org")
+	if scope := location.Query().Get("scope"); scope != "" {
+		t.Errorf("Expected no scope to be asked for, got %q", scope)
+	}
+}
+
+func TestSafeReturnTo(t *testing.T) {
+	tests := map[string]string{
+		"":                     "/",
+		"/":                    "/",
+		"/settings":            "/settings",
+		"/?branch=master":      "/?branch=master",
+		"http://evil.com":      "/",
+		"//evil.com":           "/",
+		"/\\evil.com":          "/",
+		"javascript:alert(1)":  "/",
+		"AndrewVos/builder":    "/",
+		"/AndrewVos/builder#x": "/AndrewVos/builder#x",
+	}
+	for returnTo, expected := range tests {
+		if actual := safeReturnTo(returnTo); actual != expected {
+			t.Errorf("Expected %q to return to %q, got %q", returnTo, expected, actual)
+		}
+	}
+}
+
+// findUsersAccount finds the account of the user with the id on a source
+// host.
+func findUsersAccount(host string, userId int) *Account {
+	accounts, _ := database.AllAccounts(context.Background())
+	for _, account := range accounts {
+		if account.Host == host && account.HostUserId == userId {
+			return account
+		}
+	}
+	return nil
+}
+
+func TestGithubLoginHandlerCreatesNewAccount(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	fakeGit.AccessTokenToReturn = "some-access-token-123"
+	fakeGit.UserToReturn = HostUser{
+		Id:        56733,
+		Login:     "octocat",
+		Name:      "The Octocat",
+		Email:     "octocat@github.com",
+		AvatarUrl: "https://avatars.githubusercontent.com/u/583231",
+	}
+
+	githubCallback(t, "/", url.Values{"code": {"QUERY_CODE"}})
+
+	account := findUsersAccount("github", 56733)
+	if account == nil {
+		t.Fatal("Expected an account to be created with the Github user ID")
+	}
+	if account.AccessToken != "some-access-token-123" {
+		t.Fatalf("Access Token wasn't stored")
+	}
+	if account.Login != "octocat" || account.Name != "The Octocat" ||
+		account.Email != "octocat@github.com" || account.AvatarUrl != "https://avatars.githubusercontent.com/u/583231" {
+		t.Errorf("Expected the Github profile to be stored, got %+v", account)
+	}
+}
+
+func TestGithubLoginHandlerEncryptsTheAccessToken(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	fakeGit.AccessTokenToReturn = "some-access-token-123"
+	fakeGit.UserToReturn = HostUser{Id: 56733, Login: "octocat"}
+
+	withTokenKeys(testTokenKey, nil, func() {
+		githubCallback(t, "/", url.Values{"code": {"QUERY_CODE"}})
+
+		account := findUsersAccount("github", 56733)
+		if account.AccessToken == "some-access-token-123" {
+			t.Errorf("Expected the access token to be encrypted")
+		}
+		if token, _ := account.HostToken(); token != "some-access-token-123" {
+			t.Errorf("Expected the access token to decrypt, got %q", token)
+		}
+	})
+}
+
+func TestGithubLoginHandlerRefreshesTheAccessTokenOfReturningUsers(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	database.CreateAccount(context.Background(), &Account{Id: 56733, HostUserId: 56733, AccessToken: "old-token"})
+	fakeGit.AccessTokenToReturn = "new-token"
+	fakeGit.UserToReturn = HostUser{Id: 56733, Login: "octocat"}
+
+	w := githubCallback(t, "/", url.Values{"code": {"QUERY_CODE"}})
+
+	if w.Code != 302 {
+		t.Errorf("Expected a returning user to be logged in, got %v", w.Code)
+	}
+	if account := findUsersAccount("github", 56733); account.AccessToken != "new-token" {
+		t.Errorf("Expected the access token to be refreshed, got %q", account.AccessToken)
+	}
+}
+
+func TestGithubLoginHandlerSetsSessionCookie(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	fakeGit.AccessTokenToReturn = "some-access-token-123"
+	fakeGit.UserToReturn = HostUser{Id: 56733, Login: "octocat"}
+
+	w := githubCallback(t, "/", url.Values{"code": {"QUERY_CODE"}})
+
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatalf("Expected a session cookie to be set, got %v", w.Header()["Set-Cookie"])
+	}
+	if !cookie.HttpOnly || cookie.Expires.IsZero() {
+		t.Errorf("Expected an HttpOnly cookie that expires, got %v", w.Header()["Set-Cookie"])
+	}
+	id, ok := verifySessionCookie(cookie.Value)
+	if !ok {
+		t.Fatalf("Expected the session cookie to be signed, got %v", cookie.Value)
+	}
+	if session, _ := database.FindSession(context.Background(), id); session == nil || session.AccountId != findUsersAccount("github", 56733).Id {
+		t.Errorf("Expected the cookie to be for a session of the account, got %+v", session)
+	}
+}
+
+func TestGithubLoginHandlerReturnsToThePageTheUserCameFrom(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	fakeGit.UserToReturn = HostUser{Id: 56733, Login: "octocat"}
+
+	w := githubCallback(t, "/AndrewVos/builder?branch=master", url.Values{"code": {"QUERY_CODE"}})
+	if location := w.Header().Get("Location"); location != "/AndrewVos/builder?branch=master" {
+		t.Errorf("Expected to return to the repository page, got %q", location)
+	}
+
+	w = githubCallback(t, "//evil.com", url.Values{"code": {"QUERY_CODE"}})
+	if location := w.Header().Get("Location"); location != "/" {
+		t.Errorf("Expected to return home instead of another site, got %q", location)
+	}
+}
+
+func assertLoginFailed(t *testing.T, w *httptest.ResponseRecorder, code int) {
+	if w.Code != code {
+		t.Errorf("Expected the login to fail with %v, got %v", code, w.Code)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == sessionCookie {
+			t.Errorf("Expected no session to be created, got %v", cookie)
+		}
+	}
+	if len(memoryDatabase.sessions) != 0 {
+		t.Errorf("Expected no session to be saved, got %v", memoryDatabase.sessions)
+	}
+}
+
+func TestGithubLoginHandlerRejectsAMissingState(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	fakeGit.UserToReturn = HostUser{Id: 56733, Login: "octocat"}
+
+	r, _ := http.NewRequest("GET", "http://bla.com/github_callback?code=QUERY_CODE&state=abc", nil)
+	w := httptest.NewRecorder()
+	githubLoginHandler(w, r)
+
+	assertLoginFailed(t, w, 400)
+}
+
+func TestGithubLoginHandlerRejectsTheWrongState(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	fakeGit.UserToReturn = HostUser{Id: 56733, Login: "octocat"}
+
+	w := githubCallback(t, "/", url.Values{"code": {"QUERY_CODE"}, "state": {"someone-elses-state"}})
+
+	assertLoginFailed(t, w, 400)
+	if account := findUsersAccount("github", 56733); account != nil {
+		t.Errorf("Expected no account to be created")
+	}
+}
+
+func TestGithubLoginHandlerShowsGithubErrors(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+
+	w := githubCallback(t, "/", url.Values{
+		"error":             {"access_denied"},
+		"error_description": {"The user has denied your application access."},
+	})
+
+	assertLoginFailed(t, w, 403)
+}
+
+func TestGithubLoginHandlerFailsWhenTheAccessTokenCantBeRetrieved(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	fakeGit.AccessTokenError = errors.New("bad_verification_code")
+
+	w := githubCallback(t, "/", url.Values{"code": {"QUERY_CODE"}})
+
+	assertLoginFailed(t, w, 502)
+}
+
+func TestGithubLoginHandlerFailsWhenTheUserCantBeRetrieved(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	fakeGit.UserError = errors.New("Bad credentials")
+
+	w := githubCallback(t, "/", url.Values{"code": {"QUERY_CODE"}})
+
+	assertLoginFailed(t, w, 502)
+}
+
+type failingAccountsDatabase struct {
+	*MemoryDatabase
+}
+
+func (d failingAccountsDatabase) CreateAccount(ctx context.Context, account *Account) error {
+	return errors.New("database is down")
+}
+
+func TestGithubLoginHandlerFailsWhenTheAccountCantBeSaved(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	fakeGit.UserToReturn = HostUser{Id: 56733, Login: "octocat"}
+	database = failingAccountsDatabase{memoryDatabase}
+	defer func() { database = memoryDatabase }()
+
+	w := githubCallback(t, "/", url.Values{"code": {"QUERY_CODE"}})
+
+	assertLoginFailed(t, w, 500)
+}
@@ -0,0 +1,898 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryDatabase keeps everything in memory. It is used by --dev and by the
+// tests, and behaves the same way as the sql backends.
+type MemoryDatabase struct {
+	mutex          sync.Mutex
+	accounts       map[int]Account
+	repositories   []Repository
+	builds         []Build
+	commits        []Commit
+	sessions       []Session
+	collaborations []collaboration
+	testResults    []TestResult
+	coverageFiles  []FileCoverage
+	organizations  []Organization
+	members        []organizationMember
+	teams          []Team
+	hooks          map[int][]Webhook
+	lastId         int
+}
+
+type collaboration struct {
+	HostUserId   int
+	RepositoryId int
+	Role         Role
+}
+
+type organizationMember struct {
+	OrganizationId int
+	AccountId      int
+	Role           Role
+}
+
+func NewMemoryDatabase() *MemoryDatabase {
+	return &MemoryDatabase{accounts: map[int]Account{}, hooks: map[int][]Webhook{}}
+}
+
+func (m *MemoryDatabase) nextId() int {
+	m.lastId++
+	return m.lastId
+}
+
+func (m *MemoryDatabase) AddRepositoryToAccount(ctx context.Context, account *Account, repository *Repository) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if repository.Host == "" {
+		repository.Host = account.Host
+	}
+	repository.Id = m.nextId()
+	repository.AccountId = account.Id
+	repository.Account = account
+	account.Repositories = append(account.Repositories, repository)
+
+	stored := *repository
+	stored.Account = nil
+	stored.Host = hostName(stored.Host)
+	m.repositories = append(m.repositories, stored)
+	return nil
+}
+
+func (m *MemoryDatabase) SaveCommit(ctx context.Context, commit *Commit) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.saveCommit(commit)
+	return nil
+}
+
+func (m *MemoryDatabase) saveCommit(commit *Commit) {
+	commit.Id = m.nextId()
+	m.commits = append(m.commits, *commit)
+}
+
+func (m *MemoryDatabase) SaveBuild(ctx context.Context, build *Build) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.saveBuild(build)
+	return nil
+}
+
+func (m *MemoryDatabase) saveBuild(build *Build) {
+	for i := range m.builds {
+		if m.builds[i].Id == build.Id {
+			stored := *build
+			stored.RepositoryId = m.builds[i].RepositoryId
+			stored.CreatedAt = m.builds[i].CreatedAt
+			stored.Commits = nil
+			stored.Tests = nil
+			stored.Coverage = nil
+			m.builds[i] = stored
+		}
+	}
+}
+
+func (m *MemoryDatabase) AllBuilds(ctx context.Context, account *Account) ([]*Build, error) {
+	if account == nil {
+		return []*Build{}, nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	visible := m.visibleRepositories(account)
+	return m.findBuilds(func(build Build) bool {
+		return visible[build.RepositoryId]
+	}), nil
+}
+
+func (m *MemoryDatabase) visibleRepositories(account *Account) map[int]bool {
+	visible := map[int]bool{}
+	for _, repository := range m.repositories {
+		if repository.AccountId == account.Id {
+			visible[repository.Id] = true
+		}
+	}
+	for _, repository := range m.repositories {
+		if m.repositoryRole(account.Id, repository) != roleNone {
+			visible[repository.Id] = true
+		}
+	}
+	return visible
+}
+
+func (m *MemoryDatabase) SearchBuilds(ctx context.Context, account *Account, filter BuildFilter) (*BuildPage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	visible := map[int]bool{}
+	if account != nil {
+		visible = m.visibleRepositories(account)
+	}
+	for _, repository := range m.repositories {
+		if repository.Public {
+			visible[repository.Id] = true
+		}
+	}
+	builds := m.findBuilds(func(build Build) bool {
+		if !visible[build.RepositoryId] {
+			return false
+		}
+		var messages []string
+		for _, commit := range m.commits {
+			if commit.BuildId == build.Id {
+				messages = append(messages, commit.Message)
+			}
+		}
+		return filter.matches(build, messages)
+	})
+
+	for i, j := 0, len(builds)-1; i < j; i, j = i+1, j-1 {
+		builds[i], builds[j] = builds[j], builds[i]
+	}
+	return filter.page(builds), nil
+}
+
+func (m *MemoryDatabase) FindBuild(ctx context.Context, id int) (*Build, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	builds := m.findBuilds(func(build Build) bool {
+		return build.Id == id
+	})
+	if len(builds) == 0 {
+		return nil, nil
+	}
+	return builds[0], nil
+}
+
+func (m *MemoryDatabase) CreateBuild(ctx context.Context, repository *Repository, build *Build) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	build.Id = m.nextId()
+	build.RepositoryId = repository.Id
+	build.Host = hostName(repository.Host)
+	build.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	build.Result = "incomplete"
+	build.Url = buildUrl(build)
+	m.builds = append(m.builds, Build{Id: build.Id, RepositoryId: repository.Id, CreatedAt: build.CreatedAt})
+	m.saveBuild(build)
+
+	for i := range build.Commits {
+		build.Commits[i].BuildId = build.Id
+		m.saveCommit(&build.Commits[i])
+	}
+	return nil
+}
+
+func (m *MemoryDatabase) DeleteRepository(ctx context.Context, repositoryId int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	deletedBuilds := map[int]bool{}
+	var builds []Build
+	for _, build := range m.builds {
+		if build.RepositoryId == repositoryId {
+			deletedBuilds[build.Id] = true
+		} else {
+			builds = append(builds, build)
+		}
+	}
+	m.builds = builds
+
+	var commits []Commit
+	for _, commit := range m.commits {
+		if !deletedBuilds[commit.BuildId] {
+			commits = append(commits, commit)
+		}
+	}
+	m.commits = commits
+	var testResults []TestResult
+	for _, result := range m.testResults {
+		if !deletedBuilds[result.BuildId] {
+			testResults = append(testResults, result)
+		}
+	}
+	m.testResults = testResults
+	var coverageFiles []FileCoverage
+	for _, file := range m.coverageFiles {
+		if !deletedBuilds[file.BuildId] {
+			coverageFiles = append(coverageFiles, file)
+		}
+	}
+	m.coverageFiles = coverageFiles
+
+	var collaborations []collaboration
+	for _, c := range m.collaborations {
+		if c.RepositoryId != repositoryId {
+			collaborations = append(collaborations, c)
+		}
+	}
+	m.collaborations = collaborations
+	for i := range m.teams {
+		var repositoryIds []int
+		for _, id := range m.teams[i].RepositoryIds {
+			if id != repositoryId {
+				repositoryIds = append(repositoryIds, id)
+			}
+		}
+		m.teams[i].RepositoryIds = repositoryIds
+	}
+	delete(m.hooks, repositoryId)
+
+	var repositories []Repository
+	for _, repository := range m.repositories {
+		if repository.Id != repositoryId {
+			repositories = append(repositories, repository)
+		}
+	}
+	m.repositories = repositories
+	return nil
+}
+
+func (m *MemoryDatabase) SaveRepositoryHooks(ctx context.Context, repositoryId int, hooks []Webhook) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.hooks[repositoryId] = append([]Webhook(nil), hooks...)
+	return nil
+}
+
+func (m *MemoryDatabase) RepositoryHooks(ctx context.Context, repositoryId int) ([]Webhook, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	hooks := append([]Webhook(nil), m.hooks[repositoryId]...)
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].Id < hooks[j].Id
+	})
+	return hooks, nil
+}
+
+func (m *MemoryDatabase) FindRepository(ctx context.Context, host string, owner string, name string) (*Repository, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, stored := range m.repositories {
+		if stored.Host == hostName(host) && stored.Owner == owner && stored.Repository == name {
+			repository := stored
+			repository.Account = m.findAccountById(repository.AccountId)
+			return &repository, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryDatabase) FindRepositoryById(ctx context.Context, id int) (*Repository, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, stored := range m.repositories {
+		if stored.Id == id {
+			repository := stored
+			repository.Account = m.findAccountById(repository.AccountId)
+			return &repository, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryDatabase) SetRepositoryPublic(ctx context.Context, repositoryId int, public bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range m.repositories {
+		if m.repositories[i].Id == repositoryId {
+			m.repositories[i].Public = public
+		}
+	}
+	return nil
+}
+
+func (m *MemoryDatabase) IncompleteBuilds(ctx context.Context) ([]*Build, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.findBuilds(func(build Build) bool {
+		return !build.Complete
+	}), nil
+}
+
+func (m *MemoryDatabase) AllRepositories(ctx context.Context) ([]*Repository, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	repositories := []*Repository{}
+	for _, stored := range m.repositories {
+		repository := stored
+		repositories = append(repositories, &repository)
+	}
+	return repositories, nil
+}
+
+func (m *MemoryDatabase) RepositoryBuilds(ctx context.Context, repository *Repository) ([]*Build, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.findBuilds(func(build Build) bool {
+		return build.RepositoryId == repository.Id
+	}), nil
+}
+
+func (m *MemoryDatabase) FindAccountById(ctx context.Context, id int) (*Account, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.findAccountById(id), nil
+}
+
+func (m *MemoryDatabase) findAccountById(id int) *Account {
+	stored, ok := m.accounts[id]
+	if !ok {
+		return nil
+	}
+
+	account := &Account{
+		Id:          stored.Id,
+		AccessToken: stored.AccessToken,
+		Login:       stored.Login,
+		Name:        stored.Name,
+		Email:       stored.Email,
+		AvatarUrl:   stored.AvatarUrl,
+		Host:        stored.Host,
+		HostUserId:  stored.HostUserId,
+	}
+	for _, r := range m.repositories {
+		if r.AccountId == account.Id {
+			repository := r
+			repository.Account = account
+			account.Repositories = append(account.Repositories, &repository)
+		}
+	}
+	return account
+}
+
+func (m *MemoryDatabase) CreateAccount(ctx context.Context, account *Account) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored := *account
+	stored.Repositories = nil
+	stored.Host = hostName(stored.Host)
+	if existing := m.findAccountByHostUser(stored.Host, stored.HostUserId); existing != nil {
+		stored.Id = existing.Id
+	}
+	for stored.Id == 0 {
+		stored.Id = m.nextId()
+		if _, used := m.accounts[stored.Id]; used {
+			stored.Id = 0
+		}
+	}
+	m.accounts[stored.Id] = stored
+	account.Id = stored.Id
+	return nil
+}
+
+func (m *MemoryDatabase) findAccountByHostUser(host string, hostUserId int) *Account {
+	for _, stored := range m.accounts {
+		if stored.Host == host && stored.HostUserId == hostUserId {
+			return m.findAccountById(stored.Id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryDatabase) FindAccountsByLogin(ctx context.Context, host string, logins []string) ([]*Account, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var accounts []*Account
+	for _, stored := range m.accounts {
+		if stored.Host != hostName(host) {
+			continue
+		}
+		for _, login := range logins {
+			if stored.Login != "" && strings.EqualFold(stored.Login, login) {
+				accounts = append(accounts, m.findAccountById(stored.Id))
+				break
+			}
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Id < accounts[j].Id
+	})
+	return accounts, nil
+}
+
+func (m *MemoryDatabase) AllAccounts(ctx context.Context) ([]*Account, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var accounts []*Account
+	for _, stored := range m.accounts {
+		account := stored
+		accounts = append(accounts, &account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Id < accounts[j].Id
+	})
+	return accounts, nil
+}
+
+func (m *MemoryDatabase) SetAccessToken(ctx context.Context, accountId int, accessToken string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if stored, ok := m.accounts[accountId]; ok {
+		stored.AccessToken = accessToken
+		m.accounts[accountId] = stored
+	}
+	return nil
+}
+
+func (m *MemoryDatabase) CreateSession(ctx context.Context, session *Session) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.sessions = append(m.sessions, *session)
+	return nil
+}
+
+func (m *MemoryDatabase) FindSession(ctx context.Context, id string) (*Session, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, stored := range m.sessions {
+		if stored.Id == id {
+			session := stored
+			return &session, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryDatabase) AccountSessions(ctx context.Context, accountId int, now time.Time) ([]*Session, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	sessions := []*Session{}
+	for _, stored := range m.sessions {
+		if stored.AccountId == accountId && !stored.expired(now) {
+			session := stored
+			sessions = append(sessions, &session)
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		if sessions[i].CreatedAt.Equal(sessions[j].CreatedAt) {
+			return sessions[i].Id < sessions[j].Id
+		}
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+func (m *MemoryDatabase) DeleteSession(ctx context.Context, id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.deleteSessions(func(session Session) bool {
+		return session.Id == id
+	})
+	return nil
+}
+
+func (m *MemoryDatabase) DeleteAccountSessions(ctx context.Context, accountId int, except string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.deleteSessions(func(session Session) bool {
+		return session.AccountId == accountId && session.Id != except
+	})
+	return nil
+}
+
+func (m *MemoryDatabase) DeleteExpiredSessions(ctx context.Context, now time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.deleteSessions(func(session Session) bool {
+		return session.expired(now)
+	})
+	return nil
+}
+
+func (m *MemoryDatabase) deleteSessions(remove func(session Session) bool) {
+	var kept []Session
+	for _, session := range m.sessions {
+		if !remove(session) {
+			kept = append(kept, session)
+		}
+	}
+	m.sessions = kept
+}
+
+func (m *MemoryDatabase) SaveCollaboration(ctx context.Context, hostUserId int, repositoryId int, role Role) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.collaborations = append(m.collaborations, collaboration{
+		HostUserId:   hostUserId,
+		RepositoryId: repositoryId,
+		Role:         role,
+	})
+	return nil
+}
+
+func (m *MemoryDatabase) ReplaceCollaborations(ctx context.Context, repositoryId int, roles map[int]Role) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var kept []collaboration
+	for _, c := range m.collaborations {
+		if c.RepositoryId != repositoryId {
+			kept = append(kept, c)
+		}
+	}
+	var hostUserIds []int
+	for hostUserId := range roles {
+		hostUserIds = append(hostUserIds, hostUserId)
+	}
+	sort.Ints(hostUserIds)
+	for _, hostUserId := range hostUserIds {
+		kept = append(kept, collaboration{
+			HostUserId:   hostUserId,
+			RepositoryId: repositoryId,
+			Role:         roles[hostUserId],
+		})
+	}
+	m.collaborations = kept
+	return nil
+}
+
+func (m *MemoryDatabase) RepositoryRole(ctx context.Context, accountId int, repositoryId int) (Role, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, repository := range m.repositories {
+		if repository.Id == repositoryId {
+			return m.repositoryRole(accountId, repository), nil
+		}
+	}
+	return roleNone, nil
+}
+
+func (m *MemoryDatabase) repositoryRole(accountId int, repository Repository) Role {
+	role := roleNone
+	if repository.AccountId == accountId {
+		role = roleAdmin
+	}
+	account, ok := m.accounts[accountId]
+	for _, c := range m.collaborations {
+		if ok && account.Host == repository.Host && c.HostUserId == account.HostUserId && c.RepositoryId == repository.Id {
+			role = highestRole(role, c.Role)
+		}
+	}
+	for _, member := range m.members {
+		if member.AccountId == accountId && repository.OrganizationId != 0 && member.OrganizationId == repository.OrganizationId {
+			role = highestRole(role, member.Role)
+		}
+	}
+	for _, team := range m.teams {
+		if containsId(team.MemberIds, accountId) && containsId(team.RepositoryIds, repository.Id) {
+			role = highestRole(role, team.Role)
+		}
+	}
+	return role
+}
+
+func containsId(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryDatabase) CreateOrganization(ctx context.Context, organization *Organization, admin *Account) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	organization.Id = m.nextId()
+	organization.Host = hostName(admin.Host)
+	m.organizations = append(m.organizations, *organization)
+	m.members = append(m.members, organizationMember{
+		OrganizationId: organization.Id,
+		AccountId:      admin.Id,
+		Role:           roleAdmin,
+	})
+	return nil
+}
+
+func (m *MemoryDatabase) FindOrganization(ctx context.Context, name string) (*Organization, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, organization := range m.organizations {
+		if strings.EqualFold(organization.Name, name) {
+			found := organization
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryDatabase) AccountOrganizations(ctx context.Context, accountId int) ([]*Organization, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var organizations []*Organization
+	for _, organization := range m.organizations {
+		for _, member := range m.members {
+			if member.OrganizationId == organization.Id && member.AccountId == accountId {
+				found := organization
+				organizations = append(organizations, &found)
+			}
+		}
+	}
+	sort.Slice(organizations, func(i, j int) bool {
+		return strings.ToLower(organizations[i].Name) < strings.ToLower(organizations[j].Name)
+	})
+	return organizations, nil
+}
+
+func (m *MemoryDatabase) OrganizationMembers(ctx context.Context, organizationId int) ([]*OrganizationMember, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var members []*OrganizationMember
+	for _, member := range m.members {
+		if member.OrganizationId != organizationId {
+			continue
+		}
+		account, ok := m.accounts[member.AccountId]
+		if !ok {
+			continue
+		}
+		account.Repositories = nil
+		members = append(members, &OrganizationMember{Account: &account, Role: member.Role})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		a, b := strings.ToLower(members[i].Account.Login), strings.ToLower(members[j].Account.Login)
+		if a != b {
+			return a < b
+		}
+		return members[i].Account.Id < members[j].Account.Id
+	})
+	return members, nil
+}
+
+func (m *MemoryDatabase) SaveOrganizationMember(ctx context.Context, organizationId int, accountId int, role Role) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range m.members {
+		if m.members[i].OrganizationId == organizationId && m.members[i].AccountId == accountId {
+			m.members[i].Role = role
+			return nil
+		}
+	}
+	m.members = append(m.members, organizationMember{
+		OrganizationId: organizationId,
+		AccountId:      accountId,
+		Role:           role,
+	})
+	return nil
+}
+
+func (m *MemoryDatabase) DeleteOrganizationMember(ctx context.Context, organizationId int, accountId int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var kept []organizationMember
+	for _, member := range m.members {
+		if member.OrganizationId != organizationId || member.AccountId != accountId {
+			kept = append(kept, member)
+		}
+	}
+	m.members = kept
+
+	for i := range m.teams {
+		if m.teams[i].OrganizationId != organizationId {
+			continue
+		}
+		var memberIds []int
+		for _, id := range m.teams[i].MemberIds {
+			if id != accountId {
+				memberIds = append(memberIds, id)
+			}
+		}
+		m.teams[i].MemberIds = memberIds
+	}
+	return nil
+}
+
+func (m *MemoryDatabase) CreateTeam(ctx context.Context, team *Team) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	team.Id = m.nextId()
+	stored := *team
+	stored.MemberIds = nil
+	stored.RepositoryIds = nil
+	m.teams = append(m.teams, stored)
+	return nil
+}
+
+func (m *MemoryDatabase) OrganizationTeams(ctx context.Context, organizationId int) ([]*Team, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var teams []*Team
+	for _, stored := range m.teams {
+		if stored.OrganizationId == organizationId {
+			team := stored
+			team.MemberIds = append([]int(nil), stored.MemberIds...)
+			team.RepositoryIds = append([]int(nil), stored.RepositoryIds...)
+			sort.Ints(team.MemberIds)
+			sort.Ints(team.RepositoryIds)
+			teams = append(teams, &team)
+		}
+	}
+	sort.Slice(teams, func(i, j int) bool {
+		return strings.ToLower(teams[i].Name) < strings.ToLower(teams[j].Name)
+	})
+	return teams, nil
+}
+
+func (m *MemoryDatabase) AddTeamMember(ctx context.Context, teamId int, accountId int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range m.teams {
+		if m.teams[i].Id == teamId && !containsId(m.teams[i].MemberIds, accountId) {
+			m.teams[i].MemberIds = append(m.teams[i].MemberIds, accountId)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryDatabase) AddTeamRepository(ctx context.Context, teamId int, repositoryId int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range m.teams {
+		if m.teams[i].Id == teamId && !containsId(m.teams[i].RepositoryIds, repositoryId) {
+			m.teams[i].RepositoryIds = append(m.teams[i].RepositoryIds, repositoryId)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryDatabase) SaveTestResults(ctx context.Context, build *Build, results []TestResult) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range results {
+		results[i].Id = m.nextId()
+		results[i].BuildId = build.Id
+		m.testResults = append(m.testResults, results[i])
+	}
+	return nil
+}
+
+func (m *MemoryDatabase) TestResults(ctx context.Context, builds []*Build) ([]TestResult, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	included := map[int]bool{}
+	for _, build := range builds {
+		included[build.Id] = true
+	}
+
+	results := []TestResult{}
+	for _, result := range m.testResults {
+		if included[result.BuildId] {
+			results = append(results, result)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].BuildId < results[j].BuildId
+	})
+	return results, nil
+}
+
+func (m *MemoryDatabase) SaveCoverage(ctx context.Context, build *Build, files []FileCoverage) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range files {
+		files[i].Id = m.nextId()
+		files[i].BuildId = build.Id
+		m.coverageFiles = append(m.coverageFiles, files[i])
+	}
+	return nil
+}
+
+func (m *MemoryDatabase) CoverageFiles(ctx context.Context, build *Build) ([]FileCoverage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.buildCoverageFiles(build.Id), nil
+}
+
+func (m *MemoryDatabase) buildCoverageFiles(buildId int) []FileCoverage {
+	files := []FileCoverage{}
+	for _, file := range m.coverageFiles {
+		if file.BuildId == buildId {
+			files = append(files, file)
+		}
+	}
+	sortCoverage(files)
+	return files
+}
+
+func (m *MemoryDatabase) Close() error {
+	return nil
+}
+
+func (m *MemoryDatabase) findBuilds(include func(build Build) bool) []*Build {
+	builds := []*Build{}
+	for _, stored := range m.builds {
+		if !include(stored) {
+			continue
+		}
+		build := stored
+		for _, repository := range m.repositories {
+			if repository.Id == build.RepositoryId {
+				build.Host = repository.Host
+			}
+		}
+		for _, commit := range m.commits {
+			if commit.BuildId == build.Id {
+				build.Commits = append(build.Commits, commit)
+			}
+		}
+		var results []TestResult
+		for _, result := range m.testResults {
+			if result.BuildId == build.Id {
+				results = append(results, result)
+			}
+		}
+		build.Tests = summariseTestResults(results)
+		build.Coverage = summariseCoverage(m.buildCoverageFiles(build.Id))
+		builds = append(builds, &build)
+	}
+	return builds
+}
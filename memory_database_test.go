@@ -0,0 +1,11 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMemoryDatabase(t *testing.T) {
+	testDatabaseContract(t, func(t *testing.T) Database {
+		return NewMemoryDatabase()
+	})
+}
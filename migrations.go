@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed db/migrations/*.sql db/sqlite/migrations/*.sql
+var migrationFiles embed.FS
+
+// Migrations are goose files. Applied versions are recorded in the same
+// goose_db_version table goose uses, so databases that were migrated with
+// goose carry on from where they are.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration *Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type Migratable interface {
+	Migrator() *Migrator
+}
+
+type Migrator struct {
+	db                 *sql.DB
+	migrations         []*Migration
+	createVersionTable string
+}
+
+func newMigrator(db *sql.DB, directory string, createVersionTable string) *Migrator {
+	migrations, err := loadMigrations(directory)
+	if err != nil {
+		panic(err)
+	}
+	return &Migrator{
+		db:                 db,
+		migrations:         migrations,
+		createVersionTable: createVersionTable,
+	}
+}
+
+func loadMigrations(directory string) ([]*Migration, error) {
+	entries, err := migrationFiles.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []*Migration
+	for _, entry := range entries {
+		parts := strings.SplitN(strings.TrimSuffix(entry.Name(), ".sql"), "_", 2)
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("Migration %v should be named <version>_<name>.sql", entry.Name())
+		}
+
+		b, err := migrationFiles.ReadFile(path.Join(directory, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		up, down, err := parseMigration(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("Migration %v: %v", entry.Name(), err)
+		}
+
+		migrations = append(migrations, &Migration{
+			Version: version,
+			Name:    parts[1],
+			Up:      up,
+			Down:    down,
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func parseMigration(source string) (string, string, error) {
+	up := strings.Index(source, "-- +goose Up")
+	down := strings.Index(source, "-- +goose Down")
+	if up == -1 || down == -1 || down < up {
+		return "", "", fmt.Errorf("expected a -- +goose Up section followed by a -- +goose Down section")
+	}
+	return source[up:down], source[down:], nil
+}
+
+func (m *Migrator) ensureVersionTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, m.createVersionTable)
+	return err
+}
+
+func (m *Migrator) appliedVersions(ctx context.Context) (map[int64]time.Time, error) {
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.QueryContext(ctx, `
+    SELECT version_id, is_applied, tstamp FROM goose_db_version
+      ORDER BY id
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var isApplied bool
+		var appliedAt sql.NullTime
+		if err := rows.Scan(&version, &isApplied, &appliedAt); err != nil {
+			return nil, err
+		}
+		if isApplied {
+			applied[version] = appliedAt.Time
+		} else {
+			delete(applied, version)
+		}
+	}
+	return applied, rows.Err()
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{
+			Migration: migration,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+// Check returns an error if the database has been migrated by a newer
+// version of builder than this one.
+func (m *Migrator) Check(ctx context.Context) error {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return err
+	}
+
+	known := map[int64]bool{}
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+	}
+
+	var unknown []string
+	for version := range applied {
+		if version != 0 && !known[version] {
+			unknown = append(unknown, strconv.FormatInt(version, 10))
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("The database schema is ahead of this builder, it has unknown migrations %v", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// Up applies every pending migration, oldest first.
+func (m *Migrator) Up(ctx context.Context) error {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err := m.run(ctx, migration, migration.Up, true)
+		if err != nil {
+			return fmt.Errorf("Error applying migration %v_%v: %v", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// Down rolls back the most recently applied migration.
+func (m *Migrator) Down(ctx context.Context) error {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		err := m.run(ctx, migration, migration.Down, false)
+		if err != nil {
+			return fmt.Errorf("Error rolling back migration %v_%v: %v", migration.Version, migration.Name, err)
+		}
+		return nil
+	}
+	return nil
+}
+
+func (m *Migrator) run(ctx context.Context, migration *Migration, statements string, applied bool) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, statements)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+    INSERT INTO goose_db_version (version_id, is_applied)
+      VALUES ($1, $2)
+    `, migration.Version, applied)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateOnStartup refuses to use a database with a newer schema than this
+// builder knows about, and applies any pending migrations.
+func migrateOnStartup(ctx context.Context, db Database) error {
+	migratable, ok := db.(Migratable)
+	if !ok {
+		return nil
+	}
+
+	migrator := migratable.Migrator()
+	if err := migrator.Check(ctx); err != nil {
+		return err
+	}
+	return migrator.Up(ctx)
+}
+
+func runMigrateCommand(ctx context.Context, output io.Writer, db Database, command string) error {
+	migratable, ok := db.(Migratable)
+	if !ok {
+		return fmt.Errorf("This database doesn't support migrations")
+	}
+	migrator := migratable.Migrator()
+
+	switch command {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		return migrator.Down(ctx)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format(time.RFC1123)
+			}
+			fmt.Fprintf(output, "%-30v %v_%v\n", appliedAt, status.Migration.Version, status.Migration.Name)
+		}
+		return migrator.Check(ctx)
+	}
+	return fmt.Errorf("Unknown migrate command %q, expected up, down or status", command)
+}
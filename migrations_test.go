@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openUnmigratedSQLiteDatabase(t *testing.T) *SQLiteDatabase {
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "builder.db"), configuration.DatabaseTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrationsAreEmbeddedForEveryDatabase(t *testing.T) {
+	for _, directory := range []string{"db/migrations", "db/sqlite/migrations"} {
+		migrations, err := loadMigrations(directory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(migrations) == 0 {
+			t.Errorf("Expected migrations to be embedded from %v", directory)
+		}
+		for i := 1; i < len(migrations); i++ {
+			if migrations[i-1].Version >= migrations[i].Version {
+				t.Errorf("Expected migrations to be ordered by version")
+			}
+		}
+	}
+
+	postgres, _ := loadMigrations("db/migrations")
+	sqlite, _ := loadMigrations("db/sqlite/migrations")
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("Expected postgres and sqlite to have the same migrations")
+	}
+	for i := range postgres {
+		if postgres[i].Version != sqlite[i].Version {
+			t.Errorf("Migration %v has no sqlite version", postgres[i].Version)
+		}
+	}
+}
+
+func TestMigrateUpDownAndStatus(t *testing.T) {
+	ctx := context.Background()
+	db := openUnmigratedSQLiteDatabase(t)
+	migrator := db.Migrator()
+
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Running up twice should do nothing, got %v", err)
+	}
+
+	statuses, _ := migrator.Status(ctx)
+	for _, status := range statuses {
+		if !status.Applied {
+			t.Errorf("Expected %v to be applied", status.Migration.Name)
+		}
+	}
+
+	if err := migrator.Down(ctx); err != nil {
+		t.Fatal(err)
+	}
+	statuses, _ = migrator.Status(ctx)
+	last := statuses[len(statuses)-1]
+	if last.Applied {
+		t.Errorf("Expected %v to be rolled back", last.Migration.Name)
+	}
+	for _, status := range statuses[:len(statuses)-1] {
+		if !status.Applied {
+			t.Errorf("Expected only the last migration to be rolled back, but %v was too", status.Migration.Name)
+		}
+	}
+
+	output := &bytes.Buffer{}
+	if err := runMigrateCommand(ctx, output, db, "status"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "pending") || !strings.Contains(output.String(), last.Migration.Name) {
+		t.Errorf("Expected status to show the pending migration, got:\n%v", output.String())
+	}
+}
+
+func TestRefusesToStartWhenSchemaIsAheadOfBuilder(t *testing.T) {
+	ctx := context.Background()
+	db := openUnmigratedSQLiteDatabase(t)
+
+	if err := migrateOnStartup(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := db.db.Exec("INSERT INTO goose_db_version (version_id, is_applied) VALUES (99990101000000, 1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = migrateOnStartup(ctx, db)
+	if err == nil || !strings.Contains(err.Error(), "99990101000000") {
+		t.Errorf("Expected to refuse a schema that is ahead of builder, got %v", err)
+	}
+}
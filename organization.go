@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/hoisie/mustache"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Role is what an account may do with a repository. Readers see builds,
+// writers can also rebuild and cancel them, and admins can also change the
+// settings of the repository.
+type Role string
+
+const (
+	roleNone  Role = ""
+	roleRead  Role = "read"
+	roleWrite Role = "write"
+	roleAdmin Role = "admin"
+)
+
+var roleRanks = map[Role]int{roleRead: 1, roleWrite: 2, roleAdmin: 3}
+
+var roles = []Role{roleRead, roleWrite, roleAdmin}
+
+func parseRole(role string) (Role, error) {
+	if _, ok := roleRanks[Role(role)]; !ok {
+		return roleNone, fmt.Errorf("Unknown role %q", role)
+	}
+	return Role(role), nil
+}
+
+// can is whether the role allows what required allows.
+func (role Role) can(required Role) bool {
+	return roleRanks[role] >= roleRanks[required]
+}
+
+// highestRole returns the role that allows the most.
+func highestRole(roles ...Role) Role {
+	highest := roleNone
+	for _, role := range roles {
+		if roleRanks[role] > roleRanks[highest] {
+			highest = role
+		}
+	}
+	return highest
+}
+
+// Organization owns repositories on behalf of its members, who each have a
+// role on all of its repositories.
+type Organization struct {
+	Id   int
+	Name string
+
+	// Host is the name of the source host of the organization's members,
+	// which is the host of the account that created it. Logins are only
+	// unique on one host.
+	Host string
+}
+
+type OrganizationMember struct {
+	Account *Account
+	Role    Role
+}
+
+// Team gives its members a role on some of the repositories of an
+// organization.
+type Team struct {
+	Id             int
+	OrganizationId int
+	Name           string
+	Role           Role
+	MemberIds      []int
+	RepositoryIds  []int
+}
+
+// repositoryRole is the role account has on repository. Everyone can read
+// public repositories, and the account that added a repository is its
+// admin.
+func repositoryRole(ctx context.Context, account *Account, repository *Repository) (Role, error) {
+	role := roleNone
+	if repository.Public {
+		role = roleRead
+	}
+	if account == nil {
+		return role, nil
+	}
+	granted, err := database.RepositoryRole(ctx, account.Id, repository.Id)
+	if err != nil {
+		return roleNone, err
+	}
+	return highestRole(role, granted), nil
+}
+
+// organizationRole is the role account has in the organization.
+func organizationRole(ctx context.Context, account *Account, organization *Organization) (Role, error) {
+	if account == nil {
+		return roleNone, nil
+	}
+	members, err := database.OrganizationMembers(ctx, organization.Id)
+	if err != nil {
+		return roleNone, err
+	}
+	for _, member := range members {
+		if member.Account.Id == account.Id {
+			return member.Role, nil
+		}
+	}
+	return roleNone, nil
+}
+
+func createOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	account := currentAccount(r)
+	if account == nil {
+		http.Redirect(w, r, "/", 302)
+		return
+	}
+
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" || strings.ContainsAny(name, "/ ") {
+		http.Error(w, "Organization names can't be empty or contain spaces or slashes", 400)
+		return
+	}
+	existing, err := database.FindOrganization(r.Context(), name)
+	if err != nil {
+		fmt.Println("Error finding organization:", err)
+		w.WriteHeader(500)
+		return
+	}
+	if existing != nil {
+		http.Error(w, "An organization with that name already exists", 400)
+		return
+	}
+
+	organization := &Organization{Name: name}
+	err = database.CreateOrganization(r.Context(), organization, account)
+	if err != nil {
+		fmt.Println("Error creating organization:", err)
+		w.WriteHeader(500)
+		return
+	}
+	http.Redirect(w, r, organizationUrl(organization), 302)
+}
+
+// findOrganization returns the organization in the url along with the role
+// of the current account in it. Organizations are only shown to members, so
+// for everyone else it is nil.
+func findOrganization(r *http.Request) (*Organization, Role, error) {
+	organization, err := database.FindOrganization(r.Context(), r.URL.Query().Get(":organization"))
+	if err != nil || organization == nil {
+		return nil, roleNone, err
+	}
+	role, err := organizationRole(r.Context(), currentAccount(r), organization)
+	if err != nil || role == roleNone {
+		return nil, roleNone, err
+	}
+	return organization, role, nil
+}
+
+func organizationHandler(w http.ResponseWriter, r *http.Request) {
+	organization, role, err := findOrganization(r)
+	if err != nil {
+		fmt.Println("Error finding organization:", err)
+		w.WriteHeader(500)
+		return
+	}
+	if organization == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	members, err := database.OrganizationMembers(r.Context(), organization.Id)
+	if err != nil {
+		fmt.Println("Error getting organization members:", err)
+		w.WriteHeader(500)
+		return
+	}
+	teams, err := database.OrganizationTeams(r.Context(), organization.Id)
+	if err != nil {
+		fmt.Println("Error getting teams:", err)
+		w.WriteHeader(500)
+		return
+	}
+	allRepositories, err := database.AllRepositories(r.Context())
+	if err != nil {
+		fmt.Println("Error getting repositories:", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	names := map[int]string{}
+	var memberRows []map[string]interface{}
+	for _, member := range members {
+		names[member.Account.Id] = member.Account.Login
+		memberRows = append(memberRows, map[string]interface{}{
+			"login": member.Account.Login,
+			"name":  member.Account.DisplayName(),
+			"role":  string(member.Role),
+		})
+	}
+	repositoryNames := map[int]string{}
+	var repositoryRows []map[string]string
+	for _, repository := range allRepositories {
+		if repository.OrganizationId == organization.Id {
+			repositoryNames[repository.Id] = repository.Owner + "/" + repository.Repository
+			repositoryRows = append(repositoryRows, map[string]string{
+				"name": repositoryNames[repository.Id],
+				"url":  repository.Url(),
+			})
+		}
+	}
+	var teamRows []map[string]interface{}
+	for _, team := range teams {
+		var teamMembers, teamRepositories []string
+		for _, id := range team.MemberIds {
+			teamMembers = append(teamMembers, names[id])
+		}
+		for _, id := range team.RepositoryIds {
+			teamRepositories = append(teamRepositories, repositoryNames[id])
+		}
+		teamRows = append(teamRows, map[string]interface{}{
+			"id":           team.Id,
+			"name":         team.Name,
+			"role":         string(team.Role),
+			"members":      strings.Join(teamMembers, ", "),
+			"repositories": strings.Join(teamRepositories, ", "),
+		})
+	}
+	var roleOptions []map[string]string
+	for _, option := range roles {
+		roleOptions = append(roleOptions, map[string]string{"role": string(option)})
+	}
+
+	context := defaultViewContext(r)
+	context["organization"] = organization.Name
+	context["host_name"] = sourceHost(organization.Host).Name()
+	context["admin"] = role.can(roleAdmin)
+	context["members"] = memberRows
+	context["teams"] = teamRows
+	context["repositories"] = repositoryRows
+	context["roles"] = roleOptions
+	body := mustache.RenderFileInLayout("views/organization.mustache", "views/layout.mustache", context)
+	w.Write([]byte(body))
+}
+
+// requireOrganizationAdmin only runs handler for admins of the organization
+// in the url.
+func requireOrganizationAdmin(handler func(w http.ResponseWriter, r *http.Request, organization *Organization)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		organization, role, err := findOrganization(r)
+		if err != nil {
+			fmt.Println("Error finding organization:", err)
+			w.WriteHeader(500)
+			return
+		}
+		if organization == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !role.can(roleAdmin) {
+			http.Error(w, "Only admins of the organization can do that", 403)
+			return
+		}
+		handler(w, r, organization)
+	}
+}
+
+// findAccountByLogin finds the account of someone who has logged in to
+// builder with the login on the source host.
+func findAccountByLogin(ctx context.Context, host string, login string) (*Account, error) {
+	accounts, err := database.FindAccountsByLogin(ctx, host, []string{login})
+	if err != nil || len(accounts) == 0 {
+		return nil, err
+	}
+	return accounts[0], nil
+}
+
+func organizationUrl(organization *Organization) string {
+	return "/organizations/" + organization.Name
+}
+
+func saveOrganizationMemberHandler(w http.ResponseWriter, r *http.Request, organization *Organization) {
+	role, err := parseRole(r.PostFormValue("role"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	member, err := findAccountByLogin(r.Context(), organization.Host, strings.TrimSpace(r.PostFormValue("login")))
+	if err != nil {
+		fmt.Println("Error finding account:", err)
+		w.WriteHeader(500)
+		return
+	}
+	if member == nil {
+		http.Error(w, "Nobody with that login has logged in to builder yet", 400)
+		return
+	}
+
+	err = database.SaveOrganizationMember(r.Context(), organization.Id, member.Id, role)
+	if err != nil {
+		fmt.Println("Error saving organization member:", err)
+		w.WriteHeader(500)
+		return
+	}
+	http.Redirect(w, r, organizationUrl(organization), 302)
+}
+
+func removeOrganizationMemberHandler(w http.ResponseWriter, r *http.Request, organization *Organization) {
+	member, err := findAccountByLogin(r.Context(), organization.Host, r.PostFormValue("login"))
+	if err != nil {
+		fmt.Println("Error finding account:", err)
+		w.WriteHeader(500)
+		return
+	}
+	if member == nil {
+		http.Redirect(w, r, organizationUrl(organization), 302)
+		return
+	}
+
+	// An organization always keeps an admin, so it can still be managed.
+	members, err := database.OrganizationMembers(r.Context(), organization.Id)
+	if err != nil {
+		fmt.Println("Error getting organization members:", err)
+		w.WriteHeader(500)
+		return
+	}
+	admins := 0
+	removingAdmin := false
+	for _, m := range members {
+		if m.Role == roleAdmin {
+			admins++
+			removingAdmin = removingAdmin || m.Account.Id == member.Id
+		}
+	}
+	if removingAdmin && admins == 1 {
+		http.Error(w, "The last admin of an organization can't be removed", 400)
+		return
+	}
+
+	err = database.DeleteOrganizationMember(r.Context(), organization.Id, member.Id)
+	if err != nil {
+		fmt.Println("Error removing organization member:", err)
+		w.WriteHeader(500)
+		return
+	}
+	http.Redirect(w, r, organizationUrl(organization), 302)
+}
+
+func createTeamHandler(w http.ResponseWriter, r *http.Request, organization *Organization) {
+	role, err := parseRole(r.PostFormValue("role"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" {
+		http.Error(w, "Teams need a name", 400)
+		return
+	}
+
+	err = database.CreateTeam(r.Context(), &Team{OrganizationId: organization.Id, Name: name, Role: role})
+	if err != nil {
+		fmt.Println("Error creating team:", err)
+		w.WriteHeader(500)
+		return
+	}
+	http.Redirect(w, r, organizationUrl(organization), 302)
+}
+
+// findTeam returns the team with the :team id in the url, if it belongs to
+// organization.
+func findTeam(r *http.Request, organization *Organization) (*Team, error) {
+	id, _ := strconv.Atoi(r.URL.Query().Get(":team"))
+	teams, err := database.OrganizationTeams(r.Context(), organization.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, team := range teams {
+		if team.Id == id {
+			return team, nil
+		}
+	}
+	return nil, nil
+}
+
+// addTeamMemberHandler adds a member of the organization to a team.
+func addTeamMemberHandler(w http.ResponseWriter, r *http.Request, organization *Organization) {
+	team, err := findTeam(r, organization)
+	if err != nil {
+		fmt.Println("Error finding team:", err)
+		w.WriteHeader(500)
+		return
+	}
+	if team == nil {
+		http.NotFound(w, r)
+		return
+	}
+	member, err := findAccountByLogin(r.Context(), organization.Host, strings.TrimSpace(r.PostFormValue("login")))
+	if err != nil {
+		fmt.Println("Error finding account:", err)
+		w.WriteHeader(500)
+		return
+	}
+	role := roleNone
+	if member != nil {
+		role, err = organizationRole(r.Context(), member, organization)
+		if err != nil {
+			fmt.Println("Error finding organization role:", err)
+			w.WriteHeader(500)
+			return
+		}
+	}
+	if role == roleNone {
+		http.Error(w, "Only members of the organization can join its teams", 400)
+		return
+	}
+
+	err = database.AddTeamMember(r.Context(), team.Id, member.Id)
+	if err != nil {
+		fmt.Println("Error adding team member:", err)
+		w.WriteHeader(500)
+		return
+	}
+	http.Redirect(w, r, organizationUrl(organization), 302)
+}
+
+// addTeamRepositoryHandler gives a team its role on a repository of the
+// organization.
+func addTeamRepositoryHandler(w http.ResponseWriter, r *http.Request, organization *Organization) {
+	team, err := findTeam(r, organization)
+	if err != nil {
+		fmt.Println("Error finding team:", err)
+		w.WriteHeader(500)
+		return
+	}
+	if team == nil {
+		http.NotFound(w, r)
+		return
+	}
+	owner, name := splitFullName(strings.TrimSpace(r.PostFormValue("repository")))
+	if owner == "" || name == "" {
+		http.Error(w, "Repositories are written as owner/name", 400)
+		return
+	}
+	repository, err := database.FindRepository(r.Context(), organization.Host, owner, name)
+	if err != nil {
+		fmt.Println("Error finding repository:", err)
+		w.WriteHeader(500)
+		return
+	}
+	if repository == nil || repository.OrganizationId != organization.Id {
+		http.Error(w, "Teams can only be given repositories of their organization", 400)
+		return
+	}
+
+	err = database.AddTeamRepository(r.Context(), team.Id, repository.Id)
+	if err != nil {
+		fmt.Println("Error adding team repository:", err)
+		w.WriteHeader(500)
+		return
+	}
+	http.Redirect(w, r, organizationUrl(organization), 302)
+}
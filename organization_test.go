@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	if !roleAdmin.can(roleWrite) || !roleWrite.can(roleRead) || !roleRead.can(roleRead) {
+		t.Errorf("Expected roles to allow what the roles below them allow")
+	}
+	if roleRead.can(roleWrite) || roleWrite.can(roleAdmin) || roleNone.can(roleRead) {
+		t.Errorf("Expected roles to not allow what the roles above them allow")
+	}
+	if role := highestRole(roleRead, roleNone, roleAdmin, roleWrite); role != roleAdmin {
+		t.Errorf("Expected the highest role to be admin, got %q", role)
+	}
+	if role, err := parseRole("owner"); err == nil {
+		t.Errorf("Expected an unknown role to be an error, got %q", role)
+	}
+}
+
+func TestCollaboratorRole(t *testing.T) {
+	collaborator := Collaborator{}
+	collaborator.Permissions.Pull = true
+	if role := collaborator.Role(); role != roleRead {
+		t.Errorf("Expected collaborators who can pull to read, got %q", role)
+	}
+	collaborator.Permissions.Push = true
+	if role := collaborator.Role(); role != roleWrite {
+		t.Errorf("Expected collaborators who can push to write, got %q", role)
+	}
+	collaborator.Permissions.Admin = true
+	if role := collaborator.Role(); role != roleAdmin {
+		t.Errorf("Expected Github admins to be admins, got %q", role)
+	}
+}
+
+// createOrganization creates an organization with admin, and member as a
+// reader.
+func createOrganization(admin *Account, member *Account) *Organization {
+	ctx := context.Background()
+	database.CreateAccount(ctx, admin)
+	database.CreateAccount(ctx, member)
+	organization := &Organization{Name: "acme"}
+	database.CreateOrganization(ctx, organization, admin)
+	database.SaveOrganizationMember(ctx, organization.Id, member.Id, roleRead)
+	return organization
+}
+
+func TestCreateOrganizationHandler(t *testing.T) {
+	resetMemoryDatabase()
+	account := &Account{Id: 1, HostUserId: 1, Login: "octocat"}
+	database.CreateAccount(context.Background(), account)
+
+	w := httptest.NewRecorder()
+	createOrganizationHandler(w, postForm(loginRequest("POST", "/organizations", account), url.Values{"name": {"acme"}}))
+	if w.Code != 302 || w.Header().Get("Location") != "/organizations/acme" {
+		t.Fatalf("Expected to be sent to the new organization, got %v %v", w.Code, w.Header())
+	}
+	organization, _ := database.FindOrganization(context.Background(), "acme")
+	if role, _ := organizationRole(context.Background(), account, organization); role != roleAdmin {
+		t.Errorf("Expected the creator to be an admin, got %q", role)
+	}
+
+	w = httptest.NewRecorder()
+	createOrganizationHandler(w, postForm(loginRequest("POST", "/organizations", account), url.Values{"name": {"ACME"}}))
+	if w.Code != 400 {
+		t.Errorf("Expected a duplicate name to be a bad request, got %v", w.Code)
+	}
+}
+
+func TestOrganizationHandlerIsOnlyShownToMembers(t *testing.T) {
+	resetMemoryDatabase()
+	admin := &Account{Id: 1, HostUserId: 1, Login: "admin"}
+	member := &Account{Id: 2, HostUserId: 2, Login: "member"}
+	createOrganization(admin, member)
+	stranger := &Account{Id: 3, HostUserId: 3, Login: "stranger"}
+	database.CreateAccount(context.Background(), stranger)
+
+	query := "?" + url.Values{":organization": {"acme"}}.Encode()
+	for account, code := range map[*Account]int{admin: 200, member: 200, stranger: 404} {
+		w := httptest.NewRecorder()
+		organizationHandler(w, loginRequest("GET", "/organizations/acme"+query, account))
+		if w.Code != code {
+			t.Errorf("Expected %v to get %v, got %v", account.Login, code, w.Code)
+		}
+	}
+}
+
+func TestOnlyOrganizationAdminsCanManageMembers(t *testing.T) {
+	resetMemoryDatabase()
+	admin := &Account{Id: 1, HostUserId: 1, Login: "admin"}
+	member := &Account{Id: 2, HostUserId: 2, Login: "member"}
+	organization := createOrganization(admin, member)
+	newcomer := &Account{Id: 3, HostUserId: 3, Login: "newcomer"}
+	database.CreateAccount(context.Background(), newcomer)
+	handler := requireOrganizationAdmin(saveOrganizationMemberHandler)
+	query := "?" + url.Values{":organization": {"acme"}}.Encode()
+
+	w := httptest.NewRecorder()
+	handler(w, postForm(loginRequest("POST", "/organizations/acme/members"+query, member), url.Values{"login": {"newcomer"}, "role": {"write"}}))
+	if w.Code != 403 {
+		t.Errorf("Expected readers to be forbidden, got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, postForm(loginRequest("POST", "/organizations/acme/members"+query, admin), url.Values{"login": {"NewComer"}, "role": {"write"}}))
+	if w.Code != 302 {
+		t.Errorf("Expected admins to add members, got %v", w.Code)
+	}
+	if role, _ := organizationRole(context.Background(), newcomer, organization); role != roleWrite {
+		t.Errorf("Expected the newcomer to be a writer, got %q", role)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, postForm(loginRequest("POST", "/organizations/acme/members"+query, admin), url.Values{"login": {"nobody"}, "role": {"write"}}))
+	if w.Code != 400 {
+		t.Errorf("Expected people who haven't logged in to be a bad request, got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, postForm(loginRequest("POST", "/organizations/acme/members"+query, admin), url.Values{"login": {"member"}, "role": {"owner"}}))
+	if w.Code != 400 {
+		t.Errorf("Expected an unknown role to be a bad request, got %v", w.Code)
+	}
+}
+
+func TestOrganizationMembersAreFoundOnTheOrganizationsHost(t *testing.T) {
+	resetMemoryDatabase()
+	ctx := context.Background()
+	admin := &Account{Id: 1, HostUserId: 1, Login: "admin"}
+	member := &Account{Id: 2, HostUserId: 2, Login: "member"}
+	organization := createOrganization(admin, member)
+	githubAlice := &Account{Id: 3, HostUserId: 3, Login: "alice"}
+	gitlabAlice := &Account{Id: -3, HostUserId: -3, Login: "alice", Host: "gitlab"}
+	database.CreateAccount(ctx, githubAlice)
+	database.CreateAccount(ctx, gitlabAlice)
+	handler := requireOrganizationAdmin(saveOrganizationMemberHandler)
+	query := "?" + url.Values{":organization": {"acme"}}.Encode()
+
+	w := httptest.NewRecorder()
+	handler(w, postForm(loginRequest("POST", "/organizations/acme/members"+query, admin), url.Values{"login": {"alice"}, "role": {"write"}}))
+	if w.Code != 302 {
+		t.Fatalf("Expected admins to add members, got %v", w.Code)
+	}
+	if role, _ := organizationRole(ctx, githubAlice, organization); role != roleWrite {
+		t.Errorf("Expected alice on Github to be a writer, got %q", role)
+	}
+	if role, _ := organizationRole(ctx, gitlabAlice, organization); role != roleNone {
+		t.Errorf("Expected alice on GitLab not to be a member, got %q", role)
+	}
+}
+
+func TestTheLastOrganizationAdminCantBeRemoved(t *testing.T) {
+	resetMemoryDatabase()
+	admin := &Account{Id: 1, HostUserId: 1, Login: "admin"}
+	member := &Account{Id: 2, HostUserId: 2, Login: "member"}
+	organization := createOrganization(admin, member)
+	handler := requireOrganizationAdmin(removeOrganizationMemberHandler)
+	query := "?" + url.Values{":organization": {"acme"}}.Encode()
+
+	w := httptest.NewRecorder()
+	handler(w, postForm(loginRequest("POST", "/organizations/acme/members/remove"+query, admin), url.Values{"login": {"admin"}}))
+	if w.Code != 400 {
+		t.Errorf("Expected removing the last admin to be a bad request, got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, postForm(loginRequest("POST", "/organizations/acme/members/remove"+query, admin), url.Values{"login": {"member"}}))
+	if role, _ := organizationRole(context.Background(), member, organization); w.Code != 302 || role != roleNone {
+		t.Errorf("Expected the member to be removed, got %v %q", w.Code, role)
+	}
+}
+
+func TestTeamsGiveTheirMembersAccessToRepositories(t *testing.T) {
+	resetMemoryDatabase()
+	ctx := context.Background()
+	admin := &Account{Id: 1, HostUserId: 1, Login: "admin"}
+	member := &Account{Id: 2, HostUserId: 2, Login: "member"}
+	organization := createOrganization(admin, member)
+	stranger := &Account{Id: 3, HostUserId: 3, Login: "stranger"}
+	database.CreateAccount(ctx, stranger)
+	repository := &Repository{Owner: "acme", Repository: "widgets", OrganizationId: organization.Id}
+	database.AddRepositoryToAccount(ctx, admin, repository)
+	other := &Repository{Owner: "someone", Repository: "else"}
+	database.AddRepositoryToAccount(ctx, stranger, other)
+
+	requireOrganizationAdmin(createTeamHandler)(httptest.NewRecorder(), postForm(
+		loginRequest("POST", "/organizations/acme/teams?"+url.Values{":organization": {"acme"}}.Encode(), admin),
+		url.Values{"name": {"writers"}, "role": {"write"}}))
+	teams, _ := database.OrganizationTeams(ctx, organization.Id)
+	if len(teams) != 1 {
+		t.Fatalf("Expected a team to be created, got %+v", teams)
+	}
+	teamQuery := "?" + url.Values{":organization": {"acme"}, ":team": {strconv.Itoa(teams[0].Id)}}.Encode()
+
+	post := func(handler func(w http.ResponseWriter, r *http.Request, organization *Organization), path string, values url.Values) int {
+		w := httptest.NewRecorder()
+		requireOrganizationAdmin(handler)(w, postForm(loginRequest("POST", path+teamQuery, admin), values))
+		return w.Code
+	}
+	if code := post(addTeamMemberHandler, "/organizations/acme/teams/1/members", url.Values{"login": {"stranger"}}); code != 400 {
+		t.Errorf("Expected people outside the organization to not join teams, got %v", code)
+	}
+	if code := post(addTeamRepositoryHandler, "/organizations/acme/teams/1/repositories", url.Values{"repository": {"someone/else"}}); code != 400 {
+		t.Errorf("Expected repositories outside the organization to not be added, got %v", code)
+	}
+	post(addTeamMemberHandler, "/organizations/acme/teams/1/members", url.Values{"login": {"member"}})
+	post(addTeamRepositoryHandler, "/organizations/acme/teams/1/repositories", url.Values{"repository": {"acme/widgets"}})
+
+	if role, _ := repositoryRole(ctx, member, repository); role != roleWrite {
+		t.Errorf("Expected the team to give its member write access, got %q", role)
+	}
+}
+
+func TestTeamsCanBeGivenRepositoriesInGitlabSubgroups(t *testing.T) {
+	resetMemoryDatabase()
+	ctx := context.Background()
+	admin := &Account{Id: 1, HostUserId: 1, Login: "admin", Host: "gitlab"}
+	member := &Account{Id: 2, HostUserId: 2, Login: "member", Host: "gitlab"}
+	organization := createOrganization(admin, member)
+	repository := &Repository{Owner: "acme/tools", Repository: "widgets", OrganizationId: organization.Id}
+	database.AddRepositoryToAccount(ctx, admin, repository)
+	team := &Team{OrganizationId: organization.Id, Name: "writers", Role: roleWrite}
+	database.CreateTeam(ctx, team)
+	database.AddTeamMember(ctx, team.Id, member.Id)
+
+	query := "?" + url.Values{":organization": {"acme"}, ":team": {strconv.Itoa(team.Id)}}.Encode()
+	w := httptest.NewRecorder()
+	requireOrganizationAdmin(addTeamRepositoryHandler)(w, postForm(
+		loginRequest("POST", "/organizations/acme/teams/1/repositories"+query, admin),
+		url.Values{"repository": {"acme/tools/widgets"}}))
+
+	if w.Code != 302 {
+		t.Errorf("Expected the repository to be added to the team, got %v", w.Code)
+	}
+	if role, _ := repositoryRole(ctx, member, repository); role != roleWrite {
+		t.Errorf("Expected the team to give its member write access, got %q", role)
+	}
+}
@@ -1,433 +1,45 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/base64"
-	"fmt"
-	"github.com/eaigner/jet"
+	"context"
+	"database/sql"
 	_ "github.com/lib/pq"
-	"log"
-	"strconv"
+	"time"
 )
 
-var connection *jet.Db
-
-func connect() (*jet.Db, error) {
-	if connection == nil {
-		c, err := jet.Open("postgres", "host=/var/run/postgresql dbname=builder sslmode=disable")
-		connection = c
-		return connection, err
-	}
-	err := connection.Ping()
-	if err != nil {
-		return nil, err
-	}
-	return connection, nil
-}
-
 type PostgresDatabase struct {
+	*sqlDatabase
 }
 
-func (p *PostgresDatabase) AddRepositoryToAccount(account *Account, repository *Repository) error {
-	db, err := connect()
-	if err != nil {
-		return err
-	}
-
-	var id int
-	err = db.Query(`
-    INSERT INTO repositories (account_id, owner, repository, public)
-      VALUES ($1, $2, $3, $4)
-      RETURNING (id)
-    `, account.Id, repository.Owner, repository.Repository, repository.Public).Rows(&id)
-
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	repository.Id = id
-	account.Repositories = append(account.Repositories, repository)
-
-	return nil
-}
-
-func (p *PostgresDatabase) SaveCommit(commit *Commit) error {
-	db, err := connect()
-	if err != nil {
-		return err
-	}
-
-	err = db.Query(`
-    INSERT INTO commits (build_id, sha, message, url)
-      VALUES ($1, $2, $3, $4)
-      RETURNING *
-    `, commit.BuildId, commit.Sha, commit.Message, commit.Url,
-	).Rows(&commit)
-
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (p *PostgresDatabase) SaveBuild(build *Build) error {
-	db, err := connect()
-	if err != nil {
-		return err
-	}
-
-	err = db.Query(`
-    UPDATE builds
-      SET
-        (url, owner, repository, ref, sha, complete, success, result, github_url) = ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-      WHERE id = $10
-	`,
-		build.Url,
-		build.Owner,
-		build.Repository,
-		build.Ref,
-		build.Sha,
-		build.Complete,
-		build.Success,
-		build.Result,
-		build.GithubUrl,
-		build.Id,
-	).Run()
-
-	if err != nil {
-		fmt.Printf("Error saving build:\n%v\nError:\n%v\n", err)
-	}
-	return err
-}
-
-func (p *PostgresDatabase) AllBuilds(account *Account) []*Build {
-	if account == nil {
-		return []*Build{}
-	}
-
-	db, err := connect()
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
-
-	var repositoryIds []int
-	for _, repository := range account.Repositories {
-		repositoryIds = append(repositoryIds, repository.Id)
-	}
-
-	var collaborations []int
-	err = db.Query("SELECT repository_id from collaborations WHERE account_id = $1", account.Id).Rows(&collaborations)
-
-	for _, collaboratedRepository := range collaborations {
-		repositoryIds = append(repositoryIds, collaboratedRepository)
-	}
-
-	var builds []*Build
-	if len(repositoryIds) == 0 {
-		return builds
-	}
-
-	err = db.Query("SELECT * FROM builds WHERE repository_id IN ( $1 ) ORDER BY id", repositoryIds).Rows(&builds)
-
-	if err != nil {
-		fmt.Println("Error getting all builds: ", err)
-		return nil
-	}
-
-	if len(builds) > 0 {
-		var buildIds []int
-		buildsById := map[int]*Build{}
-		for _, build := range builds {
-			buildIds = append(buildIds, build.Id)
-			buildsById[build.Id] = build
-		}
-
-		var commits []Commit
-		err = db.Query("SELECT * FROM commits WHERE build_id IN ( $1 )", buildIds).Rows(&commits)
-		if err != nil {
-			fmt.Println("Error getting commits:", err)
-			return nil
-		}
-
-		for _, commit := range commits {
-			build := buildsById[commit.BuildId]
-			build.Commits = append(build.Commits, commit)
-		}
-	}
-
-	return builds
-}
-
-func (p *PostgresDatabase) FindPublicBuilds() []*Build {
-	db, err := connect()
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
-
-	var builds []*Build
-	err = db.Query(`SELECT builds.* FROM builds
-                    INNER JOIN repositories ON (builds.repository_id = repositories.id AND repositories.public = true) ORDER BY builds.id`).Rows(&builds)
-
-	if err != nil {
-		fmt.Println("Error getting all builds: ", err)
-		return nil
-	}
-
-	if len(builds) > 0 {
-		var buildIds []int
-		buildsById := map[int]*Build{}
-		for _, build := range builds {
-			buildIds = append(buildIds, build.Id)
-			buildsById[build.Id] = build
-		}
-
-		var commits []Commit
-		err = db.Query("SELECT * FROM commits WHERE build_id IN ( $1 )", buildIds).Rows(&commits)
-		if err != nil {
-			fmt.Println("Error getting commits:", err)
-			return nil
-		}
-
-		for _, commit := range commits {
-			build := buildsById[commit.BuildId]
-			build.Commits = append(build.Commits, commit)
-		}
-	}
-
-	return builds
-}
-
-func (p *PostgresDatabase) CreateBuild(repository *Repository, build *Build) error {
-	db, err := connect()
-	if err != nil {
-		return err
-	}
-
-	var m []int
-	err = db.Query(`
-    INSERT INTO builds (repository_id)
-      VALUES ($1)
-      RETURNING (id)
-    `, repository.Id,
-	).Rows(&m)
-
+// NewPostgresDatabase opens a connection pool of at most maxConnections
+// connections. Every query is cancelled if it runs for longer than timeout.
+func NewPostgresDatabase(dataSource string, maxConnections int, timeout time.Duration) (*PostgresDatabase, error) {
+	db, err := sql.Open("postgres", dataSource)
 	if err != nil {
-		fmt.Println(err)
-		return err
-	}
-	buildId := m[0]
-
-	build.Id = buildId
-	build.Result = "incomplete"
-	build.Url = configuration.Host
-	if configuration.Port != "80" {
-		build.Url += ":" + configuration.Port
-	}
-	build.Url += "/build/" + strconv.Itoa(build.Id) + "/output"
-
-	p.SaveBuild(build)
-
-	for _, commit := range build.Commits {
-		commit.BuildId = build.Id
-		p.SaveCommit(&commit)
-	}
-
-	return nil
-}
-
-func (p *PostgresDatabase) FindRepository(owner string, name string) *Repository {
-	db, err := connect()
-	if err != nil {
-		log.Println(err)
-		return nil
-	}
-
-	var repositories []*Repository
-	err = db.Query(`
-    SELECT * FROM repositories
-      WHERE   owner      = $1
-      AND     repository = $2
-    `, owner, name).Rows(&repositories)
-
-	if err != nil {
-		log.Println(err)
-		return nil
-	}
-
-	if len(repositories) == 0 {
-		return nil
-	}
-
-	repository := repositories[0]
-	repository.Account = p.FindAccountById(repository.AccountId)
-	return repository
-}
-
-func (p *PostgresDatabase) IncompleteBuilds() []*Build {
-	db, err := connect()
-	if err != nil {
-		log.Println(err)
-		return nil
-	}
-
-	var builds []*Build
-	err = db.Query(`
-    SELECT * FROM builds
-      WHERE   complete = $1
-    `, false).Rows(&builds)
-
-	if err != nil {
-		log.Println(err)
-		return nil
-	}
-
-	return builds
-}
-
-func (p *PostgresDatabase) FindAccountById(id int) *Account {
-	db, err := connect()
-	if err != nil {
-		log.Println(err)
-		return nil
-	}
-
-	var account *Account
-	err = db.Query(`
-    SELECT * FROM accounts
-      WHERE id = $1
-  `, id).Rows(&account)
-
-	if err != nil {
-		log.Println(err)
-		return nil
-	}
-
-	if account == nil {
-		return nil
-	}
-
-	var repositories []*Repository
-	err = db.Query(`
-  SELECT * FROM repositories
-    WHERE account_id = $1
-  `, account.Id).Rows(&repositories)
-	if err != nil {
-		log.Println(err)
-	}
-
-	account.Repositories = repositories
-
-	for _, repository := range repositories {
-		repository.Account = account
-	}
-
-	return account
-}
-
-func (p *PostgresDatabase) CreateAccount(account *Account) error {
-	db, err := connect()
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	if found := p.FindAccountById(account.Id); found != nil {
-		err = db.Query(`
-    UPDATE accounts
-      SET
-        (access_token) = ($1)
-      WHERE id = $2
-    `, account.AccessToken, found.Id).Run()
-		if err != nil {
-			fmt.Println("Error updating account: ", err)
-			return err
-		}
-		account.Id = found.Id
-	} else {
-		err = db.Query(`
-      INSERT INTO accounts (id, access_token)
-      VALUES ($1, $2)
-    `, account.Id, account.AccessToken).Run()
-
-		if err != nil {
-			fmt.Println("Error creating account: ", err)
-			return err
-		}
-	}
-
-	return nil
-}
-
-func (p *PostgresDatabase) CreateLoginForAccount(account *Account) (*Login, error) {
-	db, err := connect()
-	if err != nil {
-		log.Println(err)
 		return nil, err
 	}
+	db.SetMaxOpenConns(maxConnections)
+	db.SetMaxIdleConns(maxConnections)
 
-	b := make([]byte, 50)
-	rand.Read(b)
-	encoder := base64.URLEncoding
-	token := make([]byte, encoder.EncodedLen(len(b)))
-	encoder.Encode(token, b)
-	t := fmt.Sprintf("%s", token)
-
-	var id int
-	err = db.Query(`
-      INSERT INTO logins (account_id, token)
-      VALUES ($1, $2)
-      RETURNING (id)
-    `, account.Id, t).Rows(&id)
+	p := &PostgresDatabase{&sqlDatabase{db: db, timeout: timeout}}
 
-	if err != nil {
-		fmt.Println(err)
+	ctx, cancel := p.context(context.Background())
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
 		return nil, err
 	}
-
-	return &Login{
-		Id:        id,
-		AccountId: account.Id,
-		Token:     t,
-	}, nil
+	return p, nil
 }
 
-func (p *PostgresDatabase) LoginExists(accountId int, token string) bool {
-	db, err := connect()
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-
-	var count int
-	err = db.Query(`
-      SELECT COUNT (*) FROM logins
-      WHERE account_id = $1
-      AND token = $1
-    `, accountId, token).Rows(&count)
-
-	if err != nil {
-		fmt.Println(err)
-		return false
-	}
-
-	return count == 1
-}
-
-func (p *PostgresDatabase) SaveCollaboration(accountId int, repositoryId int) error {
-	db, err := connect()
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-	err = db.Query(`
-      INSERT INTO collaborations (account_id, repository_id)
-      VALUES ($1, $2)
-    `, accountId, repositoryId).Run()
-	if err != nil {
-		log.Println(err)
-	}
-	return err
+func (p *PostgresDatabase) Migrator() *Migrator {
+	return newMigrator(p.db, "db/migrations", `
+    CREATE TABLE IF NOT EXISTS goose_db_version (
+      id         SERIAL NOT NULL,
+      version_id BIGINT NOT NULL,
+      is_applied BOOLEAN NOT NULL,
+      tstamp     TIMESTAMP NULL DEFAULT now(),
+      PRIMARY KEY(id)
+    )
+  `)
 }
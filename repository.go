@@ -1,5 +1,7 @@
 package main
 
+import "net/url"
+
 type Repository struct {
 	Id         int
 	AccountId  int
@@ -7,4 +9,23 @@ type Repository struct {
 	Repository string
 	Account    *Account
 	Public     bool
+
+	// OrganizationId is the organization that owns the repository, or zero
+	// when it belongs to the account that added it.
+	OrganizationId int
+
+	// Host is the name of the source host the repository is on, which is
+	// the host of the account that added it.
+	Host string
+}
+
+// Url is the path of the repository's page. The same owner and name can be
+// used on more than one host, so repositories that aren't on Github have
+// their host in the url.
+func (repository *Repository) Url() string {
+	path := "/" + repository.Owner + "/" + repository.Repository
+	if host := hostName(repository.Host); host != "github" {
+		path += "?host=" + url.QueryEscape(host)
+	}
+	return path
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sort"
+	"time"
+)
+
+// RetentionPolicy decides how long the workspace and log of a completed
+// build are kept. A zero MaxAge or MaxBuilds means no limit.
+type RetentionPolicy struct {
+	MaxAge time.Duration
+	// MaxBuilds is the number of builds kept for each repository.
+	MaxBuilds int
+}
+
+func (policy RetentionPolicy) expired(builds []*Build, now time.Time) []*Build {
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].Id > builds[j].Id
+	})
+
+	var expired []*Build
+	kept := 0
+	for _, build := range builds {
+		if !build.Complete || build.Pruned {
+			continue
+		}
+		tooOld := policy.MaxAge > 0 && now.Sub(build.CreatedAt) > policy.MaxAge
+		tooMany := policy.MaxBuilds > 0 && kept >= policy.MaxBuilds
+		if tooOld || tooMany {
+			expired = append(expired, build)
+		} else {
+			kept++
+		}
+	}
+	return expired
+}
+
+// Prune deletes the workspaces and logs of every build that the policy
+// no longer keeps, and marks those builds as pruned so they're skipped
+// the next time.
+func (policy RetentionPolicy) Prune(ctx context.Context, db Database, logs LogStore) error {
+	if policy.MaxAge <= 0 && policy.MaxBuilds <= 0 {
+		return nil
+	}
+
+	repositories, err := db.AllRepositories(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, repository := range repositories {
+		builds, err := db.RepositoryBuilds(ctx, repository)
+		if err != nil {
+			return err
+		}
+
+		for _, build := range policy.expired(builds, now) {
+			if err := logs.Delete(build); err != nil {
+				return err
+			}
+			if err := os.RemoveAll(build.Path()); err != nil {
+				return err
+			}
+			build.Pruned = true
+			if err := db.SaveBuild(ctx, build); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyExpiresBuildsByCount(t *testing.T) {
+	policy := RetentionPolicy{MaxBuilds: 2}
+	builds := []*Build{
+		{Id: 1, Complete: true},
+		{Id: 2, Complete: true},
+		{Id: 3, Complete: true},
+		{Id: 4, Complete: false},
+	}
+
+	expired := policy.expired(builds, time.Now())
+	if len(expired) != 1 || expired[0].Id != 1 {
+		t.Errorf("Expected only the oldest complete build to expire, got %+v", expired)
+	}
+}
+
+func TestRetentionPolicyExpiresBuildsByAge(t *testing.T) {
+	now := time.Now()
+	policy := RetentionPolicy{MaxAge: 24 * time.Hour}
+	builds := []*Build{
+		{Id: 1, Complete: true, CreatedAt: now.Add(-48 * time.Hour)},
+		{Id: 2, Complete: false, CreatedAt: now.Add(-48 * time.Hour)},
+		{Id: 3, Complete: true, CreatedAt: now.Add(-time.Hour)},
+	}
+
+	expired := policy.expired(builds, now)
+	if len(expired) != 1 || expired[0].Id != 1 {
+		t.Errorf("Expected only the old complete build to expire, got %+v", expired)
+	}
+}
+
+func TestPrunesWorkspacesAndLogsPerRepository(t *testing.T) {
+	defer cleanDataDirectory()
+	resetMemoryDatabase()
+	ctx := context.Background()
+	store := &FileLogStore{}
+
+	var builds []*Build
+	for _, name := range []string{"repo1", "repo2"} {
+		repository := createAccountWithRepository(&Account{Id: 1, HostUserId: 1}, "owner", name)
+		for i := 0; i < 2; i++ {
+			build := &Build{Owner: "owner", Repository: name}
+			database.CreateBuild(ctx, repository, build)
+			build.Complete = true
+			database.SaveBuild(ctx, build)
+
+			os.MkdirAll(build.SourcePath(), 0700)
+			output, _ := store.Create(build)
+			output.Close()
+			store.Complete(build)
+			builds = append(builds, build)
+		}
+	}
+
+	err := RetentionPolicy{MaxBuilds: 1}.Prune(ctx, database, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, build := range builds {
+		_, err := os.Stat(build.Path())
+		pruned := os.IsNotExist(err)
+		if expected := i%2 == 0; pruned != expected {
+			t.Errorf("Expected build %d pruned to be %v, but was %v", build.Id, expected, pruned)
+		}
+	}
+}
+
+type countingLogStore struct {
+	FileLogStore
+	deletes int
+}
+
+func (store *countingLogStore) Delete(build *Build) error {
+	store.deletes++
+	return store.FileLogStore.Delete(build)
+}
+
+func TestPruneSkipsBuildsItHasAlreadyPruned(t *testing.T) {
+	defer cleanDataDirectory()
+	resetMemoryDatabase()
+	ctx := context.Background()
+	store := &countingLogStore{}
+
+	repository := createAccountWithRepository(&Account{Id: 1, HostUserId: 1}, "owner", "repo1")
+	for i := 0; i < 3; i++ {
+		build := &Build{Owner: "owner", Repository: "repo1"}
+		database.CreateBuild(ctx, repository, build)
+		build.Complete = true
+		database.SaveBuild(ctx, build)
+	}
+
+	policy := RetentionPolicy{MaxBuilds: 1}
+	if err := policy.Prune(ctx, database, store); err != nil {
+		t.Fatal(err)
+	}
+	if store.deletes != 2 {
+		t.Fatalf("Expected the first prune to delete 2 logs, got %d", store.deletes)
+	}
+
+	store.deletes = 0
+	if err := policy.Prune(ctx, database, store); err != nil {
+		t.Fatal(err)
+	}
+	if store.deletes != 0 {
+		t.Errorf("Expected the second prune not to delete anything, got %d deletes", store.deletes)
+	}
+}
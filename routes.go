@@ -12,16 +12,45 @@ func init() {
 	mux.Get("/builds", buildsHandler)
 	mux.Get("/build/:id/output", buildOutputHandler)
 	mux.Get("/build/:id/output/raw", buildOutputRawHandler)
+	mux.Get("/build/:id/tests", buildTestsHandler)
+	mux.Get("/build/:id/tests/history", buildTestHistoryHandler)
+	mux.Get("/build/:id/coverage", buildCoverageHandler)
+	mux.Get("/build/:id/coverage/trend", buildCoverageTrendHandler)
+	mux.Get("/login/github", githubAuthorizeHandler)
 	mux.Get("/github_callback", githubLoginHandler)
-	mux.Get("/logout", logoutHandler)
+	mux.Get("/login/gitlab", gitlabAuthorizeHandler)
+	mux.Get("/gitlab_callback", gitlabLoginHandler)
+	mux.Get("/login/gitea", giteaAuthorizeHandler)
+	mux.Get("/gitea_callback", giteaLoginHandler)
+	mux.Get("/development_login", developmentLoginHandler)
 	mux.Get("/settings", settingsHandler)
+	mux.Get("/organizations/:organization", organizationHandler)
 
 	mux.Post("/hooks/push", pushHandler)
 	mux.Post("/hooks/pull_request", pullRequestHandler)
-	mux.Post("/repository", addRepositoryHandler)
+	mux.Post("/hooks/gitlab", gitlabHookHandler)
+	mux.Post("/hooks/gitea", giteaHookHandler)
+	mux.Post("/repository", requireCSRF(addRepositoryHandler))
+	mux.Post("/logout", requireCSRF(logoutHandler))
+	mux.Post("/sessions/others/delete", requireCSRF(logOutOtherSessionsHandler))
+	mux.Post("/build/:id/rebuild", requireCSRF(rebuildHandler))
+	mux.Post("/build/:id/cancel", requireCSRF(cancelBuildHandler))
+	mux.Post("/organizations", requireCSRF(createOrganizationHandler))
+	mux.Post("/organizations/:organization/members", requireCSRF(requireOrganizationAdmin(saveOrganizationMemberHandler)))
+	mux.Post("/organizations/:organization/members/remove", requireCSRF(requireOrganizationAdmin(removeOrganizationMemberHandler)))
+	mux.Post("/organizations/:organization/teams", requireCSRF(requireOrganizationAdmin(createTeamHandler)))
+	mux.Post("/organizations/:organization/teams/:team/members", requireCSRF(requireOrganizationAdmin(addTeamMemberHandler)))
+	mux.Post("/organizations/:organization/teams/:team/repositories", requireCSRF(requireOrganizationAdmin(addTeamRepositoryHandler)))
+	mux.Post("/:owner/:repo/settings", requireCSRF(repositorySettingsHandler))
+	mux.Post("/:owner/:repo/collaborators/sync", requireCSRF(syncCollaboratorsHandler))
+	mux.Post("/:owner/:repo/hooks/repair", requireCSRF(repairHooksHandler))
+	mux.Post("/:owner/:repo/remove", requireCSRF(removeRepositoryHandler))
 
 	pwd, _ := os.Getwd()
 	mux.Static("/assets", pwd)
 
+	// Repository pages match any two part path, so they come last.
+	mux.Get("/:owner/:repo", repositoryHandler)
+
 	http.Handle("/", mux)
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3LogStore writes running logs to disk like FileLogStore, and uploads them
+// gzipped to an S3 compatible bucket once the build is complete.
+type S3LogStore struct {
+	Endpoint        string
+	Bucket          string
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	Prefix          string
+	local           FileLogStore
+}
+
+func (s *S3LogStore) key(build *Build) string {
+	return s.Prefix + "builds/" + strconv.Itoa(build.Id) + "/output.log.gz"
+}
+
+func (s *S3LogStore) Create(build *Build) (io.WriteCloser, error) {
+	return s.local.Create(build)
+}
+
+func (s *S3LogStore) Complete(build *Build) error {
+	input, err := os.Open(build.LogPath())
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	compressed := &bytes.Buffer{}
+	writer := gzip.NewWriter(compressed)
+	if _, err := io.Copy(writer, input); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	response, err := s.request("PUT", s.key(build), compressed.Bytes())
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != 200 {
+		return fmt.Errorf("Error uploading log for build %d, S3 returned %v", build.Id, response.Status)
+	}
+
+	return s.local.Delete(build)
+}
+
+func (s *S3LogStore) ReadFrom(build *Build, offset int64) ([]byte, error) {
+	b, err := s.local.ReadFrom(build, offset)
+	if err == nil || !os.IsNotExist(err) {
+		return b, err
+	}
+
+	response, err := s.request("GET", s.key(build), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == 404 {
+		return nil, os.ErrNotExist
+	}
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("Error downloading log for build %d, S3 returned %v", build.Id, response.Status)
+	}
+	return readCompressedFrom(response.Body, offset)
+}
+
+func (s *S3LogStore) Delete(build *Build) error {
+	err := s.local.Delete(build)
+	if err != nil {
+		return err
+	}
+
+	response, err := s.request("DELETE", s.key(build), nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != 204 && response.StatusCode != 200 && response.StatusCode != 404 {
+		return fmt.Errorf("Error deleting log for build %d, S3 returned %v", build.Id, response.Status)
+	}
+	return nil
+}
+
+func (s *S3LogStore) request(method string, key string, body []byte) (*http.Response, error) {
+	url := strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket + "/" + key
+	request, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(request, body, time.Now().UTC())
+	return http.DefaultClient.Do(request)
+}
+
+// sign adds an AWS signature version 4 Authorization header to the request.
+func (s *S3LogStore) sign(request *http.Request, body []byte, now time.Time) {
+	date := now.Format("20060102")
+	timestamp := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(body)
+
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+	request.Header.Set("x-amz-date", timestamp)
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		"host:" + request.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + timestamp,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		timestamp,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		s.AccessKeyId, scope, signedHeaders, signature,
+	))
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
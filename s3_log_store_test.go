@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a stand-in for an S3 compatible server that keeps objects in memory.
+type fakeS3 struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=ACCESS/") ||
+		!strings.Contains(authorization, "/eu-west-1/s3/aws4_request") ||
+		r.Header.Get("x-amz-date") == "" {
+		w.WriteHeader(403)
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		b, _ := ioutil.ReadAll(r.Body)
+		if sha256Hex(b) != r.Header.Get("x-amz-content-sha256") {
+			w.WriteHeader(400)
+			return
+		}
+		f.objects[r.URL.Path] = b
+	case "GET":
+		b, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write(b)
+	case "DELETE":
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(204)
+	}
+}
+
+func TestS3LogStore(t *testing.T) {
+	defer cleanDataDirectory()
+
+	s3 := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(s3)
+	defer server.Close()
+
+	store := &S3LogStore{
+		Endpoint:        server.URL,
+		Bucket:          "logs",
+		Region:          "eu-west-1",
+		AccessKeyId:     "ACCESS",
+		SecretAccessKey: "SECRET",
+		Prefix:          "builder/",
+	}
+	testLogStore(t, store)
+}
+
+func TestS3LogStoreUploadsCompletedLogs(t *testing.T) {
+	defer cleanDataDirectory()
+
+	s3 := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(s3)
+	defer server.Close()
+
+	store := &S3LogStore{
+		Endpoint:        server.URL,
+		Bucket:          "logs",
+		Region:          "eu-west-1",
+		AccessKeyId:     "ACCESS",
+		SecretAccessKey: "SECRET",
+	}
+	build := &Build{Id: 12}
+	output, _ := store.Create(build)
+	output.Write([]byte("some output"))
+	output.Close()
+
+	if err := store.Complete(build); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s3.objects["/logs/builds/12/output.log.gz"]; !ok {
+		t.Errorf("Expected the log to be uploaded, got objects %v", s3.objects)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Session is a logged in browser. The cookie holds the session id, signed
+// so that ids can't be guessed at, and the session only lasts until it
+// expires or is deleted by logging out.
+type Session struct {
+	Id        string
+	AccountId int
+	// CsrfToken has to be posted with every form, which proves the post
+	// came from one of builder's own pages.
+	CsrfToken string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+const sessionCookie = "session"
+
+// csrfField is the name of the form field that holds the CSRF token.
+const csrfField = "csrf_token"
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func newSession(account *Account, now time.Time) (*Session, error) {
+	id, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	csrfToken, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	now = now.UTC().Truncate(time.Second)
+	return &Session{
+		Id:        id,
+		AccountId: account.Id,
+		CsrfToken: csrfToken,
+		CreatedAt: now,
+		ExpiresAt: now.Add(configuration.SessionLifetime),
+	}, nil
+}
+
+func (session *Session) expired(now time.Time) bool {
+	return !now.Before(session.ExpiresAt)
+}
+
+func sessionSignature(id string) string {
+	mac := hmac.New(sha256.New, []byte(configuration.SessionSecret))
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signSessionId(id string) string {
+	return id + "." + sessionSignature(id)
+}
+
+// verifySessionCookie returns the session id from a cookie, if it was
+// signed by builder.
+func verifySessionCookie(value string) (string, bool) {
+	i := strings.LastIndex(value, ".")
+	if i == -1 {
+		return "", false
+	}
+	id, signature := value[:i], value[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(sessionSignature(id))) {
+		return "", false
+	}
+	return id, true
+}
+
+func sessionCookieFor(session *Session) *http.Cookie {
+	return &http.Cookie{
+		Name:     sessionCookie,
+		Value:    signSessionId(session.Id),
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(configuration.Host, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(configuration.Host, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func logIn(w http.ResponseWriter, r *http.Request, account *Account) error {
+	session, err := newSession(account, time.Now())
+	if err != nil {
+		return err
+	}
+	err = database.CreateSession(r.Context(), session)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessionCookieFor(session))
+	return nil
+}
+
+// currentSession returns the session of the request, or nil when the
+// request isn't logged in.
+func currentSession(r *http.Request) *Session {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	id, ok := verifySessionCookie(cookie.Value)
+	if !ok {
+		return nil
+	}
+
+	session, err := database.FindSession(r.Context(), id)
+	if err != nil {
+		log.Println("Error finding session:", err)
+		return nil
+	}
+	if session == nil || session.expired(time.Now()) {
+		return nil
+	}
+	return session
+}
+
+func currentAccount(r *http.Request) *Account {
+	session := currentSession(r)
+	if session == nil {
+		return nil
+	}
+
+	account, err := database.FindAccountById(r.Context(), session.AccountId)
+	if err != nil {
+		log.Println("Error finding account:", err)
+		return nil
+	}
+	return account
+}
+
+// requireCSRF only lets a post through when it has the CSRF token of the
+// session that made it.
+func requireCSRF(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := currentSession(r)
+		token := r.PostFormValue(csrfField)
+		if session == nil || token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(session.CsrfToken)) != 1 {
+			http.Error(w, "Invalid or missing CSRF token", 403)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if session := currentSession(r); session != nil {
+		if err := database.DeleteSession(r.Context(), session.Id); err != nil {
+			log.Println("Error deleting session:", err)
+			w.WriteHeader(500)
+			return
+		}
+	}
+	clearSessionCookie(w)
+	http.Redirect(w, r, "/", 302)
+}
+
+// logOutOtherSessionsHandler deletes every session of the account apart
+// from the one making the request.
+func logOutOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+	if session == nil {
+		http.Redirect(w, r, "/", 302)
+		return
+	}
+	if err := database.DeleteAccountSessions(r.Context(), session.AccountId, session.Id); err != nil {
+		log.Println("Error deleting sessions:", err)
+		w.WriteHeader(500)
+		return
+	}
+	http.Redirect(w, r, "/settings", 302)
+}
+
+func pruneSessions() {
+	for {
+		err := database.DeleteExpiredSessions(context.Background(), time.Now())
+		if err != nil {
+			log.Println("Error deleting expired sessions:", err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
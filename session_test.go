@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySessionCookie(t *testing.T) {
+	signed := signSessionId("abc")
+	if id, ok := verifySessionCookie(signed); !ok || id != "abc" {
+		t.Errorf("Expected a signed id to verify, got %q, %v", id, ok)
+	}
+	for _, value := range []string{"abc", "abd" + strings.TrimPrefix(signed, "abc"), signed + "x", ""} {
+		if _, ok := verifySessionCookie(value); ok {
+			t.Errorf("Expected %q not to verify", value)
+		}
+	}
+}
+
+func TestCurrentAccountNeedsALiveSession(t *testing.T) {
+	resetMemoryDatabase()
+	ctx := context.Background()
+	account := &Account{Id: 1, HostUserId: 1}
+	database.CreateAccount(ctx, account)
+
+	r := loginRequest("GET", "/", account)
+	if currentAccount(r) == nil {
+		t.Fatal("Expected the session to be logged in")
+	}
+
+	expired, _ := newSession(account, time.Now().Add(-configuration.SessionLifetime))
+	database.CreateSession(ctx, expired)
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(sessionCookieFor(expired))
+	if currentAccount(r) != nil {
+		t.Error("Expected expired sessions not to be logged in")
+	}
+
+	unsigned, _ := newSession(account, time.Now())
+	database.CreateSession(ctx, unsigned)
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: unsigned.Id})
+	if currentAccount(r) != nil {
+		t.Error("Expected unsigned session ids not to be logged in")
+	}
+}
+
+func postForm(r *http.Request, values url.Values) *http.Request {
+	r.Body = nil
+	r.PostForm = values
+	return r
+}
+
+func TestRequireCSRF(t *testing.T) {
+	resetMemoryDatabase()
+	account := &Account{Id: 1, HostUserId: 1}
+	database.CreateAccount(context.Background(), account)
+
+	called := false
+	handler := requireCSRF(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	r := loginRequest("POST", "/repository", account)
+	session := currentSession(r)
+	for _, token := range []string{"", "not the token"} {
+		w := httptest.NewRecorder()
+		handler(w, postForm(r, url.Values{csrfField: {token}}))
+		if called || w.Code != 403 {
+			t.Errorf("Expected a post with the token %q to be forbidden, got %v", token, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, postForm(httptest.NewRequest("POST", "/repository", nil), url.Values{csrfField: {session.CsrfToken}}))
+	if called || w.Code != 403 {
+		t.Errorf("Expected a post without a session to be forbidden, got %v", w.Code)
+	}
+
+	handler(httptest.NewRecorder(), postForm(r, url.Values{csrfField: {session.CsrfToken}}))
+	if !called {
+		t.Error("Expected a post with the session's token to be allowed")
+	}
+}
+
+func TestLogoutHandlerDeletesTheSession(t *testing.T) {
+	resetMemoryDatabase()
+	account := &Account{Id: 1, HostUserId: 1}
+	database.CreateAccount(context.Background(), account)
+
+	r := loginRequest("POST", "/logout", account)
+	w := httptest.NewRecorder()
+	logoutHandler(w, r)
+
+	if currentAccount(r) != nil {
+		t.Error("Expected the session to be logged out")
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie || cookies[0].MaxAge >= 0 {
+		t.Errorf("Expected the session cookie to be cleared, got %v", w.Header()["Set-Cookie"])
+	}
+}
+
+func TestLogOutOtherSessionsHandler(t *testing.T) {
+	resetMemoryDatabase()
+	account := &Account{Id: 1, HostUserId: 1}
+	database.CreateAccount(context.Background(), account)
+
+	other := loginRequest("GET", "/", account)
+	current := loginRequest("POST", "/sessions/others/delete", account)
+	logOutOtherSessionsHandler(httptest.NewRecorder(), current)
+
+	if currentAccount(other) != nil {
+		t.Error("Expected other sessions to be logged out")
+	}
+	if currentAccount(current) == nil {
+		t.Error("Expected the current session to stay logged in")
+	}
+}
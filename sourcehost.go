@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// SourceHost is a service like Github that hosts repositories. People log in
+// to builder with it, and builder builds its repositories when its webhooks
+// post pushes and pull requests, then reports the results back to it.
+type SourceHost interface {
+	// Name is what the host is called on pages and in errors.
+	Name() string
+	// AuthorizeUrl is where people are sent to log in. Private asks for
+	// access to private repositories as well.
+	AuthorizeUrl(state string, private bool) string
+	// GetAccessToken swaps the code the host sent back after logging in for
+	// an access token.
+	GetAccessToken(code string) (string, error)
+	GetUser(accessToken string) (*HostUser, error)
+	// CloneUrl and CloneCredentials are what git retrieves a repository
+	// with.
+	CloneUrl(owner string, name string) string
+	CloneCredentials(accessToken string) Credentials
+	// IsRepositoryPrivate is true when the repository can't be seen without
+	// logging in to the host.
+	IsRepositoryPrivate(owner string, name string) (bool, error)
+	RepositoryCollaborators(accessToken string, owner string, name string) ([]Collaborator, error)
+	// Repositories returns the repositories the access token can see.
+	Repositories(accessToken string) ([]HostRepository, error)
+	// WantedHooks are the webhooks builder needs on every repository.
+	WantedHooks() []Webhook
+	// CreateHooks creates the webhooks on a repository.
+	CreateHooks(accessToken string, owner string, repo string, hooks []Webhook) ([]Webhook, error)
+	// Hooks returns every webhook on the repository, including ones builder
+	// didn't create.
+	Hooks(accessToken string, owner string, repo string) ([]Webhook, error)
+	// DeleteHook deletes a webhook. Hooks that don't exist any more are
+	// already deleted.
+	DeleteHook(accessToken string, owner string, repo string, id int) error
+	// SetStatus shows the state of a build next to its commit on the host.
+	SetStatus(accessToken string, owner string, repo string, sha string, state CommitState, targetUrl string) error
+}
+
+// sourceHosts are the hosts builder can build repositories from, by name.
+var sourceHosts = map[string]SourceHost{
+	"github": Github{},
+	"gitlab": Gitlab{},
+	"gitea":  Gitea{},
+}
+
+// hostName is the name of the host accounts and repositories are on. They
+// are on Github unless they say otherwise.
+func hostName(name string) string {
+	if name == "" {
+		return "github"
+	}
+	return name
+}
+
+// sourceHost returns the host with the name.
+func sourceHost(name string) SourceHost {
+	return sourceHosts[hostName(name)]
+}
+
+// noCommit is the sha hosts send as the new head of a deleted branch.
+const noCommit = "0000000000000000000000000000000000000000"
+
+// splitFullName splits owner/name into the owner and the name. GitLab
+// projects in subgroups have owners with slashes in them.
+func splitFullName(fullName string) (string, string) {
+	i := strings.LastIndex(fullName, "/")
+	if i < 0 {
+		return "", fullName
+	}
+	return fullName[:i], fullName[i+1:]
+}
+
+// HostUser is the profile of the user an access token belongs to. Email is
+// empty when the user keeps it private.
+type HostUser struct {
+	Id        int    `json:"id"`
+	Login     string `json:"login"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	AvatarUrl string `json:"avatar_url"`
+}
+
+// HostRepository is a repository on a source host that could be added to
+// builder.
+type HostRepository struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Private  bool   `json:"private"`
+}
+
+type Collaborator struct {
+	Id          int
+	Login       string
+	Permissions struct {
+		Admin bool
+		Push  bool
+		Pull  bool
+	}
+}
+
+// Role is the role on builder that matches the collaborator's permissions
+// on the source host.
+func (collaborator Collaborator) Role() Role {
+	switch {
+	case collaborator.Permissions.Admin:
+		return roleAdmin
+	case collaborator.Permissions.Push:
+		return roleWrite
+	}
+	return roleRead
+}
+
+// CommitState is the state of a build as shown on its commit.
+type CommitState string
+
+const (
+	statePending CommitState = "pending"
+	stateSuccess CommitState = "success"
+	stateFailure CommitState = "failure"
+)
+
+func (state CommitState) description() string {
+	switch state {
+	case statePending:
+		return "The build is running"
+	case stateSuccess:
+		return "The build passed"
+	}
+	return "The build failed"
+}
+
+// sendJson sends body to url as json, and returns the body of the response
+// as long as it has the expected status. Header is sent with the request.
+func sendJson(method string, url string, header http.Header, body interface{}, expected int) ([]byte, error) {
+	var encoded []byte
+	if body != nil {
+		encoded, _ = json.Marshal(body)
+	}
+	request, err := http.NewRequest(method, url, bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == 401 {
+		return nil, errors.New("Access Token appears to be invalid")
+	}
+	if response.StatusCode != expected {
+		return b, fmt.Errorf("Got %v:\n%v", response.StatusCode, string(b))
+	}
+	return b, nil
+}
+
+// getPages gets url and every page after it, passing the body of each page
+// to read. Header is sent with every request.
+func getPages(url string, header http.Header, read func(body []byte) error) error {
+	for url != "" {
+		request, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		for name, values := range header {
+			request.Header[name] = values
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+		if response.StatusCode != 200 {
+			return fmt.Errorf("Got %v:\n%v", response.StatusCode, string(b))
+		}
+		if err := read(b); err != nil {
+			return err
+		}
+		url = nextPageLink(response.Header.Get("Link"))
+	}
+	return nil
+}
+
+// nextPageLink returns the url of the next page in a Link header, or an
+// empty string on the last page.
+func nextPageLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestNextPageLink(t *testing.T) {
+	tests := map[string]string{
+		"": "",
+		`<https://api.github.com/repositories/1/collaborators?page=2>; rel="next", <https://api.github.com/repositories/1/collaborators?page=5>; rel="last"`:  "https://api.github.com/repositories/1/collaborators?page=2",
+		`<https://api.github.com/repositories/1/collaborators?page=1>; rel="first", <https://api.github.com/repositories/1/collaborators?page=4>; rel="prev"`: "",
+	}
+	for header, expected := range tests {
+		if actual := nextPageLink(header); actual != expected {
+			t.Errorf("Expected %q to link to %q, got %q", header, expected, actual)
+		}
+	}
+}
+
+func TestSourceHostDefaultsToGithub(t *testing.T) {
+	if sourceHost("") != sourceHosts["github"] {
+		t.Errorf("Expected accounts and repositories without a host to be on Github")
+	}
+	if _, ok := sourceHost("gitlab").(Gitlab); !ok {
+		t.Errorf("Expected gitlab to be GitLab")
+	}
+}
@@ -0,0 +1,1378 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const buildColumns = `
+  builds.id, builds.repository_id,
+  COALESCE(builds.url, ''), COALESCE(builds.owner, ''), COALESCE(builds.repository, ''),
+  COALESCE(builds.ref, ''), COALESCE(builds.sha, ''),
+  COALESCE(builds.complete, false), COALESCE(builds.success, false),
+  COALESCE(builds.result, ''), COALESCE(builds.github_url, ''),
+  COALESCE(builds.base_ref, ''), COALESCE(builds.author, ''),
+  COALESCE(builds.triggered_by, ''), COALESCE(builds.pull_request_closed, false),
+  COALESCE(builds.pruned, false), builds.created_at, builds.finished_at,
+  (SELECT host FROM repositories WHERE repositories.id = builds.repository_id)`
+
+const repositoryColumns = `
+  repositories.id, repositories.account_id, repositories.owner,
+  repositories.repository, COALESCE(repositories.public, false),
+  COALESCE(repositories.organization_id, 0), repositories.host`
+
+// accessibleRepositories selects the ids of the repositories an account can
+// see, with placeholder standing for the account id.
+func accessibleRepositories(placeholder string) string {
+	return strings.Replace(`
+    SELECT id FROM repositories WHERE account_id = ?
+    UNION
+    SELECT collaborations.repository_id FROM collaborations
+      JOIN repositories ON repositories.id = collaborations.repository_id
+      JOIN accounts ON accounts.host = repositories.host AND accounts.host_user_id = collaborations.host_user_id
+      WHERE accounts.id = ?
+    UNION
+    SELECT repositories.id FROM repositories
+      JOIN organization_members ON organization_members.organization_id = repositories.organization_id
+      WHERE organization_members.account_id = ?
+    UNION
+    SELECT team_repositories.repository_id FROM team_repositories
+      JOIN team_members ON team_members.team_id = team_repositories.team_id
+      WHERE team_members.account_id = ?`, "?", placeholder, -1)
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// sqlDatabase holds the queries shared by the postgres and sqlite backends.
+// Both drivers accept $n placeholders and RETURNING clauses.
+type sqlDatabase struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func (d *sqlDatabase) Close() error {
+	return d.db.Close()
+}
+
+func (d *sqlDatabase) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d.timeout)
+}
+
+func (d *sqlDatabase) AddRepositoryToAccount(ctx context.Context, account *Account, repository *Repository) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	if repository.Host == "" {
+		repository.Host = account.Host
+	}
+	var id int
+	err := d.db.QueryRowContext(ctx, `
+    INSERT INTO repositories (account_id, owner, repository, public, organization_id, host)
+      VALUES ($1, $2, $3, $4, $5, $6)
+      RETURNING id
+    `, account.Id, repository.Owner, repository.Repository, repository.Public, nullInt(repository.OrganizationId), hostName(repository.Host)).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	repository.Id = id
+	repository.AccountId = account.Id
+	repository.Account = account
+	account.Repositories = append(account.Repositories, repository)
+
+	return nil
+}
+
+func (d *sqlDatabase) SaveCommit(ctx context.Context, commit *Commit) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	return saveCommit(ctx, d.db, commit)
+}
+
+func (d *sqlDatabase) SaveBuild(ctx context.Context, build *Build) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	return saveBuild(ctx, d.db, build)
+}
+
+func (d *sqlDatabase) AllBuilds(ctx context.Context, account *Account) ([]*Build, error) {
+	if account == nil {
+		return []*Build{}, nil
+	}
+
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	return d.findBuilds(ctx, `
+    SELECT `+buildColumns+` FROM builds
+      WHERE builds.repository_id IN (`+accessibleRepositories("$1")+`)
+      ORDER BY builds.id
+    `, account.Id)
+}
+
+func (d *sqlDatabase) SearchBuilds(ctx context.Context, account *Account, filter BuildFilter) (*BuildPage, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, values ...interface{}) {
+		for _, value := range values {
+			args = append(args, value)
+			condition = strings.Replace(condition, "?", "$"+strconv.Itoa(len(args)), 1)
+		}
+		conditions = append(conditions, condition)
+	}
+
+	if account == nil {
+		where("builds.repository_id IN (SELECT id FROM repositories WHERE public = ?)", true)
+	} else {
+		where("builds.repository_id IN (SELECT id FROM repositories WHERE public = ? UNION "+accessibleRepositories("?")+")",
+			true, account.Id, account.Id, account.Id, account.Id)
+	}
+
+	if filter.Before != 0 {
+		where("builds.id < ?", filter.Before)
+	}
+	if filter.Repository != "" {
+		repository := strings.ToLower(filter.Repository)
+		where("(LOWER(builds.repository) = ? OR LOWER(builds.owner) || '/' || LOWER(builds.repository) = ?)", repository, repository)
+	}
+	if filter.Branch != "" {
+		where("builds.ref = ?", filter.Branch)
+	}
+	if filter.Result != "" {
+		where("builds.result = ?", filter.Result)
+	}
+	if filter.Author != "" {
+		where("LOWER(builds.author) = ?", strings.ToLower(filter.Author))
+	}
+	if filter.Trigger != "" {
+		where("builds.triggered_by = ?", filter.Trigger)
+	}
+	if !filter.Since.IsZero() {
+		where("builds.created_at >= ?", filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		where("builds.created_at < ?", filter.createdBefore().UTC())
+	}
+	if filter.Query != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(filter.Query)) + "%"
+		where(`(
+      LOWER(builds.repository) LIKE ? ESCAPE '\' OR
+      LOWER(builds.ref) LIKE ? ESCAPE '\' OR
+      LOWER(COALESCE(builds.author, '')) LIKE ? ESCAPE '\' OR
+      EXISTS (
+        SELECT 1 FROM commits
+          WHERE commits.build_id = builds.id AND LOWER(commits.message) LIKE ? ESCAPE '\'
+      )
+    )`, pattern, pattern, pattern, pattern)
+	}
+
+	query := `
+    SELECT ` + buildColumns + ` FROM builds
+      WHERE ` + strings.Join(conditions, " AND ") + `
+      ORDER BY builds.id DESC`
+	if filter.Limit > 0 {
+		// One more build than the page holds tells us whether there's
+		// another page.
+		args = append(args, filter.Limit+1)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	builds, err := d.findBuilds(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return filter.page(builds), nil
+}
+
+// likeEscaper escapes the wildcards in text searched for with LIKE.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (d *sqlDatabase) FindBuild(ctx context.Context, id int) (*Build, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	builds, err := d.findBuilds(ctx, `
+    SELECT `+buildColumns+` FROM builds
+      WHERE builds.id = $1
+    `, id)
+	if err != nil || len(builds) == 0 {
+		return nil, err
+	}
+	return builds[0], nil
+}
+
+func (d *sqlDatabase) CreateBuild(ctx context.Context, repository *Repository, build *Build) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	build.CreatedAt = time.Now().UTC().Truncate(time.Second)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, `
+    INSERT INTO builds (repository_id, created_at)
+      VALUES ($1, $2)
+      RETURNING id
+    `, repository.Id, build.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	build.Id = id
+	build.RepositoryId = repository.Id
+	build.Host = hostName(repository.Host)
+	build.Result = "incomplete"
+	build.Url = buildUrl(build)
+
+	err = saveBuild(ctx, tx, build)
+	if err != nil {
+		return err
+	}
+
+	for i := range build.Commits {
+		build.Commits[i].BuildId = build.Id
+		err = saveCommit(ctx, tx, &build.Commits[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (d *sqlDatabase) FindRepository(ctx context.Context, host string, owner string, name string) (*Repository, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	return d.findRepository(ctx, `
+    SELECT `+repositoryColumns+` FROM repositories
+      WHERE   host       = $1
+      AND     owner      = $2
+      AND     repository = $3
+      ORDER BY id
+      LIMIT 1
+    `, hostName(host), owner, name)
+}
+
+func (d *sqlDatabase) FindRepositoryById(ctx context.Context, id int) (*Repository, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	return d.findRepository(ctx, `
+    SELECT `+repositoryColumns+` FROM repositories
+      WHERE id = $1
+    `, id)
+}
+
+// findRepository finds a repository along with the account that added it.
+func (d *sqlDatabase) findRepository(ctx context.Context, query string, args ...interface{}) (*Repository, error) {
+	repository, err := scanRepository(d.db.QueryRowContext(ctx, query, args...))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	repository.Account, err = d.findAccountById(ctx, repository.AccountId)
+	if err != nil {
+		return nil, err
+	}
+	return repository, nil
+}
+
+func (d *sqlDatabase) SetRepositoryPublic(ctx context.Context, repositoryId int, public bool) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `
+    UPDATE repositories
+      SET public = $1
+      WHERE id = $2
+    `, public, repositoryId)
+	return err
+}
+
+func (d *sqlDatabase) DeleteRepository(ctx context.Context, repositoryId int) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM test_results WHERE build_id IN (SELECT id FROM builds WHERE repository_id = $1)`,
+		`DELETE FROM coverage_files WHERE build_id IN (SELECT id FROM builds WHERE repository_id = $1)`,
+		`DELETE FROM commits WHERE build_id IN (SELECT id FROM builds WHERE repository_id = $1)`,
+		`DELETE FROM builds WHERE repository_id = $1`,
+		`DELETE FROM collaborations WHERE repository_id = $1`,
+		`DELETE FROM team_repositories WHERE repository_id = $1`,
+		`DELETE FROM repository_hooks WHERE repository_id = $1`,
+		`DELETE FROM repositories WHERE id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, repositoryId); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *sqlDatabase) SaveRepositoryHooks(ctx context.Context, repositoryId int, hooks []Webhook) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM repository_hooks WHERE repository_id = $1`, repositoryId)
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		_, err = tx.ExecContext(ctx, `
+      INSERT INTO repository_hooks (repository_id, hook_id, event, url)
+      VALUES ($1, $2, $3, $4)
+    `, repositoryId, hook.Id, hook.Event, hook.Url)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *sqlDatabase) RepositoryHooks(ctx context.Context, repositoryId int) ([]Webhook, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `
+    SELECT hook_id, event, url FROM repository_hooks
+      WHERE repository_id = $1
+      ORDER BY hook_id
+  `, repositoryId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []Webhook
+	for rows.Next() {
+		var hook Webhook
+		if err := rows.Scan(&hook.Id, &hook.Event, &hook.Url); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+func (d *sqlDatabase) IncompleteBuilds(ctx context.Context) ([]*Build, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	return d.findBuilds(ctx, `
+    SELECT `+buildColumns+` FROM builds
+      WHERE COALESCE(complete, false) = $1
+      ORDER BY id
+    `, false)
+}
+
+func (d *sqlDatabase) AllRepositories(ctx context.Context) ([]*Repository, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `
+    SELECT `+repositoryColumns+` FROM repositories
+      ORDER BY id
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	repositories := []*Repository{}
+	for rows.Next() {
+		repository, err := scanRepository(rows)
+		if err != nil {
+			return nil, err
+		}
+		repositories = append(repositories, repository)
+	}
+	return repositories, rows.Err()
+}
+
+func (d *sqlDatabase) RepositoryBuilds(ctx context.Context, repository *Repository) ([]*Build, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	return d.findBuilds(ctx, `
+    SELECT `+buildColumns+` FROM builds
+      WHERE repository_id = $1
+      ORDER BY id
+    `, repository.Id)
+}
+
+func (d *sqlDatabase) FindAccountById(ctx context.Context, id int) (*Account, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	return d.findAccountById(ctx, id)
+}
+
+const accountColumns = `id, access_token, login, name, email, avatar_url, host, host_user_id`
+
+func scanAccount(s scanner) (*Account, error) {
+	account := &Account{}
+	err := s.Scan(&account.Id, &account.AccessToken, &account.Login, &account.Name, &account.Email, &account.AvatarUrl, &account.Host, &account.HostUserId)
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (d *sqlDatabase) findAccountById(ctx context.Context, id int) (*Account, error) {
+	account, err := scanAccount(d.db.QueryRowContext(ctx, `
+    SELECT `+accountColumns+` FROM accounts
+      WHERE id = $1
+  `, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.QueryContext(ctx, `
+  SELECT `+repositoryColumns+` FROM repositories
+    WHERE account_id = $1
+    ORDER BY id
+  `, account.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		repository, err := scanRepository(rows)
+		if err != nil {
+			return nil, err
+		}
+		repository.Account = account
+		account.Repositories = append(account.Repositories, repository)
+	}
+	return account, rows.Err()
+}
+
+func (d *sqlDatabase) CreateAccount(ctx context.Context, account *Account) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	columns := `access_token, login, name, email, avatar_url, host, host_user_id`
+	values := `$1, $2, $3, $4, $5, $6, $7`
+	args := []interface{}{account.AccessToken, account.Login, account.Name, account.Email, account.AvatarUrl, hostName(account.Host), account.HostUserId}
+	if account.Id != 0 {
+		columns += `, id`
+		values += `, $8`
+		args = append(args, account.Id)
+	}
+
+	return d.db.QueryRowContext(ctx, `
+      INSERT INTO accounts (`+columns+`)
+      VALUES (`+values+`)
+      ON CONFLICT (host, host_user_id) DO UPDATE SET
+        access_token = excluded.access_token,
+        login = excluded.login,
+        name = excluded.name,
+        email = excluded.email,
+        avatar_url = excluded.avatar_url
+      RETURNING id
+    `, args...).Scan(&account.Id)
+}
+
+func (d *sqlDatabase) FindAccountsByLogin(ctx context.Context, host string, logins []string) ([]*Account, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	if len(logins) == 0 {
+		return nil, nil
+	}
+	placeholders := []string{}
+	values := []interface{}{hostName(host)}
+	for i, login := range logins {
+		placeholders = append(placeholders, "$"+strconv.Itoa(i+2))
+		values = append(values, strings.ToLower(login))
+	}
+	rows, err := d.db.QueryContext(ctx, `
+    SELECT `+accountColumns+` FROM accounts
+      WHERE host = $1 AND login <> '' AND LOWER(login) IN (`+strings.Join(placeholders, ", ")+`)
+      ORDER BY id
+  `, values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []*Account
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+func (d *sqlDatabase) AllAccounts(ctx context.Context) ([]*Account, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `
+    SELECT `+accountColumns+` FROM accounts
+      ORDER BY id
+  `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []*Account
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+func (d *sqlDatabase) SetAccessToken(ctx context.Context, accountId int, accessToken string) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `
+      UPDATE accounts SET access_token = $1
+      WHERE id = $2
+    `, accessToken, accountId)
+	return err
+}
+
+const sessionColumns = `id, account_id, csrf_token, created_at, expires_at`
+
+func scanSession(s scanner) (*Session, error) {
+	session := &Session{}
+	err := s.Scan(&session.Id, &session.AccountId, &session.CsrfToken, &session.CreatedAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (d *sqlDatabase) CreateSession(ctx context.Context, session *Session) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `
+      INSERT INTO sessions (id, account_id, csrf_token, created_at, expires_at)
+      VALUES ($1, $2, $3, $4, $5)
+    `, session.Id, session.AccountId, session.CsrfToken, session.CreatedAt.UTC(), session.ExpiresAt.UTC())
+	return err
+}
+
+func (d *sqlDatabase) FindSession(ctx context.Context, id string) (*Session, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	session, err := scanSession(d.db.QueryRowContext(ctx, `
+      SELECT `+sessionColumns+` FROM sessions
+      WHERE id = $1
+    `, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return session, err
+}
+
+func (d *sqlDatabase) AccountSessions(ctx context.Context, accountId int, now time.Time) ([]*Session, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `
+      SELECT `+sessionColumns+` FROM sessions
+      WHERE account_id = $1 AND expires_at > $2
+      ORDER BY created_at DESC, id
+    `, accountId, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (d *sqlDatabase) DeleteSession(ctx context.Context, id string) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1`, id)
+	return err
+}
+
+func (d *sqlDatabase) DeleteAccountSessions(ctx context.Context, accountId int, except string) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `
+      DELETE FROM sessions
+      WHERE account_id = $1 AND id <> $2
+    `, accountId, except)
+	return err
+}
+
+func (d *sqlDatabase) DeleteExpiredSessions(ctx context.Context, now time.Time) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= $1`, now.UTC())
+	return err
+}
+
+func (d *sqlDatabase) SaveCollaboration(ctx context.Context, hostUserId int, repositoryId int, role Role) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `
+      INSERT INTO collaborations (host_user_id, repository_id, role)
+      VALUES ($1, $2, $3)
+    `, hostUserId, repositoryId, string(role))
+	return err
+}
+
+func (d *sqlDatabase) ReplaceCollaborations(ctx context.Context, repositoryId int, roles map[int]Role) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM collaborations WHERE repository_id = $1`, repositoryId)
+	if err != nil {
+		return err
+	}
+	for hostUserId, role := range roles {
+		_, err = tx.ExecContext(ctx, `
+      INSERT INTO collaborations (host_user_id, repository_id, role)
+      VALUES ($1, $2, $3)
+    `, hostUserId, repositoryId, string(role))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *sqlDatabase) RepositoryRole(ctx context.Context, accountId int, repositoryId int) (Role, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `
+    SELECT 'admin' FROM repositories
+      WHERE account_id = $1 AND id = $2
+    UNION ALL
+    SELECT collaborations.role FROM collaborations
+      JOIN repositories ON repositories.id = collaborations.repository_id
+      JOIN accounts ON accounts.host = repositories.host AND accounts.host_user_id = collaborations.host_user_id
+      WHERE accounts.id = $1 AND collaborations.repository_id = $2
+    UNION ALL
+    SELECT organization_members.role FROM organization_members
+      JOIN repositories ON repositories.organization_id = organization_members.organization_id
+      WHERE organization_members.account_id = $1 AND repositories.id = $2
+    UNION ALL
+    SELECT teams.role FROM teams
+      JOIN team_members ON team_members.team_id = teams.id
+      JOIN team_repositories ON team_repositories.team_id = teams.id
+      WHERE team_members.account_id = $1 AND team_repositories.repository_id = $2
+    `, accountId, repositoryId)
+	if err != nil {
+		return roleNone, err
+	}
+	defer rows.Close()
+
+	role := roleNone
+	for rows.Next() {
+		var granted string
+		if err := rows.Scan(&granted); err != nil {
+			return roleNone, err
+		}
+		role = highestRole(role, Role(granted))
+	}
+	return role, rows.Err()
+}
+
+func (d *sqlDatabase) CreateOrganization(ctx context.Context, organization *Organization, admin *Account) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+    INSERT INTO organizations (name, host)
+      VALUES ($1, $2)
+      RETURNING id
+    `, organization.Name, hostName(admin.Host)).Scan(&organization.Id)
+	if err != nil {
+		return err
+	}
+	organization.Host = hostName(admin.Host)
+	_, err = tx.ExecContext(ctx, `
+    INSERT INTO organization_members (organization_id, account_id, role)
+      VALUES ($1, $2, $3)
+    `, organization.Id, admin.Id, string(roleAdmin))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *sqlDatabase) FindOrganization(ctx context.Context, name string) (*Organization, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	organization := &Organization{}
+	err := d.db.QueryRowContext(ctx, `
+    SELECT id, name, host FROM organizations
+      WHERE LOWER(name) = $1
+    `, strings.ToLower(name)).Scan(&organization.Id, &organization.Name, &organization.Host)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return organization, nil
+}
+
+func (d *sqlDatabase) AccountOrganizations(ctx context.Context, accountId int) ([]*Organization, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `
+    SELECT organizations.id, organizations.name, organizations.host FROM organizations
+      JOIN organization_members ON organization_members.organization_id = organizations.id
+      WHERE organization_members.account_id = $1
+      ORDER BY LOWER(organizations.name)
+    `, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var organizations []*Organization
+	for rows.Next() {
+		organization := &Organization{}
+		if err := rows.Scan(&organization.Id, &organization.Name, &organization.Host); err != nil {
+			return nil, err
+		}
+		organizations = append(organizations, organization)
+	}
+	return organizations, rows.Err()
+}
+
+func (d *sqlDatabase) OrganizationMembers(ctx context.Context, organizationId int) ([]*OrganizationMember, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `
+    SELECT organization_members.role, `+accountColumns+` FROM organization_members
+      JOIN accounts ON accounts.id = organization_members.account_id
+      WHERE organization_members.organization_id = $1
+      ORDER BY LOWER(accounts.login), accounts.id
+    `, organizationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []*OrganizationMember
+	for rows.Next() {
+		member := &OrganizationMember{Account: &Account{}}
+		var role string
+		err := rows.Scan(&role, &member.Account.Id, &member.Account.AccessToken, &member.Account.Login,
+			&member.Account.Name, &member.Account.Email, &member.Account.AvatarUrl, &member.Account.Host, &member.Account.HostUserId)
+		if err != nil {
+			return nil, err
+		}
+		member.Role = Role(role)
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (d *sqlDatabase) SaveOrganizationMember(ctx context.Context, organizationId int, accountId int, role Role) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `
+    INSERT INTO organization_members (organization_id, account_id, role)
+      VALUES ($1, $2, $3)
+      ON CONFLICT (organization_id, account_id) DO UPDATE SET role = excluded.role
+    `, organizationId, accountId, string(role))
+	return err
+}
+
+func (d *sqlDatabase) DeleteOrganizationMember(ctx context.Context, organizationId int, accountId int) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+    DELETE FROM team_members
+      WHERE account_id = $1
+      AND team_id IN (SELECT id FROM teams WHERE organization_id = $2)
+    `, accountId, organizationId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+    DELETE FROM organization_members
+      WHERE organization_id = $1 AND account_id = $2
+    `, organizationId, accountId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *sqlDatabase) CreateTeam(ctx context.Context, team *Team) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	return d.db.QueryRowContext(ctx, `
+    INSERT INTO teams (organization_id, name, role)
+      VALUES ($1, $2, $3)
+      RETURNING id
+    `, team.OrganizationId, team.Name, string(team.Role)).Scan(&team.Id)
+}
+
+func (d *sqlDatabase) OrganizationTeams(ctx context.Context, organizationId int) ([]*Team, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `
+    SELECT id, organization_id, name, role FROM teams
+      WHERE organization_id = $1
+      ORDER BY LOWER(name)
+    `, organizationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var teams []*Team
+	byId := map[int]*Team{}
+	for rows.Next() {
+		team := &Team{}
+		var role string
+		if err := rows.Scan(&team.Id, &team.OrganizationId, &team.Name, &role); err != nil {
+			return nil, err
+		}
+		team.Role = Role(role)
+		teams = append(teams, team)
+		byId[team.Id] = team
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = d.findTeamLinks(ctx, organizationId, "team_members", "account_id", func(teamId int, id int) {
+		byId[teamId].MemberIds = append(byId[teamId].MemberIds, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = d.findTeamLinks(ctx, organizationId, "team_repositories", "repository_id", func(teamId int, id int) {
+		byId[teamId].RepositoryIds = append(byId[teamId].RepositoryIds, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return teams, nil
+}
+
+// findTeamLinks reads the ids a table links to the teams of an
+// organization.
+func (d *sqlDatabase) findTeamLinks(ctx context.Context, organizationId int, table string, column string, add func(teamId int, id int)) error {
+	rows, err := d.db.QueryContext(ctx, `
+    SELECT `+table+`.team_id, `+table+`.`+column+` FROM `+table+`
+      JOIN teams ON teams.id = `+table+`.team_id
+      WHERE teams.organization_id = $1
+      ORDER BY `+table+`.`+column+`
+    `, organizationId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var teamId, id int
+		if err := rows.Scan(&teamId, &id); err != nil {
+			return err
+		}
+		add(teamId, id)
+	}
+	return rows.Err()
+}
+
+func (d *sqlDatabase) AddTeamMember(ctx context.Context, teamId int, accountId int) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `
+    INSERT INTO team_members (team_id, account_id)
+      VALUES ($1, $2)
+      ON CONFLICT DO NOTHING
+    `, teamId, accountId)
+	return err
+}
+
+func (d *sqlDatabase) AddTeamRepository(ctx context.Context, teamId int, repositoryId int) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `
+    INSERT INTO team_repositories (team_id, repository_id)
+      VALUES ($1, $2)
+      ON CONFLICT DO NOTHING
+    `, teamId, repositoryId)
+	return err
+}
+
+func (d *sqlDatabase) SaveTestResults(ctx context.Context, build *Build, results []TestResult) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range results {
+		result := &results[i]
+		result.BuildId = build.Id
+		err := tx.QueryRowContext(ctx, `
+      INSERT INTO test_results (build_id, class_name, name, duration, status, message, line)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id
+      `,
+			result.BuildId,
+			result.ClassName,
+			result.Name,
+			int64(result.Duration/time.Millisecond),
+			result.Status,
+			result.Message,
+			result.Line,
+		).Scan(&result.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *sqlDatabase) TestResults(ctx context.Context, builds []*Build) ([]TestResult, error) {
+	results := []TestResult{}
+	if len(builds) == 0 {
+		return results, nil
+	}
+
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	placeholders, buildIds := buildIdPlaceholders(builds)
+	rows, err := d.db.QueryContext(ctx, `
+    SELECT id, build_id, class_name, name, duration, status, message, line
+      FROM test_results
+      WHERE build_id IN (`+placeholders+`)
+      ORDER BY build_id, id
+    `, buildIds...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result TestResult
+		var milliseconds int64
+		err := rows.Scan(
+			&result.Id,
+			&result.BuildId,
+			&result.ClassName,
+			&result.Name,
+			&milliseconds,
+			&result.Status,
+			&result.Message,
+			&result.Line,
+		)
+		if err != nil {
+			return nil, err
+		}
+		result.Duration = time.Duration(milliseconds) * time.Millisecond
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func (d *sqlDatabase) SaveCoverage(ctx context.Context, build *Build, files []FileCoverage) error {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range files {
+		file := &files[i]
+		file.BuildId = build.Id
+		err := tx.QueryRowContext(ctx, `
+      INSERT INTO coverage_files (build_id, path, covered, total)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id
+      `, file.BuildId, file.Path, file.Covered, file.Total,
+		).Scan(&file.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *sqlDatabase) CoverageFiles(ctx context.Context, build *Build) ([]FileCoverage, error) {
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `
+    SELECT id, build_id, path, covered, total
+      FROM coverage_files
+      WHERE build_id = $1
+      ORDER BY path
+    `, build.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []FileCoverage{}
+	for rows.Next() {
+		var file FileCoverage
+		err := rows.Scan(&file.Id, &file.BuildId, &file.Path, &file.Covered, &file.Total)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+func (d *sqlDatabase) findBuilds(ctx context.Context, query string, args ...interface{}) ([]*Build, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	builds := []*Build{}
+	for rows.Next() {
+		build, err := scanBuild(rows)
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, build)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadCommits(ctx, d.db, builds); err != nil {
+		return nil, err
+	}
+	if err := loadTestSummaries(ctx, d.db, builds); err != nil {
+		return nil, err
+	}
+	return builds, loadCoverageSummaries(ctx, d.db, builds)
+}
+
+func saveCommit(ctx context.Context, db rowQueryer, commit *Commit) error {
+	return db.QueryRowContext(ctx, `
+    INSERT INTO commits (build_id, sha, message, url)
+      VALUES ($1, $2, $3, $4)
+      RETURNING id
+    `, commit.BuildId, commit.Sha, commit.Message, commit.Url,
+	).Scan(&commit.Id)
+}
+
+func saveBuild(ctx context.Context, db execer, build *Build) error {
+	_, err := db.ExecContext(ctx, `
+    UPDATE builds
+      SET
+        url = $1, owner = $2, repository = $3, ref = $4, sha = $5,
+        complete = $6, success = $7, result = $8, github_url = $9,
+        base_ref = $10, author = $11, triggered_by = $12,
+        pull_request_closed = $13, pruned = $14, finished_at = $15
+      WHERE id = $16
+	`,
+		build.Url,
+		build.Owner,
+		build.Repository,
+		build.Ref,
+		build.Sha,
+		build.Complete,
+		build.Success,
+		build.Result,
+		build.GithubUrl,
+		build.BaseRef,
+		build.Author,
+		build.Trigger,
+		build.PullRequestClosed,
+		build.Pruned,
+		nullTime(build.FinishedAt),
+		build.Id,
+	)
+	return err
+}
+
+// buildIdPlaceholders returns the placeholders and arguments for a
+// "build_id IN (...)" clause.
+func buildIdPlaceholders(builds []*Build) (string, []interface{}) {
+	var placeholders []string
+	var buildIds []interface{}
+	for _, build := range builds {
+		buildIds = append(buildIds, build.Id)
+		placeholders = append(placeholders, "$"+strconv.Itoa(len(buildIds)))
+	}
+	return strings.Join(placeholders, ", "), buildIds
+}
+
+func loadCommits(ctx context.Context, db queryer, builds []*Build) error {
+	if len(builds) == 0 {
+		return nil
+	}
+
+	buildsById := map[int]*Build{}
+	for _, build := range builds {
+		buildsById[build.Id] = build
+	}
+
+	placeholders, buildIds := buildIdPlaceholders(builds)
+	rows, err := db.QueryContext(ctx, `
+    SELECT id, build_id, COALESCE(sha, ''), COALESCE(message, ''), COALESCE(url, '')
+      FROM commits
+      WHERE build_id IN (`+placeholders+`)
+      ORDER BY id
+    `, buildIds...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var commit Commit
+		err := rows.Scan(&commit.Id, &commit.BuildId, &commit.Sha, &commit.Message, &commit.Url)
+		if err != nil {
+			return err
+		}
+		build := buildsById[commit.BuildId]
+		build.Commits = append(build.Commits, commit)
+	}
+	return rows.Err()
+}
+
+func loadTestSummaries(ctx context.Context, db queryer, builds []*Build) error {
+	if len(builds) == 0 {
+		return nil
+	}
+
+	buildsById := map[int]*Build{}
+	for _, build := range builds {
+		buildsById[build.Id] = build
+	}
+
+	placeholders, buildIds := buildIdPlaceholders(builds)
+	rows, err := db.QueryContext(ctx, `
+    SELECT build_id, status, COUNT(*), SUM(duration)
+      FROM test_results
+      WHERE build_id IN (`+placeholders+`)
+      AND name <> ''
+      GROUP BY build_id, status
+    `, buildIds...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var buildId, count int
+		var status string
+		var milliseconds int64
+		if err := rows.Scan(&buildId, &status, &count, &milliseconds); err != nil {
+			return err
+		}
+		build := buildsById[buildId]
+		if build.Tests == nil {
+			build.Tests = &TestSummary{}
+		}
+		build.Tests.add(status, count, time.Duration(milliseconds)*time.Millisecond)
+	}
+	return rows.Err()
+}
+
+func loadCoverageSummaries(ctx context.Context, db queryer, builds []*Build) error {
+	if len(builds) == 0 {
+		return nil
+	}
+
+	buildsById := map[int]*Build{}
+	for _, build := range builds {
+		buildsById[build.Id] = build
+	}
+
+	placeholders, buildIds := buildIdPlaceholders(builds)
+	rows, err := db.QueryContext(ctx, `
+    SELECT build_id, SUM(covered), SUM(total)
+      FROM coverage_files
+      WHERE build_id IN (`+placeholders+`)
+      GROUP BY build_id
+    `, buildIds...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var buildId int
+		summary := &CoverageSummary{}
+		if err := rows.Scan(&buildId, &summary.Covered, &summary.Total); err != nil {
+			return err
+		}
+		buildsById[buildId].Coverage = summary
+	}
+	return rows.Err()
+}
+
+func scanBuild(s scanner) (*Build, error) {
+	build := &Build{}
+	var finishedAt sql.NullTime
+	var host sql.NullString
+	err := s.Scan(
+		&build.Id,
+		&build.RepositoryId,
+		&build.Url,
+		&build.Owner,
+		&build.Repository,
+		&build.Ref,
+		&build.Sha,
+		&build.Complete,
+		&build.Success,
+		&build.Result,
+		&build.GithubUrl,
+		&build.BaseRef,
+		&build.Author,
+		&build.Trigger,
+		&build.PullRequestClosed,
+		&build.Pruned,
+		&build.CreatedAt,
+		&finishedAt,
+		&host,
+	)
+	if err != nil {
+		return nil, err
+	}
+	build.FinishedAt = finishedAt.Time
+	build.Host = host.String
+	return build, nil
+}
+
+// nullTime stores zero times as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func nullInt(i int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(i), Valid: i != 0}
+}
+
+func scanRepository(s scanner) (*Repository, error) {
+	repository := &Repository{}
+	err := s.Scan(
+		&repository.Id,
+		&repository.AccountId,
+		&repository.Owner,
+		&repository.Repository,
+		&repository.Public,
+		&repository.OrganizationId,
+		&repository.Host,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return repository, nil
+}
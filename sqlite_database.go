@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type SQLiteDatabase struct {
+	*sqlDatabase
+}
+
+// NewSQLiteDatabase opens the sqlite database at path. SQLite only allows
+// one writer at a time, so the pool is limited to a single connection.
+func NewSQLiteDatabase(path string, timeout time.Duration) (*SQLiteDatabase, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_foreign_keys=1")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	s := &SQLiteDatabase{&sqlDatabase{db: db, timeout: timeout}}
+
+	ctx, cancel := s.context(context.Background())
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLiteDatabase) Migrator() *Migrator {
+	return newMigrator(s.db, "db/sqlite/migrations", `
+    CREATE TABLE IF NOT EXISTS goose_db_version (
+      id         INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+      version_id INTEGER NOT NULL,
+      is_applied BOOLEAN NOT NULL,
+      tstamp     TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    )
+  `)
+}
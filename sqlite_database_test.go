@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func createCleanSQLiteDatabase(t *testing.T) *SQLiteDatabase {
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "builder.db"), configuration.DatabaseTimeout)
+	if err != nil {
+		t.Fatalf("Couldn't open sqlite database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Migrator().Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSQLiteDatabase(t *testing.T) {
+	testDatabaseContract(t, func(t *testing.T) Database {
+		return createCleanSQLiteDatabase(t)
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	testPassed  = "passed"
+	testFailed  = "failed"
+	testSkipped = "skipped"
+)
+
+// TestResult is the result of a single test. Go test reports also have a
+// result for each package, which has the package as the ClassName and an
+// empty Name.
+type TestResult struct {
+	Id        int
+	BuildId   int
+	ClassName string
+	Name      string
+	Duration  time.Duration
+	// Status is one of passed, failed or skipped.
+	Status  string
+	Message string
+	// Line is the line of the build output a failing test starts on,
+	// counting from 1, or 0 when the test isn't in the output.
+	Line int
+}
+
+func (result TestResult) isPackage() bool {
+	return result.Name == ""
+}
+
+type TestSummary struct {
+	Total    int
+	Passed   int
+	Failed   int
+	Skipped  int
+	Duration time.Duration
+}
+
+func (summary *TestSummary) add(status string, count int, duration time.Duration) {
+	summary.Total += count
+	summary.Duration += duration
+	switch status {
+	case testPassed:
+		summary.Passed += count
+	case testFailed:
+		summary.Failed += count
+	case testSkipped:
+		summary.Skipped += count
+	}
+}
+
+func summariseTestResults(results []TestResult) *TestSummary {
+	var summary *TestSummary
+	for _, result := range results {
+		if result.isPackage() {
+			continue
+		}
+		if summary == nil {
+			summary = &TestSummary{}
+		}
+		summary.add(result.Status, 1, result.Duration)
+	}
+	return summary
+}
+
+// TestHistory is how one test did over a run of builds. Results has one
+// entry for every build, with an empty Status when the test didn't run.
+type TestHistory struct {
+	ClassName string
+	Name      string
+	Results   []TestResult
+	// Flaky tests both passed and failed in the same run of builds.
+	Flaky bool
+}
+
+// testHistories lines up the results of every test across builds. Flaky
+// tests come first.
+func testHistories(builds []*Build, results []TestResult) []*TestHistory {
+	column := map[int]int{}
+	for i, build := range builds {
+		column[build.Id] = i
+	}
+
+	type key struct{ className, name string }
+	histories := map[key]*TestHistory{}
+	var ordered []*TestHistory
+	for _, result := range results {
+		if result.isPackage() {
+			continue
+		}
+		k := key{result.ClassName, result.Name}
+		history, ok := histories[k]
+		if !ok {
+			history = &TestHistory{
+				ClassName: result.ClassName,
+				Name:      result.Name,
+				Results:   make([]TestResult, len(builds)),
+			}
+			histories[k] = history
+			ordered = append(ordered, history)
+		}
+		if i, ok := column[result.BuildId]; ok {
+			history.Results[i] = result
+		}
+	}
+
+	for _, history := range ordered {
+		passed, failed := false, false
+		for _, result := range history.Results {
+			passed = passed || result.Status == testPassed
+			failed = failed || result.Status == testFailed
+		}
+		history.Flaky = passed && failed
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Flaky && !ordered[j].Flaky
+	})
+	return ordered
+}
+
+// testReports are the Builderfile directives that point at test reports,
+// along with the parser for each kind of report.
+var testReports = []struct {
+	directive string
+	parse     func(report io.Reader) ([]TestResult, error)
+}{
+	{"junit", parseJUnit},
+	{"go-test-json", parseGoTestJSON},
+}
+
+// collectTestResults saves the results from the test reports the
+// Builderfile points at with directives like "# builder: junit <glob>".
+func (build *Build) collectTestResults(output io.Writer, directives map[string][]string) {
+	var results []TestResult
+	for _, report := range testReports {
+		build.readReports(output, directives, report.directive, func(r io.Reader) error {
+			reportResults, err := report.parse(r)
+			if err != nil {
+				return err
+			}
+			results = append(results, reportResults...)
+			return nil
+		})
+	}
+
+	if len(results) == 0 {
+		return
+	}
+	if buildOutput, err := logStore.ReadFrom(build, 0); err == nil {
+		findTestOutputLines(buildOutput, results)
+	}
+	err := database.SaveTestResults(context.Background(), build, results)
+	if err != nil {
+		log.Println("Error saving test results:", err)
+	}
+}
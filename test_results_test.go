@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTestHistoriesPutFlakyTestsFirst(t *testing.T) {
+	builds := []*Build{{Id: 1}, {Id: 2}, {Id: 3}}
+	results := []TestResult{
+		{BuildId: 1, ClassName: "BuildTest", Name: "stable", Status: testPassed},
+		{BuildId: 1, ClassName: "BuildTest", Name: "flaky", Status: testPassed},
+		{BuildId: 2, ClassName: "BuildTest", Name: "stable", Status: testPassed},
+		{BuildId: 2, ClassName: "BuildTest", Name: "flaky", Status: testFailed},
+		{BuildId: 3, ClassName: "BuildTest", Name: "flaky", Status: testPassed},
+	}
+
+	histories := testHistories(builds, results)
+	if len(histories) != 2 {
+		t.Fatalf("Expected a history for each test, got %+v", histories)
+	}
+
+	flaky := histories[0]
+	if flaky.Name != "flaky" || !flaky.Flaky {
+		t.Errorf("Expected the flaky test first, got %+v", flaky)
+	}
+	stable := histories[1]
+	if stable.Name != "stable" || stable.Flaky {
+		t.Errorf("Expected the stable test not to be flaky, got %+v", stable)
+	}
+
+	var statuses []string
+	for _, result := range stable.Results {
+		statuses = append(statuses, result.Status)
+	}
+	if expected := []string{testPassed, testPassed, ""}; !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("Expected a result for every build, got %v", statuses)
+	}
+}
+
+func TestBuildCollectsJUnitResults(t *testing.T) {
+	defer cleanDataDirectory()
+
+	fakeGit.FakeRepo = "junit"
+	resetMemoryDatabase()
+	createAccountWithRepository(&Account{AccessToken: "sdsd"}, "some-owner", "some-repo")
+	build := &Build{Owner: "some-owner", Repository: "some-repo"}
+
+	build.start()
+
+	if build.Success {
+		t.Error("Build should have failed")
+	}
+
+	results, _ := database.TestResults(context.Background(), []*Build{build})
+	expected := []TestResult{
+		{ClassName: "BuildTest", Name: "test_passes", Duration: 500 * time.Millisecond, Status: testPassed},
+		{ClassName: "BuildTest", Name: "test_fails", Duration: 1250 * time.Millisecond, Status: testFailed, Message: "expected pass, got fail"},
+	}
+	for i := range results {
+		results[i].Id = 0
+		results[i].BuildId = 0
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Expected results:\n%+v\nGot:\n%+v", expected, results)
+	}
+
+	if output := build.ReadOutput(); strings.Contains(output, "Error") {
+		t.Errorf("Expected no errors collecting the results, got:\n%v", output)
+	}
+}
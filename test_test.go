@@ -1,39 +1,82 @@
 package main
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"sync"
+	"time"
 )
 
 var fakeGit *FakeGit
-var fakeDatabase *FakeDatabase
+var memoryDatabase *MemoryDatabase
 
 func init() {
 	resetFakeGit()
-	resetFakeDatabase()
+	resetMemoryDatabase()
 }
 
-func resetFakeDatabase() {
-	fakeDatabase = &FakeDatabase{}
-	database = fakeDatabase
+func resetMemoryDatabase() {
+	memoryDatabase = NewMemoryDatabase()
+	database = memoryDatabase
 }
 
+// resetFakeGit fakes git and Github. Accounts and repositories without a
+// host are on Github, so they use the fake as well.
 func resetFakeGit() {
 	fakeGit = &FakeGit{}
 	git = fakeGit
+	sourceHosts["github"] = fakeGit
+}
+
+func createAccountWithRepository(account *Account, owner string, name string) *Repository {
+	ctx := context.Background()
+	database.CreateAccount(ctx, account)
+	repository := &Repository{Owner: owner, Repository: name}
+	database.AddRepositoryToAccount(ctx, account, repository)
+	return repository
+}
+
+func loginRequest(method string, url string, account *Account) *http.Request {
+	r, _ := http.NewRequest(method, url, nil)
+	session, _ := newSession(account, time.Now())
+	database.CreateSession(context.Background(), session)
+	r.AddCookie(sessionCookieFor(session))
+	return r
 }
 
 type FakeGit struct {
 	FakeRepo                  string
-	UserIdToReturn            int
+	UserToReturn              HostUser
 	AccessTokenToReturn       string
 	createHooksParameters     map[string]interface{}
+	retrieveParameters        map[string]string
 	IsRepositoryPrivateResult bool
+	IsRepositoryPrivateError  error
 	CollaboratorsToReturn     []Collaborator
-}
-
-func (g *FakeGit) Retrieve(log io.Writer, url string, path string, branch string, sha string) error {
+	AccessTokenError          error
+	UserError                 error
+	CollaboratorsError        error
+	RepositoriesToReturn      []HostRepository
+	// Webhooks are the hooks on Github, which CreateHooks and DeleteHook
+	// change.
+	Webhooks     []Webhook
+	HooksError   error
+	deletedHooks []int
+	lastHookId   int
+
+	statusesMutex sync.Mutex
+	statuses      []CommitState
+}
+
+func (g *FakeGit) Retrieve(log io.Writer, url string, credentials Credentials, path string, branch string, sha string) error {
+	g.retrieveParameters = map[string]string{
+		"url":      url,
+		"username": credentials.Username,
+		"password": credentials.Password,
+	}
 	files, _ := ioutil.ReadDir("test-repos/" + g.FakeRepo)
 	os.MkdirAll(path, 0700)
 	for _, file := range files {
@@ -43,98 +86,95 @@ func (g *FakeGit) Retrieve(log io.Writer, url string, path string, branch string
 	return nil
 }
 
-func (g *FakeGit) CreateHooks(accessToken string, owner string, repo string) error {
-	g.createHooksParameters = map[string]interface{}{
-		"accessToken": accessToken,
-		"owner":       owner,
-		"repository":  repo,
-	}
-	return nil
-}
-
-func (g *FakeGit) GetAccessToken(clientId string, clientSecret string, code string) (string, error) {
-	return g.AccessTokenToReturn, nil
+func (g *FakeGit) Name() string {
+	return "Github"
 }
 
-func (g *FakeGit) GetUserID(accessToken string) (int, error) {
-	return g.UserIdToReturn, nil
+func (g *FakeGit) AuthorizeUrl(state string, private bool) string {
+	return Github{}.AuthorizeUrl(state, private)
 }
 
-func (g *FakeGit) IsRepositoryPrivate(owner string, name string) bool {
-	return g.IsRepositoryPrivateResult
+func (g *FakeGit) CloneUrl(owner string, name string) string {
+	return Github{}.CloneUrl(owner, name)
 }
 
-type FakeDatabase struct {
-	SavedRepository         *Repository
-	CreatedAccount          *Account
-	LoginToReturn           *Login
-	FindAccountByIdToReturn *Account
-	AddedCollaborations     []map[string]int
+func (g *FakeGit) CloneCredentials(accessToken string) Credentials {
+	return Github{}.CloneCredentials(accessToken)
 }
 
-func (g *FakeGit) RepositoryCollaborators(accessToken string, owner string, name string) []Collaborator {
-	return g.CollaboratorsToReturn
+func (g *FakeGit) WantedHooks() []Webhook {
+	return Github{}.WantedHooks()
 }
 
-func (f *FakeDatabase) AddRepositoryToAccount(account *Account, repository *Repository) error {
-	f.SavedRepository = repository
-	return nil
-}
-
-func (f *FakeDatabase) SaveCommit(commit *Commit) error {
-	return nil
+func (g *FakeGit) CreateHooks(accessToken string, owner string, repo string, hooks []Webhook) ([]Webhook, error) {
+	g.createHooksParameters = map[string]interface{}{
+		"accessToken": accessToken,
+		"owner":       owner,
+		"repository":  repo,
+	}
+	var created []Webhook
+	for _, hook := range hooks {
+		g.lastHookId++
+		hook.Id = g.lastHookId
+		g.Webhooks = append(g.Webhooks, hook)
+		created = append(created, hook)
+	}
+	return created, nil
 }
 
-func (f *FakeDatabase) SaveBuild(build *Build) error {
-	return nil
+func (g *FakeGit) Hooks(accessToken string, owner string, repo string) ([]Webhook, error) {
+	return g.Webhooks, g.HooksError
 }
 
-func (f *FakeDatabase) AllBuilds(account *Account) []*Build {
+func (g *FakeGit) DeleteHook(accessToken string, owner string, repo string, id int) error {
+	var kept []Webhook
+	for _, hook := range g.Webhooks {
+		if hook.Id != id {
+			kept = append(kept, hook)
+		}
+	}
+	g.Webhooks = kept
+	g.deletedHooks = append(g.deletedHooks, id)
 	return nil
 }
 
-func (f *FakeDatabase) FindPublicBuilds() []*Build {
-	return nil
+func (g *FakeGit) Repositories(accessToken string) ([]HostRepository, error) {
+	return g.RepositoriesToReturn, nil
 }
 
-func (f *FakeDatabase) CreateBuild(githubBuild *Repository, build *Build) error {
-	return nil
+func (g *FakeGit) GetAccessToken(code string) (string, error) {
+	return g.AccessTokenToReturn, g.AccessTokenError
 }
 
-func (f *FakeDatabase) FindRepository(owner string, repository string) *Repository {
-	if f.SavedRepository != nil {
-		if f.SavedRepository.Owner == owner && f.SavedRepository.Repository == repository {
-			return f.SavedRepository
-		}
+func (g *FakeGit) GetUser(accessToken string) (*HostUser, error) {
+	if g.UserError != nil {
+		return nil, g.UserError
 	}
-	return nil
+	user := g.UserToReturn
+	return &user, nil
 }
 
-func (f *FakeDatabase) IncompleteBuilds() []*Build {
-	return nil
+func (g *FakeGit) IsRepositoryPrivate(owner string, name string) (bool, error) {
+	return g.IsRepositoryPrivateResult, g.IsRepositoryPrivateError
 }
 
-func (f *FakeDatabase) FindAccountById(id int) *Account {
-	return f.FindAccountByIdToReturn
+func (g *FakeGit) RepositoryCollaborators(accessToken string, owner string, name string) ([]Collaborator, error) {
+	if g.CollaboratorsError != nil {
+		return nil, g.CollaboratorsError
+	}
+	return g.CollaboratorsToReturn, nil
 }
 
-func (f *FakeDatabase) CreateAccount(account *Account) error {
-	f.CreatedAccount = account
+func (g *FakeGit) SetStatus(accessToken string, owner string, repo string, sha string, state CommitState, targetUrl string) error {
+	g.statusesMutex.Lock()
+	defer g.statusesMutex.Unlock()
+	g.statuses = append(g.statuses, state)
 	return nil
 }
 
-func (f *FakeDatabase) CreateLoginForAccount(account *Account) (*Login, error) {
-	return f.LoginToReturn, nil
-}
-
-func (f *FakeDatabase) LoginExists(accountId int, token string) bool {
-	return true
-}
-
-func (f *FakeDatabase) SaveCollaboration(accountId int, repositoryId int) error {
-	f.AddedCollaborations = append(f.AddedCollaborations, map[string]int{
-		"account_id":    accountId,
-		"repository_id": repositoryId,
-	})
-	return nil
+// reportedStatuses are the states SetStatus was called with, in order.
+func (g *FakeGit) reportedStatuses() []CommitState {
+	g.statusesMutex.Lock()
+	defer g.statusesMutex.Unlock()
+	return append([]CommitState(nil), g.statuses...)
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// encryptedTokenPrefix marks access tokens that have been encrypted. Tokens
+// saved before encryption was turned on don't have it, and are used as they
+// are until they're re-encrypted.
+const encryptedTokenPrefix = "enc:v1:"
+
+// tokenKeys returns the key access tokens are encrypted with, followed by
+// the old keys that can still decrypt them.
+func tokenKeys() ([]cipher.AEAD, error) {
+	var keys []cipher.AEAD
+	for _, encoded := range append([]string{configuration.TokenKey}, configuration.OldTokenKeys...) {
+		if encoded == "" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, errors.New("Token keys have to be 32 bytes, base64 encoded")
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, aead)
+	}
+	return keys, nil
+}
+
+// encryptToken encrypts an access token with the current key. Tokens are
+// saved as they are when no key is configured, which builder only allows in
+// development.
+func encryptToken(token string) (string, error) {
+	if configuration.TokenKey == "" || token == "" {
+		return token, nil
+	}
+	keys, err := tokenKeys()
+	if err != nil {
+		return "", err
+	}
+	aead := keys[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(token), nil)
+	return encryptedTokenPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// decryptToken decrypts an access token with whichever key encrypted it.
+func decryptToken(token string) (string, error) {
+	if !strings.HasPrefix(token, encryptedTokenPrefix) {
+		return token, nil
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, encryptedTokenPrefix))
+	if err != nil {
+		return "", err
+	}
+	keys, err := tokenKeys()
+	if err != nil {
+		return "", err
+	}
+	for _, aead := range keys {
+		if len(sealed) < aead.NonceSize() {
+			break
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if plaintext, err := aead.Open(nil, nonce, ciphertext, nil); err == nil {
+			return string(plaintext), nil
+		}
+	}
+	return "", errors.New("None of the token keys can decrypt the access token")
+}
+
+// HostToken decrypts the access token of the account, for talking to its
+// source host on its behalf.
+func (account *Account) HostToken() (string, error) {
+	return decryptToken(account.AccessToken)
+}
+
+// runReencryptTokensCommand encrypts every access token with the current
+// key, so that old keys can be removed after the key has been rotated.
+func runReencryptTokensCommand(ctx context.Context, output io.Writer, db Database) error {
+	if configuration.TokenKey == "" {
+		return errors.New("TOKEN_KEY has to be set to encrypt access tokens")
+	}
+	accounts, err := db.AllAccounts(ctx)
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		token, err := account.HostToken()
+		if err != nil {
+			return fmt.Errorf("Couldn't decrypt the access token of account %d: %v", account.Id, err)
+		}
+		encrypted, err := encryptToken(token)
+		if err != nil {
+			return err
+		}
+		err = db.SetAccessToken(ctx, account.Id, encrypted)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(output, "Re-encrypted %d access tokens\n", len(accounts))
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+var (
+	testTokenKey    = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	testOldTokenKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+)
+
+func withTokenKeys(key string, oldKeys []string, block func()) {
+	oldKey, oldOldKeys := configuration.TokenKey, configuration.OldTokenKeys
+	configuration.TokenKey, configuration.OldTokenKeys = key, oldKeys
+	defer func() { configuration.TokenKey, configuration.OldTokenKeys = oldKey, oldOldKeys }()
+	block()
+}
+
+func TestEncryptToken(t *testing.T) {
+	withTokenKeys(testTokenKey, nil, func() {
+		encrypted, err := encryptToken("secret-token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(encrypted, encryptedTokenPrefix) || strings.Contains(encrypted, "secret-token") {
+			t.Errorf("Expected the token to be encrypted, got %q", encrypted)
+		}
+		if again, _ := encryptToken("secret-token"); again == encrypted {
+			t.Errorf("Expected every encryption to use a new nonce")
+		}
+
+		decrypted, err := decryptToken(encrypted)
+		if err != nil || decrypted != "secret-token" {
+			t.Errorf("Expected the token to decrypt, got %q, %v", decrypted, err)
+		}
+	})
+}
+
+func TestEncryptTokenWithoutAKey(t *testing.T) {
+	withTokenKeys("", nil, func() {
+		if encrypted, _ := encryptToken("secret-token"); encrypted != "secret-token" {
+			t.Errorf("Expected the token to be saved as it is, got %q", encrypted)
+		}
+	})
+}
+
+func TestDecryptTokenUsesOldKeys(t *testing.T) {
+	var encrypted string
+	withTokenKeys(testOldTokenKey, nil, func() {
+		encrypted, _ = encryptToken("secret-token")
+	})
+
+	withTokenKeys(testTokenKey, []string{testOldTokenKey}, func() {
+		if decrypted, err := decryptToken(encrypted); err != nil || decrypted != "secret-token" {
+			t.Errorf("Expected an old key to decrypt the token, got %q, %v", decrypted, err)
+		}
+	})
+	withTokenKeys(testTokenKey, nil, func() {
+		if _, err := decryptToken(encrypted); err == nil {
+			t.Errorf("Expected the token to not decrypt once the old key is removed")
+		}
+	})
+}
+
+func TestDecryptTokenLeavesUnencryptedTokens(t *testing.T) {
+	withTokenKeys(testTokenKey, nil, func() {
+		if decrypted, err := decryptToken("plain-token"); err != nil || decrypted != "plain-token" {
+			t.Errorf("Expected tokens saved before encryption to work, got %q, %v", decrypted, err)
+		}
+	})
+}
+
+func TestTokenKeysHaveToBe32Bytes(t *testing.T) {
+	withTokenKeys("c2hvcnQ=", nil, func() {
+		if _, err := tokenKeys(); err == nil {
+			t.Errorf("Expected a short key to be rejected")
+		}
+	})
+}
+
+func TestReencryptTokensCommand(t *testing.T) {
+	resetMemoryDatabase()
+	ctx := context.Background()
+	withTokenKeys(testOldTokenKey, nil, func() {
+		encrypted, _ := encryptToken("old-key-token")
+		database.CreateAccount(ctx, &Account{Id: 1, HostUserId: 1, AccessToken: encrypted})
+	})
+	database.CreateAccount(ctx, &Account{Id: 2, HostUserId: 2, AccessToken: "plain-token"})
+
+	withTokenKeys(testTokenKey, []string{testOldTokenKey}, func() {
+		var output bytes.Buffer
+		if err := runReencryptTokensCommand(ctx, &output, database); err != nil {
+			t.Fatal(err)
+		}
+		if output.String() != "Re-encrypted 2 access tokens\n" {
+			t.Errorf("Unexpected output %q", output.String())
+		}
+	})
+
+	withTokenKeys(testTokenKey, nil, func() {
+		for id, expected := range map[int]string{1: "old-key-token", 2: "plain-token"} {
+			account, _ := database.FindAccountById(ctx, id)
+			if !strings.HasPrefix(account.AccessToken, encryptedTokenPrefix) {
+				t.Errorf("Expected account %d to have an encrypted token, got %q", id, account.AccessToken)
+			}
+			if token, err := account.HostToken(); err != nil || token != expected {
+				t.Errorf("Expected account %d to decrypt with the new key, got %q, %v", id, token, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Webhook is a hook on a source host that posts events to a url. Event holds
+// every event the hook is for, comma separated.
+type Webhook struct {
+	Id    int
+	Event string
+	Url   string
+}
+
+// hookUrl is where a source host posts events to, for the handler at
+// /hooks/path.
+func hookUrl(path string) string {
+	return configuration.Host + ":" + configuration.Port + "/hooks/" + path
+}
+
+// installHooks makes sure the repository has exactly one of each of the
+// webhooks the host wants. Missing hooks are created and duplicates are
+// deleted, so it can be run again on a repository that already has them.
+func installHooks(host SourceHost, accessToken string, owner string, repo string) ([]Webhook, error) {
+	existing, err := host.Hooks(accessToken, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var hooks []Webhook
+	var missing []Webhook
+	for _, wanted := range host.WantedHooks() {
+		found := false
+		for _, hook := range existing {
+			if hook.Url != wanted.Url {
+				continue
+			}
+			if found {
+				if err := host.DeleteHook(accessToken, owner, repo, hook.Id); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			found = true
+			hooks = append(hooks, hook)
+		}
+		if !found {
+			missing = append(missing, wanted)
+		}
+	}
+
+	if len(missing) > 0 {
+		created, err := host.CreateHooks(accessToken, owner, repo, missing)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, created...)
+	}
+	return hooks, nil
+}
+
+// removeHooks deletes builder's webhooks from the repository. Repositories
+// added before builder kept track of its hooks have them looked up.
+func removeHooks(ctx context.Context, accessToken string, repository *Repository) error {
+	host := sourceHost(repository.Host)
+	hooks, err := database.RepositoryHooks(ctx, repository.Id)
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		existing, err := host.Hooks(accessToken, repository.Owner, repository.Repository)
+		if err != nil {
+			return err
+		}
+		for _, hook := range existing {
+			for _, wanted := range host.WantedHooks() {
+				if hook.Url == wanted.Url {
+					hooks = append(hooks, hook)
+				}
+			}
+		}
+	}
+
+	for _, hook := range hooks {
+		err := host.DeleteHook(accessToken, repository.Owner, repository.Repository, hook.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findRepositoryOwnerToken returns the access token of the account that
+// added the repository, which builder uses to manage it on its source host.
+// Otherwise it writes the error and returns false.
+func findRepositoryOwnerToken(w http.ResponseWriter, r *http.Request, repository *Repository) (string, bool) {
+	owner := findRepositoryOwner(w, r, repository)
+	if owner == nil {
+		return "", false
+	}
+	token, err := owner.HostToken()
+	if err != nil {
+		fmt.Println("Error decrypting access token:", err)
+		w.WriteHeader(500)
+		return "", false
+	}
+	return token, true
+}
+
+// repairHooksHandler recreates webhooks that have been deleted on the source
+// host.
+func repairHooksHandler(w http.ResponseWriter, r *http.Request) {
+	repository := findRepositoryWithRole(w, r, roleAdmin)
+	if repository == nil {
+		return
+	}
+	token, ok := findRepositoryOwnerToken(w, r, repository)
+	if !ok {
+		return
+	}
+
+	host := sourceHost(repository.Host)
+	hooks, err := installHooks(host, token, repository.Owner, repository.Repository)
+	if err != nil {
+		fmt.Println("Error installing hooks:", err)
+		http.Error(w, "Couldn't repair the webhooks on "+host.Name(), 502)
+		return
+	}
+	err = database.SaveRepositoryHooks(r.Context(), repository.Id, hooks)
+	if err != nil {
+		fmt.Println("Error saving hooks:", err)
+		w.WriteHeader(500)
+		return
+	}
+	http.Redirect(w, r, repository.Url(), 302)
+}
+
+// removeRepositoryHandler deletes builder's webhooks from the source host and
+// then the repository with all of its builds.
+func removeRepositoryHandler(w http.ResponseWriter, r *http.Request) {
+	repository := findRepositoryWithRole(w, r, roleAdmin)
+	if repository == nil {
+		return
+	}
+	token, ok := findRepositoryOwnerToken(w, r, repository)
+	if !ok {
+		return
+	}
+
+	err := removeHooks(r.Context(), token, repository)
+	if err != nil {
+		fmt.Println("Error removing hooks:", err)
+		http.Error(w, "Couldn't delete the webhooks on "+sourceHost(repository.Host).Name(), 502)
+		return
+	}
+
+	builds, err := database.RepositoryBuilds(r.Context(), repository)
+	if err != nil {
+		fmt.Println("Error getting repository builds:", err)
+		w.WriteHeader(500)
+		return
+	}
+	for _, build := range builds {
+		if err := logStore.Delete(build); err != nil {
+			fmt.Println("Error deleting build log:", err)
+		}
+		if err := os.RemoveAll(build.Path()); err != nil {
+			fmt.Println("Error deleting build:", err)
+		}
+	}
+
+	err = database.DeleteRepository(r.Context(), repository.Id)
+	if err != nil {
+		fmt.Println("Error deleting repository:", err)
+		w.WriteHeader(500)
+		return
+	}
+	http.Redirect(w, r, "/settings", 302)
+}
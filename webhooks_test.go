@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestInstallHooksCreatesMissingHooks(t *testing.T) {
+	resetFakeGit()
+	fakeGit.Webhooks = []Webhook{
+		{Id: 50, Event: "push", Url: hookUrl("push")},
+		{Id: 51, Event: "push", Url: "https://example.com/someone-elses-hook"},
+	}
+	fakeGit.lastHookId = 100
+
+	hooks, err := installHooks(fakeGit, "TOKEN", "owner", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Webhook{
+		{Id: 50, Event: "push", Url: hookUrl("push")},
+		{Id: 101, Event: "pull_request", Url: hookUrl("pull_request")},
+	}
+	if !reflect.DeepEqual(hooks, expected) {
+		t.Errorf("Expected the existing push hook and a new pull request hook, got %+v", hooks)
+	}
+	if len(fakeGit.Webhooks) != 3 {
+		t.Errorf("Expected other hooks to be left alone, got %+v", fakeGit.Webhooks)
+	}
+}
+
+func TestInstallHooksDeletesDuplicateHooks(t *testing.T) {
+	resetFakeGit()
+	fakeGit.Webhooks = []Webhook{
+		{Id: 50, Event: "push", Url: hookUrl("push")},
+		{Id: 51, Event: "pull_request", Url: hookUrl("pull_request")},
+		{Id: 52, Event: "push", Url: hookUrl("push")},
+	}
+
+	hooks, err := installHooks(fakeGit, "TOKEN", "owner", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hooks) != 2 || hooks[0].Id != 50 || hooks[1].Id != 51 {
+		t.Errorf("Expected the first hook for each event to be kept, got %+v", hooks)
+	}
+	if !reflect.DeepEqual(fakeGit.deletedHooks, []int{52}) {
+		t.Errorf("Expected the duplicate hook to be deleted, got %v", fakeGit.deletedHooks)
+	}
+	if fakeGit.createHooksParameters != nil {
+		t.Errorf("Expected no hooks to be created")
+	}
+}
+
+func TestRemoveHooksFindsHooksOfRepositoriesAddedBeforeTheyWereSaved(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	repository := createAccountWithRepository(&Account{Id: 1, HostUserId: 1}, "owner", "repo")
+	fakeGit.Webhooks = []Webhook{
+		{Id: 50, Event: "push", Url: hookUrl("push")},
+		{Id: 51, Event: "push", Url: "https://example.com/someone-elses-hook"},
+	}
+
+	if err := removeHooks(context.Background(), "TOKEN", repository); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fakeGit.deletedHooks, []int{50}) {
+		t.Errorf("Expected only builder's hook to be deleted, got %v", fakeGit.deletedHooks)
+	}
+}
+
+func TestRepairHooksHandlerRecreatesHooks(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	_, members := createOrganizationBuild()
+	repository, _ := database.FindRepository(context.Background(), "github", "acme", "widgets")
+	database.SaveRepositoryHooks(context.Background(), repository.Id, []Webhook{{Id: 7, Event: "push", Url: hookUrl("push")}})
+	query := "?" + url.Values{":owner": {"acme"}, ":repo": {"widgets"}}.Encode()
+
+	w := httptest.NewRecorder()
+	repairHooksHandler(w, postForm(loginRequest("POST", "/acme/widgets/hooks/repair"+query, members[roleWrite]), url.Values{}))
+	if w.Code != 403 {
+		t.Errorf("Expected writers to not be allowed to repair hooks, got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	repairHooksHandler(w, postForm(loginRequest("POST", "/acme/widgets/hooks/repair"+query, members[roleAdmin]), url.Values{}))
+	if w.Code != 302 {
+		t.Fatalf("Expected the admin to be redirected, got %v", w.Code)
+	}
+	hooks, _ := database.RepositoryHooks(context.Background(), repository.Id)
+	if !reflect.DeepEqual(hooks, fakeGit.Webhooks) || len(hooks) != 2 {
+		t.Errorf("Expected the hooks on Github to be saved, got %+v", hooks)
+	}
+}
+
+func TestRemoveRepositoryHandlerDeletesHooksAndTheRepository(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	_, members := createOrganizationBuild()
+	repository, _ := database.FindRepository(context.Background(), "github", "acme", "widgets")
+	fakeGit.Webhooks = []Webhook{{Id: 7, Event: "push", Url: hookUrl("push")}, {Id: 8, Event: "pull_request", Url: hookUrl("pull_request")}}
+	database.SaveRepositoryHooks(context.Background(), repository.Id, fakeGit.Webhooks)
+	query := "?" + url.Values{":owner": {"acme"}, ":repo": {"widgets"}}.Encode()
+
+	w := httptest.NewRecorder()
+	removeRepositoryHandler(w, postForm(loginRequest("POST", "/acme/widgets/remove"+query, members[roleWrite]), url.Values{}))
+	if w.Code != 403 {
+		t.Errorf("Expected writers to not be allowed to remove the repository, got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	removeRepositoryHandler(w, postForm(loginRequest("POST", "/acme/widgets/remove"+query, members[roleAdmin]), url.Values{}))
+	if w.Code != 302 {
+		t.Fatalf("Expected the admin to be redirected, got %v", w.Code)
+	}
+	if !reflect.DeepEqual(fakeGit.deletedHooks, []int{7, 8}) {
+		t.Errorf("Expected builder's hooks to be deleted, got %v", fakeGit.deletedHooks)
+	}
+	if found, _ := database.FindRepository(context.Background(), "github", "acme", "widgets"); found != nil {
+		t.Errorf("Expected the repository to be deleted")
+	}
+	if builds, _ := database.AllBuilds(context.Background(), members[roleAdmin]); len(builds) != 0 {
+		t.Errorf("Expected the builds to be deleted, got %d", len(builds))
+	}
+}
+
+func TestAvailableRepositoriesLeavesOutAddedRepositories(t *testing.T) {
+	resetMemoryDatabase()
+	resetFakeGit()
+	account := &Account{Id: 1, HostUserId: 1}
+	createAccountWithRepository(account, "owner", "added")
+	fakeGit.RepositoriesToReturn = []HostRepository{{Name: "Added", FullName: "owner/Added"}, {Name: "new", FullName: "owner/new"}}
+
+	available, err := availableRepositories(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(available) != 1 || available[0].FullName != "owner/new" {
+		t.Errorf("Expected only repositories that haven't been added, got %+v", available)
+	}
+}